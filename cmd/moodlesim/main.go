@@ -0,0 +1,42 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+
+	"api_concurrencia/pkg/moodlesim"
+
+	"github.com/joho/godotenv"
+)
+
+const defaultAddr = ":8081"
+
+// Servidor simulado de Moodle para desarrollo local. Uso:
+//
+//	go run ./cmd/moodlesim
+//	MOODLE_URL=http://localhost:8081 go run .
+//
+// Acepta el mismo MOODLE_TOKEN que usa la API (o MOODLESIM_TOKEN si se define) y guarda todo en
+// memoria: al reiniciarlo se pierden las categorías, cursos, usuarios y grupos creados.
+func main() {
+	godotenv.Load()
+
+	addr := os.Getenv("MOODLESIM_ADDR")
+	if addr == "" {
+		addr = defaultAddr
+	}
+	token := os.Getenv("MOODLESIM_TOKEN")
+	if token == "" {
+		token = os.Getenv("MOODLE_TOKEN")
+	}
+	if token == "" {
+		log.Println("⚠️ Sin MOODLESIM_TOKEN ni MOODLE_TOKEN: se acepta cualquier token.")
+	}
+
+	server := moodlesim.NewServer(token)
+	log.Printf("🧪 Moodle simulado escuchando en http://localhost%s%s", addr, moodlesim.RESTPath)
+	if err := http.ListenAndServe(addr, server.Handler()); err != nil {
+		log.Fatalf("❌ Error al iniciar el servidor simulado de Moodle: %v", err)
+	}
+}
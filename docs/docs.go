@@ -90,16 +90,19 @@ const docTemplate = `{
         },
         "/asignatura/bulk-sync": {
             "post": {
-                "description": "Sincroniza todas las asignaturas que no tienen ID_Moodle a Moodle",
+                "description": "Sincroniza todas las asignaturas que no tienen ID_Moodle a Moodle. Se ejecuta en segundo plano como trabajo de sincronización; su avance se consulta en GET /sync/jobs/{id}",
+                "produces": [
+                    "application/json"
+                ],
                 "tags": [
                     "asignatura"
                 ],
                 "summary": "Sincronización masiva de Asignaturas",
                 "responses": {
-                    "200": {
-                        "description": "OK",
+                    "202": {
+                        "description": "Trabajo registrado",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/models.SyncJob"
                         }
                     },
                     "500": {
@@ -235,7 +238,7 @@ const docTemplate = `{
                 }
             },
             "delete": {
-                "description": "Elimina una asignatura por ID",
+                "description": "Elimina una asignatura por ID y, con propagate=true, su curso en Moodle. La eliminación en Moodle se hace en segundo plano mediante el outbox",
                 "tags": [
                     "asignatura"
                 ],
@@ -247,6 +250,12 @@ const docTemplate = `{
                         "name": "id",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Eliminar también en Moodle (por defecto MOODLE_DELETE_PROPAGATE)",
+                        "name": "propagate",
+                        "in": "query"
                     }
                 ],
                 "responses": {
@@ -262,6 +271,12 @@ const docTemplate = `{
                             "type": "string"
                         }
                     },
+                    "404": {
+                        "description": "Asignatura no encontrada",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -271,6 +286,256 @@ const docTemplate = `{
                 }
             }
         },
+        "/asignatura/{id}/calificaciones": {
+            "get": {
+                "description": "Devuelve las calificaciones guardadas localmente de todos los alumnos de la asignatura",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Calificaciones"
+                ],
+                "summary": "Calificaciones de una asignatura",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "ID de la asignatura",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.Calificacion"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "ID inválido",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Error al obtener calificaciones",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/asignatura/{id}/calificaciones/import": {
+            "post": {
+                "description": "Consulta gradereport_user_get_grade_items para todos los alumnos del curso en una sola llamada y sustituye sus calificaciones locales",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Calificaciones"
+                ],
+                "summary": "Importar calificaciones de una asignatura",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "ID de la asignatura",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.ImportacionCalificaciones"
+                        }
+                    },
+                    "400": {
+                        "description": "ID inválido",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Asignatura no encontrada",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "501": {
+                        "description": "Moodle no tiene habilitada la función de calificaciones",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "502": {
+                        "description": "Error de Moodle",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/asignatura/{id}/finalizacion": {
+            "get": {
+                "description": "Devuelve, para cada alumno de la asignatura, si completó el curso en Moodle y cuándo, según la última importación",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Finalizacion"
+                ],
+                "summary": "Finalización del curso de una asignatura",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "ID de la asignatura",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.Finalizacion"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "ID inválido",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Error al obtener la finalización",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/asignatura/{id}/finalizacion/import": {
+            "post": {
+                "description": "Consulta core_completion_get_course_completion_status para cada alumno de la asignatura y actualiza su estado de finalización local",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Finalizacion"
+                ],
+                "summary": "Importar finalización de una asignatura",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "ID de la asignatura",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.ImportacionFinalizacion"
+                        }
+                    },
+                    "400": {
+                        "description": "ID inválido",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Asignatura no encontrada",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "501": {
+                        "description": "Moodle no tiene habilitada la función de finalización",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "502": {
+                        "description": "Error de Moodle",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/asignatura/{id}/template-import": {
+            "post": {
+                "description": "Copia actividades, bloques y filtros del curso plantilla (plantilla_moodle_id) en el curso ya creado de la asignatura. Con replace=true borra antes su contenido.",
+                "tags": [
+                    "asignatura"
+                ],
+                "summary": "Importar plantilla en el curso de una Asignatura",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "ID de la asignatura",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Borrar el contenido actual del curso antes de importar",
+                        "name": "replace",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "501": {
+                        "description": "Not Implemented",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "502": {
+                        "description": "Bad Gateway",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
         "/auth/login": {
             "post": {
                 "description": "Autentica un usuario con username y password, devuelve un token JWT",
@@ -450,16 +715,19 @@ const docTemplate = `{
         },
         "/cuatrimestre/bulk-sync": {
             "post": {
-                "description": "Sincroniza todos los cuatrimestres que no tienen ID_Moodle a Moodle",
+                "description": "Sincroniza todos los cuatrimestres que no tienen ID_Moodle a Moodle. Se ejecuta en segundo plano como trabajo de sincronización; su avance se consulta en GET /sync/jobs/{id}",
+                "produces": [
+                    "application/json"
+                ],
                 "tags": [
                     "cuatrimestre"
                 ],
                 "summary": "Sincronización masiva de Cuatrimestres",
                 "responses": {
-                    "200": {
-                        "description": "OK",
+                    "202": {
+                        "description": "Trabajo registrado",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/models.SyncJob"
                         }
                     },
                     "500": {
@@ -471,13 +739,16 @@ const docTemplate = `{
                 }
             }
         },
-        "/cuatrimestre/sync/{id}": {
+        "/cuatrimestre/cohort/{id}": {
             "post": {
-                "description": "Inicia la sincronización del cuatrimestre a Moodle",
+                "description": "Crea, si no existe, la cohorte de Moodle del cuatrimestre (core_cohort_create_cohorts) y le añade como miembros los alumnos matriculados en sus asignaturas que ya están en Moodle (core_cohort_add_cohort_members). Puede repetirse para incorporar alumnos nuevos; quita de la cohorte a los miembros que ya no están matriculados (core_cohort_delete_cohort_members)",
+                "produces": [
+                    "application/json"
+                ],
                 "tags": [
                     "cuatrimestre"
                 ],
-                "summary": "Sincronizar Cuatrimestre",
+                "summary": "Sincronizar cohorte del cuatrimestre",
                 "parameters": [
                     {
                         "type": "integer",
@@ -491,11 +762,29 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/services.CohortSyncResult"
                         }
                     },
                     "400": {
-                        "description": "Bad Request",
+                        "description": "ID inválido",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Cuatrimestre no encontrado",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "501": {
+                        "description": "Moodle no tiene habilitadas las funciones de cohortes",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "502": {
+                        "description": "Error de Moodle",
                         "schema": {
                             "type": "string"
                         }
@@ -503,12 +792,44 @@ const docTemplate = `{
                 }
             }
         },
-        "/cuatrimestre/{id}/": {
-            "get": {
-                "description": "Obtiene un cuatrimestre por ID",
-                "produces": [
-                    "application/json"
-                ],
+        "/cuatrimestre/sync/{id}": {
+            "post": {
+                "description": "Inicia la sincronización del cuatrimestre a Moodle",
+                "tags": [
+                    "cuatrimestre"
+                ],
+                "summary": "Sincronizar Cuatrimestre",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "ID del cuatrimestre",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/cuatrimestre/{id}/": {
+            "get": {
+                "description": "Obtiene un cuatrimestre por ID",
+                "produces": [
+                    "application/json"
+                ],
                 "tags": [
                     "cuatrimestre"
                 ],
@@ -595,7 +916,7 @@ const docTemplate = `{
                 }
             },
             "delete": {
-                "description": "Elimina un cuatrimestre por ID",
+                "description": "Elimina un cuatrimestre por ID y, con propagate=true, su subcategoría en Moodle (solo si está vacía). La eliminación en Moodle se hace en segundo plano mediante el outbox",
                 "tags": [
                     "cuatrimestre"
                 ],
@@ -607,6 +928,12 @@ const docTemplate = `{
                         "name": "id",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Eliminar también en Moodle (por defecto MOODLE_DELETE_PROPAGATE)",
+                        "name": "propagate",
+                        "in": "query"
                     }
                 ],
                 "responses": {
@@ -622,11 +949,146 @@ const docTemplate = `{
                             "type": "string"
                         }
                     },
+                    "404": {
+                        "description": "Cuatrimestre no encontrado",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "La subcategoría de Moodle todavía tiene cursos",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
                             "type": "string"
                         }
+                    },
+                    "502": {
+                        "description": "Error de Moodle",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/cuatrimestre/{id}/finalizacion": {
+            "get": {
+                "description": "Para cada alumno del cuatrimestre indica en cuántas asignaturas está matriculado, cuántas completó y si las completó todas, con los datos de la última importación",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Finalizacion"
+                ],
+                "summary": "Informe de finalización de un cuatrimestre",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "ID del cuatrimestre",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.InformeFinalizacionCuatrimestre"
+                        }
+                    },
+                    "400": {
+                        "description": "ID inválido",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Cuatrimestre no encontrado",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Error al generar el informe",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/cuatrimestre/{id}/finalizacion/import": {
+            "post": {
+                "description": "Consulta core_completion_get_course_completion_status para cada alumno de cada asignatura del cuatrimestre y actualiza su estado de finalización local",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Finalizacion"
+                ],
+                "summary": "Importar finalización de un cuatrimestre",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "ID del cuatrimestre",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.ImportacionFinalizacion"
+                        }
+                    },
+                    "400": {
+                        "description": "ID inválido",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Cuatrimestre no encontrado",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "501": {
+                        "description": "Moodle no tiene habilitada la función de finalización",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "502": {
+                        "description": "Error de Moodle",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/finalizacion/import": {
+            "post": {
+                "description": "Importa en segundo plano el estado de finalización de todas las matrículas de alumnos. Es la misma tarea que ejecuta la importación periódica (MOODLE_COMPLETION_IMPORT_INTERVAL); no se solapan",
+                "tags": [
+                    "Finalizacion"
+                ],
+                "summary": "Importar toda la finalización",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
                     }
                 }
             }
@@ -706,7 +1168,7 @@ const docTemplate = `{
         },
         "/grupo/add-members/{grupoID}": {
             "post": {
-                "description": "Añade miembros al grupo y sincroniza con Moodle",
+                "description": "Añade miembros al grupo local y los añade en Moodle en segundo plano (core_group_add_group_members)",
                 "consumes": [
                     "application/json"
                 ],
@@ -751,6 +1213,12 @@ const docTemplate = `{
                             "type": "string"
                         }
                     },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -762,11 +1230,64 @@ const docTemplate = `{
         },
         "/grupo/bulk-sync": {
             "post": {
-                "description": "Sincroniza todos los grupos que no tienen ID_Moodle a Moodle",
+                "description": "Sincroniza todos los grupos que no tienen ID_Moodle a Moodle. Se ejecuta en segundo plano como trabajo de sincronización; su avance se consulta en GET /sync/jobs/{id}",
+                "produces": [
+                    "application/json"
+                ],
                 "tags": [
                     "grupo"
                 ],
                 "summary": "Sincronización masiva de Grupos",
+                "responses": {
+                    "202": {
+                        "description": "Trabajo registrado",
+                        "schema": {
+                            "$ref": "#/definitions/models.SyncJob"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/grupo/remove-members/{grupoID}": {
+            "post": {
+                "description": "Quita miembros del grupo local y los quita de Moodle en segundo plano (core_group_delete_group_members)",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "text/plain"
+                ],
+                "tags": [
+                    "grupo"
+                ],
+                "summary": "Quitar Miembros de Grupo",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "ID del grupo",
+                        "name": "grupoID",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "IDs de usuarios",
+                        "name": "usuarios",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "type": "integer"
+                            }
+                        }
+                    }
+                ],
                 "responses": {
                     "200": {
                         "description": "OK",
@@ -774,6 +1295,18 @@ const docTemplate = `{
                             "type": "string"
                         }
                     },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -862,7 +1395,7 @@ const docTemplate = `{
                 }
             },
             "put": {
-                "description": "Actualiza un grupo por ID",
+                "description": "Actualiza un grupo por ID. Si está sincronizado, el cambio se lleva a Moodle (core_group_update_groups) en segundo plano mediante el outbox",
                 "consumes": [
                     "application/json"
                 ],
@@ -913,7 +1446,7 @@ const docTemplate = `{
                 }
             },
             "delete": {
-                "description": "Elimina un grupo por ID",
+                "description": "Elimina un grupo por ID y, con propagate=true, también en Moodle. La eliminación en Moodle se hace en segundo plano mediante el outbox",
                 "tags": [
                     "grupo"
                 ],
@@ -925,6 +1458,12 @@ const docTemplate = `{
                         "name": "id",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Eliminar también en Moodle (por defecto MOODLE_DELETE_PROPAGATE)",
+                        "name": "propagate",
+                        "in": "query"
                     }
                 ],
                 "responses": {
@@ -940,6 +1479,12 @@ const docTemplate = `{
                             "type": "string"
                         }
                     },
+                    "404": {
+                        "description": "Grupo no encontrado",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -949,59 +1494,72 @@ const docTemplate = `{
                 }
             }
         },
-        "/programa-estudio/sync/{id}": {
-            "post": {
-                "description": "Sincroniza un programa de estudio local con Moodle como categoría padre",
+        "/moodle/limiter": {
+            "get": {
+                "description": "Devuelve la configuración del limitador global (peticiones por segundo y llamadas concurrentes) y cuánto han esperado las llamadas en él",
                 "produces": [
-                    "text/plain"
+                    "application/json"
                 ],
                 "tags": [
-                    "ProgramaEstudio"
-                ],
-                "summary": "Sincronizar programa de estudio con Moodle",
-                "parameters": [
-                    {
-                        "type": "integer",
-                        "description": "ID del programa de estudio a sincronizar",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
-                    }
+                    "moodle"
                 ],
+                "summary": "Métricas del limitador de Moodle",
                 "responses": {
                     "200": {
-                        "description": "Sincronización iniciada correctamente",
-                        "schema": {
-                            "type": "string"
-                        }
-                    },
-                    "400": {
-                        "description": "ID inválido",
-                        "schema": {
-                            "type": "string"
-                        }
-                    },
-                    "500": {
-                        "description": "Error durante la sincronización",
+                        "description": "OK",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/moodle.LimiterStats"
                         }
                     }
                 }
             }
         },
-        "/programa_estudio": {
+        "/moodle/site-info": {
             "get": {
-                "description": "Recupera la lista completa de programas de estudio",
+                "description": "Devuelve la última comprobación hecha con core_webservice_get_site_info (versión de Moodle y funciones imprescindibles u opcionales no habilitadas para el token). Con refresh=true vuelve a consultar a Moodle y actualiza el modo degradado",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "ProgramaEstudio"
+                    "moodle"
                 ],
-                "summary": "Obtener todos los programas de estudio",
-                "responses": {
-                    "200": {
+                "summary": "Capacidades del sitio Moodle",
+                "parameters": [
+                    {
+                        "type": "boolean",
+                        "description": "Volver a consultar a Moodle",
+                        "name": "refresh",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/moodle.SiteReport"
+                        }
+                    },
+                    "502": {
+                        "description": "Moodle no respondió o rechazó el token",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/programa-estudio": {
+            "get": {
+                "description": "Recupera la lista completa de programas de estudio",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "ProgramaEstudio"
+                ],
+                "summary": "Obtener todos los programas de estudio",
+                "responses": {
+                    "200": {
                         "description": "Lista de programas de estudio",
                         "schema": {
                             "type": "array",
@@ -1063,16 +1621,16 @@ const docTemplate = `{
                 }
             }
         },
-        "/programa_estudio/{id}": {
-            "get": {
-                "description": "Recupera un programa de estudio específico mediante su ID",
+        "/programa-estudio/cohort/{id}": {
+            "post": {
+                "description": "Crea, si no existe, la cohorte de Moodle del programa de estudio (core_cohort_create_cohorts) y le añade como miembros los alumnos matriculados en sus asignaturas que ya están en Moodle (core_cohort_add_cohort_members). Puede repetirse para incorporar alumnos nuevos; quita de la cohorte a los miembros que ya no están matriculados (core_cohort_delete_cohort_members)",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
                     "ProgramaEstudio"
                 ],
-                "summary": "Obtener programa de estudio por ID",
+                "summary": "Sincronizar cohorte del programa de estudio",
                 "parameters": [
                     {
                         "type": "integer",
@@ -1084,9 +1642,9 @@ const docTemplate = `{
                 ],
                 "responses": {
                     "200": {
-                        "description": "Programa de estudio encontrado",
+                        "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/models.ProgramaEstudio"
+                            "$ref": "#/definitions/services.CohortSyncResult"
                         }
                     },
                     "400": {
@@ -1100,54 +1658,97 @@ const docTemplate = `{
                         "schema": {
                             "type": "string"
                         }
+                    },
+                    "501": {
+                        "description": "Moodle no tiene habilitadas las funciones de cohortes",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "502": {
+                        "description": "Error de Moodle",
+                        "schema": {
+                            "type": "string"
+                        }
                     }
                 }
-            },
-            "put": {
-                "description": "Actualiza un programa de estudio existente en la base de datos local",
-                "consumes": [
-                    "application/json"
-                ],
+            }
+        },
+        "/programa-estudio/sync/{id}": {
+            "post": {
+                "description": "Sincroniza un programa de estudio local con Moodle como categoría padre",
                 "produces": [
-                    "application/json"
+                    "text/plain"
                 ],
                 "tags": [
                     "ProgramaEstudio"
                 ],
-                "summary": "Actualizar programa de estudio",
+                "summary": "Sincronizar programa de estudio con Moodle",
                 "parameters": [
                     {
                         "type": "integer",
-                        "description": "ID del programa de estudio a actualizar",
+                        "description": "ID del programa de estudio a sincronizar",
                         "name": "id",
                         "in": "path",
                         "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Sincronización iniciada correctamente",
+                        "schema": {
+                            "type": "string"
+                        }
                     },
-                    {
-                        "description": "Datos actualizados del programa de estudio",
-                        "name": "programa_estudio",
-                        "in": "body",
-                        "required": true,
+                    "400": {
+                        "description": "ID inválido",
                         "schema": {
-                            "$ref": "#/definitions/models.ProgramaEstudio"
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Error durante la sincronización",
+                        "schema": {
+                            "type": "string"
                         }
                     }
+                }
+            }
+        },
+        "/programa-estudio/{id}": {
+            "get": {
+                "description": "Recupera un programa de estudio específico mediante su ID",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "ProgramaEstudio"
+                ],
+                "summary": "Obtener programa de estudio por ID",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "ID del programa de estudio",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
                 ],
                 "responses": {
                     "200": {
-                        "description": "Programa de estudio actualizado exitosamente",
+                        "description": "Programa de estudio encontrado",
                         "schema": {
                             "$ref": "#/definitions/models.ProgramaEstudio"
                         }
                     },
                     "400": {
-                        "description": "ID inválido o error en los datos de entrada",
+                        "description": "ID inválido",
                         "schema": {
                             "type": "string"
                         }
                     },
-                    "500": {
-                        "description": "Error al actualizar el programa de estudio",
+                    "404": {
+                        "description": "Programa de estudio no encontrado",
                         "schema": {
                             "type": "string"
                         }
@@ -1155,7 +1756,7 @@ const docTemplate = `{
                 }
             },
             "delete": {
-                "description": "Elimina un programa de estudio de la base de datos local",
+                "description": "Elimina un programa de estudio de la base de datos local y, con propagate=true, su categoría en Moodle (solo si está vacía). La eliminación en Moodle se hace en segundo plano mediante el outbox",
                 "tags": [
                     "ProgramaEstudio"
                 ],
@@ -1167,6 +1768,12 @@ const docTemplate = `{
                         "name": "id",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Eliminar también en Moodle (por defecto MOODLE_DELETE_PROPAGATE)",
+                        "name": "propagate",
+                        "in": "query"
                     }
                 ],
                 "responses": {
@@ -1179,37 +1786,102 @@ const docTemplate = `{
                             "type": "string"
                         }
                     },
+                    "404": {
+                        "description": "Programa de estudio no encontrado",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "La categoría de Moodle todavía tiene contenido",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
                     "500": {
                         "description": "Error al eliminar el programa de estudio",
                         "schema": {
                             "type": "string"
                         }
+                    },
+                    "502": {
+                        "description": "Error de Moodle",
+                        "schema": {
+                            "type": "string"
+                        }
                     }
                 }
             }
         },
-        "/usuario": {
+        "/programa-estudio/{id}/sync-tree": {
+            "post": {
+                "description": "Sincroniza con Moodle, en orden de dependencias, el programa, sus cuatrimestres, sus asignaturas, sus grupos, las matrículas (las locales pendientes y las de los miembros de los grupos) y los miembros de los grupos. Un fallo no detiene el resto: los descendientes de lo que falla se omiten. Responde con el resultado de cada nivel",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "ProgramaEstudio"
+                ],
+                "summary": "Sincronizar el árbol del programa de estudio",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "ID del programa de estudio",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.SyncTreeResult"
+                        }
+                    },
+                    "400": {
+                        "description": "ID inválido",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Programa de estudio no encontrado",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Error al leer la base de datos local",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/rol-moodle/": {
             "get": {
-                "description": "Recupera la lista completa de usuarios (Docentes y Alumnos)",
+                "description": "Devuelve todos los roles locales con su rol de Moodle",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "Usuario"
+                    "RolMoodle"
                 ],
-                "summary": "Obtener todos los usuarios",
+                "summary": "Listar correspondencias de rol",
                 "responses": {
                     "200": {
-                        "description": "Lista de usuarios",
+                        "description": "OK",
                         "schema": {
                             "type": "array",
                             "items": {
-                                "$ref": "#/definitions/models.Usuario"
+                                "$ref": "#/definitions/models.RolMoodle"
                             }
                         }
                     },
                     "500": {
-                        "description": "Error al obtener usuarios",
+                        "description": "Internal Server Error",
                         "schema": {
                             "type": "string"
                         }
@@ -1217,7 +1889,7 @@ const docTemplate = `{
                 }
             },
             "post": {
-                "description": "Crea un nuevo usuario (Docente o Alumno) en la base de datos local con validaciones de contraseña, unicidad y rol",
+                "description": "Asocia un rol local con un rol de Moodle por ID o por shortname (el ID se resuelve al validar contra Moodle)",
                 "consumes": [
                     "application/json"
                 ],
@@ -1225,41 +1897,35 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "Usuario"
+                    "RolMoodle"
                 ],
-                "summary": "Crear un nuevo usuario",
+                "summary": "Crear correspondencia de rol",
                 "parameters": [
                     {
-                        "description": "Datos del usuario a crear (Password debe tener mín. 8 caracteres, mayúscula, número y símbolo)",
-                        "name": "usuario",
+                        "description": "Correspondencia de rol",
+                        "name": "rol",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/models.Usuario"
+                            "$ref": "#/definitions/models.RolMoodle"
                         }
                     }
                 ],
                 "responses": {
                     "201": {
-                        "description": "Usuario creado exitosamente",
+                        "description": "Created",
                         "schema": {
-                            "$ref": "#/definitions/models.Usuario"
+                            "$ref": "#/definitions/models.RolMoodle"
                         }
                     },
                     "400": {
-                        "description": "Error en los datos de entrada, campos obligatorios faltantes o contraseña inválida",
-                        "schema": {
-                            "type": "string"
-                        }
-                    },
-                    "409": {
-                        "description": "Ya existe un usuario con el mismo Username, Email o Matrícula",
+                        "description": "Datos inválidos o rol ya existente",
                         "schema": {
                             "type": "string"
                         }
                     },
                     "500": {
-                        "description": "Error interno del servidor al crear el usuario",
+                        "description": "Internal Server Error",
                         "schema": {
                             "type": "string"
                         }
@@ -1267,34 +1933,25 @@ const docTemplate = `{
                 }
             }
         },
-        "/usuario/bulk-sync": {
+        "/rol-moodle/validate": {
             "post": {
-                "description": "Sincroniza todos los usuarios de un rol específico (Docente o Alumno) con Moodle de forma asíncrona",
+                "description": "Obtiene los roles de Moodle con local_wsgetroles_get_roles, completa el ID de los roles definidos sólo por shortname e informa de los que no existen en Moodle. Si el plugin no está instalado, disponible es false",
                 "produces": [
-                    "text/plain"
+                    "application/json"
                 ],
                 "tags": [
-                    "Usuario"
-                ],
-                "summary": "Sincronización masiva de usuarios por rol",
-                "parameters": [
-                    {
-                        "type": "string",
-                        "description": "Rol a sincronizar: 'Docente' o 'Alumno'",
-                        "name": "role",
-                        "in": "query",
-                        "required": true
-                    }
+                    "RolMoodle"
                 ],
+                "summary": "Validar correspondencias de rol",
                 "responses": {
                     "200": {
-                        "description": "Sincronización masiva de usuarios iniciada correctamente en segundo plano",
+                        "description": "OK",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/services.ValidacionRoles"
                         }
                     },
-                    "400": {
-                        "description": "Rol inválido o no especificado",
+                    "502": {
+                        "description": "Error de Moodle",
                         "schema": {
                             "type": "string"
                         }
@@ -1302,122 +1959,165 @@ const docTemplate = `{
                 }
             }
         },
-        "/usuario/by_group/{grupoID}": {
+        "/rol-moodle/{id}/": {
             "get": {
-                "description": "Recupera la lista de usuarios que pertenecen a un grupo específico",
+                "description": "Obtiene una correspondencia de rol por ID",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "Usuario"
+                    "RolMoodle"
                 ],
-                "summary": "Obtener usuarios por ID de grupo",
+                "summary": "Obtener correspondencia de rol",
                 "parameters": [
                     {
                         "type": "integer",
-                        "description": "ID del grupo",
-                        "name": "grupoID",
+                        "description": "ID de la correspondencia",
+                        "name": "id",
                         "in": "path",
                         "required": true
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "Lista de usuarios del grupo",
+                        "description": "OK",
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/models.Usuario"
-                            }
+                            "$ref": "#/definitions/models.RolMoodle"
                         }
                     },
                     "400": {
-                        "description": "ID de Grupo inválido",
+                        "description": "ID inválido",
                         "schema": {
                             "type": "string"
                         }
                     },
-                    "500": {
-                        "description": "Error al obtener usuarios por grupo",
+                    "404": {
+                        "description": "Rol no encontrado",
                         "schema": {
                             "type": "string"
                         }
                     }
                 }
-            }
-        },
-        "/usuario/sync/{id}": {
-            "post": {
-                "description": "Sincroniza un usuario local con Moodle de forma asíncrona",
+            },
+            "put": {
+                "description": "Cambia el rol de Moodle asociado a un rol local. Las matrículas existentes conservan el rol con el que se crearon. El nombre sólo puede cambiarse si ningún usuario tiene ese rol",
+                "consumes": [
+                    "application/json"
+                ],
                 "produces": [
-                    "text/plain"
+                    "application/json"
                 ],
                 "tags": [
-                    "Usuario"
+                    "RolMoodle"
                 ],
-                "summary": "Sincronizar usuario con Moodle",
+                "summary": "Actualizar correspondencia de rol",
                 "parameters": [
                     {
                         "type": "integer",
-                        "description": "ID del usuario a sincronizar",
+                        "description": "ID de la correspondencia",
                         "name": "id",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "description": "Correspondencia de rol",
+                        "name": "rol",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.RolMoodle"
+                        }
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "Sincronización del Usuario iniciada correctamente en segundo plano",
+                        "description": "OK",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/models.RolMoodle"
                         }
                     },
                     "400": {
-                        "description": "ID inválido",
+                        "description": "Datos inválidos",
                         "schema": {
                             "type": "string"
                         }
-                    }
-                }
-            }
-        },
-        "/usuario/unsynced": {
-            "get": {
-                "description": "Recupera la lista de usuarios que aún no han sido sincronizados con Moodle, filtrados por rol",
-                "produces": [
-                    "application/json"
-                ],
+                    },
+                    "404": {
+                        "description": "Rol no encontrado",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "El rol está asignado a usuarios",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Elimina una correspondencia de rol que ningún usuario tenga asignada",
                 "tags": [
-                    "Usuario"
+                    "RolMoodle"
                 ],
-                "summary": "Obtener usuarios no sincronizados por rol",
+                "summary": "Eliminar correspondencia de rol",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "Rol a filtrar: 'Docente' o 'Alumno'",
-                        "name": "role",
-                        "in": "query",
+                        "type": "integer",
+                        "description": "ID de la correspondencia",
+                        "name": "id",
+                        "in": "path",
                         "required": true
                     }
                 ],
                 "responses": {
-                    "200": {
-                        "description": "Lista de usuarios no sincronizados",
+                    "204": {
+                        "description": "No Content",
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/models.Usuario"
-                            }
+                            "type": "string"
                         }
                     },
                     "400": {
-                        "description": "Rol no especificado o inválido",
+                        "description": "ID inválido",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Rol no encontrado",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "El rol está asignado a usuarios",
                         "schema": {
                             "type": "string"
                         }
+                    }
+                }
+            }
+        },
+        "/sync/dirty": {
+            "get": {
+                "description": "Devuelve, por entidad, los registros en estado dirty: sus datos locales ya no coinciden con los enviados a Moodle",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "sync"
+                ],
+                "summary": "Listar registros modificados sin sincronizar",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.RegistrosSync"
+                        }
                     },
                     "500": {
-                        "description": "Error al obtener usuarios no sincronizados",
+                        "description": "Internal Server Error",
                         "schema": {
                             "type": "string"
                         }
@@ -1425,48 +2125,70 @@ const docTemplate = `{
                 }
             }
         },
-        "/usuario/{id}": {
+        "/sync/discrepancias": {
             "get": {
-                "description": "Recupera un usuario específico mediante su ID",
+                "description": "Devuelve las discrepancias detectadas por las conciliaciones, en orden de detección, con las acciones que las resuelven",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "Usuario"
+                    "sync"
                 ],
-                "summary": "Obtener usuario por ID",
+                "summary": "Listar discrepancias con Moodle",
                 "parameters": [
                     {
                         "type": "integer",
-                        "description": "ID del usuario",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
+                        "description": "Filtrar por trabajo de conciliación",
+                        "name": "job_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Filtrar por entidad (programa_estudio, cuatrimestre, asignatura, usuario, grupo)",
+                        "name": "entidad",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Filtrar por estado (abierta, resuelta, obsoleta)",
+                        "name": "estado",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Número máximo de discrepancias (por defecto 50)",
+                        "name": "limit",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "Usuario encontrado",
+                        "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/models.Usuario"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.Discrepancia"
+                            }
                         }
                     },
                     "400": {
-                        "description": "ID inválido",
+                        "description": "Bad Request",
                         "schema": {
                             "type": "string"
                         }
                     },
-                    "404": {
-                        "description": "Usuario no encontrado",
+                    "500": {
+                        "description": "Internal Server Error",
                         "schema": {
                             "type": "string"
                         }
                     }
                 }
-            },
-            "put": {
-                "description": "Actualiza un usuario existente en la base de datos local",
+            }
+        },
+        "/sync/discrepancias/{id}/resolve": {
+            "post": {
+                "description": "Aplica una de las acciones que ofrece la discrepancia: push envía los datos locales a Moodle (en segundo plano mediante el outbox), pull copia en local los valores de Moodle y link solo vincula el registro local con la entidad de Moodle (en solo_en_moodle hay que indicar entidad_id); si los datos difieren, la discrepancia sigue abierta como campos_distintos",
                 "consumes": [
                     "application/json"
                 ],
@@ -1474,75 +2196,135 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "Usuario"
+                    "sync"
                 ],
-                "summary": "Actualizar usuario",
+                "summary": "Resolver discrepancia con Moodle",
                 "parameters": [
                     {
                         "type": "integer",
-                        "description": "ID del usuario a actualizar",
+                        "description": "ID de la discrepancia",
                         "name": "id",
                         "in": "path",
                         "required": true
                     },
                     {
-                        "description": "Datos actualizados del usuario",
-                        "name": "usuario",
+                        "description": "Acción",
+                        "name": "request",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/models.Usuario"
+                            "$ref": "#/definitions/handlers.ResolverDiscrepanciaRequest"
                         }
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "Usuario actualizado exitosamente",
+                        "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/models.Usuario"
+                            "$ref": "#/definitions/models.Discrepancia"
                         }
                     },
                     "400": {
-                        "description": "ID inválido o error en los datos de entrada",
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "La discrepancia no está abierta, no ofrece esa acción o el ID de Moodle ya está vinculado con otro registro",
                         "schema": {
                             "type": "string"
                         }
                     },
                     "500": {
-                        "description": "Error al actualizar el usuario",
+                        "description": "Internal Server Error",
                         "schema": {
                             "type": "string"
                         }
                     }
                 }
-            },
-            "delete": {
-                "description": "Elimina un usuario de la base de datos local",
+            }
+        },
+        "/sync/failed": {
+            "get": {
+                "description": "Devuelve, por entidad, los registros en estado failed con el error del último intento (last_sync_error)",
+                "produces": [
+                    "application/json"
+                ],
                 "tags": [
-                    "Usuario"
+                    "sync"
                 ],
-                "summary": "Eliminar usuario",
+                "summary": "Listar registros con sincronización fallida",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.RegistrosSync"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/sync/jobs": {
+            "get": {
+                "description": "Devuelve los trabajos de sincronización masiva, los más recientes primero, sin el detalle de errores",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "sync"
+                ],
+                "summary": "Listar trabajos de sincronización",
                 "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Filtrar por tipo (usuarios, cuatrimestres, asignaturas, grupos, conciliacion)",
+                        "name": "tipo",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Filtrar por estado (pendiente, en_curso, completado, fallido, cancelado)",
+                        "name": "estado",
+                        "in": "query"
+                    },
                     {
                         "type": "integer",
-                        "description": "ID del usuario a eliminar",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
+                        "description": "Número máximo de trabajos (por defecto 50)",
+                        "name": "limit",
+                        "in": "query"
                     }
                 ],
                 "responses": {
-                    "204": {
-                        "description": "Usuario eliminado exitosamente"
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.SyncJob"
+                            }
+                        }
                     },
                     "400": {
-                        "description": "ID inválido",
+                        "description": "Bad Request",
                         "schema": {
                             "type": "string"
                         }
                     },
                     "500": {
-                        "description": "Error al eliminar el usuario",
+                        "description": "Internal Server Error",
                         "schema": {
                             "type": "string"
                         }
@@ -1550,277 +2332,2077 @@ const docTemplate = `{
                 }
             }
         },
-        "/usuario/{usuarioID}/matricular/{asignaturaID}": {
-            "post": {
-                "description": "Matricula un usuario en una asignatura de forma asíncrona (crea el enrolamiento en Moodle)",
+        "/sync/jobs/{id}": {
+            "get": {
+                "description": "Devuelve el estado, los contadores y los errores por registro de un trabajo",
                 "produces": [
-                    "text/plain"
+                    "application/json"
                 ],
                 "tags": [
-                    "Usuario"
+                    "sync"
                 ],
-                "summary": "Matricular usuario en asignatura",
+                "summary": "Obtener trabajo de sincronización",
                 "parameters": [
                     {
                         "type": "integer",
-                        "description": "ID del usuario a matricular",
-                        "name": "usuarioID",
+                        "description": "ID del trabajo",
+                        "name": "id",
                         "in": "path",
                         "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.SyncJob"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
                     },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/sync/jobs/{id}/cancel": {
+            "post": {
+                "description": "Detiene un trabajo en curso: los registros ya sincronizados se conservan y el trabajo queda cancelado en cuanto la tarea se detiene (consultar GET /sync/jobs/{id})",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "sync"
+                ],
+                "summary": "Cancelar trabajo de sincronización",
+                "parameters": [
                     {
                         "type": "integer",
-                        "description": "ID de la asignatura",
-                        "name": "asignaturaID",
+                        "description": "ID del trabajo",
+                        "name": "id",
                         "in": "path",
                         "required": true
                     }
                 ],
                 "responses": {
-                    "200": {
-                        "description": "Matriculación iniciada en segundo plano",
+                    "202": {
+                        "description": "Cancelación solicitada",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/models.SyncJob"
                         }
                     },
                     "400": {
-                        "description": "ID de Usuario o Asignatura inválido",
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "El trabajo no está en curso",
                         "schema": {
                             "type": "string"
                         }
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "handlers.AuthResponse": {
-            "type": "object",
-            "properties": {
-                "expires_at": {
-                    "type": "integer",
-                    "example": 1701734400
-                },
-                "rol": {
-                    "type": "string",
-                    "example": "Alumno"
-                },
-                "token": {
-                    "type": "string",
-                    "example": "eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9..."
-                },
-                "user_id": {
-                    "type": "integer",
-                    "example": 1
-                },
-                "username": {
-                    "type": "string",
-                    "example": "jperez2025"
-                }
-            }
         },
-        "handlers.LoginRequest": {
-            "type": "object",
+        "/sync/outbox": {
+            "get": {
+                "description": "Devuelve los cambios locales registrados para llevarlos a Moodle, los más recientes primero, con su estado de entrega",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "sync"
+                ],
+                "summary": "Listar eventos de propagación a Moodle",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Filtrar por estado (pendiente, enviado, fallido)",
+                        "name": "estado",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Número máximo de eventos (por defecto 50)",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.OutboxEvent"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/sync/outbox/{id}/retry": {
+            "post": {
+                "description": "Deja pendiente un evento que agotó sus reintentos para que el despachador lo entregue de nuevo. Mientras está fallido retiene los eventos posteriores del mismo registro",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "sync"
+                ],
+                "summary": "Reintentar evento fallido",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "ID del evento",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.OutboxEvent"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "El evento no está fallido",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/sync/reconcile": {
+            "post": {
+                "description": "Compara programas, cuatrimestres, asignaturas, usuarios y grupos con las categorías, cursos, usuarios y grupos de Moodle y guarda las diferencias como discrepancias. Se ejecuta en segundo plano como trabajo de sincronización; su avance se consulta en GET /sync/jobs/{id} y las discrepancias en GET /sync/discrepancias?job_id={id}",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "sync"
+                ],
+                "summary": "Conciliar la BD local con Moodle",
+                "responses": {
+                    "202": {
+                        "description": "Trabajo registrado",
+                        "schema": {
+                            "$ref": "#/definitions/models.SyncJob"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/usuario": {
+            "get": {
+                "description": "Recupera la lista completa de usuarios (Docentes y Alumnos)",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Usuario"
+                ],
+                "summary": "Obtener todos los usuarios",
+                "responses": {
+                    "200": {
+                        "description": "Lista de usuarios",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.Usuario"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Error al obtener usuarios",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Crea un nuevo usuario (Docente o Alumno) en la base de datos local con validaciones de contraseña, unicidad y rol",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Usuario"
+                ],
+                "summary": "Crear un nuevo usuario",
+                "parameters": [
+                    {
+                        "description": "Datos del usuario a crear (Password debe tener mín. 8 caracteres, mayúscula, número y símbolo)",
+                        "name": "usuario",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.Usuario"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Usuario creado exitosamente",
+                        "schema": {
+                            "$ref": "#/definitions/models.Usuario"
+                        }
+                    },
+                    "400": {
+                        "description": "Error en los datos de entrada, campos obligatorios faltantes o contraseña inválida",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "Ya existe un usuario con el mismo Username, Email o Matrícula",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Error interno del servidor al crear el usuario",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/usuario/bulk-sync": {
+            "post": {
+                "description": "Sincroniza todos los usuarios de un rol específico (Docente, Alumno u otro rol definido en /rol-moodle) con Moodle de forma asíncrona",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Usuario"
+                ],
+                "summary": "Sincronización masiva de usuarios por rol",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Rol a sincronizar: un rol definido en /rol-moodle (ej: 'Docente' o 'Alumno')",
+                        "name": "role",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Trabajo de sincronización registrado; su avance se consulta en GET /sync/jobs/{id}",
+                        "schema": {
+                            "$ref": "#/definitions/models.SyncJob"
+                        }
+                    },
+                    "400": {
+                        "description": "Rol inválido o no especificado",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/usuario/by_group/{grupoID}": {
+            "get": {
+                "description": "Recupera la lista de usuarios que pertenecen a un grupo específico",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Usuario"
+                ],
+                "summary": "Obtener usuarios por ID de grupo",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "ID del grupo",
+                        "name": "grupoID",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Lista de usuarios del grupo",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.Usuario"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "ID de Grupo inválido",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Error al obtener usuarios por grupo",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/usuario/enrol/{usuarioID}/{asignaturaID}": {
+            "post": {
+                "description": "Guarda la matrícula local y la envía a Moodle en segundo plano (enrol_manual_enrol_users). El usuario y la asignatura deben estar ya en Moodle",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "text/plain"
+                ],
+                "tags": [
+                    "Usuario"
+                ],
+                "summary": "Matricular usuario en asignatura",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "ID del usuario a matricular",
+                        "name": "usuarioID",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "ID de la asignatura",
+                        "name": "asignaturaID",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Periodo de matrícula, suspensión y rol para este curso (opcionales)",
+                        "name": "opciones",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/services.OpcionesMatricula"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Matrícula registrada; se enviará a Moodle en segundo plano",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "ID de Usuario o Asignatura inválido, u opciones de matrícula inválidas",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Usuario o asignatura no encontrados",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "El usuario o la asignatura todavía no están en Moodle",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Error al guardar la matrícula",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Elimina la matrícula local y la pertenencia a los grupos de la asignatura; la baja en Moodle (enrol_manual_unenrol_users) se envía en segundo plano",
+                "tags": [
+                    "Usuario"
+                ],
+                "summary": "Dar de baja usuario de asignatura",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "ID del usuario",
+                        "name": "usuarioID",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "ID de la asignatura",
+                        "name": "asignaturaID",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "Usuario dado de baja"
+                    },
+                    "400": {
+                        "description": "ID de Usuario o Asignatura inválido",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Matrícula no encontrada",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Error al eliminar la matrícula local",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/usuario/enrol/{usuarioID}/{asignaturaID}/reactivate": {
+            "post": {
+                "description": "Reactiva una matrícula suspendida y envía el cambio a Moodle en segundo plano (enrol_manual_enrol_users con suspend=0)",
+                "tags": [
+                    "Usuario"
+                ],
+                "summary": "Reactivar matrícula",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "ID del usuario",
+                        "name": "usuarioID",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "ID de la asignatura",
+                        "name": "asignaturaID",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "Matrícula reactivada"
+                    },
+                    "400": {
+                        "description": "ID de Usuario o Asignatura inválido",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Matrícula no encontrada",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Error al guardar la matrícula",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/usuario/enrol/{usuarioID}/{asignaturaID}/suspend": {
+            "post": {
+                "description": "Suspende la matrícula local y envía el cambio a Moodle en segundo plano (enrol_manual_enrol_users con suspend=1); el usuario conserva su historial pero pierde el acceso",
+                "tags": [
+                    "Usuario"
+                ],
+                "summary": "Suspender matrícula",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "ID del usuario",
+                        "name": "usuarioID",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "ID de la asignatura",
+                        "name": "asignaturaID",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "Matrícula suspendida"
+                    },
+                    "400": {
+                        "description": "ID de Usuario o Asignatura inválido",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Matrícula no encontrada",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Error al guardar la matrícula",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/usuario/sync/{id}": {
+            "post": {
+                "description": "Sincroniza un usuario local con Moodle de forma asíncrona",
+                "produces": [
+                    "text/plain"
+                ],
+                "tags": [
+                    "Usuario"
+                ],
+                "summary": "Sincronizar usuario con Moodle",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "ID del usuario a sincronizar",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Sincronización del Usuario iniciada correctamente en segundo plano",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "ID inválido",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/usuario/unsynced": {
+            "get": {
+                "description": "Recupera la lista de usuarios que aún no han sido sincronizados con Moodle, filtrados por rol",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Usuario"
+                ],
+                "summary": "Obtener usuarios no sincronizados por rol",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Rol a filtrar: un rol definido en /rol-moodle (ej: 'Docente' o 'Alumno')",
+                        "name": "role",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Lista de usuarios no sincronizados",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.Usuario"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Rol no especificado o inválido",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Error al obtener usuarios no sincronizados",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/usuario/{id}": {
+            "get": {
+                "description": "Recupera un usuario específico mediante su ID",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Usuario"
+                ],
+                "summary": "Obtener usuario por ID",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "ID del usuario",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Usuario encontrado",
+                        "schema": {
+                            "$ref": "#/definitions/models.Usuario"
+                        }
+                    },
+                    "400": {
+                        "description": "ID inválido",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Usuario no encontrado",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Elimina un usuario de la base de datos local y, con propagate=true, lo suspende o elimina en Moodle según MOODLE_USER_DELETE_POLICY. La operación en Moodle se hace en segundo plano mediante el outbox",
+                "tags": [
+                    "Usuario"
+                ],
+                "summary": "Eliminar usuario",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "ID del usuario a eliminar",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Eliminar también en Moodle (por defecto MOODLE_DELETE_PROPAGATE)",
+                        "name": "propagate",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "Usuario eliminado exitosamente"
+                    },
+                    "400": {
+                        "description": "ID inválido",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Usuario no encontrado",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Error al eliminar el usuario",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/usuario/{id}/calificaciones": {
+            "get": {
+                "description": "Devuelve las calificaciones guardadas localmente del usuario en todas sus asignaturas (incluido el total de cada curso), con la fecha de la última importación",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Calificaciones"
+                ],
+                "summary": "Calificaciones de un usuario",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "ID del usuario",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.Calificacion"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "ID inválido",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Error al obtener calificaciones",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/usuario/{id}/calificaciones/import": {
+            "post": {
+                "description": "Consulta gradereport_user_get_grade_items en cada asignatura en la que el usuario está matriculado como alumno y sustituye sus calificaciones locales",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Calificaciones"
+                ],
+                "summary": "Importar calificaciones de un usuario",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "ID del usuario",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/services.ImportacionCalificaciones"
+                        }
+                    },
+                    "400": {
+                        "description": "ID inválido",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Usuario no encontrado",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "501": {
+                        "description": "Moodle no tiene habilitada la función de calificaciones",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "502": {
+                        "description": "Error de Moodle",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/usuario/{id}/finalizacion": {
+            "get": {
+                "description": "Devuelve, para cada asignatura en la que el usuario está matriculado como alumno, si completó el curso en Moodle y cuándo, según la última importación",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Finalizacion"
+                ],
+                "summary": "Finalización de cursos de un usuario",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "ID del usuario",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.Finalizacion"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "ID inválido",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Error al obtener la finalización",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "handlers.AuthResponse": {
+            "type": "object",
+            "properties": {
+                "expires_at": {
+                    "type": "integer",
+                    "example": 1701734400
+                },
+                "rol": {
+                    "type": "string",
+                    "example": "Alumno"
+                },
+                "token": {
+                    "type": "string",
+                    "example": "eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9..."
+                },
+                "user_id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "username": {
+                    "type": "string",
+                    "example": "jperez2025"
+                }
+            }
+        },
+        "handlers.LoginRequest": {
+            "type": "object",
+            "properties": {
+                "password": {
+                    "type": "string",
+                    "example": "Segura123#"
+                },
+                "username": {
+                    "type": "string",
+                    "example": "jperez2025"
+                }
+            }
+        },
+        "handlers.RegisterRequest": {
+            "type": "object",
+            "properties": {
+                "email": {
+                    "type": "string",
+                    "example": "juan.perez@universidad.edu.mx"
+                },
+                "first_name": {
+                    "type": "string",
+                    "example": "Juan"
+                },
+                "last_name": {
+                    "type": "string",
+                    "example": "Pérez García"
+                },
+                "matricula": {
+                    "type": "string",
+                    "example": "20250001"
+                },
+                "password": {
+                    "type": "string",
+                    "example": "Segura123#"
+                },
+                "rol": {
+                    "type": "string",
+                    "example": "Alumno"
+                },
+                "username": {
+                    "type": "string",
+                    "example": "jperez2025"
+                }
+            }
+        },
+        "handlers.ResolverDiscrepanciaRequest": {
+            "type": "object",
+            "properties": {
+                "accion": {
+                    "description": "push, pull o link",
+                    "type": "string",
+                    "example": "pull"
+                },
+                "entidad_id": {
+                    "description": "Registro local; solo para vincular una discrepancia solo_en_moodle",
+                    "type": "integer",
+                    "example": 10
+                }
+            }
+        },
+        "models.Asignatura": {
+            "description": "Modelo de Asignatura (Curso) utilizado en la API y sincronizado con Moodle.",
+            "type": "object",
+            "properties": {
+                "cuatrimestre_id": {
+                    "description": "Relación de Pertenencia (Clave Foránea)",
+                    "type": "integer",
+                    "example": 5
+                },
+                "fecha_fin": {
+                    "type": "string",
+                    "example": "2025-04-30T23:59:59Z"
+                },
+                "fecha_inicio": {
+                    "type": "string",
+                    "example": "2025-01-06T00:00:00Z"
+                },
+                "formato": {
+                    "description": "Configuración del curso en Moodle. Si no se indica se toma del Cuatrimestre (visible por defecto).",
+                    "type": "string",
+                    "example": "weeks"
+                },
+                "id_externo": {
+                    "description": "Moodle: idnumber",
+                    "type": "string",
+                    "example": "ASIG-POO1-2025"
+                },
+                "id_moodle": {
+                    "description": "Sincronización con Moodle",
+                    "type": "integer",
+                    "example": 1234
+                },
+                "last_sync_error": {
+                    "type": "string"
+                },
+                "last_synced_at": {
+                    "type": "string"
+                },
+                "nombre_completo": {
+                    "description": "Datos del Curso (Obligatorios para Moodle)",
+                    "type": "string",
+                    "example": "Programación Orientada a Objetos I"
+                },
+                "nombre_corto": {
+                    "description": "Moodle: shortname",
+                    "type": "string",
+                    "example": "POO1-2025-A"
+                },
+                "payload_hash": {
+                    "type": "string"
+                },
+                "plantilla_moodle_id": {
+                    "description": "Curso de Moodle que sirve de plantilla: el curso se crea duplicándolo (core_course_duplicate_course).",
+                    "type": "integer",
+                    "example": 42
+                },
+                "resumen": {
+                    "description": "Información adicional (Opcional)",
+                    "type": "string",
+                    "example": "Curso introductorio de programación orientada a objetos que cubre conceptos fundamentales como clases, objetos, herencia y polimorfismo."
+                },
+                "sync_status": {
+                    "type": "string",
+                    "example": "synced"
+                },
+                "visible": {
+                    "type": "boolean",
+                    "example": true
+                }
+            }
+        },
+        "models.Calificacion": {
+            "description": "Calificación importada de Moodle para una matrícula. Se conserva aunque la matrícula se elimine, para el expediente oficial.",
+            "type": "object",
+            "properties": {
+                "asignatura_id": {
+                    "type": "integer",
+                    "example": 10
+                },
+                "fecha_calificacion": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "importada_en": {
+                    "type": "string"
+                },
+                "item_moodle_id": {
+                    "description": "Elemento de calificación en Moodle",
+                    "type": "integer",
+                    "example": 345
+                },
+                "matricula_id": {
+                    "description": "Matrícula de la que procede. No es clave foránea: la calificación sobrevive a la baja del alumno.",
+                    "type": "integer",
+                    "example": 12
+                },
+                "maximo": {
+                    "type": "number",
+                    "example": 10
+                },
+                "minimo": {
+                    "type": "number",
+                    "example": 0
+                },
+                "modulo": {
+                    "type": "string",
+                    "example": "assign"
+                },
+                "nombre": {
+                    "type": "string",
+                    "example": "Tarea 1"
+                },
+                "porcentaje": {
+                    "type": "string",
+                    "example": "85.00 %"
+                },
+                "retroalimentacion": {
+                    "type": "string"
+                },
+                "tipo": {
+                    "type": "string",
+                    "example": "mod"
+                },
+                "total_curso": {
+                    "type": "boolean",
+                    "example": false
+                },
+                "usuario_id": {
+                    "type": "integer",
+                    "example": 25
+                },
+                "valor": {
+                    "description": "Calificación obtenida",
+                    "type": "number",
+                    "example": 8.5
+                },
+                "valor_formateado": {
+                    "type": "string",
+                    "example": "8.50"
+                }
+            }
+        },
+        "models.Cuatrimestre": {
+            "description": "Modelo de Cuatrimestre utilizado en la API y sincronizado como subcategoría en Moodle.",
+            "type": "object",
             "properties": {
-                "password": {
+                "cohort_moodle_id": {
+                    "description": "Cohorte opcional con los alumnos matriculados en las asignaturas del cuatrimestre",
+                    "type": "integer",
+                    "example": 78
+                },
+                "descripcion": {
+                    "type": "string",
+                    "example": "Cuatrimestre correspondiente al periodo enero-abril 2025"
+                },
+                "fecha_fin": {
+                    "type": "string",
+                    "example": "2025-04-30T23:59:59Z"
+                },
+                "fecha_inicio": {
+                    "description": "Valores por defecto de los cursos de Moodle de sus asignaturas",
+                    "type": "string",
+                    "example": "2025-01-06T00:00:00Z"
+                },
+                "formato_curso": {
+                    "type": "string",
+                    "example": "topics"
+                },
+                "id_externo": {
+                    "type": "string",
+                    "example": "CUATR-2025-01"
+                },
+                "id_moodle": {
+                    "type": "integer",
+                    "example": 5678
+                },
+                "last_sync_error": {
+                    "type": "string"
+                },
+                "last_synced_at": {
+                    "type": "string"
+                },
+                "nombre": {
+                    "type": "string",
+                    "example": "Primer Cuatrimestre 2025"
+                },
+                "payload_hash": {
+                    "type": "string"
+                },
+                "programa_estudio_id": {
+                    "description": "Campo de la Clave Foránea",
+                    "type": "integer",
+                    "example": 3
+                },
+                "sync_status": {
+                    "type": "string",
+                    "example": "synced"
+                }
+            }
+        },
+        "models.DiferenciaCampo": {
+            "type": "object",
+            "properties": {
+                "campo": {
+                    "type": "string",
+                    "example": "nombre_completo"
+                },
+                "local": {
+                    "type": "string",
+                    "example": "Programación Orientada a Objetos I"
+                },
+                "moodle": {
+                    "type": "string",
+                    "example": "POO I (2025)"
+                }
+            }
+        },
+        "models.Discrepancia": {
+            "description": "Diferencia entre la BD local y Moodle, con las acciones que la resuelven.",
+            "type": "object",
+            "properties": {
+                "accion": {
+                    "type": "string",
+                    "example": "pull"
+                },
+                "acciones": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "push",
+                        "pull"
+                    ]
+                },
+                "creado_en": {
+                    "type": "string"
+                },
+                "diferencias": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.DiferenciaCampo"
+                    }
+                },
+                "entidad": {
+                    "type": "string",
+                    "example": "asignatura"
+                },
+                "entidad_id": {
+                    "type": "integer",
+                    "example": 10
+                },
+                "estado": {
+                    "type": "string",
+                    "example": "abierta"
+                },
+                "id": {
+                    "type": "integer",
+                    "example": 15
+                },
+                "job_id": {
+                    "type": "integer",
+                    "example": 9
+                },
+                "moodle_id": {
+                    "type": "integer",
+                    "example": 1234
+                },
+                "nombre": {
+                    "type": "string",
+                    "example": "POO1-2025-A"
+                },
+                "resuelta_en": {
+                    "type": "string"
+                },
+                "tipo": {
+                    "type": "string",
+                    "example": "campos_distintos"
+                }
+            }
+        },
+        "models.Finalizacion": {
+            "description": "Estado de finalización de una asignatura para una matrícula de alumno. Se conserva aunque la matrícula se elimine.",
+            "type": "object",
+            "properties": {
+                "asignatura_id": {
+                    "type": "integer",
+                    "example": 10
+                },
+                "completada": {
+                    "type": "boolean",
+                    "example": true
+                },
+                "criterios_completados": {
+                    "type": "integer",
+                    "example": 2
+                },
+                "criterios_totales": {
+                    "type": "integer",
+                    "example": 3
+                },
+                "fecha_finalizacion": {
+                    "description": "Moodle no devuelve la fecha de finalización del curso: se usa la del último criterio completado\no, si no tiene, el momento de la primera importación en que apareció como completado.",
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "importada_en": {
+                    "type": "string"
+                },
+                "matricula_id": {
+                    "description": "Matrícula de la que procede. No es clave foránea: el registro sobrevive a la baja del alumno.",
+                    "type": "integer",
+                    "example": 12
+                },
+                "usuario_id": {
+                    "type": "integer",
+                    "example": 25
+                }
+            }
+        },
+        "models.Grupo": {
+            "description": "Modelo de Grupo utilizado en la API y sincronizado con Moodle.",
+            "type": "object",
+            "properties": {
+                "course_id": {
+                    "description": "ID de la Asignatura/Curso local",
+                    "type": "integer",
+                    "example": 12
+                },
+                "description": {
+                    "type": "string",
+                    "example": "Grupo de clases matutinas para el curso de Programación"
+                },
+                "descriptionformat": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "enrolmentkey": {
+                    "type": "string",
+                    "example": "claveA2025"
+                },
+                "id_moodle": {
+                    "description": "ID del Grupo devuelto por Moodle",
+                    "type": "integer",
+                    "example": 888
+                },
+                "last_sync_error": {
+                    "type": "string"
+                },
+                "last_synced_at": {
+                    "type": "string"
+                },
+                "nombre": {
+                    "type": "string",
+                    "example": "Grupo A - Turno Matutino"
+                },
+                "payload_hash": {
+                    "type": "string"
+                },
+                "sync_status": {
+                    "type": "string",
+                    "example": "synced"
+                },
+                "visibility": {
+                    "type": "integer",
+                    "example": 0
+                }
+            }
+        },
+        "models.Matricula": {
+            "description": "Modelo de Matricula que representa el enrolamiento de un usuario en una asignatura con un rol específico en Moodle.",
+            "type": "object",
+            "properties": {
+                "asignatura_id": {
+                    "description": "🛑 FKs Locales (RELACIONES)",
+                    "type": "integer",
+                    "example": 10
+                },
+                "course_moodle_id": {
+                    "description": "Datos de Moodle (Claves de sincronización)\nCreamos un índice único compuesto para evitar dobles enrolamientos.",
+                    "type": "integer",
+                    "example": 1234
+                },
+                "id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "last_sync_error": {
+                    "type": "string"
+                },
+                "last_synced_at": {
+                    "type": "string"
+                },
+                "payload_hash": {
+                    "type": "string"
+                },
+                "role_id": {
+                    "type": "integer",
+                    "example": 5
+                },
+                "suspended": {
+                    "description": "Suspensión: la matrícula se conserva (con su historial) pero el usuario no puede acceder al curso",
+                    "type": "boolean",
+                    "example": false
+                },
+                "sync_status": {
+                    "type": "string",
+                    "example": "synced"
+                },
+                "timeend": {
+                    "type": "integer",
+                    "example": 1719792000
+                },
+                "timestart": {
+                    "description": "Tiempos de enrolamiento",
+                    "type": "integer",
+                    "example": 1704067200
+                },
+                "user_moodle_id": {
+                    "type": "integer",
+                    "example": 5678
+                },
+                "usuario_id": {
+                    "type": "integer",
+                    "example": 25
+                }
+            }
+        },
+        "models.OutboxEvent": {
+            "description": "Cambio local registrado para propagarlo a Moodle en segundo plano.",
+            "type": "object",
+            "properties": {
+                "creado_en": {
+                    "type": "string"
+                },
+                "entidad": {
+                    "type": "string",
+                    "example": "asignatura"
+                },
+                "entidad_id": {
+                    "type": "integer",
+                    "example": 10
+                },
+                "enviado_en": {
+                    "type": "string"
+                },
+                "estado": {
+                    "type": "string",
+                    "example": "pendiente"
+                },
+                "id": {
+                    "type": "integer",
+                    "example": 42
+                },
+                "intentos": {
+                    "type": "integer",
+                    "example": 0
+                },
+                "moodle_id": {
+                    "description": "Solo en delete: el registro local ya no existe y esto es lo que hay que eliminar en Moodle",
+                    "type": "integer",
+                    "example": 1234
+                },
+                "operacion": {
+                    "type": "string",
+                    "example": "update"
+                },
+                "siguiente_intento": {
+                    "type": "string"
+                },
+                "ultimo_error": {
+                    "type": "string"
+                },
+                "user_moodle_id": {
+                    "description": "Solo en bajas de matrículas y grupos: el usuario a quitar del curso o grupo MoodleID",
+                    "type": "integer",
+                    "example": 77
+                }
+            }
+        },
+        "models.ProgramaEstudio": {
+            "description": "Modelo de Programa de Estudio utilizado en la API y sincronizado como categoría padre en Moodle.",
+            "type": "object",
+            "properties": {
+                "cohort_moodle_id": {
+                    "type": "integer",
+                    "example": 77
+                },
+                "descripcion": {
+                    "type": "string",
+                    "example": "Programa de estudios enfocado en el desarrollo de software y sistemas de información"
+                },
+                "id_externo": {
+                    "type": "string",
+                    "example": "PROG-ISC-2025"
+                },
+                "id_moodle": {
+                    "type": "integer",
+                    "example": 9012
+                },
+                "last_sync_error": {
+                    "type": "string"
+                },
+                "last_synced_at": {
+                    "type": "string"
+                },
+                "nombre": {
+                    "type": "string",
+                    "example": "Ingeniería en Sistemas Computacionales"
+                },
+                "payload_hash": {
+                    "type": "string"
+                },
+                "sync_status": {
+                    "type": "string",
+                    "example": "synced"
+                }
+            }
+        },
+        "models.RolMoodle": {
+            "description": "Correspondencia entre un rol local y un rol de Moodle.",
+            "type": "object",
+            "properties": {
+                "descripcion": {
+                    "type": "string",
+                    "example": "Estudiante del curso"
+                },
+                "estudiante": {
+                    "description": "Estudiante indica que las matrículas con este rol son de alumnos (calificaciones, finalización, cohortes).",
+                    "type": "boolean",
+                    "example": true
+                },
+                "id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "moodle_role_id": {
+                    "description": "MoodleRoleID 0 significa que aún no se conoce: se resuelve por Shortname al validar contra Moodle.",
+                    "type": "integer",
+                    "example": 5
+                },
+                "nombre": {
+                    "type": "string",
+                    "example": "Alumno"
+                },
+                "shortname": {
+                    "type": "string",
+                    "example": "student"
+                }
+            }
+        },
+        "models.SyncJob": {
+            "description": "Trabajo de sincronización masiva: su estado y sus contadores se actualizan mientras avanza.",
+            "type": "object",
+            "properties": {
+                "creado_en": {
+                    "type": "string"
+                },
+                "detalle": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.SyncJobError"
+                    }
+                },
+                "errores": {
+                    "type": "integer",
+                    "example": 2
+                },
+                "estado": {
+                    "type": "string",
+                    "example": "en_curso"
+                },
+                "exitosos": {
+                    "type": "integer",
+                    "example": 118
+                },
+                "finalizado_en": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer",
+                    "example": 7
+                },
+                "iniciado_en": {
+                    "type": "string"
+                },
+                "mensaje": {
+                    "type": "string"
+                },
+                "parametros": {
                     "type": "string",
-                    "example": "Segura123#"
+                    "example": "role=Alumno"
                 },
-                "username": {
+                "tipo": {
                     "type": "string",
-                    "example": "jperez2025"
+                    "example": "usuarios"
+                },
+                "total": {
+                    "type": "integer",
+                    "example": 120
                 }
             }
         },
-        "handlers.RegisterRequest": {
+        "models.SyncJobError": {
+            "type": "object",
+            "properties": {
+                "entidad": {
+                    "type": "string",
+                    "example": "usuario"
+                },
+                "entidad_id": {
+                    "type": "integer",
+                    "example": 42
+                },
+                "fecha": {
+                    "type": "string"
+                },
+                "mensaje": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.Usuario": {
+            "description": "Modelo de Usuario utilizado en la API y sincronizado como usuario en Moodle. Puede ser Docente o Alumno.",
             "type": "object",
             "properties": {
                 "email": {
+                    "description": "OBLIGATORIO",
                     "type": "string",
                     "example": "juan.perez@universidad.edu.mx"
                 },
                 "first_name": {
+                    "description": "OBLIGATORIO",
                     "type": "string",
                     "example": "Juan"
                 },
+                "id_moodle": {
+                    "description": "ID devuelto por Moodle",
+                    "type": "integer",
+                    "example": 3456
+                },
                 "last_name": {
+                    "description": "OBLIGATORIO",
                     "type": "string",
                     "example": "Pérez García"
                 },
+                "last_sync_error": {
+                    "type": "string"
+                },
+                "last_synced_at": {
+                    "type": "string"
+                },
                 "matricula": {
+                    "description": "Uso como 'idnumber'",
                     "type": "string",
                     "example": "20250001"
                 },
                 "password": {
+                    "description": "OBLIGATORIO",
                     "type": "string",
                     "example": "Segura123#"
                 },
+                "payload_hash": {
+                    "type": "string"
+                },
                 "rol": {
+                    "description": "'Docente', 'Alumno' u otro rol de /rol-moodle",
                     "type": "string",
                     "example": "Alumno"
                 },
+                "sync_status": {
+                    "type": "string",
+                    "example": "synced"
+                },
                 "username": {
+                    "description": "OBLIGATORIO",
                     "type": "string",
                     "example": "jperez2025"
                 }
             }
         },
-        "models.Asignatura": {
-            "description": "Modelo de Asignatura (Curso) utilizado en la API y sincronizado con Moodle.",
+        "moodle.LimiterStats": {
             "type": "object",
             "properties": {
-                "cuatrimestre_id": {
-                    "description": "Relación de Pertenencia (Clave Foránea)",
-                    "type": "integer",
-                    "example": 5
+                "avg_wait_ms": {
+                    "description": "Espera media por llamada",
+                    "type": "number"
                 },
-                "id_externo": {
-                    "description": "Moodle: idnumber",
-                    "type": "string",
-                    "example": "ASIG-POO1-2025"
+                "burst": {
+                    "type": "integer"
                 },
-                "id_moodle": {
-                    "description": "Sincronización con Moodle",
-                    "type": "integer",
-                    "example": 1234
+                "calls": {
+                    "description": "Llamadas que pasaron por el limitador",
+                    "type": "integer"
                 },
-                "nombre_completo": {
-                    "description": "Datos del Curso (Obligatorios para Moodle)",
-                    "type": "string",
-                    "example": "Programación Orientada a Objetos I"
+                "in_flight": {
+                    "type": "integer"
                 },
-                "nombre_corto": {
-                    "description": "Moodle: shortname",
-                    "type": "string",
-                    "example": "POO1-2025-A"
+                "max_concurrent": {
+                    "type": "integer"
                 },
-                "resumen": {
-                    "description": "Información adicional (Opcional)",
-                    "type": "string",
-                    "example": "Curso introductorio de programación orientada a objetos que cubre conceptos fundamentales como clases, objetos, herencia y polimorfismo."
+                "max_wait_ms": {
+                    "description": "Espera máxima observada",
+                    "type": "number"
+                },
+                "rate_per_second": {
+                    "type": "number"
+                },
+                "total_wait_ms": {
+                    "description": "Tiempo total esperado",
+                    "type": "number"
+                },
+                "waited_calls": {
+                    "description": "Llamadas que tuvieron que esperar",
+                    "type": "integer"
                 }
             }
         },
-        "models.Cuatrimestre": {
-            "description": "Modelo de Cuatrimestre utilizado en la API y sincronizado como subcategoría en Moodle.",
+        "moodle.Role": {
             "type": "object",
             "properties": {
-                "descripcion": {
-                    "type": "string",
-                    "example": "Cuatrimestre correspondiente al periodo enero-abril 2025"
+                "archetype": {
+                    "type": "string"
                 },
-                "id_externo": {
-                    "type": "string",
-                    "example": "CUATR-2025-01"
+                "id": {
+                    "type": "integer"
                 },
-                "id_moodle": {
-                    "type": "integer",
-                    "example": 5678
+                "name": {
+                    "type": "string"
                 },
-                "nombre": {
+                "shortname": {
+                    "type": "string"
+                },
+                "sortorder": {
+                    "type": "integer"
+                }
+            }
+        },
+        "moodle.SiteReport": {
+            "type": "object",
+            "properties": {
+                "checked_at": {
+                    "type": "string"
+                },
+                "function_count": {
+                    "type": "integer"
+                },
+                "missing_optional": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "missing_required": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "release": {
+                    "type": "string"
+                },
+                "sitename": {
+                    "type": "string"
+                },
+                "username": {
+                    "type": "string"
+                },
+                "version": {
+                    "type": "string"
+                }
+            }
+        },
+        "moodle.Warning": {
+            "type": "object",
+            "properties": {
+                "item": {
+                    "type": "string"
+                },
+                "itemid": {
+                    "type": "integer"
+                },
+                "message": {
+                    "type": "string"
+                },
+                "warningcode": {
+                    "type": "string"
+                }
+            }
+        },
+        "services.CohortSyncResult": {
+            "type": "object",
+            "properties": {
+                "cohort_id": {
+                    "type": "integer"
+                },
+                "created": {
+                    "description": "La cohorte se creó (o vinculó) en esta llamada",
+                    "type": "boolean"
+                },
+                "members": {
+                    "description": "Alumnos enviados a la cohorte",
+                    "type": "integer"
+                },
+                "removed": {
+                    "description": "Miembros quitados por no estar ya matriculados",
+                    "type": "integer"
+                },
+                "skipped": {
+                    "description": "Alumnos sin ID_Moodle (aún no sincronizados)",
+                    "type": "integer"
+                },
+                "warnings": {
+                    "description": "Miembros que Moodle no pudo añadir",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/moodle.Warning"
+                    }
+                }
+            }
+        },
+        "services.ErrorNivelSync": {
+            "type": "object",
+            "properties": {
+                "error": {
                     "type": "string",
-                    "example": "Primer Cuatrimestre 2025"
+                    "example": "fallo al crear Grupo en Moodle: ..."
                 },
-                "programa_estudio_id": {
-                    "description": "Campo de la Clave Foránea",
+                "id": {
+                    "description": "ID local (en matrículas, el del usuario)",
                     "type": "integer",
-                    "example": 3
+                    "example": 12
                 }
             }
         },
-        "models.Grupo": {
-            "description": "Modelo de Grupo utilizado en la API y sincronizado con Moodle.",
+        "services.FinalizacionAlumno": {
             "type": "object",
             "properties": {
-                "course_id": {
-                    "description": "ID de la Asignatura/Curso local",
+                "completadas": {
+                    "description": "Asignaturas completadas según la última importación",
+                    "type": "integer"
+                },
+                "completo": {
+                    "description": "Completó todas sus asignaturas del cuatrimestre",
+                    "type": "boolean"
+                },
+                "matriculado": {
+                    "description": "Asignaturas del cuatrimestre en las que está matriculado",
+                    "type": "integer"
+                },
+                "pendientes": {
+                    "description": "IDs de las asignaturas aún no completadas",
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
+                "username": {
+                    "type": "string"
+                },
+                "usuario_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "services.ImportacionCalificaciones": {
+            "type": "object",
+            "properties": {
+                "calificaciones": {
+                    "description": "Elementos de calificación guardados",
+                    "type": "integer"
+                },
+                "errores": {
+                    "description": "Matrículas que no se pudieron importar",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "matriculas": {
+                    "description": "Matrículas de alumnos actualizadas",
+                    "type": "integer"
+                },
+                "sin_datos": {
+                    "description": "Matrículas para las que Moodle no devolvió calificaciones",
+                    "type": "integer"
+                }
+            }
+        },
+        "services.ImportacionFinalizacion": {
+            "type": "object",
+            "properties": {
+                "completadas": {
+                    "description": "De ellas, las que tienen el curso completado",
+                    "type": "integer"
+                },
+                "errores": {
+                    "description": "Matrículas que no se pudieron importar",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "matriculas": {
+                    "description": "Matrículas de alumnos actualizadas",
+                    "type": "integer"
+                },
+                "sin_datos": {
+                    "description": "Cursos sin seguimiento de finalización o alumnos sin seguimiento",
+                    "type": "integer"
+                }
+            }
+        },
+        "services.InformeFinalizacionCuatrimestre": {
+            "type": "object",
+            "properties": {
+                "alumnos": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/services.FinalizacionAlumno"
+                    }
+                },
+                "completos": {
+                    "description": "Alumnos que completaron todas sus asignaturas",
+                    "type": "integer"
+                },
+                "cuatrimestre_id": {
+                    "type": "integer"
+                },
+                "nombre": {
+                    "type": "string"
+                }
+            }
+        },
+        "services.NivelSync": {
+            "type": "object",
+            "properties": {
+                "errores": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/services.ErrorNivelSync"
+                    }
+                },
+                "fallidos": {
+                    "description": "Falló la llamada a Moodle",
                     "type": "integer",
-                    "example": 12
+                    "example": 1
                 },
-                "description": {
+                "nivel": {
                     "type": "string",
-                    "example": "Grupo de clases matutinas para el curso de Programación"
+                    "example": "asignaturas"
                 },
-                "descriptionformat": {
+                "omitidos": {
+                    "description": "No se intentaron porque su padre no está en Moodle",
                     "type": "integer",
-                    "example": 1
+                    "example": 0
                 },
-                "id_moodle": {
-                    "description": "ID del Grupo devuelto por Moodle",
+                "sincronizados": {
+                    "description": "En Moodle al terminar",
                     "type": "integer",
-                    "example": 888
+                    "example": 6
                 },
-                "nombre": {
-                    "type": "string",
-                    "example": "Grupo A - Turno Matutino"
+                "total": {
+                    "type": "integer",
+                    "example": 7
                 }
             }
         },
-        "models.ProgramaEstudio": {
-            "description": "Modelo de Programa de Estudio utilizado en la API y sincronizado como categoría padre en Moodle.",
+        "services.OpcionesMatricula": {
             "type": "object",
             "properties": {
-                "descripcion": {
+                "rol": {
+                    "description": "Rol local para este curso (por defecto, el Rol del usuario)",
                     "type": "string",
-                    "example": "Programa de estudios enfocado en el desarrollo de software y sistemas de información"
+                    "example": "Docente sin edición"
                 },
-                "id_externo": {
-                    "type": "string",
-                    "example": "PROG-ISC-2025"
+                "suspended": {
+                    "description": "Matricular ya suspendido",
+                    "type": "boolean",
+                    "example": false
                 },
-                "id_moodle": {
+                "timeend": {
+                    "description": "Fin del acceso (UNIX timestamp)",
                     "type": "integer",
-                    "example": 9012
+                    "example": 1719792000
                 },
-                "nombre": {
-                    "type": "string",
-                    "example": "Ingeniería en Sistemas Computacionales"
+                "timestart": {
+                    "description": "Inicio del acceso (UNIX timestamp)",
+                    "type": "integer",
+                    "example": 1704067200
                 }
             }
         },
-        "models.Usuario": {
-            "description": "Modelo de Usuario utilizado en la API y sincronizado como usuario en Moodle. Puede ser Docente o Alumno.",
+        "services.RegistrosSync": {
             "type": "object",
             "properties": {
-                "email": {
-                    "description": "OBLIGATORIO",
-                    "type": "string",
-                    "example": "juan.perez@universidad.edu.mx"
+                "asignaturas": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.Asignatura"
+                    }
                 },
-                "first_name": {
-                    "description": "OBLIGATORIO",
+                "cuatrimestres": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.Cuatrimestre"
+                    }
+                },
+                "estado": {
                     "type": "string",
-                    "example": "Juan"
+                    "example": "failed"
                 },
-                "id_moodle": {
-                    "description": "ID devuelto por Moodle",
+                "grupos": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.Grupo"
+                    }
+                },
+                "matriculas": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.Matricula"
+                    }
+                },
+                "programas_estudio": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.ProgramaEstudio"
+                    }
+                },
+                "total": {
                     "type": "integer",
-                    "example": 3456
+                    "example": 3
                 },
-                "last_name": {
-                    "description": "OBLIGATORIO",
-                    "type": "string",
-                    "example": "Pérez García"
+                "usuarios": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.Usuario"
+                    }
+                }
+            }
+        },
+        "services.SyncTreeResult": {
+            "type": "object",
+            "properties": {
+                "niveles": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/services.NivelSync"
+                    }
                 },
-                "matricula": {
-                    "description": "Uso como 'idnumber'",
-                    "type": "string",
-                    "example": "20250001"
+                "programa_estudio_id": {
+                    "type": "integer",
+                    "example": 3
+                }
+            }
+        },
+        "services.ValidacionRoles": {
+            "type": "object",
+            "properties": {
+                "disponible": {
+                    "description": "Moodle permite listar los roles (plugin local_wsgetroles)",
+                    "type": "boolean"
                 },
-                "password": {
-                    "description": "OBLIGATORIO",
-                    "type": "string",
-                    "example": "Segura123#"
+                "problemas": {
+                    "description": "Correspondencias que no encajan con Moodle",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
                 },
-                "rol": {
-                    "description": "'Docente' o 'Alumno'",
-                    "type": "string",
-                    "example": "Alumno"
+                "resueltos": {
+                    "description": "Roles locales cuyo ID se completó a partir del shortname",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
                 },
-                "username": {
-                    "description": "OBLIGATORIO",
-                    "type": "string",
-                    "example": "jperez2025"
+                "roles": {
+                    "description": "Roles definidos en Moodle",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/moodle.Role"
+                    }
                 }
             }
         }
@@ -1,14 +1,21 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	_ "api_concurrencia/docs"
 	"api_concurrencia/pkg/migration"
 	"api_concurrencia/src/handlers"
 	"api_concurrencia/src/moodle"
+	"api_concurrencia/src/services"
 
 	httpSwagger "github.com/swaggo/http-swagger"
 
@@ -17,7 +24,11 @@ import (
 	"gorm.io/gorm"
 )
 
-const defaultPort = "8080"
+const (
+	defaultPort = "8080"
+	// shutdownTimeout es lo que se espera a que terminen las peticiones en curso al detener la API.
+	shutdownTimeout = 30 * time.Second
+)
 
 // @title Control Escolar API
 // @version 2.0
@@ -25,6 +36,10 @@ const defaultPort = "8080"
 // @host localhost:8080
 // @BasePath /
 func main() {
+	// ctx se cancela con SIGINT/SIGTERM y detiene las tareas en segundo plano y el servidor.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
 	// 1. Configuración de la Base de Datos
 	godotenv.Load()
 	dsn := os.Getenv("DATABASE_URL")
@@ -46,24 +61,73 @@ func main() {
 
 	moodleClient := moodle.NewClient()
 	log.Println("✅ Cliente de Moodle inicializado.")
-	// 3. Inicialización del Router y las Rutas
-	router := handlers.Routes(db, moodleClient)
 
-	// 3.1. Swagger UI en /swagger/index.html
+	// 2.1. Comprobar que el token de Moodle funciona y tiene habilitadas las funciones necesarias
+	checkCtx, cancelCheck := context.WithTimeout(ctx, 30*time.Second)
+	err = moodle.StartupCheck(checkCtx, moodleClient, moodle.StartupCheckModeFromEnv())
+	cancelCheck()
+	if err != nil {
+		log.Fatalf("❌ Comprobación de Moodle fallida (MOODLE_STARTUP_CHECK=strict): %v", err)
+	}
+
+	// 3. Servicios y tareas en segundo plano
+	svc := services.NewServices(db, moodleClient)
+	svc.Jobs.FailInterrupted()
+
+	var workers sync.WaitGroup
+	if moodle.StartupCheckModeFromEnv() != moodle.StartupCheckOff {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			rolesCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+			defer cancel()
+			svc.Roles.StartupCheck(rolesCtx)
+		}()
+	}
+	workers.Add(2)
+	go func() {
+		defer workers.Done()
+		svc.Outbox.Run(ctx, services.OutboxIntervalFromEnv())
+	}()
+	go func() {
+		defer workers.Done()
+		svc.Finalizacion.RunPeriodicImport(ctx, services.CompletionImportIntervalFromEnv())
+	}()
+
+	// 4. Inicialización del Router y las Rutas
+	// Los trabajos que lancen las peticiones viven lo que ctx: se cancelan al detener la API.
+	router := handlers.Routes(ctx, svc, moodleClient)
+
+	// 4.1. Swagger UI en /swagger/index.html
 	// Requiere ejecutar: swag init -g main.go -o ./docs
 	router.Get("/swagger/*", httpSwagger.WrapHandler)
 
-	// 4. Inicialización del Servidor HTTP
+	// 5. Inicialización del Servidor HTTP
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = defaultPort
 	}
 
-	log.Printf("🌐 Servidor escuchando en http://localhost:%s", port)
-
 	// El router (chi.Mux) implementa la interfaz http.Handler
-	err = http.ListenAndServe(":"+port, router)
-	if err != nil {
+	server := &http.Server{Addr: ":" + port, Handler: router}
+	go func() {
+		<-ctx.Done()
+		log.Println("🛑 Deteniendo la API...")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("⚠️ Error al detener el servidor: %v", err)
+		}
+	}()
+
+	log.Printf("🌐 Servidor escuchando en http://localhost:%s", port)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatalf("❌ Error al iniciar el servidor: %v", err)
 	}
+
+	// Esperar a que las tareas en segundo plano terminen lo que estaban entregando y a que los trabajos
+	// de sincronización cancelados guarden su estado.
+	workers.Wait()
+	svc.Jobs.Stop()
+	log.Println("✅ API detenida.")
 }
@@ -4,6 +4,8 @@ import (
 	"api_concurrencia/src/models"
 	"log"
 	"gorm.io/gorm"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
 // AutoMigrateTables ejecuta las migraciones para todas las entidades.
@@ -15,6 +17,13 @@ func AutoMigrateTables(db *gorm.DB) {
 		&models.Usuario{},
 		&models.Matricula{},
 		&models.Grupo{},
+		&models.Calificacion{},
+		&models.Finalizacion{},
+		&models.RolMoodle{},
+		&models.SyncJob{},
+		&models.SyncJobError{},
+		&models.OutboxEvent{},
+		&models.Discrepancia{},
 	)
 
 	if err != nil {
@@ -22,4 +31,90 @@ func AutoMigrateTables(db *gorm.DB) {
 	}
 
 	log.Println("✅ Migraciones de tablas completadas exitosamente.")
-}
\ No newline at end of file
+
+	seedRolesMoodle(db)
+	hashPlaintextPasswords(db)
+	backfillSyncState(db)
+}
+
+// rolesMoodlePorDefecto son los roles de una instalación estándar de Moodle. Moodle no trae un rol de
+// observador: se crea sin ID y se resuelve por shortname si el sitio lo tiene.
+var rolesMoodlePorDefecto = []models.RolMoodle{
+	{Nombre: "Alumno", MoodleRoleID: 5, Shortname: "student", Estudiante: true, Descripcion: "Estudiante"},
+	{Nombre: "Docente", MoodleRoleID: 3, Shortname: "editingteacher", Descripcion: "Profesor con permiso de edición"},
+	{Nombre: "Docente sin edición", MoodleRoleID: 4, Shortname: "teacher", Descripcion: "Profesor sin permiso de edición"},
+	{Nombre: "Gestor", MoodleRoleID: 1, Shortname: "manager", Descripcion: "Gestor"},
+	{Nombre: "Observador", Shortname: "observer", Descripcion: "Observador (rol personalizado)"},
+}
+
+// seedRolesMoodle crea las correspondencias de rol que falten. No modifica las existentes.
+func seedRolesMoodle(db *gorm.DB) {
+	for _, rol := range rolesMoodlePorDefecto {
+		rol := rol
+		if err := db.Where("nombre = ?", rol.Nombre).FirstOrCreate(&rol).Error; err != nil {
+			log.Fatalf("Error al crear el rol por defecto '%s': %v", rol.Nombre, err)
+		}
+	}
+}
+
+// hashPlaintextPasswords sustituye por su hash bcrypt las contraseñas que se guardaron en claro
+// (los usuarios creados con POST /usuario antes de que el servicio las hasheara).
+func hashPlaintextPasswords(db *gorm.DB) {
+	var usuarios []models.Usuario
+	if err := db.Select("id", "password").Find(&usuarios).Error; err != nil {
+		log.Fatalf("Error al revisar las contraseñas de los usuarios: %v", err)
+	}
+	count := 0
+	for _, u := range usuarios {
+		if _, err := bcrypt.Cost([]byte(u.Password)); err == nil || u.Password == "" {
+			continue
+		}
+		hashed, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
+		if err != nil {
+			log.Fatalf("Error al hashear la contraseña del usuario %d: %v", u.ID, err)
+		}
+		if err := db.Model(&models.Usuario{}).Where("id = ?", u.ID).Update("password", string(hashed)).Error; err != nil {
+			log.Fatalf("Error al guardar la contraseña del usuario %d: %v", u.ID, err)
+		}
+		count++
+	}
+	if count > 0 {
+		log.Printf("🔒 %d contraseñas guardadas en claro sustituidas por su hash.", count)
+	}
+}
+
+// backfillSyncState marca como sincronizados los registros que ya estaban vinculados con Moodle antes de
+// que existiera el estado de sincronización (siguen en pending sin fecha de sincronización). No tienen hash
+// de los datos enviados, así que cualquier modificación local posterior los deja en dirty.
+func backfillSyncState(db *gorm.DB) {
+	vinculados := []interface{}{
+		&models.ProgramaEstudio{},
+		&models.Cuatrimestre{},
+		&models.Asignatura{},
+		&models.Usuario{},
+		&models.Grupo{},
+	}
+	var total int64
+	for _, model := range vinculados {
+		result := db.Model(model).
+			Where("sync_status = ? AND last_synced_at IS NULL AND id_moodle IS NOT NULL", models.SyncPending).
+			Update("sync_status", models.SyncSynced)
+		if result.Error != nil {
+			log.Fatalf("Error al inicializar el estado de sincronización de %T: %v", model, result.Error)
+		}
+		total += result.RowsAffected
+	}
+
+	// Las matrículas locales solo se guardan tras matricular en Moodle.
+	result := db.Model(&models.Matricula{}).
+		Where("sync_status = ? AND last_synced_at IS NULL", models.SyncPending).
+		Update("sync_status", models.SyncSynced)
+	if result.Error != nil {
+		log.Fatalf("Error al inicializar el estado de sincronización de las matrículas: %v", result.Error)
+	}
+	total += result.RowsAffected
+
+	if total > 0 {
+		log.Printf("🔄 %d registros ya vinculados con Moodle marcados como sincronizados.", total)
+	}
+}
@@ -0,0 +1,133 @@
+// Package moodlesim sirve un sustituto del WebService REST de Moodle sobre net/http.
+// Guarda el estado en memoria (a través de moodle.FakeClient) y responde con el mismo JSON que
+// Moodle, incluidas las excepciones, de modo que basta con apuntar MOODLE_URL a este servidor
+// para ejecutar la API completa, sincronizaciones masivas incluidas, sin instalar Moodle.
+package moodlesim
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"reflect"
+
+	"api_concurrencia/src/moodle"
+)
+
+// RESTPath es la ruta del WebService REST de Moodle.
+const RESTPath = "/webservice/rest/server.php"
+
+// paramTypes indica, para cada función soportada, el struct de parámetros en el que se decodifica
+// el formulario recibido. Son los mismos structs con los que moodle.Client codifica la petición.
+var paramTypes = map[string]reflect.Type{
+	moodle.SiteInfoFunction:                        reflect.TypeOf(moodle.GetSiteInfoParams{}),
+	"core_course_create_categories":                reflect.TypeOf(moodle.CreateCategoriesParams{}),
+	"core_course_update_categories":                reflect.TypeOf(moodle.UpdateCategoriesParams{}),
+	"core_course_delete_categories":                reflect.TypeOf(moodle.DeleteCategoriesParams{}),
+	"core_course_get_categories":                   reflect.TypeOf(moodle.GetCategoriesParams{}),
+	"core_course_create_courses":                   reflect.TypeOf(moodle.CreateCoursesParams{}),
+	"core_course_update_courses":                   reflect.TypeOf(moodle.UpdateCoursesParams{}),
+	"core_course_delete_courses":                   reflect.TypeOf(moodle.DeleteCoursesParams{}),
+	"core_course_get_courses_by_field":             reflect.TypeOf(moodle.GetCoursesByFieldParams{}),
+	"core_user_create_users":                       reflect.TypeOf(moodle.CreateUsersParams{}),
+	"core_user_update_users":                       reflect.TypeOf(moodle.UpdateUsersParams{}),
+	"core_user_delete_users":                       reflect.TypeOf(moodle.DeleteUsersParams{}),
+	"core_user_get_users_by_field":                 reflect.TypeOf(moodle.GetUsersByFieldParams{}),
+	"core_user_get_users":                          reflect.TypeOf(moodle.GetUsersParams{}),
+	"enrol_manual_enrol_users":                     reflect.TypeOf(moodle.EnrolUsersParams{}),
+	"enrol_manual_unenrol_users":                   reflect.TypeOf(moodle.UnenrolUsersParams{}),
+	"core_group_create_groups":                     reflect.TypeOf(moodle.CreateGroupsParams{}),
+	"core_group_update_groups":                     reflect.TypeOf(moodle.UpdateGroupsParams{}),
+	"core_group_delete_groups":                     reflect.TypeOf(moodle.DeleteGroupsParams{}),
+	"core_group_get_course_groups":                 reflect.TypeOf(moodle.GetCourseGroupsParams{}),
+	"core_group_add_group_members":                 reflect.TypeOf(moodle.AddGroupMembersParams{}),
+	"core_group_delete_group_members":              reflect.TypeOf(moodle.DeleteGroupMembersParams{}),
+	"core_cohort_create_cohorts":                   reflect.TypeOf(moodle.CreateCohortsParams{}),
+	"core_cohort_add_cohort_members":               reflect.TypeOf(moodle.AddCohortMembersParams{}),
+	"core_cohort_search_cohorts":                   reflect.TypeOf(moodle.SearchCohortsParams{}),
+	"core_cohort_get_cohort_members":               reflect.TypeOf(moodle.GetCohortMembersParams{}),
+	"core_cohort_delete_cohort_members":            reflect.TypeOf(moodle.DeleteCohortMembersParams{}),
+	"gradereport_user_get_grade_items":             reflect.TypeOf(moodle.GetGradeItemsParams{}),
+	"core_completion_get_course_completion_status": reflect.TypeOf(moodle.GetCourseCompletionStatusParams{}),
+	"core_course_duplicate_course":                 reflect.TypeOf(moodle.DuplicateCourseParams{}),
+	"core_course_import_course":                    reflect.TypeOf(moodle.ImportCourseParams{}),
+	moodle.RolesFunction:                           reflect.TypeOf(moodle.GetRolesParams{}),
+}
+
+// Server atiende peticiones al WebService REST con el estado guardado en Fake.
+type Server struct {
+	// Token es el wstoken aceptado. Vacío acepta cualquier token.
+	Token string
+	// Fake guarda el estado. Puede usarse directamente para precargar datos, forzar errores
+	// (FailNext) o marcar funciones como no soportadas.
+	Fake *moodle.FakeClient
+}
+
+// NewServer crea un servidor vacío que acepta el token indicado.
+func NewServer(token string) *Server {
+	return &Server{Token: token, Fake: moodle.NewFakeClient()}
+}
+
+// Handler devuelve un http.Handler que sólo atiende RESTPath.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(RESTPath, s)
+	return mux
+}
+
+// ServeHTTP procesa una llamada al WebService. Igual que Moodle, los errores de la función se
+// devuelven con HTTP 200 y un objeto de excepción; sólo los fallos de protocolo usan otros estados.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodGet {
+		http.Error(w, "Método no permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Formulario inválido: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	values := r.Form
+	function := values.Get("wsfunction")
+	if s.Token != "" && values.Get("wstoken") != s.Token {
+		writeException(w, &moodle.MoodleError{Exception: "moodle_exception", ErrorCode: "invalidtoken", Message: "Invalid token - token not found"})
+		return
+	}
+	if format := values.Get("moodlewsrestformat"); format != "" && format != "json" {
+		writeException(w, &moodle.MoodleError{Exception: "moodle_exception", ErrorCode: "invalidparameter", Message: "Invalid parameter value detected (moodlewsrestformat)"})
+		return
+	}
+
+	paramType, ok := paramTypes[function]
+	if !ok {
+		writeException(w, &moodle.MoodleError{Exception: "dml_missing_record_exception", ErrorCode: "invalidrecord", Message: "Can't find data record in database table external_functions."})
+		return
+	}
+
+	for _, key := range []string{"wstoken", "wsfunction", "moodlewsrestformat"} {
+		values.Del(key)
+	}
+	params := reflect.New(paramType)
+	if err := moodle.DecodeParams(values, params.Interface()); err != nil {
+		writeException(w, &moodle.MoodleError{Exception: "invalid_parameter_exception", ErrorCode: "invalidparameter", Message: "Invalid parameter value detected", DebugInfo: err.Error()})
+		return
+	}
+
+	var result json.RawMessage
+	if err := s.Fake.Call(r.Context(), function, params.Elem().Interface(), &result); err != nil {
+		if me, ok := moodle.AsMoodleError(err); ok {
+			writeException(w, me)
+			return
+		}
+		log.Printf("⚠️ moodlesim: %s falló: %v", function, err)
+		writeException(w, &moodle.MoodleError{Exception: "moodle_exception", ErrorCode: "generalexceptionmessage", Message: err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+}
+
+func writeException(w http.ResponseWriter, me *moodle.MoodleError) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(me)
+}
@@ -0,0 +1,88 @@
+package moodlesim
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"api_concurrencia/src/moodle"
+)
+
+// Las llamadas pasan por el cliente real: EncodeParams aplana los structs en el formulario y el
+// servidor los reconstruye con DecodeParams antes de entregarlos al FakeClient.
+func TestServerRoundTrip(t *testing.T) {
+	sim := NewServer("secreto")
+	ts := httptest.NewServer(sim.Handler())
+	defer ts.Close()
+
+	client := moodle.NewClient()
+	client.BaseURL = ts.URL
+	client.Token = "secreto"
+	ctx := context.Background()
+
+	var users []moodle.UserResponse
+	err := client.Call(ctx, "core_user_create_users", moodle.CreateUsersParams{Users: []moodle.UserRequest{{
+		Username:    "jperez",
+		Password:    "Temporal.123",
+		Firstname:   "Juan",
+		Lastname:    "Pérez Núñez",
+		Email:       "jperez@example.com",
+		Auth:        "manual",
+		Preferences: []moodle.UserPreference{{Type: "auth_forcepasswordchange", Value: "1"}},
+	}}}, &users)
+	if err != nil {
+		t.Fatalf("core_user_create_users: %v", err)
+	}
+	if len(users) != 1 {
+		t.Fatalf("se esperaba 1 usuario creado, hay %d", len(users))
+	}
+	u := sim.Fake.Users[users[0].ID]
+	if u.Lastname != "Pérez Núñez" || u.Email != "jperez@example.com" || !u.ForcePasswordChange {
+		t.Errorf("el usuario no llegó completo al simulador: %+v", *u)
+	}
+
+	// Un puntero a 0 debe llegar como 0, no como ausente.
+	suspender, reactivar := 1, 0
+	for _, s := range []*int{&suspender, &reactivar} {
+		err := client.Call(ctx, "core_user_update_users", moodle.UpdateUsersParams{Users: []moodle.UserUpdateRequest{{ID: u.ID, Suspended: s}}}, nil)
+		if err != nil {
+			t.Fatalf("core_user_update_users: %v", err)
+		}
+		if got := sim.Fake.Users[u.ID].Suspended; got != (*s == 1) {
+			t.Errorf("suspended=%d: el usuario quedó suspendido=%v", *s, got)
+		}
+	}
+
+	var categories []moodle.CategoryResponse
+	err = client.Call(ctx, "core_course_create_categories", moodle.CreateCategoriesParams{Categories: []moodle.CategoryRequest{{Name: "Ingeniería"}}}, &categories)
+	if err != nil || len(categories) != 1 {
+		t.Fatalf("core_course_create_categories: %v (%d categorías)", err, len(categories))
+	}
+	var courses []moodle.CourseResponse
+	err = client.Call(ctx, "core_course_create_courses", moodle.CreateCoursesParams{Courses: []moodle.CourseRequest{{
+		Fullname:   "Programación I",
+		Shortname:  "PROG1",
+		Categoryid: int(categories[0].ID),
+		StartDate:  1735689600,
+		EndDate:    1751328000,
+	}}}, &courses)
+	if err != nil || len(courses) != 1 {
+		t.Fatalf("core_course_create_courses: %v (%d cursos)", err, len(courses))
+	}
+	sinFin := int64(0)
+	err = client.Call(ctx, "core_course_update_courses", moodle.UpdateCoursesParams{Courses: []moodle.CourseUpdateRequest{{ID: courses[0].ID, EndDate: &sinFin}}}, nil)
+	if err != nil {
+		t.Fatalf("core_course_update_courses: %v", err)
+	}
+	if c := sim.Fake.Courses[courses[0].ID]; c.StartDate != 1735689600 || c.EndDate != 0 {
+		t.Errorf("fechas del curso = %d..%d, se esperaba 1735689600..0", c.StartDate, c.EndDate)
+	}
+
+	client.Token = "otro"
+	err = client.Call(ctx, "core_course_get_categories", moodle.GetCategoriesParams{}, nil)
+	var me *moodle.MoodleError
+	if !errors.As(err, &me) || me.ErrorCode != "invalidtoken" {
+		t.Errorf("con otro token se esperaba invalidtoken, se obtuvo %v", err)
+	}
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
 
@@ -13,10 +14,11 @@ import (
 
 type AsignaturaHandler struct {
 	Service *services.AsignaturaService
+	Jobs    *services.SyncJobService
 }
 
-func NewAsignaturaHandler(s *services.AsignaturaService) *AsignaturaHandler {
-	return &AsignaturaHandler{Service: s}
+func NewAsignaturaHandler(s *services.AsignaturaService, jobs *services.SyncJobService) *AsignaturaHandler {
+	return &AsignaturaHandler{Service: s, Jobs: jobs}
 }
 
 // CreateAsignatura maneja la creación local. (POST /asignatura)
@@ -129,11 +131,13 @@ func (h *AsignaturaHandler) UpdateAsignatura(w http.ResponseWriter, r *http.Requ
 
 // DeleteCuatrimestre maneja la eliminación local. (DELETE /cuatrimestre/{id})
 // @Summary Eliminar Asignatura
-// @Description Elimina una asignatura por ID
+// @Description Elimina una asignatura por ID y, con propagate=true, su curso en Moodle. La eliminación en Moodle se hace en segundo plano mediante el outbox
 // @Tags asignatura
 // @Param id path int true "ID de la asignatura"
+// @Param propagate query bool false "Eliminar también en Moodle (por defecto MOODLE_DELETE_PROPAGATE)"
 // @Success 204 {string} string
 // @Failure 400 {string} string
+// @Failure 404 {string} string "Asignatura no encontrada"
 // @Failure 500 {string} string
 // @Router /asignatura/{id}/ [delete]
 func (h *AsignaturaHandler) DeleteAsignatura(w http.ResponseWriter, r *http.Request) {
@@ -144,8 +148,14 @@ func (h *AsignaturaHandler) DeleteAsignatura(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	if err := h.Service.DeleteLocal(uint(id)); err != nil {
-		http.Error(w, "Error al eliminar Asignatura local: "+err.Error(), http.StatusInternalServerError)
+	propagate, err := propagateParam(r)
+	if err != nil {
+		http.Error(w, "Parámetro propagate inválido (use true o false)", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.Service.Delete(uint(id), propagate); err != nil {
+		http.Error(w, "Error al eliminar Asignatura: "+err.Error(), moodleErrorStatus(err))
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
@@ -168,7 +178,7 @@ func (h *AsignaturaHandler) SyncAsignatura(w http.ResponseWriter, r *http.Reques
 	}
 
 	// Tarea asíncrona para no bloquear el hilo principal
-	go h.Service.SyncToMoodle(uint(id))
+	go h.Service.SyncToMoodle(backgroundContext(r), uint(id))
 
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Sincronización de la Asignatura iniciada correctamente en segundo plano."))
@@ -176,14 +186,50 @@ func (h *AsignaturaHandler) SyncAsignatura(w http.ResponseWriter, r *http.Reques
 
 // BulkSyncAsignaturas maneja la sincronización masiva de asignaturas a Moodle. (POST /asignatura/bulk-sync)
 // @Summary Sincronización masiva de Asignaturas
-// @Description Sincroniza todas las asignaturas que no tienen ID_Moodle a Moodle
+// @Description Sincroniza todas las asignaturas que no tienen ID_Moodle a Moodle. Se ejecuta en segundo plano como trabajo de sincronización; su avance se consulta en GET /sync/jobs/{id}
 // @Tags asignatura
-// @Success 200 {string} string
+// @Produce json
+// @Success 202 {object} models.SyncJob "Trabajo registrado"
 // @Failure 500 {string} string
 // @Router /asignatura/bulk-sync [post]
 func (h *AsignaturaHandler) BulkSyncAsignaturas(w http.ResponseWriter, r *http.Request) {
-	h.Service.BulkSyncToMoodle()
+	startSyncJob(w, r, h.Jobs, models.SyncJobAsignaturas, "", h.Service.BulkSyncToMoodle)
+}
 
+// ImportarPlantillaAsignatura copia el contenido del curso plantilla en el curso de la asignatura. (POST /asignatura/{id}/template-import)
+// @Summary Importar plantilla en el curso de una Asignatura
+// @Description Copia actividades, bloques y filtros del curso plantilla (plantilla_moodle_id) en el curso ya creado de la asignatura. Con replace=true borra antes su contenido.
+// @Tags asignatura
+// @Param id path int true "ID de la asignatura"
+// @Param replace query bool false "Borrar el contenido actual del curso antes de importar"
+// @Success 200 {string} string
+// @Failure 400 {string} string
+// @Failure 409 {string} string
+// @Failure 501 {string} string
+// @Failure 502 {string} string
+// @Router /asignatura/{id}/template-import [post]
+func (h *AsignaturaHandler) ImportarPlantillaAsignatura(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 32)
+	if err != nil {
+		http.Error(w, "ID inválido", http.StatusBadRequest)
+		return
+	}
+	replace := false
+	if raw := r.URL.Query().Get("replace"); raw != "" {
+		if replace, err = strconv.ParseBool(raw); err != nil {
+			http.Error(w, "Parámetro replace inválido (use true o false)", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := h.Service.ImportarPlantilla(r.Context(), uint(id), replace); err != nil {
+		status := moodleErrorStatus(err)
+		if errors.Is(err, services.ErrSinPlantilla) {
+			status = http.StatusConflict
+		}
+		http.Error(w, "Error al importar la plantilla: "+err.Error(), status)
+		return
+	}
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("Sincronización masiva de asignaturas iniciada correctamente en segundo plano."))
+	w.Write([]byte("Plantilla importada correctamente en el curso de la asignatura."))
 }
@@ -70,8 +70,7 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Rol != "Docente" && req.Rol != "Alumno" {
-		http.Error(w, "Rol debe ser 'Docente' o 'Alumno'", http.StatusBadRequest)
+	if !checkRol(w, h.UsuarioService, req.Rol) {
 		return
 	}
 
@@ -93,16 +92,10 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Hash de la contraseña
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
-	if err != nil {
-		http.Error(w, "Error al procesar la contraseña", http.StatusInternalServerError)
-		return
-	}
-
+	// CreateLocal guarda el hash de la contraseña, nunca el texto en claro
 	usuario := models.Usuario{
 		Username:  req.Username,
-		Password:  string(hashedPassword),
+		Password:  req.Password,
 		FirstName: req.FirstName,
 		LastName:  req.LastName,
 		Email:     req.Email,
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"api_concurrencia/src/services"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type CalificacionHandler struct {
+	Service *services.CalificacionService
+}
+
+func NewCalificacionHandler(s *services.CalificacionService) *CalificacionHandler {
+	return &CalificacionHandler{Service: s}
+}
+
+// GetCalificacionesUsuario devuelve las calificaciones importadas de un usuario. (GET /usuario/{id}/calificaciones)
+// @Summary Calificaciones de un usuario
+// @Description Devuelve las calificaciones guardadas localmente del usuario en todas sus asignaturas (incluido el total de cada curso), con la fecha de la última importación
+// @Tags Calificaciones
+// @Produce json
+// @Param id path int true "ID del usuario"
+// @Success 200 {array} models.Calificacion
+// @Failure 400 {string} string "ID inválido"
+// @Failure 500 {string} string "Error al obtener calificaciones"
+// @Router /usuario/{id}/calificaciones [get]
+func (h *CalificacionHandler) GetCalificacionesUsuario(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 32)
+	if err != nil || id == 0 {
+		http.Error(w, "ID inválido", http.StatusBadRequest)
+		return
+	}
+	calificaciones, err := h.Service.GetByUsuario(uint(id))
+	if err != nil {
+		http.Error(w, "Error al obtener calificaciones: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(calificaciones)
+}
+
+// ImportarCalificacionesUsuario importa desde Moodle las calificaciones de un usuario. (POST /usuario/{id}/calificaciones/import)
+// @Summary Importar calificaciones de un usuario
+// @Description Consulta gradereport_user_get_grade_items en cada asignatura en la que el usuario está matriculado como alumno y sustituye sus calificaciones locales
+// @Tags Calificaciones
+// @Produce json
+// @Param id path int true "ID del usuario"
+// @Success 200 {object} services.ImportacionCalificaciones
+// @Failure 400 {string} string "ID inválido"
+// @Failure 404 {string} string "Usuario no encontrado"
+// @Failure 501 {string} string "Moodle no tiene habilitada la función de calificaciones"
+// @Failure 502 {string} string "Error de Moodle"
+// @Router /usuario/{id}/calificaciones/import [post]
+func (h *CalificacionHandler) ImportarCalificacionesUsuario(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 32)
+	if err != nil || id == 0 {
+		http.Error(w, "ID inválido", http.StatusBadRequest)
+		return
+	}
+	result, err := h.Service.ImportarUsuario(r.Context(), uint(id))
+	if err != nil {
+		http.Error(w, "Error al importar calificaciones: "+err.Error(), moodleErrorStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
+}
+
+// GetCalificacionesAsignatura devuelve las calificaciones importadas de una asignatura. (GET /asignatura/{id}/calificaciones)
+// @Summary Calificaciones de una asignatura
+// @Description Devuelve las calificaciones guardadas localmente de todos los alumnos de la asignatura
+// @Tags Calificaciones
+// @Produce json
+// @Param id path int true "ID de la asignatura"
+// @Success 200 {array} models.Calificacion
+// @Failure 400 {string} string "ID inválido"
+// @Failure 500 {string} string "Error al obtener calificaciones"
+// @Router /asignatura/{id}/calificaciones [get]
+func (h *CalificacionHandler) GetCalificacionesAsignatura(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 32)
+	if err != nil || id == 0 {
+		http.Error(w, "ID inválido", http.StatusBadRequest)
+		return
+	}
+	calificaciones, err := h.Service.GetByAsignatura(uint(id))
+	if err != nil {
+		http.Error(w, "Error al obtener calificaciones: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(calificaciones)
+}
+
+// ImportarCalificacionesAsignatura importa desde Moodle las calificaciones de una asignatura. (POST /asignatura/{id}/calificaciones/import)
+// @Summary Importar calificaciones de una asignatura
+// @Description Consulta gradereport_user_get_grade_items para todos los alumnos del curso en una sola llamada y sustituye sus calificaciones locales
+// @Tags Calificaciones
+// @Produce json
+// @Param id path int true "ID de la asignatura"
+// @Success 200 {object} services.ImportacionCalificaciones
+// @Failure 400 {string} string "ID inválido"
+// @Failure 404 {string} string "Asignatura no encontrada"
+// @Failure 501 {string} string "Moodle no tiene habilitada la función de calificaciones"
+// @Failure 502 {string} string "Error de Moodle"
+// @Router /asignatura/{id}/calificaciones/import [post]
+func (h *CalificacionHandler) ImportarCalificacionesAsignatura(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 32)
+	if err != nil || id == 0 {
+		http.Error(w, "ID inválido", http.StatusBadRequest)
+		return
+	}
+	result, err := h.Service.ImportarAsignatura(r.Context(), uint(id))
+	if err != nil {
+		http.Error(w, "Error al importar calificaciones: "+err.Error(), moodleErrorStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"api_concurrencia/src/models"
+	"api_concurrencia/src/services"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type ConciliacionHandler struct {
+	Service *services.ConciliacionService
+	Jobs    *services.SyncJobService
+}
+
+func NewConciliacionHandler(s *services.ConciliacionService, jobs *services.SyncJobService) *ConciliacionHandler {
+	return &ConciliacionHandler{Service: s, Jobs: jobs}
+}
+
+// ResolverDiscrepanciaRequest es el cuerpo de POST /sync/discrepancias/{id}/resolve.
+type ResolverDiscrepanciaRequest struct {
+	Accion    string `json:"accion" example:"pull"`             // push, pull o link
+	EntidadID uint   `json:"entidad_id,omitempty" example:"10"` // Registro local; solo para vincular una discrepancia solo_en_moodle
+}
+
+// Reconcile lanza la conciliación con Moodle. (POST /sync/reconcile)
+// @Summary Conciliar la BD local con Moodle
+// @Description Compara programas, cuatrimestres, asignaturas, usuarios y grupos con las categorías, cursos, usuarios y grupos de Moodle y guarda las diferencias como discrepancias. Se ejecuta en segundo plano como trabajo de sincronización; su avance se consulta en GET /sync/jobs/{id} y las discrepancias en GET /sync/discrepancias?job_id={id}
+// @Tags sync
+// @Produce json
+// @Success 202 {object} models.SyncJob "Trabajo registrado"
+// @Failure 500 {string} string
+// @Router /sync/reconcile [post]
+func (h *ConciliacionHandler) Reconcile(w http.ResponseWriter, r *http.Request) {
+	startSyncJob(w, r, h.Jobs, models.SyncJobConciliacion, "", h.Service.Conciliar)
+}
+
+// GetDiscrepancias lista las discrepancias detectadas. (GET /sync/discrepancias)
+// @Summary Listar discrepancias con Moodle
+// @Description Devuelve las discrepancias detectadas por las conciliaciones, en orden de detección, con las acciones que las resuelven
+// @Tags sync
+// @Produce json
+// @Param job_id query int false "Filtrar por trabajo de conciliación"
+// @Param entidad query string false "Filtrar por entidad (programa_estudio, cuatrimestre, asignatura, usuario, grupo)"
+// @Param estado query string false "Filtrar por estado (abierta, resuelta, obsoleta)"
+// @Param limit query int false "Número máximo de discrepancias (por defecto 50)"
+// @Success 200 {array} models.Discrepancia
+// @Failure 400 {string} string
+// @Failure 500 {string} string
+// @Router /sync/discrepancias [get]
+func (h *ConciliacionHandler) GetDiscrepancias(w http.ResponseWriter, r *http.Request) {
+	limit := 50
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			http.Error(w, "Parámetro limit inválido", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	var jobID uint
+	if raw := r.URL.Query().Get("job_id"); raw != "" {
+		n, err := strconv.ParseUint(raw, 10, 32)
+		if err != nil {
+			http.Error(w, "Parámetro job_id inválido", http.StatusBadRequest)
+			return
+		}
+		jobID = uint(n)
+	}
+
+	discrepancias, err := h.Service.GetDiscrepancias(jobID, r.URL.Query().Get("entidad"), r.URL.Query().Get("estado"), limit)
+	if err != nil {
+		http.Error(w, "Error al obtener las discrepancias: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(discrepancias)
+}
+
+// ResolveDiscrepancia aplica una acción a una discrepancia. (POST /sync/discrepancias/{id}/resolve)
+// @Summary Resolver discrepancia con Moodle
+// @Description Aplica una de las acciones que ofrece la discrepancia: push envía los datos locales a Moodle (en segundo plano mediante el outbox), pull copia en local los valores de Moodle y link solo vincula el registro local con la entidad de Moodle (en solo_en_moodle hay que indicar entidad_id); si los datos difieren, la discrepancia sigue abierta como campos_distintos
+// @Tags sync
+// @Accept json
+// @Produce json
+// @Param id path int true "ID de la discrepancia"
+// @Param request body ResolverDiscrepanciaRequest true "Acción"
+// @Success 200 {object} models.Discrepancia
+// @Failure 400 {string} string
+// @Failure 404 {string} string
+// @Failure 409 {string} string "La discrepancia no está abierta, no ofrece esa acción o el ID de Moodle ya está vinculado con otro registro"
+// @Failure 500 {string} string
+// @Router /sync/discrepancias/{id}/resolve [post]
+func (h *ConciliacionHandler) ResolveDiscrepancia(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 32)
+	if err != nil {
+		http.Error(w, "ID inválido", http.StatusBadRequest)
+		return
+	}
+	var req ResolverDiscrepanciaRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Datos inválidos: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch req.Accion {
+	case models.AccionPush, models.AccionPull, models.AccionLink:
+	default:
+		http.Error(w, "Acción inválida: debe ser push, pull o link", http.StatusBadRequest)
+		return
+	}
+
+	d, err := h.Service.Resolver(uint(id), req.Accion, req.EntidadID)
+	if err != nil {
+		status := moodleErrorStatus(err)
+		if errors.Is(err, services.ErrEntidadIDRequerido) {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, "Error al resolver la discrepancia: "+err.Error(), status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(d)
+}
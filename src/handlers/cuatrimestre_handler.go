@@ -13,10 +13,11 @@ import (
 
 type CuatrimestreHandler struct {
 	Service *services.CuatrimestreService
+	Jobs    *services.SyncJobService
 }
 
-func NewCuatrimestreHandler(s *services.CuatrimestreService) *CuatrimestreHandler {
-	return &CuatrimestreHandler{Service: s}
+func NewCuatrimestreHandler(s *services.CuatrimestreService, jobs *services.SyncJobService) *CuatrimestreHandler {
+	return &CuatrimestreHandler{Service: s, Jobs: jobs}
 }
 
 // CreateCuatrimestre maneja la creación local. (POST /cuatrimestre)
@@ -132,12 +133,16 @@ func (h *CuatrimestreHandler) UpdateCuatrimestre(w http.ResponseWriter, r *http.
 
 // DeleteCuatrimestre maneja la eliminación local. (DELETE /cuatrimestre/{id})
 // @Summary Eliminar Cuatrimestre
-// @Description Elimina un cuatrimestre por ID
+// @Description Elimina un cuatrimestre por ID y, con propagate=true, su subcategoría en Moodle (solo si está vacía). La eliminación en Moodle se hace en segundo plano mediante el outbox
 // @Tags cuatrimestre
 // @Param id path int true "ID del cuatrimestre"
+// @Param propagate query bool false "Eliminar también en Moodle (por defecto MOODLE_DELETE_PROPAGATE)"
 // @Success 204 {string} string
 // @Failure 400 {string} string
+// @Failure 404 {string} string "Cuatrimestre no encontrado"
+// @Failure 409 {string} string "La subcategoría de Moodle todavía tiene cursos"
 // @Failure 500 {string} string
+// @Failure 502 {string} string "Error de Moodle"
 // @Router /cuatrimestre/{id}/ [delete]
 func (h *CuatrimestreHandler) DeleteCuatrimestre(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
@@ -147,8 +152,14 @@ func (h *CuatrimestreHandler) DeleteCuatrimestre(w http.ResponseWriter, r *http.
 		return
 	}
 
-	if err := h.Service.DeleteLocal(uint(id)); err != nil {
-		http.Error(w, "Error al eliminar Cuatrimestre local: "+err.Error(), http.StatusInternalServerError)
+	propagate, err := propagateParam(r)
+	if err != nil {
+		http.Error(w, "Parámetro propagate inválido (use true o false)", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.Service.Delete(r.Context(), uint(id), propagate); err != nil {
+		http.Error(w, "Error al eliminar Cuatrimestre: "+err.Error(), moodleErrorStatus(err))
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
@@ -171,7 +182,7 @@ func (h *CuatrimestreHandler) SyncCuatrimestre(w http.ResponseWriter, r *http.Re
 	}
 
 	// Al igual que con PE, lanzamos la tarea asíncrona para no bloquear la petición HTTP
-	go h.Service.SyncToMoodle(uint(id))
+	go h.Service.SyncToMoodle(backgroundContext(r), uint(id))
 
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Sincronización del Cuatrimestre iniciada correctamente en segundo plano."))
@@ -179,14 +190,41 @@ func (h *CuatrimestreHandler) SyncCuatrimestre(w http.ResponseWriter, r *http.Re
 
 // BulkSyncCuatrimestres maneja la sincronización masiva de cuatrimestres a Moodle. (POST /cuatrimestre/bulk-sync)
 // @Summary Sincronización masiva de Cuatrimestres
-// @Description Sincroniza todos los cuatrimestres que no tienen ID_Moodle a Moodle
+// @Description Sincroniza todos los cuatrimestres que no tienen ID_Moodle a Moodle. Se ejecuta en segundo plano como trabajo de sincronización; su avance se consulta en GET /sync/jobs/{id}
 // @Tags cuatrimestre
-// @Success 200 {string} string
+// @Produce json
+// @Success 202 {object} models.SyncJob "Trabajo registrado"
 // @Failure 500 {string} string
 // @Router /cuatrimestre/bulk-sync [post]
 func (h *CuatrimestreHandler) BulkSyncCuatrimestres(w http.ResponseWriter, r *http.Request) {
-	h.Service.BulkSyncToMoodle()
+	startSyncJob(w, r, h.Jobs, models.SyncJobCuatrimestres, "", h.Service.BulkSyncToMoodle)
+}
 
+// SyncCohort crea o actualiza la cohorte de Moodle del cuatrimestre. (POST /cuatrimestre/cohort/{id})
+// @Summary Sincronizar cohorte del cuatrimestre
+// @Description Crea, si no existe, la cohorte de Moodle del cuatrimestre (core_cohort_create_cohorts) y le añade como miembros los alumnos matriculados en sus asignaturas que ya están en Moodle (core_cohort_add_cohort_members). Puede repetirse para incorporar alumnos nuevos; quita de la cohorte a los miembros que ya no están matriculados (core_cohort_delete_cohort_members)
+// @Tags cuatrimestre
+// @Produce json
+// @Param id path int true "ID del cuatrimestre"
+// @Success 200 {object} services.CohortSyncResult
+// @Failure 400 {string} string "ID inválido"
+// @Failure 404 {string} string "Cuatrimestre no encontrado"
+// @Failure 501 {string} string "Moodle no tiene habilitadas las funciones de cohortes"
+// @Failure 502 {string} string "Error de Moodle"
+// @Router /cuatrimestre/cohort/{id} [post]
+func (h *CuatrimestreHandler) SyncCohort(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 32)
+	if err != nil || id == 0 {
+		http.Error(w, "ID inválido", http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.Service.SyncCohort(r.Context(), uint(id))
+	if err != nil {
+		http.Error(w, "Error al sincronizar la cohorte: "+err.Error(), moodleErrorStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("Sincronización masiva de cuatrimestres iniciada correctamente en segundo plano."))
+	json.NewEncoder(w).Encode(result)
 }
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"api_concurrencia/src/services"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type FinalizacionHandler struct {
+	Service *services.FinalizacionService
+}
+
+func NewFinalizacionHandler(s *services.FinalizacionService) *FinalizacionHandler {
+	return &FinalizacionHandler{Service: s}
+}
+
+// GetFinalizacionUsuario devuelve el estado de finalización importado de un usuario. (GET /usuario/{id}/finalizacion)
+// @Summary Finalización de cursos de un usuario
+// @Description Devuelve, para cada asignatura en la que el usuario está matriculado como alumno, si completó el curso en Moodle y cuándo, según la última importación
+// @Tags Finalizacion
+// @Produce json
+// @Param id path int true "ID del usuario"
+// @Success 200 {array} models.Finalizacion
+// @Failure 400 {string} string "ID inválido"
+// @Failure 500 {string} string "Error al obtener la finalización"
+// @Router /usuario/{id}/finalizacion [get]
+func (h *FinalizacionHandler) GetFinalizacionUsuario(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 32)
+	if err != nil || id == 0 {
+		http.Error(w, "ID inválido", http.StatusBadRequest)
+		return
+	}
+	finalizaciones, err := h.Service.GetByUsuario(uint(id))
+	if err != nil {
+		http.Error(w, "Error al obtener la finalización: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(finalizaciones)
+}
+
+// GetFinalizacionAsignatura devuelve el estado de finalización importado de una asignatura. (GET /asignatura/{id}/finalizacion)
+// @Summary Finalización del curso de una asignatura
+// @Description Devuelve, para cada alumno de la asignatura, si completó el curso en Moodle y cuándo, según la última importación
+// @Tags Finalizacion
+// @Produce json
+// @Param id path int true "ID de la asignatura"
+// @Success 200 {array} models.Finalizacion
+// @Failure 400 {string} string "ID inválido"
+// @Failure 500 {string} string "Error al obtener la finalización"
+// @Router /asignatura/{id}/finalizacion [get]
+func (h *FinalizacionHandler) GetFinalizacionAsignatura(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 32)
+	if err != nil || id == 0 {
+		http.Error(w, "ID inválido", http.StatusBadRequest)
+		return
+	}
+	finalizaciones, err := h.Service.GetByAsignatura(uint(id))
+	if err != nil {
+		http.Error(w, "Error al obtener la finalización: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(finalizaciones)
+}
+
+// GetInformeCuatrimestre devuelve qué alumnos completaron todas sus asignaturas del cuatrimestre. (GET /cuatrimestre/{id}/finalizacion)
+// @Summary Informe de finalización de un cuatrimestre
+// @Description Para cada alumno del cuatrimestre indica en cuántas asignaturas está matriculado, cuántas completó y si las completó todas, con los datos de la última importación
+// @Tags Finalizacion
+// @Produce json
+// @Param id path int true "ID del cuatrimestre"
+// @Success 200 {object} services.InformeFinalizacionCuatrimestre
+// @Failure 400 {string} string "ID inválido"
+// @Failure 404 {string} string "Cuatrimestre no encontrado"
+// @Failure 500 {string} string "Error al generar el informe"
+// @Router /cuatrimestre/{id}/finalizacion [get]
+func (h *FinalizacionHandler) GetInformeCuatrimestre(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 32)
+	if err != nil || id == 0 {
+		http.Error(w, "ID inválido", http.StatusBadRequest)
+		return
+	}
+	informe, err := h.Service.InformeCuatrimestre(uint(id))
+	if err != nil {
+		http.Error(w, "Error al generar el informe de finalización: "+err.Error(), moodleErrorStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(informe)
+}
+
+// ImportarFinalizacionAsignatura importa desde Moodle la finalización de una asignatura. (POST /asignatura/{id}/finalizacion/import)
+// @Summary Importar finalización de una asignatura
+// @Description Consulta core_completion_get_course_completion_status para cada alumno de la asignatura y actualiza su estado de finalización local
+// @Tags Finalizacion
+// @Produce json
+// @Param id path int true "ID de la asignatura"
+// @Success 200 {object} services.ImportacionFinalizacion
+// @Failure 400 {string} string "ID inválido"
+// @Failure 404 {string} string "Asignatura no encontrada"
+// @Failure 501 {string} string "Moodle no tiene habilitada la función de finalización"
+// @Failure 502 {string} string "Error de Moodle"
+// @Router /asignatura/{id}/finalizacion/import [post]
+func (h *FinalizacionHandler) ImportarFinalizacionAsignatura(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 32)
+	if err != nil || id == 0 {
+		http.Error(w, "ID inválido", http.StatusBadRequest)
+		return
+	}
+	result, err := h.Service.ImportarAsignatura(r.Context(), uint(id))
+	if err != nil {
+		http.Error(w, "Error al importar la finalización: "+err.Error(), moodleErrorStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
+}
+
+// ImportarFinalizacionCuatrimestre importa desde Moodle la finalización de un cuatrimestre. (POST /cuatrimestre/{id}/finalizacion/import)
+// @Summary Importar finalización de un cuatrimestre
+// @Description Consulta core_completion_get_course_completion_status para cada alumno de cada asignatura del cuatrimestre y actualiza su estado de finalización local
+// @Tags Finalizacion
+// @Produce json
+// @Param id path int true "ID del cuatrimestre"
+// @Success 200 {object} services.ImportacionFinalizacion
+// @Failure 400 {string} string "ID inválido"
+// @Failure 404 {string} string "Cuatrimestre no encontrado"
+// @Failure 501 {string} string "Moodle no tiene habilitada la función de finalización"
+// @Failure 502 {string} string "Error de Moodle"
+// @Router /cuatrimestre/{id}/finalizacion/import [post]
+func (h *FinalizacionHandler) ImportarFinalizacionCuatrimestre(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 32)
+	if err != nil || id == 0 {
+		http.Error(w, "ID inválido", http.StatusBadRequest)
+		return
+	}
+	result, err := h.Service.ImportarCuatrimestre(r.Context(), uint(id))
+	if err != nil {
+		http.Error(w, "Error al importar la finalización: "+err.Error(), moodleErrorStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
+}
+
+// ImportarFinalizacionTodo lanza la importación de la finalización de todas las matrículas. (POST /finalizacion/import)
+// @Summary Importar toda la finalización
+// @Description Importa en segundo plano el estado de finalización de todas las matrículas de alumnos. Es la misma tarea que ejecuta la importación periódica (MOODLE_COMPLETION_IMPORT_INTERVAL); no se solapan
+// @Tags Finalizacion
+// @Success 200 {string} string
+// @Router /finalizacion/import [post]
+func (h *FinalizacionHandler) ImportarFinalizacionTodo(w http.ResponseWriter, r *http.Request) {
+	ctx := backgroundContext(r)
+	go func() {
+		if _, err := h.Service.ImportarTodo(ctx); err != nil {
+			if errors.Is(err, services.ErrImportacionEnCurso) {
+				log.Printf("Importación de finalización omitida: %v", err)
+				return
+			}
+			log.Printf("ERROR: Importación de finalización fallida: %v", err)
+		}
+	}()
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Importación de finalización iniciada correctamente en segundo plano."))
+}
@@ -5,7 +5,6 @@ import (
 	"api_concurrencia/src/services"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
 	"strconv"
 
@@ -14,10 +13,11 @@ import (
 
 type GrupoHandler struct {
 	Service *services.GrupoService
+	Jobs    *services.SyncJobService
 }
 
-func NewGrupoHandler(s *services.GrupoService) *GrupoHandler {
-	return &GrupoHandler{Service: s}
+func NewGrupoHandler(s *services.GrupoService, jobs *services.SyncJobService) *GrupoHandler {
+	return &GrupoHandler{Service: s, Jobs: jobs}
 }
 
 // CreateGrupo maneja la creación local del grupo y su sincronización a Moodle. (POST /grupo)
@@ -95,7 +95,7 @@ func (h *GrupoHandler) GetGrupoByID(w http.ResponseWriter, r *http.Request) {
 }
 
 // @Summary Actualizar Grupo
-// @Description Actualiza un grupo por ID
+// @Description Actualiza un grupo por ID. Si está sincronizado, el cambio se lleva a Moodle (core_group_update_groups) en segundo plano mediante el outbox
 // @Tags grupo
 // @Accept json
 // @Produce json
@@ -121,7 +121,7 @@ func (h *GrupoHandler) UpdateGrupo(w http.ResponseWriter, r *http.Request) {
 	pe.ID = uint(id) // Asegurar que se actualice el registro correcto
 
 	if err := h.Service.UpdateLocal(&pe); err != nil {
-		http.Error(w, "Error al actualizar Grupo local: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Error al actualizar Grupo local: "+err.Error(), moodleErrorStatus(err))
 		return
 	}
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
@@ -131,11 +131,13 @@ func (h *GrupoHandler) UpdateGrupo(w http.ResponseWriter, r *http.Request) {
 
 // DeleteProgramaEstudio maneja la eliminación local.
 // @Summary Eliminar Grupo
-// @Description Elimina un grupo por ID
+// @Description Elimina un grupo por ID y, con propagate=true, también en Moodle. La eliminación en Moodle se hace en segundo plano mediante el outbox
 // @Tags grupo
 // @Param id path int true "ID del grupo"
+// @Param propagate query bool false "Eliminar también en Moodle (por defecto MOODLE_DELETE_PROPAGATE)"
 // @Success 204 {string} string
 // @Failure 400 {string} string
+// @Failure 404 {string} string "Grupo no encontrado"
 // @Failure 500 {string} string
 // @Router /grupo/{id}/ [delete]
 func (h *GrupoHandler) DeleteGrupo(w http.ResponseWriter, r *http.Request) {
@@ -146,8 +148,14 @@ func (h *GrupoHandler) DeleteGrupo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.Service.DeleteLocal(uint(id)); err != nil {
-		http.Error(w, "Error al eliminar Grupo local: "+err.Error(), http.StatusInternalServerError)
+	propagate, err := propagateParam(r)
+	if err != nil {
+		http.Error(w, "Parámetro propagate inválido (use true o false)", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.Service.Delete(uint(id), propagate); err != nil {
+		http.Error(w, "Error al eliminar Grupo: "+err.Error(), moodleErrorStatus(err))
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
@@ -169,7 +177,7 @@ func (h *GrupoHandler) SyncGrupo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.Service.SyncToMoodle(uint(id)); err != nil {
+	if err := h.Service.SyncToMoodle(r.Context(), uint(id)); err != nil {
 		http.Error(w, "Error durante la sincronización: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -179,10 +187,10 @@ func (h *GrupoHandler) SyncGrupo(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("Sincronización iniciada correctamente."))
 }
 
-// AddMembersToGroup maneja la adición de miembros a un grupo local y su sincronización a Moodle.
+// AddMembersToGroup añade miembros a un grupo local; el outbox los lleva a Moodle en segundo plano.
 // POST /grupo/add-members/{grupoID}
 // @Summary Añadir Miembros a Grupo
-// @Description Añade miembros al grupo y sincroniza con Moodle
+// @Description Añade miembros al grupo local y los añade en Moodle en segundo plano (core_group_add_group_members)
 // @Tags grupo
 // @Accept json
 // @Produce plain
@@ -190,6 +198,7 @@ func (h *GrupoHandler) SyncGrupo(w http.ResponseWriter, r *http.Request) {
 // @Param usuarios body []uint true "IDs de usuarios"
 // @Success 200 {string} string
 // @Failure 400 {string} string
+// @Failure 404 {string} string
 // @Failure 500 {string} string
 // @Router /grupo/add-members/{grupoID} [post]
 func (h *GrupoHandler) AddMembersToGroup(w http.ResponseWriter, r *http.Request) {
@@ -212,39 +221,71 @@ func (h *GrupoHandler) AddMembersToGroup(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// 1. Añadir miembros en la tabla de unión local (Many-to-Many)
-	if err := h.Service.Repo.AddMembers(uint(grupoID), usuarioIDs); err != nil {
-		http.Error(w, "Error al añadir miembros localmente: "+err.Error(), http.StatusInternalServerError)
+	// Añadir miembros en la tabla de unión local (Many-to-Many); si el grupo aún no está en Moodle,
+	// el outbox los añade cuando se cree.
+	if err := h.Service.AddMembers(uint(grupoID), usuarioIDs); err != nil {
+		http.Error(w, "Error al añadir miembros localmente: "+err.Error(), moodleErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(fmt.Sprintf("Miembros añadidos localmente al Grupo ID %d; se enviarán a Moodle en segundo plano.", grupoID)))
+}
+
+// RemoveMembersFromGroup quita miembros de un grupo local; el outbox los quita de Moodle en segundo plano.
+// POST /grupo/remove-members/{grupoID}
+// @Summary Quitar Miembros de Grupo
+// @Description Quita miembros del grupo local y los quita de Moodle en segundo plano (core_group_delete_group_members)
+// @Tags grupo
+// @Accept json
+// @Produce plain
+// @Param grupoID path int true "ID del grupo"
+// @Param usuarios body []uint true "IDs de usuarios"
+// @Success 200 {string} string
+// @Failure 400 {string} string
+// @Failure 404 {string} string
+// @Failure 500 {string} string
+// @Router /grupo/remove-members/{grupoID} [post]
+func (h *GrupoHandler) RemoveMembersFromGroup(w http.ResponseWriter, r *http.Request) {
+	grupoIDStr := chi.URLParam(r, "grupoID")
+	grupoID, err := strconv.ParseUint(grupoIDStr, 10, 32)
+	if err != nil {
+		http.Error(w, "ID de Grupo inválido", http.StatusBadRequest)
+		return
+	}
+
+	var usuarioIDs []uint
+	if err := json.NewDecoder(r.Body).Decode(&usuarioIDs); err != nil {
+		http.Error(w, "Error al decodificar IDs de usuarios: "+err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// 2. Iniciar Sincronización Asíncrona con Moodle
-	// Esto sólo funciona si el grupo ya está sincronizado.
-	go func(id uint) {
-		if err := h.Service.SyncMembersToMoodle(id); err != nil {
-			log.Printf("ERROR ASÍNCRONO al añadir miembros a Moodle para Grupo ID %d: %v", id, err)
-		} else {
-			log.Printf("✅ Sincronización asíncrona de miembros para Grupo ID %d finalizada.", id)
-		}
-	}(uint(grupoID))
+	if len(usuarioIDs) == 0 {
+		http.Error(w, "Debe proporcionar al menos un ID de usuario.", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.Service.RemoveMembers(uint(grupoID), usuarioIDs); err != nil {
+		http.Error(w, "Error al quitar miembros: "+err.Error(), moodleErrorStatus(err))
+		return
+	}
 
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(fmt.Sprintf("Miembros añadidos localmente e iniciada sincronización a Moodle para Grupo ID %d.", grupoID)))
+	w.Write([]byte(fmt.Sprintf("%d miembros quitados del Grupo ID %d.", len(usuarioIDs), grupoID)))
 }
 
 // BulkSyncGrupos maneja la sincronización masiva de grupos a Moodle. (POST /grupo/bulk-sync)
 // @Summary Sincronización masiva de Grupos
-// @Description Sincroniza todos los grupos que no tienen ID_Moodle a Moodle
+// @Description Sincroniza todos los grupos que no tienen ID_Moodle a Moodle. Se ejecuta en segundo plano como trabajo de sincronización; su avance se consulta en GET /sync/jobs/{id}
 // @Tags grupo
-// @Success 200 {string} string
+// @Produce json
+// @Success 202 {object} models.SyncJob "Trabajo registrado"
 // @Failure 500 {string} string
 // @Router /grupo/bulk-sync [post]
 func (h *GrupoHandler) BulkSyncGrupos(w http.ResponseWriter, r *http.Request) {
-	h.Service.BulkSyncToMoodle()
-
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("Sincronización masiva de grupos iniciada correctamente en segundo plano."))
+	startSyncJob(w, r, h.Jobs, models.SyncJobGrupos, "", h.Service.BulkSyncToMoodle)
 }
 
 // ... (Aquí podrías añadir GetByID, GetAll, etc. si fueran necesarios)
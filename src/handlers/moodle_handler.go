@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"api_concurrencia/src/moodle"
+)
+
+type MoodleHandler struct {
+	Limiter      *moodle.Limiter
+	MoodleClient moodle.MoodleAPI
+}
+
+func NewMoodleHandler(limiter *moodle.Limiter, moodleClient moodle.MoodleAPI) *MoodleHandler {
+	return &MoodleHandler{Limiter: limiter, MoodleClient: moodleClient}
+}
+
+// GetLimiterStats devuelve las métricas del limitador de tráfico hacia Moodle. (GET /moodle/limiter)
+// @Summary Métricas del limitador de Moodle
+// @Description Devuelve la configuración del limitador global (peticiones por segundo y llamadas concurrentes) y cuánto han esperado las llamadas en él
+// @Tags moodle
+// @Produce json
+// @Success 200 {object} moodle.LimiterStats
+// @Router /moodle/limiter [get]
+func (h *MoodleHandler) GetLimiterStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(h.Limiter.Stats())
+}
+
+// GetSiteInfo devuelve la versión de Moodle y las funciones del servicio web que faltan. (GET /moodle/site-info)
+// @Summary Capacidades del sitio Moodle
+// @Description Devuelve la última comprobación hecha con core_webservice_get_site_info (versión de Moodle y funciones imprescindibles u opcionales no habilitadas para el token). Con refresh=true vuelve a consultar a Moodle y actualiza el modo degradado
+// @Tags moodle
+// @Produce json
+// @Param refresh query bool false "Volver a consultar a Moodle"
+// @Success 200 {object} moodle.SiteReport
+// @Failure 502 {string} string "Moodle no respondió o rechazó el token"
+// @Router /moodle/site-info [get]
+func (h *MoodleHandler) GetSiteInfo(w http.ResponseWriter, r *http.Request) {
+	var report *moodle.SiteReport
+	if c, ok := h.MoodleClient.(*moodle.Client); ok && r.URL.Query().Get("refresh") != "true" {
+		report = c.SiteReport()
+	}
+
+	if report == nil {
+		var err error
+		report, err = moodle.CheckSite(r.Context(), h.MoodleClient)
+		if err != nil {
+			http.Error(w, "No se pudo consultar la información del sitio Moodle: "+err.Error(), moodleErrorStatus(err))
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(report)
+}
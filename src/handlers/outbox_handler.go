@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"api_concurrencia/src/services"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type OutboxHandler struct {
+	Service *services.OutboxService
+}
+
+func NewOutboxHandler(s *services.OutboxService) *OutboxHandler {
+	return &OutboxHandler{Service: s}
+}
+
+// GetOutbox lista los eventos del outbox. (GET /sync/outbox)
+// @Summary Listar eventos de propagación a Moodle
+// @Description Devuelve los cambios locales registrados para llevarlos a Moodle, los más recientes primero, con su estado de entrega
+// @Tags sync
+// @Produce json
+// @Param estado query string false "Filtrar por estado (pendiente, enviado, fallido)"
+// @Param limit query int false "Número máximo de eventos (por defecto 50)"
+// @Success 200 {array} models.OutboxEvent
+// @Failure 400 {string} string
+// @Failure 500 {string} string
+// @Router /sync/outbox [get]
+func (h *OutboxHandler) GetOutbox(w http.ResponseWriter, r *http.Request) {
+	limit := 50
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			http.Error(w, "Parámetro limit inválido", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	events, err := h.Service.GetAll(r.URL.Query().Get("estado"), limit)
+	if err != nil {
+		http.Error(w, "Error al obtener los eventos: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(events)
+}
+
+// RetryOutboxEvent vuelve a poner en cola un evento fallido. (POST /sync/outbox/{id}/retry)
+// @Summary Reintentar evento fallido
+// @Description Deja pendiente un evento que agotó sus reintentos para que el despachador lo entregue de nuevo. Mientras está fallido retiene los eventos posteriores del mismo registro
+// @Tags sync
+// @Produce json
+// @Param id path int true "ID del evento"
+// @Success 200 {object} models.OutboxEvent
+// @Failure 400 {string} string
+// @Failure 404 {string} string
+// @Failure 409 {string} string "El evento no está fallido"
+// @Failure 500 {string} string
+// @Router /sync/outbox/{id}/retry [post]
+func (h *OutboxHandler) RetryOutboxEvent(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 32)
+	if err != nil {
+		http.Error(w, "ID inválido", http.StatusBadRequest)
+		return
+	}
+
+	event, err := h.Service.Retry(uint(id))
+	if err != nil {
+		http.Error(w, "Error al reintentar el evento: "+err.Error(), moodleErrorStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(event)
+}
@@ -12,11 +12,12 @@ import (
 )
 
 type ProgramaEstudioHandler struct {
-	Service *services.ProgramaEstudioService
+	Service  *services.ProgramaEstudioService
+	SyncTree *services.SyncTreeService
 }
 
-func NewProgramaEstudioHandler(s *services.ProgramaEstudioService) *ProgramaEstudioHandler {
-	return &ProgramaEstudioHandler{Service: s}
+func NewProgramaEstudioHandler(s *services.ProgramaEstudioService, tree *services.SyncTreeService) *ProgramaEstudioHandler {
+	return &ProgramaEstudioHandler{Service: s, SyncTree: tree}
 }
 
 // CreateProgramaEstudio maneja la creación local.
@@ -29,7 +30,7 @@ func NewProgramaEstudioHandler(s *services.ProgramaEstudioService) *ProgramaEstu
 // @Success 201 {object} models.ProgramaEstudio "Programa de estudio creado exitosamente"
 // @Failure 400 {string} string "Error en los datos de entrada o campos obligatorios faltantes"
 // @Failure 500 {string} string "Error interno del servidor al crear el programa de estudio"
-// @Router /programa_estudio [post]
+// @Router /programa-estudio [post]
 func (h *ProgramaEstudioHandler) CreateProgramaEstudio(w http.ResponseWriter, r *http.Request) {
 	var pe models.ProgramaEstudio
 	if err := json.NewDecoder(r.Body).Decode(&pe); err != nil {
@@ -77,7 +78,7 @@ func (h *ProgramaEstudioHandler) SyncProgramaEstudio(w http.ResponseWriter, r *h
 		return
 	}
 
-	if err := h.Service.SyncToMoodle(uint(id)); err != nil {
+	if err := h.Service.SyncToMoodle(r.Context(), uint(id)); err != nil {
 		http.Error(w, "Error durante la sincronización: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -93,7 +94,7 @@ func (h *ProgramaEstudioHandler) SyncProgramaEstudio(w http.ResponseWriter, r *h
 // @Produce json
 // @Success 200 {array} models.ProgramaEstudio "Lista de programas de estudio"
 // @Failure 500 {string} string "Error al obtener programas de estudio"
-// @Router /programa_estudio [get]
+// @Router /programa-estudio [get]
 func (h *ProgramaEstudioHandler) GetAllProgramaEstudio(w http.ResponseWriter, r *http.Request) {
 	programas, err := h.Service.GetAll()
 	if err != nil {
@@ -113,7 +114,7 @@ func (h *ProgramaEstudioHandler) GetAllProgramaEstudio(w http.ResponseWriter, r
 // @Success 200 {object} models.ProgramaEstudio "Programa de estudio encontrado"
 // @Failure 400 {string} string "ID inválido"
 // @Failure 404 {string} string "Programa de estudio no encontrado"
-// @Router /programa_estudio/{id} [get]
+// @Router /programa-estudio/{id} [get]
 func (h *ProgramaEstudioHandler) GetProgramaEstudioByID(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	id, _ := strconv.ParseUint(idStr, 10, 32)
@@ -138,7 +139,6 @@ func (h *ProgramaEstudioHandler) GetProgramaEstudioByID(w http.ResponseWriter, r
 // @Success 200 {object} models.ProgramaEstudio "Programa de estudio actualizado exitosamente"
 // @Failure 400 {string} string "ID inválido o error en los datos de entrada"
 // @Failure 500 {string} string "Error al actualizar el programa de estudio"
-// @Router /programa_estudio/{id} [put]
 func (h *ProgramaEstudioHandler) UpdateProgramaEstudio(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	id, _ := strconv.ParseUint(idStr, 10, 32)
@@ -160,20 +160,87 @@ func (h *ProgramaEstudioHandler) UpdateProgramaEstudio(w http.ResponseWriter, r
 
 // DeleteProgramaEstudio maneja la eliminación local.
 // @Summary Eliminar programa de estudio
-// @Description Elimina un programa de estudio de la base de datos local
+// @Description Elimina un programa de estudio de la base de datos local y, con propagate=true, su categoría en Moodle (solo si está vacía). La eliminación en Moodle se hace en segundo plano mediante el outbox
 // @Tags ProgramaEstudio
 // @Param id path int true "ID del programa de estudio a eliminar"
+// @Param propagate query bool false "Eliminar también en Moodle (por defecto MOODLE_DELETE_PROPAGATE)"
 // @Success 204 "Programa de estudio eliminado exitosamente"
 // @Failure 400 {string} string "ID inválido"
+// @Failure 404 {string} string "Programa de estudio no encontrado"
+// @Failure 409 {string} string "La categoría de Moodle todavía tiene contenido"
 // @Failure 500 {string} string "Error al eliminar el programa de estudio"
-// @Router /programa_estudio/{id} [delete]
+// @Failure 502 {string} string "Error de Moodle"
+// @Router /programa-estudio/{id} [delete]
 func (h *ProgramaEstudioHandler) DeleteProgramaEstudio(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	id, _ := strconv.ParseUint(idStr, 10, 32)
 
-	if err := h.Service.DeleteLocal(uint(id)); err != nil {
-		http.Error(w, "Error al eliminar PE local: "+err.Error(), http.StatusInternalServerError)
+	propagate, err := propagateParam(r)
+	if err != nil {
+		http.Error(w, "Parámetro propagate inválido (use true o false)", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.Service.Delete(r.Context(), uint(id), propagate); err != nil {
+		http.Error(w, "Error al eliminar PE: "+err.Error(), moodleErrorStatus(err))
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// SyncCohort crea o actualiza la cohorte de Moodle del programa de estudio. (POST /programa-estudio/cohort/{id})
+// @Summary Sincronizar cohorte del programa de estudio
+// @Description Crea, si no existe, la cohorte de Moodle del programa de estudio (core_cohort_create_cohorts) y le añade como miembros los alumnos matriculados en sus asignaturas que ya están en Moodle (core_cohort_add_cohort_members). Puede repetirse para incorporar alumnos nuevos; quita de la cohorte a los miembros que ya no están matriculados (core_cohort_delete_cohort_members)
+// @Tags ProgramaEstudio
+// @Produce json
+// @Param id path int true "ID del programa de estudio"
+// @Success 200 {object} services.CohortSyncResult
+// @Failure 400 {string} string "ID inválido"
+// @Failure 404 {string} string "Programa de estudio no encontrado"
+// @Failure 501 {string} string "Moodle no tiene habilitadas las funciones de cohortes"
+// @Failure 502 {string} string "Error de Moodle"
+// @Router /programa-estudio/cohort/{id} [post]
+func (h *ProgramaEstudioHandler) SyncCohort(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 32)
+	if err != nil || id == 0 {
+		http.Error(w, "ID inválido", http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.Service.SyncCohort(r.Context(), uint(id))
+	if err != nil {
+		http.Error(w, "Error al sincronizar la cohorte: "+err.Error(), moodleErrorStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
+}
+
+// SyncProgramaEstudioTree sincroniza el programa y todo su árbol. (POST /programa-estudio/{id}/sync-tree)
+// @Summary Sincronizar el árbol del programa de estudio
+// @Description Sincroniza con Moodle, en orden de dependencias, el programa, sus cuatrimestres, sus asignaturas, sus grupos, las matrículas (las locales pendientes y las de los miembros de los grupos) y los miembros de los grupos. Un fallo no detiene el resto: los descendientes de lo que falla se omiten. Responde con el resultado de cada nivel
+// @Tags ProgramaEstudio
+// @Produce json
+// @Param id path int true "ID del programa de estudio"
+// @Success 200 {object} services.SyncTreeResult
+// @Failure 400 {string} string "ID inválido"
+// @Failure 404 {string} string "Programa de estudio no encontrado"
+// @Failure 500 {string} string "Error al leer la base de datos local"
+// @Router /programa-estudio/{id}/sync-tree [post]
+func (h *ProgramaEstudioHandler) SyncProgramaEstudioTree(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 32)
+	if err != nil || id == 0 {
+		http.Error(w, "ID inválido", http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.SyncTree.SyncTree(r.Context(), uint(id))
+	if err != nil {
+		http.Error(w, "Error al sincronizar el árbol: "+err.Error(), moodleErrorStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
+}
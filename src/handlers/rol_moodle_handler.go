@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"api_concurrencia/src/models"
+	"api_concurrencia/src/services"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type RolMoodleHandler struct {
+	Service *services.RolMoodleService
+}
+
+func NewRolMoodleHandler(s *services.RolMoodleService) *RolMoodleHandler {
+	return &RolMoodleHandler{Service: s}
+}
+
+// rolMoodleErrorStatus añade a moodleErrorStatus los errores de validación de la correspondencia.
+func rolMoodleErrorStatus(err error) int {
+	if errors.Is(err, services.ErrRolInvalido) {
+		return http.StatusBadRequest
+	}
+	return moodleErrorStatus(err)
+}
+
+// CreateRolMoodle crea una correspondencia de rol. (POST /rol-moodle)
+// @Summary Crear correspondencia de rol
+// @Description Asocia un rol local con un rol de Moodle por ID o por shortname (el ID se resuelve al validar contra Moodle)
+// @Tags RolMoodle
+// @Accept json
+// @Produce json
+// @Param rol body models.RolMoodle true "Correspondencia de rol"
+// @Success 201 {object} models.RolMoodle
+// @Failure 400 {string} string "Datos inválidos o rol ya existente"
+// @Failure 500 {string} string
+// @Router /rol-moodle/ [post]
+func (h *RolMoodleHandler) CreateRolMoodle(w http.ResponseWriter, r *http.Request) {
+	var rol models.RolMoodle
+	if err := json.NewDecoder(r.Body).Decode(&rol); err != nil {
+		http.Error(w, "Error al decodificar JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	rol.ID = 0
+
+	if err := h.Service.Create(&rol); err != nil {
+		http.Error(w, "Error al crear el rol: "+err.Error(), rolMoodleErrorStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(rol)
+}
+
+// GetAllRolesMoodle lista las correspondencias de rol. (GET /rol-moodle)
+// @Summary Listar correspondencias de rol
+// @Description Devuelve todos los roles locales con su rol de Moodle
+// @Tags RolMoodle
+// @Produce json
+// @Success 200 {array} models.RolMoodle
+// @Failure 500 {string} string
+// @Router /rol-moodle/ [get]
+func (h *RolMoodleHandler) GetAllRolesMoodle(w http.ResponseWriter, r *http.Request) {
+	roles, err := h.Service.GetAll()
+	if err != nil {
+		http.Error(w, "Error al obtener los roles: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(roles)
+}
+
+// GetRolMoodleByID obtiene una correspondencia de rol. (GET /rol-moodle/{id})
+// @Summary Obtener correspondencia de rol
+// @Description Obtiene una correspondencia de rol por ID
+// @Tags RolMoodle
+// @Produce json
+// @Param id path int true "ID de la correspondencia"
+// @Success 200 {object} models.RolMoodle
+// @Failure 400 {string} string "ID inválido"
+// @Failure 404 {string} string "Rol no encontrado"
+// @Router /rol-moodle/{id}/ [get]
+func (h *RolMoodleHandler) GetRolMoodleByID(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 32)
+	if err != nil || id == 0 {
+		http.Error(w, "ID inválido", http.StatusBadRequest)
+		return
+	}
+	rol, err := h.Service.GetByID(uint(id))
+	if err != nil {
+		http.Error(w, "Rol no encontrado: "+err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(rol)
+}
+
+// UpdateRolMoodle actualiza una correspondencia de rol. (PUT /rol-moodle/{id})
+// @Summary Actualizar correspondencia de rol
+// @Description Cambia el rol de Moodle asociado a un rol local. Las matrículas existentes conservan el rol con el que se crearon. El nombre sólo puede cambiarse si ningún usuario tiene ese rol
+// @Tags RolMoodle
+// @Accept json
+// @Produce json
+// @Param id path int true "ID de la correspondencia"
+// @Param rol body models.RolMoodle true "Correspondencia de rol"
+// @Success 200 {object} models.RolMoodle
+// @Failure 400 {string} string "Datos inválidos"
+// @Failure 404 {string} string "Rol no encontrado"
+// @Failure 409 {string} string "El rol está asignado a usuarios"
+// @Router /rol-moodle/{id}/ [put]
+func (h *RolMoodleHandler) UpdateRolMoodle(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 32)
+	if err != nil || id == 0 {
+		http.Error(w, "ID inválido", http.StatusBadRequest)
+		return
+	}
+	var rol models.RolMoodle
+	if err := json.NewDecoder(r.Body).Decode(&rol); err != nil {
+		http.Error(w, "Error al decodificar JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	rol.ID = uint(id)
+
+	if err := h.Service.Update(&rol); err != nil {
+		http.Error(w, "Error al actualizar el rol: "+err.Error(), rolMoodleErrorStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(rol)
+}
+
+// DeleteRolMoodle elimina una correspondencia de rol. (DELETE /rol-moodle/{id})
+// @Summary Eliminar correspondencia de rol
+// @Description Elimina una correspondencia de rol que ningún usuario tenga asignada
+// @Tags RolMoodle
+// @Param id path int true "ID de la correspondencia"
+// @Success 204 {string} string
+// @Failure 400 {string} string "ID inválido"
+// @Failure 404 {string} string "Rol no encontrado"
+// @Failure 409 {string} string "El rol está asignado a usuarios"
+// @Router /rol-moodle/{id}/ [delete]
+func (h *RolMoodleHandler) DeleteRolMoodle(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 32)
+	if err != nil || id == 0 {
+		http.Error(w, "ID inválido", http.StatusBadRequest)
+		return
+	}
+	if err := h.Service.Delete(uint(id)); err != nil {
+		http.Error(w, "Error al eliminar el rol: "+err.Error(), rolMoodleErrorStatus(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ValidarRolesMoodle compara las correspondencias con los roles de Moodle. (POST /rol-moodle/validate)
+// @Summary Validar correspondencias de rol
+// @Description Obtiene los roles de Moodle con local_wsgetroles_get_roles, completa el ID de los roles definidos sólo por shortname e informa de los que no existen en Moodle. Si el plugin no está instalado, disponible es false
+// @Tags RolMoodle
+// @Produce json
+// @Success 200 {object} services.ValidacionRoles
+// @Failure 502 {string} string "Error de Moodle"
+// @Router /rol-moodle/validate [post]
+func (h *RolMoodleHandler) ValidarRolesMoodle(w http.ResponseWriter, r *http.Request) {
+	result, err := h.Service.ValidarConMoodle(r.Context())
+	if err != nil {
+		http.Error(w, "Error al validar los roles: "+err.Error(), moodleErrorStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
+}
@@ -3,9 +3,12 @@ package handlers
 import (
 	//"api_concurrencia/src/middleware"
 	"api_concurrencia/src/moodle"
-	"api_concurrencia/src/repository"
 	"api_concurrencia/src/services"
+	"context"
+	"errors"
+	"net/http"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/go-chi/chi/v5"
@@ -13,8 +16,12 @@ import (
 	"gorm.io/gorm"
 )
 
-func Routes(db *gorm.DB, moodleClient *moodle.Client) *chi.Mux {
+// Routes conecta los handlers de los servicios de svc. No arranca tareas en segundo plano: de eso se
+// encarga main. ctx vive lo que el servidor: las tareas que una petición deja en segundo plano (trabajos
+// de sincronización, importaciones...) se cancelan con él al detener la API.
+func Routes(ctx context.Context, svc *services.Services, moodleClient moodle.MoodleAPI) *chi.Mux {
 	r := chi.NewRouter()
+	r.Use(withServerContext(ctx))
 
 	// Configuración de CORS
 	allowedOrigins := []string{"https://*", "http://*"} // Valor por defecto
@@ -31,35 +38,21 @@ func Routes(db *gorm.DB, moodleClient *moodle.Client) *chi.Mux {
 		MaxAge:           300,
 	}))
 
-	// Inicialización de Repositorios, Servicios y Handlers
-
-	// --- PROGRAMA ESTUDIO (PE) ---
-	peRepo := repository.NewProgramaEstudioRepository(db)
-	peService := services.NewProgramaEstudioService(peRepo, moodleClient)
-	peHandler := NewProgramaEstudioHandler(peService)
-
-	// --- CUATRIMESTRE ---
-	cRepo := repository.NewCuatrimestreRepository(db)
-	cService := services.NewCuatrimestreService(cRepo, moodleClient)
-	cHandler := NewCuatrimestreHandler(cService)
-
-	// --- ASIGNATURA ---
-	aRepo := repository.NewAsignaturaRepository(db)
-	aService := services.NewAsignaturaService(aRepo, moodleClient)
-	aHandler := NewAsignaturaHandler(aService)
-
-	// --- USUARIO ---
-	uRepo := repository.NewUsuarioRepository(db)
-	uService := services.NewUsuarioService(uRepo, moodleClient, aRepo)
-	uHandler := NewUsuarioHandler(uService)
-
-	// --- AUTH ---
-	authHandler := NewAuthHandler(uService)
-
-	// --- GRUPO ---
-	gRepo := repository.NewGrupoRepository(db)
-	gService := services.NewGrupoService(gRepo, moodleClient, aRepo, uRepo)
-	gHandler := NewGrupoHandler(gService)
+	// Inicialización de Handlers
+	jobHandler := NewSyncJobHandler(svc.Jobs)
+	syncStateHandler := NewSyncStateHandler(svc.SyncState)
+	outboxHandler := NewOutboxHandler(svc.Outbox)
+	peHandler := NewProgramaEstudioHandler(svc.Programas, svc.Tree)
+	cHandler := NewCuatrimestreHandler(svc.Cuatrimestres, svc.Jobs)
+	aHandler := NewAsignaturaHandler(svc.Asignaturas, svc.Jobs)
+	rolHandler := NewRolMoodleHandler(svc.Roles)
+	uHandler := NewUsuarioHandler(svc.Usuarios, svc.Jobs)
+	authHandler := NewAuthHandler(svc.Usuarios)
+	gHandler := NewGrupoHandler(svc.Grupos, svc.Jobs)
+	concHandler := NewConciliacionHandler(svc.Conciliacion, svc.Jobs)
+	calHandler := NewCalificacionHandler(svc.Calificaciones)
+	finHandler := NewFinalizacionHandler(svc.Finalizacion)
+	moodleHandler := NewMoodleHandler(moodle.SharedLimiter(), moodleClient)
 
 	// Rutas públicas (sin autenticación)
 	r.Route("/auth", func(r chi.Router) {
@@ -75,10 +68,12 @@ func Routes(db *gorm.DB, moodleClient *moodle.Client) *chi.Mux {
 			r.Post("/", peHandler.CreateProgramaEstudio)
 			r.Get("/", peHandler.GetAllProgramaEstudio)
 			r.Post("/sync/{id}", peHandler.SyncProgramaEstudio)
+			r.Post("/cohort/{id}", peHandler.SyncCohort)
 			r.Route("/{id}", func(r chi.Router) {
 				r.Get("/", peHandler.GetProgramaEstudioByID)
+				r.Post("/sync-tree", peHandler.SyncProgramaEstudioTree)
 				//r.Put("/", peHandler.UpdateProgramaEstudio)
-				//r.Delete("/", peHandler.DeleteProgramaEstudio)
+				r.Delete("/", peHandler.DeleteProgramaEstudio)
 			})
 		})
 
@@ -87,10 +82,13 @@ func Routes(db *gorm.DB, moodleClient *moodle.Client) *chi.Mux {
 			r.Get("/", cHandler.GetAllCuatrimestres)
 			r.Post("/sync/{id}", cHandler.SyncCuatrimestre)
 			r.Post("/bulk-sync", cHandler.BulkSyncCuatrimestres)
+			r.Post("/cohort/{id}", cHandler.SyncCohort)
 			r.Route("/{id}", func(r chi.Router) {
 				r.Get("/", cHandler.GetCuatrimestreByID)
 				r.Put("/", cHandler.UpdateCuatrimestre)
 				r.Delete("/", cHandler.DeleteCuatrimestre)
+				r.Get("/finalizacion", finHandler.GetInformeCuatrimestre)
+				r.Post("/finalizacion/import", finHandler.ImportarFinalizacionCuatrimestre)
 			})
 		})
 
@@ -103,6 +101,11 @@ func Routes(db *gorm.DB, moodleClient *moodle.Client) *chi.Mux {
 				r.Get("/", aHandler.GetAsignaturaByID)
 				r.Put("/", aHandler.UpdateAsignatura)
 				r.Delete("/", aHandler.DeleteAsignatura)
+				r.Get("/calificaciones", calHandler.GetCalificacionesAsignatura)
+				r.Post("/calificaciones/import", calHandler.ImportarCalificacionesAsignatura)
+				r.Get("/finalizacion", finHandler.GetFinalizacionAsignatura)
+				r.Post("/finalizacion/import", finHandler.ImportarFinalizacionAsignatura)
+				r.Post("/template-import", aHandler.ImportarPlantillaAsignatura)
 			})
 		})
 
@@ -114,17 +117,56 @@ func Routes(db *gorm.DB, moodleClient *moodle.Client) *chi.Mux {
 			r.Post("/sync/{id}", uHandler.SyncUsuario)
 			r.Post("/bulk-sync", uHandler.BulkSyncUsuarios)
 			r.Post("/enrol/{usuarioID}/{asignaturaID}", uHandler.MatricularUsuario)
+			r.Delete("/enrol/{usuarioID}/{asignaturaID}", uHandler.DesmatricularUsuario)
+			r.Post("/enrol/{usuarioID}/{asignaturaID}/suspend", uHandler.SuspenderMatricula)
+			r.Post("/enrol/{usuarioID}/{asignaturaID}/reactivate", uHandler.ReactivarMatricula)
 			r.Route("/{id}", func(r chi.Router) {
 				r.Get("/", uHandler.GetUsuarioByID)
+				r.Delete("/", uHandler.DeleteUsuario)
+				r.Get("/calificaciones", calHandler.GetCalificacionesUsuario)
+				r.Post("/calificaciones/import", calHandler.ImportarCalificacionesUsuario)
+				r.Get("/finalizacion", finHandler.GetFinalizacionUsuario)
 			})
 		})
 
+		r.Post("/finalizacion/import", finHandler.ImportarFinalizacionTodo)
+
+		r.Route("/rol-moodle", func(r chi.Router) {
+			r.Post("/", rolHandler.CreateRolMoodle)
+			r.Get("/", rolHandler.GetAllRolesMoodle)
+			r.Post("/validate", rolHandler.ValidarRolesMoodle)
+			r.Route("/{id}", func(r chi.Router) {
+				r.Get("/", rolHandler.GetRolMoodleByID)
+				r.Put("/", rolHandler.UpdateRolMoodle)
+				r.Delete("/", rolHandler.DeleteRolMoodle)
+			})
+		})
+
+		r.Route("/sync", func(r chi.Router) {
+			r.Get("/jobs", jobHandler.GetSyncJobs)
+			r.Get("/jobs/{id}", jobHandler.GetSyncJobByID)
+			r.Post("/jobs/{id}/cancel", jobHandler.CancelSyncJob)
+			r.Get("/failed", syncStateHandler.GetFailed)
+			r.Get("/dirty", syncStateHandler.GetDirty)
+			r.Get("/outbox", outboxHandler.GetOutbox)
+			r.Post("/outbox/{id}/retry", outboxHandler.RetryOutboxEvent)
+			r.Post("/reconcile", concHandler.Reconcile)
+			r.Get("/discrepancias", concHandler.GetDiscrepancias)
+			r.Post("/discrepancias/{id}/resolve", concHandler.ResolveDiscrepancia)
+		})
+
+		r.Route("/moodle", func(r chi.Router) {
+			r.Get("/limiter", moodleHandler.GetLimiterStats)
+			r.Get("/site-info", moodleHandler.GetSiteInfo)
+		})
+
 		r.Route("/grupo", func(r chi.Router) {
 			r.Post("/", gHandler.CreateGrupo)
 			r.Get("/", gHandler.GetAllGrupo)
 			r.Post("/sync/{id}", gHandler.SyncGrupo)
 			r.Post("/bulk-sync", gHandler.BulkSyncGrupos)
 			r.Post("/add-members/{grupoID}", gHandler.AddMembersToGroup)
+			r.Post("/remove-members/{grupoID}", gHandler.RemoveMembersFromGroup)
 			r.Route("/{id}", func(r chi.Router) {
 				r.Get("/", gHandler.GetGrupoByID)
 				r.Put("/", gHandler.UpdateGrupo)
@@ -135,3 +177,63 @@ func Routes(db *gorm.DB, moodleClient *moodle.Client) *chi.Mux {
 
 	return r
 }
+
+// serverContextKey guarda en cada petición el contexto de vida del servidor.
+type serverContextKey struct{}
+
+// withServerContext deja ctx en el contexto de cada petición para backgroundContext.
+func withServerContext(ctx context.Context) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), serverContextKey{}, ctx)))
+		})
+	}
+}
+
+// backgroundContext devuelve el contexto para las tareas que siguen ejecutándose después de
+// responder la petición: el del servidor, que no se cancela al cerrar la conexión HTTP pero sí al
+// detener la API.
+func backgroundContext(r *http.Request) context.Context {
+	if ctx, ok := r.Context().Value(serverContextKey{}).(context.Context); ok {
+		return ctx
+	}
+	return context.WithoutCancel(r.Context())
+}
+
+// propagateParam lee el parámetro de consulta ?propagate=true|false de los DELETE.
+// Si no se indica se usa el valor de MOODLE_DELETE_PROPAGATE.
+func propagateParam(r *http.Request) (bool, error) {
+	raw := r.URL.Query().Get("propagate")
+	if raw == "" {
+		return services.PropagateDeletesByDefault(), nil
+	}
+	return strconv.ParseBool(raw)
+}
+
+// moodleErrorStatus elige el código HTTP para un error de una operación que involucra a Moodle:
+// 404 si el registro local no existe, 409 si la categoría aún tiene contenido (o el evento del outbox
+// no está fallido, la discrepancia ya no está abierta o no ofrece la acción, o el registro del que
+// depende aún no está en Moodle, o el trabajo ya no está en curso), 501 si la versión de
+// Moodle no tiene la función, 502 si falló Moodle y 500 en cualquier otro caso.
+func moodleErrorStatus(err error) int {
+	var (
+		moodleErr *moodle.MoodleError
+		httpErr   *moodle.HTTPError
+		netErr    *moodle.NetworkError
+	)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrCategoryNotEmpty), errors.Is(err, services.ErrRolEnUso),
+		errors.Is(err, services.ErrEventoNoFallido), errors.Is(err, services.ErrDiscrepanciaCerrada),
+		errors.Is(err, services.ErrAccionNoPermitida), errors.Is(err, services.ErrParentNotSynced),
+		errors.Is(err, services.ErrTrabajoNoEnCurso):
+		return http.StatusConflict
+	case errors.Is(err, moodle.ErrUnsupported):
+		return http.StatusNotImplemented
+	case errors.As(err, &moodleErr), errors.As(err, &httpErr), errors.As(err, &netErr):
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
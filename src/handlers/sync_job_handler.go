@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"api_concurrencia/src/services"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type SyncJobHandler struct {
+	Service *services.SyncJobService
+}
+
+func NewSyncJobHandler(s *services.SyncJobService) *SyncJobHandler {
+	return &SyncJobHandler{Service: s}
+}
+
+// startSyncJob lanza task como trabajo de sincronización y responde 202 con el trabajo registrado.
+func startSyncJob(w http.ResponseWriter, r *http.Request, jobs *services.SyncJobService, tipo, parametros string, task services.SyncTask) {
+	job, err := jobs.Start(backgroundContext(r), tipo, parametros, task)
+	if err != nil {
+		http.Error(w, "Error al iniciar la sincronización: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Location", "/sync/jobs/"+strconv.FormatUint(uint64(job.ID), 10))
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// GetSyncJobs lista los trabajos de sincronización. (GET /sync/jobs)
+// @Summary Listar trabajos de sincronización
+// @Description Devuelve los trabajos de sincronización masiva, los más recientes primero, sin el detalle de errores
+// @Tags sync
+// @Produce json
+// @Param tipo query string false "Filtrar por tipo (usuarios, cuatrimestres, asignaturas, grupos, conciliacion)"
+// @Param estado query string false "Filtrar por estado (pendiente, en_curso, completado, fallido, cancelado)"
+// @Param limit query int false "Número máximo de trabajos (por defecto 50)"
+// @Success 200 {array} models.SyncJob
+// @Failure 400 {string} string
+// @Failure 500 {string} string
+// @Router /sync/jobs [get]
+func (h *SyncJobHandler) GetSyncJobs(w http.ResponseWriter, r *http.Request) {
+	limit := 50
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			http.Error(w, "Parámetro limit inválido", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	jobs, err := h.Service.GetAll(r.URL.Query().Get("tipo"), r.URL.Query().Get("estado"), limit)
+	if err != nil {
+		http.Error(w, "Error al obtener los trabajos: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(jobs)
+}
+
+// GetSyncJobByID obtiene un trabajo de sincronización. (GET /sync/jobs/{id})
+// @Summary Obtener trabajo de sincronización
+// @Description Devuelve el estado, los contadores y los errores por registro de un trabajo
+// @Tags sync
+// @Produce json
+// @Param id path int true "ID del trabajo"
+// @Success 200 {object} models.SyncJob
+// @Failure 400 {string} string
+// @Failure 404 {string} string
+// @Router /sync/jobs/{id} [get]
+func (h *SyncJobHandler) GetSyncJobByID(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 32)
+	if err != nil {
+		http.Error(w, "ID inválido", http.StatusBadRequest)
+		return
+	}
+
+	job, err := h.Service.GetByID(uint(id))
+	if err != nil {
+		http.Error(w, "Trabajo no encontrado: "+err.Error(), moodleErrorStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(job)
+}
+
+// CancelSyncJob cancela un trabajo de sincronización en curso. (POST /sync/jobs/{id}/cancel)
+// @Summary Cancelar trabajo de sincronización
+// @Description Detiene un trabajo en curso: los registros ya sincronizados se conservan y el trabajo queda cancelado en cuanto la tarea se detiene (consultar GET /sync/jobs/{id})
+// @Tags sync
+// @Produce json
+// @Param id path int true "ID del trabajo"
+// @Success 202 {object} models.SyncJob "Cancelación solicitada"
+// @Failure 400 {string} string
+// @Failure 404 {string} string
+// @Failure 409 {string} string "El trabajo no está en curso"
+// @Router /sync/jobs/{id}/cancel [post]
+func (h *SyncJobHandler) CancelSyncJob(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 32)
+	if err != nil {
+		http.Error(w, "ID inválido", http.StatusBadRequest)
+		return
+	}
+
+	job, err := h.Service.Cancel(uint(id))
+	if err != nil {
+		http.Error(w, "Error al cancelar el trabajo: "+err.Error(), moodleErrorStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
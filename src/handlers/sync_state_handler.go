@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"api_concurrencia/src/models"
+	"api_concurrencia/src/services"
+)
+
+type SyncStateHandler struct {
+	Service *services.SyncStateService
+}
+
+func NewSyncStateHandler(s *services.SyncStateService) *SyncStateHandler {
+	return &SyncStateHandler{Service: s}
+}
+
+// GetFailed lista los registros cuya última sincronización con Moodle falló. (GET /sync/failed)
+// @Summary Listar registros con sincronización fallida
+// @Description Devuelve, por entidad, los registros en estado failed con el error del último intento (last_sync_error)
+// @Tags sync
+// @Produce json
+// @Success 200 {object} services.RegistrosSync
+// @Failure 500 {string} string
+// @Router /sync/failed [get]
+func (h *SyncStateHandler) GetFailed(w http.ResponseWriter, r *http.Request) {
+	h.registros(w, models.SyncFailed)
+}
+
+// GetDirty lista los registros modificados localmente desde su última sincronización. (GET /sync/dirty)
+// @Summary Listar registros modificados sin sincronizar
+// @Description Devuelve, por entidad, los registros en estado dirty: sus datos locales ya no coinciden con los enviados a Moodle
+// @Tags sync
+// @Produce json
+// @Success 200 {object} services.RegistrosSync
+// @Failure 500 {string} string
+// @Router /sync/dirty [get]
+func (h *SyncStateHandler) GetDirty(w http.ResponseWriter, r *http.Request) {
+	h.registros(w, models.SyncDirty)
+}
+
+func (h *SyncStateHandler) registros(w http.ResponseWriter, estado string) {
+	registros, err := h.Service.Registros(estado)
+	if err != nil {
+		http.Error(w, "Error al obtener los registros: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(registros)
+}
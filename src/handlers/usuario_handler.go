@@ -1,8 +1,11 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"regexp"
@@ -16,10 +19,11 @@ import (
 
 type UsuarioHandler struct {
 	Service *services.UsuarioService
+	Jobs    *services.SyncJobService
 }
 
-func NewUsuarioHandler(s *services.UsuarioService) *UsuarioHandler {
-	return &UsuarioHandler{Service: s}
+func NewUsuarioHandler(s *services.UsuarioService, jobs *services.SyncJobService) *UsuarioHandler {
+	return &UsuarioHandler{Service: s, Jobs: jobs}
 }
 
 // CreateUsuario maneja la creación de un nuevo usuario.
@@ -64,9 +68,8 @@ func (h *UsuarioHandler) CreateUsuario(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 1. Validación de Rol (Existente)
-	if u.Rol != "Docente" && u.Rol != "Alumno" {
-		http.Error(w, "Rol debe ser 'Docente' o 'Alumno'", http.StatusBadRequest)
+	// 1. Validación de Rol: debe tener correspondencia en /rol-moodle
+	if !checkRol(w, h.Service, u.Rol) {
 		return
 	}
 
@@ -122,7 +125,7 @@ func (h *UsuarioHandler) SyncUsuario(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Tarea asíncrona (aunque es individual, por consistencia)
-	go h.Service.SyncToMoodle(uint(id))
+	go h.Service.SyncToMoodle(backgroundContext(r), uint(id))
 
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Sincronización del Usuario iniciada correctamente en segundo plano."))
@@ -130,39 +133,46 @@ func (h *UsuarioHandler) SyncUsuario(w http.ResponseWriter, r *http.Request) {
 
 // BulkSyncUsuarios maneja la solicitud de sincronización masiva.
 // @Summary Sincronización masiva de usuarios por rol
-// @Description Sincroniza todos los usuarios de un rol específico (Docente o Alumno) con Moodle de forma asíncrona
+// @Description Sincroniza todos los usuarios de un rol específico (Docente, Alumno u otro rol definido en /rol-moodle) con Moodle de forma asíncrona
 // @Tags Usuario
-// @Produce plain
-// @Param role query string true "Rol a sincronizar: 'Docente' o 'Alumno'"
-// @Success 200 {string} string "Sincronización masiva de usuarios iniciada correctamente en segundo plano"
+// @Produce json
+// @Param role query string true "Rol a sincronizar: un rol definido en /rol-moodle (ej: 'Docente' o 'Alumno')"
+// @Success 202 {object} models.SyncJob "Trabajo de sincronización registrado; su avance se consulta en GET /sync/jobs/{id}"
 // @Failure 400 {string} string "Rol inválido o no especificado"
 // @Router /usuario/bulk-sync [post]
 func (h *UsuarioHandler) BulkSyncUsuarios(w http.ResponseWriter, r *http.Request) {
 	// Leer el parámetro de consulta para determinar qué rol sincronizar (ej: ?role=Alumno)
 	role := r.URL.Query().Get("role")
 
-	if role == "" || (role != "Docente" && role != "Alumno") {
-		http.Error(w, "Debe especificar un rol válido (role=Docente o role=Alumno)", http.StatusBadRequest)
+	if role == "" {
+		http.Error(w, "Debe especificar un rol válido (ej: role=Docente o role=Alumno)", http.StatusBadRequest)
+		return
+	}
+	if !checkRol(w, h.Service, role) {
 		return
 	}
 
-	// Lanzar la sincronización masiva en segundo plano
-	h.Service.BulkSyncToMoodle(role)
-
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("Sincronización masiva de usuarios iniciada correctamente en segundo plano para el rol: " + role))
+	// Lanzar la sincronización masiva en segundo plano como trabajo de sincronización
+	startSyncJob(w, r, h.Jobs, models.SyncJobUsuarios, "role="+role, func(ctx context.Context, progress *services.SyncProgress) error {
+		return h.Service.BulkSyncToMoodle(ctx, role, progress)
+	})
 }
 
 // MatricularUsuario maneja la matriculación de un usuario en una asignatura.
 // @Summary Matricular usuario en asignatura
-// @Description Matricula un usuario en una asignatura de forma asíncrona (crea el enrolamiento en Moodle)
+// @Description Guarda la matrícula local y la envía a Moodle en segundo plano (enrol_manual_enrol_users). El usuario y la asignatura deben estar ya en Moodle
 // @Tags Usuario
+// @Accept json
 // @Produce plain
 // @Param usuarioID path int true "ID del usuario a matricular"
 // @Param asignaturaID path int true "ID de la asignatura"
-// @Success 200 {string} string "Matriculación iniciada en segundo plano"
-// @Failure 400 {string} string "ID de Usuario o Asignatura inválido"
-// @Router /usuario/{usuarioID}/matricular/{asignaturaID} [post]
+// @Param opciones body services.OpcionesMatricula false "Periodo de matrícula, suspensión y rol para este curso (opcionales)"
+// @Success 200 {string} string "Matrícula registrada; se enviará a Moodle en segundo plano"
+// @Failure 400 {string} string "ID de Usuario o Asignatura inválido, u opciones de matrícula inválidas"
+// @Failure 404 {string} string "Usuario o asignatura no encontrados"
+// @Failure 409 {string} string "El usuario o la asignatura todavía no están en Moodle"
+// @Failure 500 {string} string "Error al guardar la matrícula"
+// @Router /usuario/enrol/{usuarioID}/{asignaturaID} [post]
 func (h *UsuarioHandler) MatricularUsuario(w http.ResponseWriter, r *http.Request) {
 	usuarioIDStr := chi.URLParam(r, "usuarioID")
 	asignaturaIDStr := chi.URLParam(r, "asignaturaID")
@@ -179,16 +189,106 @@ func (h *UsuarioHandler) MatricularUsuario(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// Ejecutamos la función de servicio en segundo plano (asíncrona)
-	go func() {
-		if err := h.Service.MatricularUsuario(uint(usuarioID), uint(asignaturaID)); err != nil {
-			// Es importante registrar errores en la goroutine, ya que no podemos devolverlos al cliente HTTP
-			log.Printf("ERROR de Matrícula (U:%d, A:%d): %v", usuarioID, asignaturaID, err)
-		}
-	}()
+	// El cuerpo es opcional: sin él se matricula sin periodo y activo.
+	var opts services.OpcionesMatricula
+	if err := json.NewDecoder(r.Body).Decode(&opts); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Opciones de matrícula inválidas: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := opts.Validate(); err != nil {
+		http.Error(w, "Opciones de matrícula inválidas: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Se guarda la matrícula local; el outbox la lleva a Moodle en segundo plano.
+	if _, err := h.Service.MatricularUsuario(uint(usuarioID), uint(asignaturaID), opts); err != nil {
+		http.Error(w, "Error al matricular: "+err.Error(), moodleErrorStatus(err))
+		return
+	}
 
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(fmt.Sprintf("Matriculación del Usuario %d en la Asignatura %d iniciada en segundo plano.", usuarioID, asignaturaID)))
+	w.Write([]byte(fmt.Sprintf("Matrícula del Usuario %d en la Asignatura %d registrada; se enviará a Moodle en segundo plano.", usuarioID, asignaturaID)))
+}
+
+// SuspenderMatricula suspende la matrícula de un usuario en una asignatura sin eliminarla.
+// @Summary Suspender matrícula
+// @Description Suspende la matrícula local y envía el cambio a Moodle en segundo plano (enrol_manual_enrol_users con suspend=1); el usuario conserva su historial pero pierde el acceso
+// @Tags Usuario
+// @Param usuarioID path int true "ID del usuario"
+// @Param asignaturaID path int true "ID de la asignatura"
+// @Success 204 "Matrícula suspendida"
+// @Failure 400 {string} string "ID de Usuario o Asignatura inválido"
+// @Failure 404 {string} string "Matrícula no encontrada"
+// @Failure 500 {string} string "Error al guardar la matrícula"
+// @Router /usuario/enrol/{usuarioID}/{asignaturaID}/suspend [post]
+func (h *UsuarioHandler) SuspenderMatricula(w http.ResponseWriter, r *http.Request) {
+	h.setMatriculaSuspendida(w, r, true)
+}
+
+// ReactivarMatricula reactiva una matrícula suspendida.
+// @Summary Reactivar matrícula
+// @Description Reactiva una matrícula suspendida y envía el cambio a Moodle en segundo plano (enrol_manual_enrol_users con suspend=0)
+// @Tags Usuario
+// @Param usuarioID path int true "ID del usuario"
+// @Param asignaturaID path int true "ID de la asignatura"
+// @Success 204 "Matrícula reactivada"
+// @Failure 400 {string} string "ID de Usuario o Asignatura inválido"
+// @Failure 404 {string} string "Matrícula no encontrada"
+// @Failure 500 {string} string "Error al guardar la matrícula"
+// @Router /usuario/enrol/{usuarioID}/{asignaturaID}/reactivate [post]
+func (h *UsuarioHandler) ReactivarMatricula(w http.ResponseWriter, r *http.Request) {
+	h.setMatriculaSuspendida(w, r, false)
+}
+
+func (h *UsuarioHandler) setMatriculaSuspendida(w http.ResponseWriter, r *http.Request, suspended bool) {
+	usuarioID, err := strconv.ParseUint(chi.URLParam(r, "usuarioID"), 10, 32)
+	if err != nil {
+		http.Error(w, "ID de Usuario inválido", http.StatusBadRequest)
+		return
+	}
+
+	asignaturaID, err := strconv.ParseUint(chi.URLParam(r, "asignaturaID"), 10, 32)
+	if err != nil {
+		http.Error(w, "ID de Asignatura inválido", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.Service.SetMatriculaSuspendida(uint(usuarioID), uint(asignaturaID), suspended); err != nil {
+		http.Error(w, "Error al cambiar el estado de la matrícula: "+err.Error(), moodleErrorStatus(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DesmatricularUsuario elimina la matrícula local y da de baja al usuario en Moodle en segundo plano.
+// @Summary Dar de baja usuario de asignatura
+// @Description Elimina la matrícula local y la pertenencia a los grupos de la asignatura; la baja en Moodle (enrol_manual_unenrol_users) se envía en segundo plano
+// @Tags Usuario
+// @Param usuarioID path int true "ID del usuario"
+// @Param asignaturaID path int true "ID de la asignatura"
+// @Success 204 "Usuario dado de baja"
+// @Failure 400 {string} string "ID de Usuario o Asignatura inválido"
+// @Failure 404 {string} string "Matrícula no encontrada"
+// @Failure 500 {string} string "Error al eliminar la matrícula local"
+// @Router /usuario/enrol/{usuarioID}/{asignaturaID} [delete]
+func (h *UsuarioHandler) DesmatricularUsuario(w http.ResponseWriter, r *http.Request) {
+	usuarioID, err := strconv.ParseUint(chi.URLParam(r, "usuarioID"), 10, 32)
+	if err != nil {
+		http.Error(w, "ID de Usuario inválido", http.StatusBadRequest)
+		return
+	}
+
+	asignaturaID, err := strconv.ParseUint(chi.URLParam(r, "asignaturaID"), 10, 32)
+	if err != nil {
+		http.Error(w, "ID de Asignatura inválido", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.Service.DesmatricularUsuario(uint(usuarioID), uint(asignaturaID)); err != nil {
+		http.Error(w, "Error al dar de baja: "+err.Error(), moodleErrorStatus(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
 }
 
 // GetUsuarioByID obtiene un usuario por su ID.
@@ -237,7 +337,7 @@ func (h *UsuarioHandler) GetAllUsuarios(w http.ResponseWriter, r *http.Request)
 // @Description Recupera la lista de usuarios que aún no han sido sincronizados con Moodle, filtrados por rol
 // @Tags Usuario
 // @Produce json
-// @Param role query string true "Rol a filtrar: 'Docente' o 'Alumno'"
+// @Param role query string true "Rol a filtrar: un rol definido en /rol-moodle (ej: 'Docente' o 'Alumno')"
 // @Success 200 {array} models.Usuario "Lista de usuarios no sincronizados"
 // @Failure 400 {string} string "Rol no especificado o inválido"
 // @Failure 500 {string} string "Error al obtener usuarios no sincronizados"
@@ -250,8 +350,7 @@ func (h *UsuarioHandler) GetUnsyncedUsuarios(w http.ResponseWriter, r *http.Requ
 		http.Error(w, "Debe especificar el parámetro 'role' (Docente o Alumno).", http.StatusBadRequest)
 		return
 	}
-	if role != "Docente" && role != "Alumno" {
-		http.Error(w, "El rol especificado es inválido. Use 'Docente' o 'Alumno'.", http.StatusBadRequest)
+	if !checkRol(w, h.Service, role) {
 		return
 	}
 
@@ -306,7 +405,6 @@ func (h *UsuarioHandler) GetUsuariosByGroupID(w http.ResponseWriter, r *http.Req
 // @Success 200 {object} models.Usuario "Usuario actualizado exitosamente"
 // @Failure 400 {string} string "ID inválido o error en los datos de entrada"
 // @Failure 500 {string} string "Error al actualizar el usuario"
-// @Router /usuario/{id} [put]
 func (h *UsuarioHandler) UpdateUsuario(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	id, _ := strconv.ParseUint(idStr, 10, 32)
@@ -328,20 +426,43 @@ func (h *UsuarioHandler) UpdateUsuario(w http.ResponseWriter, r *http.Request) {
 
 // DeleteUsuario elimina un usuario.
 // @Summary Eliminar usuario
-// @Description Elimina un usuario de la base de datos local
+// @Description Elimina un usuario de la base de datos local y, con propagate=true, lo suspende o elimina en Moodle según MOODLE_USER_DELETE_POLICY. La operación en Moodle se hace en segundo plano mediante el outbox
 // @Tags Usuario
 // @Param id path int true "ID del usuario a eliminar"
+// @Param propagate query bool false "Eliminar también en Moodle (por defecto MOODLE_DELETE_PROPAGATE)"
 // @Success 204 "Usuario eliminado exitosamente"
 // @Failure 400 {string} string "ID inválido"
+// @Failure 404 {string} string "Usuario no encontrado"
 // @Failure 500 {string} string "Error al eliminar el usuario"
 // @Router /usuario/{id} [delete]
 func (h *UsuarioHandler) DeleteUsuario(w http.ResponseWriter, r *http.Request) {
 	idStr := chi.URLParam(r, "id")
 	id, _ := strconv.ParseUint(idStr, 10, 32)
 
-	if err := h.Service.DeleteLocal(uint(id)); err != nil {
-		http.Error(w, "Error al eliminar Asignatura local: "+err.Error(), http.StatusInternalServerError)
+	propagate, err := propagateParam(r)
+	if err != nil {
+		http.Error(w, "Parámetro propagate inválido (use true o false)", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.Service.Delete(uint(id), propagate); err != nil {
+		http.Error(w, "Error al eliminar Usuario: "+err.Error(), moodleErrorStatus(err))
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// checkRol comprueba que el rol local tenga correspondencia con un rol de Moodle (ver /rol-moodle).
+// Si no la tiene, o no se puede comprobar, responde con el error y devuelve false.
+func checkRol(w http.ResponseWriter, s *services.UsuarioService, rol string) bool {
+	ok, err := s.RolValido(rol)
+	if err != nil {
+		http.Error(w, "Error al validar el rol: "+err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if !ok {
+		http.Error(w, "Rol desconocido: '"+rol+"'. Los roles válidos se gestionan en /rol-moodle.", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
@@ -1,6 +1,10 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 // Asignatura representa un Curso en Moodle.
 // @Description Modelo de Asignatura (Curso) utilizado en la API y sincronizado con Moodle.
@@ -15,8 +19,17 @@ type Asignatura struct {
 	Resumen    *string `gorm:"type:text" json:"resumen,omitempty" example:"Curso introductorio de programación orientada a objetos que cubre conceptos fundamentales como clases, objetos, herencia y polimorfismo." description:"Descripción del curso (opcional)"` // Moodle: summary
 	ID_Externo *string `gorm:"type:varchar(100);unique" json:"id_externo,omitempty" example:"ASIG-POO1-2025" description:"Identificador externo único (opcional, máx. 100 caracteres)"`                                                                              // Moodle: idnumber
 
+	// Configuración del curso en Moodle. Si no se indica se toma del Cuatrimestre (visible por defecto).
+	Formato     *string    `gorm:"type:varchar(21)" json:"formato,omitempty" example:"weeks" description:"Formato del curso en Moodle (opcional, por defecto el del cuatrimestre)"`
+	Visible     *bool      `json:"visible,omitempty" example:"true" description:"Visibilidad del curso para los alumnos (opcional, por defecto visible)"`
+	FechaInicio *time.Time `json:"fecha_inicio,omitempty" example:"2025-01-06T00:00:00Z" description:"Inicio del curso (opcional, por defecto el del cuatrimestre)"`
+	FechaFin    *time.Time `json:"fecha_fin,omitempty" example:"2025-04-30T23:59:59Z" description:"Fin del curso (opcional, por defecto el del cuatrimestre)"`
+	// Curso de Moodle que sirve de plantilla: el curso se crea duplicándolo (core_course_duplicate_course).
+	PlantillaMoodleID *uint `json:"plantilla_moodle_id,omitempty" example:"42" description:"ID del curso plantilla en Moodle (opcional)"`
+
 	// Sincronización con Moodle
 	ID_Moodle *uint `gorm:"unique" json:"id_moodle,omitempty" example:"1234" description:"ID del curso en Moodle (asignado automáticamente tras sincronización)"`
+	SyncState
 
 	// Relación de Pertenencia (Clave Foránea)
 	CuatrimestreID uint `gorm:"not null" json:"cuatrimestre_id" example:"5" description:"ID del cuatrimestre al que pertenece (requerido)"` // <- ID local del Cuatrimestre
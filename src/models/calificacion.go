@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+// Calificacion es un elemento del libro de calificaciones de Moodle (o el total del curso) de un
+// alumno en una asignatura, importado con gradereport_user_get_grade_items.
+// @Description Calificación importada de Moodle para una matrícula. Se conserva aunque la matrícula se elimine, para el expediente oficial.
+type Calificacion struct {
+	ID uint `gorm:"primaryKey" json:"id" example:"1" description:"ID único de la calificación"`
+
+	// Matrícula de la que procede. No es clave foránea: la calificación sobrevive a la baja del alumno.
+	MatriculaID  uint `gorm:"not null;uniqueIndex:idx_calificacion_item" json:"matricula_id" example:"12" description:"ID de la matrícula local"`
+	UsuarioID    uint `gorm:"not null;index" json:"usuario_id" example:"25" description:"ID del usuario local"`
+	AsignaturaID uint `gorm:"not null;index" json:"asignatura_id" example:"10" description:"ID de la asignatura local"`
+
+	// Elemento de calificación en Moodle
+	ItemMoodleID uint   `gorm:"not null;uniqueIndex:idx_calificacion_item" json:"item_moodle_id" example:"345" description:"ID del elemento de calificación en Moodle"`
+	Nombre       string `gorm:"type:varchar(255)" json:"nombre" example:"Tarea 1" description:"Nombre del elemento (vacío en el total del curso)"`
+	Tipo         string `gorm:"type:varchar(50)" json:"tipo" example:"mod" description:"Tipo de elemento en Moodle: course, category, mod o manual"`
+	Modulo       string `gorm:"type:varchar(50)" json:"modulo,omitempty" example:"assign" description:"Módulo de la actividad (assign, quiz...) si el tipo es mod"`
+	TotalCurso   bool   `gorm:"not null;default:false;index" json:"total_curso" example:"false" description:"Indica si es la calificación final del curso"`
+
+	// Calificación obtenida
+	Valor             *float64   `json:"valor,omitempty" example:"8.5" description:"Calificación numérica (nula si aún no está calificado)"`
+	ValorFormateado   string     `gorm:"type:varchar(100)" json:"valor_formateado" example:"8.50" description:"Calificación tal como la muestra Moodle"`
+	Minimo            float64    `json:"minimo" example:"0" description:"Calificación mínima del elemento"`
+	Maximo            float64    `json:"maximo" example:"10" description:"Calificación máxima del elemento"`
+	Porcentaje        string     `gorm:"type:varchar(50)" json:"porcentaje,omitempty" example:"85.00 %" description:"Porcentaje formateado por Moodle"`
+	Retroalimentacion *string    `gorm:"type:text" json:"retroalimentacion,omitempty" description:"Comentario del docente"`
+	FechaCalificacion *time.Time `json:"fecha_calificacion,omitempty" description:"Fecha en que se calificó en Moodle"`
+
+	ImportadaEn time.Time `gorm:"not null" json:"importada_en" description:"Momento de la importación desde Moodle"`
+}
@@ -1,6 +1,10 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 // Cuatrimestre representa la subcategoría en Moodle.
 // @Description Modelo de Cuatrimestre utilizado en la API y sincronizado como subcategoría en Moodle.
@@ -10,6 +14,16 @@ type Cuatrimestre struct {
 	Descripcion *string `gorm:"type:text" json:"descripcion,omitempty" example:"Cuatrimestre correspondiente al periodo enero-abril 2025" description:"Descripción del cuatrimestre (opcional)"`
 	ID_Externo  *string `gorm:"type:varchar(100);unique" json:"id_externo,omitempty" example:"CUATR-2025-01" description:"Identificador externo único (opcional, máx. 100 caracteres)"`
 	ID_Moodle   *uint   `gorm:"unique" json:"id_moodle,omitempty" example:"5678" description:"ID de la subcategoría en Moodle (asignado automáticamente tras sincronización)"`
+	// Cohorte opcional con los alumnos matriculados en las asignaturas del cuatrimestre
+	CohortMoodleID *uint `gorm:"unique" json:"cohort_moodle_id,omitempty" example:"78" description:"ID de la cohorte de Moodle con los alumnos del cuatrimestre (opcional, asignado al sincronizar la cohorte)"`
+
+	// Estado de sincronización con Moodle
+	SyncState
+
+	// Valores por defecto de los cursos de Moodle de sus asignaturas
+	FechaInicio  *time.Time `json:"fecha_inicio,omitempty" example:"2025-01-06T00:00:00Z" description:"Inicio del cuatrimestre; fecha de inicio por defecto de sus cursos (opcional)"`
+	FechaFin     *time.Time `json:"fecha_fin,omitempty" example:"2025-04-30T23:59:59Z" description:"Fin del cuatrimestre; fecha de fin por defecto de sus cursos (opcional)"`
+	FormatoCurso *string    `gorm:"type:varchar(21)" json:"formato_curso,omitempty" example:"topics" description:"Formato por defecto de sus cursos en Moodle: topics, weeks, singleactivity, social... (opcional)"`
 
 	// Campo de la Clave Foránea
 	ProgramaEstudioID uint `json:"programa_estudio_id" example:"3" description:"ID del programa de estudio al que pertenece (requerido)"` // <- Asegura que el valor esté presente
@@ -0,0 +1,61 @@
+package models
+
+import "time"
+
+// Tipos de discrepancia entre la BD local y Moodle.
+const (
+	DiscrepanciaFaltaEnMoodle   = "falta_en_moodle"  // El registro local no está en Moodle (nunca se sincronizó o se eliminó allí)
+	DiscrepanciaSoloEnMoodle    = "solo_en_moodle"   // Moodle tiene una entidad que no corresponde a ningún registro local
+	DiscrepanciaSinVincular     = "sin_vincular"     // Coinciden por idnumber (o nombre corto / username) pero el ID_Moodle local no apunta a ella
+	DiscrepanciaCamposDistintos = "campos_distintos" // Están vinculados pero algún campo difiere
+)
+
+// Acciones con las que se resuelve una discrepancia.
+const (
+	AccionPush = "push" // Enviar los datos locales a Moodle
+	AccionPull = "pull" // Copiar en local los valores de Moodle
+	AccionLink = "link" // Vincular el registro local con la entidad de Moodle
+)
+
+// Estados de una discrepancia.
+const (
+	DiscrepanciaAbierta  = "abierta"
+	DiscrepanciaResuelta = "resuelta"
+	DiscrepanciaObsoleta = "obsoleta" // Una conciliación posterior la sustituyó
+)
+
+// DiferenciaCampo es un campo cuyo valor local no coincide con el de Moodle.
+type DiferenciaCampo struct {
+	Campo  string `json:"campo" example:"nombre_completo"`
+	Local  string `json:"local" example:"Programación Orientada a Objetos I"`
+	Moodle string `json:"moodle" example:"POO I (2025)"`
+}
+
+// Discrepancia es una diferencia entre un registro local y Moodle detectada por una conciliación.
+// @Description Diferencia entre la BD local y Moodle, con las acciones que la resuelven.
+type Discrepancia struct {
+	ID          uint              `gorm:"primaryKey" json:"id" example:"15" description:"ID de la discrepancia"`
+	JobID       uint              `gorm:"not null;index" json:"job_id" example:"9" description:"Trabajo de conciliación que la detectó"`
+	Entidad     string            `gorm:"type:varchar(30);not null;index" json:"entidad" example:"asignatura" description:"programa_estudio, cuatrimestre, asignatura, usuario o grupo"`
+	Tipo        string            `gorm:"type:varchar(30);not null" json:"tipo" example:"campos_distintos" description:"falta_en_moodle, solo_en_moodle, sin_vincular o campos_distintos"`
+	EntidadID   *uint             `json:"entidad_id,omitempty" example:"10" description:"ID del registro local (vacío en solo_en_moodle)"`
+	MoodleID    *uint             `json:"moodle_id,omitempty" example:"1234" description:"ID de la entidad en Moodle (vacío en falta_en_moodle)"`
+	Nombre      string            `gorm:"type:varchar(255)" json:"nombre" example:"POO1-2025-A" description:"Nombre del registro, para identificarlo"`
+	Diferencias []DiferenciaCampo `gorm:"serializer:json;type:text" json:"diferencias,omitempty" description:"Campos que difieren"`
+	Acciones    []string          `gorm:"serializer:json;type:varchar(50)" json:"acciones" example:"push,pull" description:"Acciones disponibles: push, pull, link"`
+
+	Estado     string     `gorm:"type:varchar(20);not null;index" json:"estado" example:"abierta" description:"abierta, resuelta u obsoleta"`
+	Accion     *string    `gorm:"type:varchar(10)" json:"accion,omitempty" example:"pull" description:"Acción con la que se resolvió"`
+	CreadoEn   time.Time  `gorm:"autoCreateTime" json:"creado_en"`
+	ResueltaEn *time.Time `json:"resuelta_en,omitempty"`
+}
+
+// Permite indica si la acción está entre las disponibles para la discrepancia.
+func (d Discrepancia) Permite(accion string) bool {
+	for _, a := range d.Acciones {
+		if a == accion {
+			return true
+		}
+	}
+	return false
+}
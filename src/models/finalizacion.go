@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// Finalizacion es el estado de finalización del curso de Moodle de un alumno en una asignatura,
+// importado con core_completion_get_course_completion_status.
+// @Description Estado de finalización de una asignatura para una matrícula de alumno. Se conserva aunque la matrícula se elimine.
+type Finalizacion struct {
+	ID uint `gorm:"primaryKey" json:"id" example:"1" description:"ID único del registro de finalización"`
+
+	// Matrícula de la que procede. No es clave foránea: el registro sobrevive a la baja del alumno.
+	MatriculaID  uint `gorm:"not null;uniqueIndex" json:"matricula_id" example:"12" description:"ID de la matrícula local"`
+	UsuarioID    uint `gorm:"not null;index" json:"usuario_id" example:"25" description:"ID del usuario local"`
+	AsignaturaID uint `gorm:"not null;index" json:"asignatura_id" example:"10" description:"ID de la asignatura local"`
+
+	Completada bool `gorm:"not null;default:false" json:"completada" example:"true" description:"Indica si el alumno completó el curso en Moodle"`
+	// Moodle no devuelve la fecha de finalización del curso: se usa la del último criterio completado
+	// o, si no tiene, el momento de la primera importación en que apareció como completado.
+	FechaFinalizacion    *time.Time `json:"fecha_finalizacion,omitempty" description:"Fecha en que se completó el curso"`
+	CriteriosTotales     int        `json:"criterios_totales" example:"3" description:"Criterios de finalización configurados en el curso"`
+	CriteriosCompletados int        `json:"criterios_completados" example:"2" description:"Criterios que el alumno ha cumplido"`
+
+	ImportadaEn time.Time `gorm:"not null" json:"importada_en" description:"Momento de la última importación desde Moodle"`
+}
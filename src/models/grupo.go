@@ -11,6 +11,9 @@ type Grupo struct {
 	ID_Moodle         *uint  `gorm:"unique" json:"id_moodle,omitempty" example:"888" description:"ID del grupo en Moodle (asignado automáticamente tras sincronización)"` // ID del Grupo devuelto por Moodle
 	Description       string `json:"description,omitempty" example:"Grupo de clases matutinas para el curso de Programación" description:"Descripción del grupo (opcional)"`
 	DescriptionFormat int    `json:"descriptionformat,omitempty" example:"1" description:"Formato de la descripción (1=HTML, 0=texto plano)"`
+	EnrolmentKey      string `gorm:"type:varchar(100)" json:"enrolmentkey,omitempty" example:"claveA2025" description:"Clave de matriculación del grupo en Moodle (opcional)"`
+	Visibility        int    `gorm:"not null;default:0" json:"visibility" example:"0" description:"Visibilidad en Moodle (0=visible a todos, 1=solo miembros, 2=solo la propia pertenencia, 3=oculto)"`
+	SyncState
 	// Relación Many-to-Many (Inversa)
 	Usuarios []Usuario `gorm:"many2many:usuario_grupos;" json:"usuarios,omitempty" swaggerignore:"true"`
 }
@@ -18,9 +18,14 @@ type Matricula struct {
 	// Creamos un índice único compuesto para evitar dobles enrolamientos.
 	CourseMoodleID uint `gorm:"not null;uniqueIndex:idx_unique_enrollment" json:"course_moodle_id" example:"1234" description:"ID del curso en Moodle (requerido, único por combinación usuario-curso)"`
 	UserMoodleID   uint `gorm:"not null;uniqueIndex:idx_unique_enrollment" json:"user_moodle_id" example:"5678" description:"ID del usuario en Moodle (requerido, único por combinación usuario-curso)"`
-	RoleID         uint `gorm:"not null" json:"role_id" example:"5" description:"ID del rol en Moodle (requerido: 5=Estudiante, 3=Docente)"` // 5=Estudiante, 3=Docente
+	RoleID         uint `gorm:"not null" json:"role_id" example:"5" description:"ID del rol en Moodle asignado en este curso, según la correspondencia de /rol-moodle (p. ej. 5=Estudiante, 3=Docente)"`
 
 	// Tiempos de enrolamiento
 	Timestart *int64 `json:"timestart,omitempty" example:"1704067200" description:"Timestamp de inicio del enrolamiento (opcional, UNIX timestamp)"`
 	Timeend   *int64 `json:"timeend,omitempty" example:"1719792000" description:"Timestamp de finalización del enrolamiento (opcional, UNIX timestamp)"`
+	// Suspensión: la matrícula se conserva (con su historial) pero el usuario no puede acceder al curso
+	Suspended bool `gorm:"not null;default:false" json:"suspended" example:"false" description:"Indica si la matrícula está suspendida en Moodle"`
+
+	// Estado de sincronización con Moodle
+	SyncState
 }
@@ -0,0 +1,63 @@
+package models
+
+import "time"
+
+// Entidades cuyos cambios locales se propagan a Moodle mediante el outbox.
+const (
+	OutboxProgramaEstudio = "programa_estudio"
+	OutboxCuatrimestre    = "cuatrimestre"
+	OutboxAsignatura      = "asignatura"
+	OutboxUsuario         = "usuario"
+	OutboxGrupo           = "grupo"
+	// Matrículas de un usuario (EntidadID es el ID del usuario: sus altas y bajas se entregan en orden)
+	OutboxMatricula = "matricula"
+	// Miembros de un grupo (EntidadID es el ID del grupo)
+	OutboxGrupoMiembros = "grupo_miembros"
+)
+
+// Operaciones locales registradas en el outbox.
+const (
+	OutboxCreate = "create"
+	OutboxUpdate = "update"
+	OutboxDelete = "delete"
+)
+
+// Estados de un evento del outbox.
+const (
+	OutboxPendiente = "pendiente"
+	OutboxEnviado   = "enviado"
+	OutboxFallido   = "fallido"
+)
+
+// OutboxEvent es un cambio local pendiente de llevar a Moodle. Se guarda en la misma transacción que el
+// cambio, así que no se pierde si la API se detiene antes de enviarlo. Los eventos de un mismo registro
+// se entregan en orden: mientras uno no se envía, los posteriores esperan.
+// @Description Cambio local registrado para propagarlo a Moodle en segundo plano.
+type OutboxEvent struct {
+	ID        uint   `gorm:"primaryKey" json:"id" example:"42" description:"ID del evento (define el orden de entrega)"`
+	Entidad   string `gorm:"type:varchar(30);not null;index:idx_outbox_registro" json:"entidad" example:"asignatura" description:"programa_estudio, cuatrimestre, asignatura, usuario, grupo, matricula o grupo_miembros"`
+	EntidadID uint   `gorm:"not null;index:idx_outbox_registro" json:"entidad_id" example:"10" description:"ID local del registro"`
+	Operacion string `gorm:"type:varchar(10);not null" json:"operacion" example:"update" description:"create, update o delete"`
+	// Solo en delete: el registro local ya no existe y esto es lo que hay que eliminar en Moodle
+	MoodleID *uint `json:"moodle_id,omitempty" example:"1234" description:"ID en Moodle a eliminar (delete con propagación)"`
+	// Solo en bajas de matrículas y grupos: el usuario a quitar del curso o grupo MoodleID
+	UserMoodleID *uint `json:"user_moodle_id,omitempty" example:"77" description:"ID en Moodle del usuario a dar de baja del curso o grupo"`
+
+	Estado           string     `gorm:"type:varchar(20);not null;index" json:"estado" example:"pendiente" description:"pendiente, enviado o fallido (agotó los reintentos)"`
+	Intentos         int        `gorm:"not null;default:0" json:"intentos" example:"0" description:"Intentos de entrega fallidos"`
+	UltimoError      *string    `gorm:"type:text" json:"ultimo_error,omitempty" description:"Error del último intento"`
+	SiguienteIntento time.Time  `gorm:"not null" json:"siguiente_intento" description:"No se intenta entregar antes de este momento"`
+	CreadoEn         time.Time  `gorm:"autoCreateTime" json:"creado_en" description:"Momento del cambio local"`
+	EnviadoEn        *time.Time `json:"enviado_en,omitempty" description:"Momento en que se entregó a Moodle"`
+}
+
+// NewOutboxEvent crea un evento pendiente para entregar en cuanto sea posible.
+func NewOutboxEvent(entidad string, id uint, operacion string) *OutboxEvent {
+	return &OutboxEvent{
+		Entidad:          entidad,
+		EntidadID:        id,
+		Operacion:        operacion,
+		Estado:           OutboxPendiente,
+		SiguienteIntento: time.Now(),
+	}
+}
@@ -0,0 +1,16 @@
+package models
+
+// RolMoodle asocia un rol local (el Rol del Usuario o el indicado al matricular) con el rol de Moodle
+// que se asigna en enrol_manual_enrol_users. Los IDs de rol cambian entre sitios, por eso no se fijan en código.
+// @Description Correspondencia entre un rol local y un rol de Moodle.
+type RolMoodle struct {
+	ID uint `gorm:"primaryKey" json:"id" example:"1" description:"ID único de la correspondencia"`
+
+	Nombre string `gorm:"type:varchar(50);not null;uniqueIndex" json:"nombre" example:"Alumno" description:"Nombre del rol local (requerido, único)"`
+	// MoodleRoleID 0 significa que aún no se conoce: se resuelve por Shortname al validar contra Moodle.
+	MoodleRoleID uint   `gorm:"not null;default:0" json:"moodle_role_id" example:"5" description:"ID del rol en Moodle (0 = pendiente de resolver por shortname)"`
+	Shortname    string `gorm:"type:varchar(100)" json:"shortname" example:"student" description:"Shortname del rol en Moodle, usado para validar y resolver el ID"`
+	// Estudiante indica que las matrículas con este rol son de alumnos (calificaciones, finalización, cohortes).
+	Estudiante  bool   `gorm:"not null;default:false" json:"estudiante" example:"true" description:"Las matrículas con este rol cuentan como de alumno"`
+	Descripcion string `gorm:"type:varchar(255)" json:"descripcion,omitempty" example:"Estudiante del curso" description:"Descripción (opcional)"`
+}
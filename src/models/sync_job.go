@@ -0,0 +1,51 @@
+package models
+
+import "time"
+
+// Tipos de trabajo de sincronización.
+const (
+	SyncJobUsuarios      = "usuarios"
+	SyncJobCuatrimestres = "cuatrimestres"
+	SyncJobAsignaturas   = "asignaturas"
+	SyncJobGrupos        = "grupos"
+	SyncJobConciliacion  = "conciliacion"
+)
+
+// Estados de un trabajo de sincronización.
+const (
+	SyncJobPendiente  = "pendiente"
+	SyncJobEnCurso    = "en_curso"
+	SyncJobCompletado = "completado"
+	SyncJobFallido    = "fallido"
+	SyncJobCancelado  = "cancelado"
+)
+
+// SyncJob es una sincronización masiva con Moodle lanzada en segundo plano.
+// @Description Trabajo de sincronización masiva: su estado y sus contadores se actualizan mientras avanza.
+type SyncJob struct {
+	ID         uint    `gorm:"primaryKey" json:"id" example:"7" description:"ID del trabajo"`
+	Tipo       string  `gorm:"type:varchar(30);not null;index" json:"tipo" example:"usuarios" description:"Entidad sincronizada (usuarios, cuatrimestres, asignaturas o grupos) o conciliacion"`
+	Parametros *string `gorm:"type:varchar(255)" json:"parametros,omitempty" example:"role=Alumno" description:"Parámetros con los que se lanzó"`
+	Estado     string  `gorm:"type:varchar(20);not null;index" json:"estado" example:"en_curso" description:"pendiente, en_curso, completado, fallido o cancelado"`
+	Mensaje    *string `gorm:"type:text" json:"mensaje,omitempty" description:"Motivo por el que el trabajo no pudo completarse"`
+
+	Total    int `json:"total" example:"120" description:"Registros a sincronizar"`
+	Exitosos int `json:"exitosos" example:"118" description:"Registros sincronizados"`
+	Errores  int `json:"errores" example:"2" description:"Registros que fallaron"`
+
+	CreadoEn     time.Time  `gorm:"autoCreateTime" json:"creado_en" description:"Momento en que se solicitó"`
+	IniciadoEn   *time.Time `json:"iniciado_en,omitempty" description:"Momento en que empezó a ejecutarse"`
+	FinalizadoEn *time.Time `json:"finalizado_en,omitempty" description:"Momento en que terminó"`
+
+	Detalle []SyncJobError `gorm:"foreignKey:JobID;constraint:OnDelete:CASCADE" json:"detalle,omitempty" description:"Errores por registro"`
+}
+
+// SyncJobError es el error de un registro concreto dentro de un trabajo de sincronización.
+type SyncJobError struct {
+	ID        uint      `gorm:"primaryKey" json:"-"`
+	JobID     uint      `gorm:"not null;index" json:"-"`
+	Entidad   string    `gorm:"type:varchar(30);not null" json:"entidad" example:"usuario"`
+	EntidadID uint      `json:"entidad_id" example:"42"`
+	Mensaje   string    `gorm:"type:text;not null" json:"mensaje"`
+	Fecha     time.Time `gorm:"autoCreateTime" json:"fecha"`
+}
@@ -0,0 +1,63 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Estados de sincronización de un registro con Moodle.
+const (
+	SyncPending = "pending" // Nunca se ha enviado a Moodle
+	SyncSynced  = "synced"  // Moodle tiene los datos locales actuales
+	SyncDirty   = "dirty"   // Se modificó localmente después de la última sincronización
+	SyncFailed  = "failed"  // El último intento de sincronización falló
+)
+
+// SyncState es el estado de sincronización con Moodle de un registro. Se embebe en cada modelo que
+// se sincroniza; sus campos los mantienen los servicios y se ignoran si llegan en el cuerpo de una petición.
+type SyncState struct {
+	SyncStatus    string     `gorm:"type:varchar(10);not null;default:pending;index" json:"sync_status" example:"synced" description:"Estado de sincronización con Moodle: pending, synced, dirty o failed"`
+	LastSyncedAt  *time.Time `json:"last_synced_at,omitempty" description:"Última sincronización correcta con Moodle"`
+	LastSyncError *string    `gorm:"type:text" json:"last_sync_error,omitempty" description:"Error del último intento de sincronización fallido"`
+	PayloadHash   *string    `gorm:"type:char(64)" json:"payload_hash,omitempty" description:"SHA-256 de los datos enviados a Moodle en la última sincronización correcta"`
+}
+
+// BeforeCreate deja pendientes de sincronizar los registros nuevos que no indican otro estado.
+func (s *SyncState) BeforeCreate(*gorm.DB) error {
+	if s.SyncStatus == "" {
+		s.SyncStatus = SyncPending
+	}
+	return nil
+}
+
+// MarkSynced registra una sincronización correcta con los datos cuyo hash es hash.
+func (s *SyncState) MarkSynced(hash string) {
+	now := time.Now()
+	s.SyncStatus = SyncSynced
+	s.LastSyncedAt = &now
+	s.LastSyncError = nil
+	s.PayloadHash = &hash
+}
+
+// MarkFailed registra un intento de sincronización fallido. Conserva la fecha y el hash de la última
+// sincronización correcta.
+func (s *SyncState) MarkFailed(err error) {
+	msg := err.Error()
+	s.SyncStatus = SyncFailed
+	s.LastSyncError = &msg
+}
+
+// MarkDirty compara el hash de los datos locales actuales con el de los últimos enviados: un registro
+// sincronizado pasa a dirty si cambiaron, y uno dirty vuelve a synced si los cambios se deshicieron.
+// Los registros pending o failed no cambian de estado: ya están pendientes de sincronizar.
+func (s *SyncState) MarkDirty(hash string) {
+	if s.SyncStatus != SyncSynced && s.SyncStatus != SyncDirty {
+		return
+	}
+	if s.PayloadHash != nil && *s.PayloadHash == hash {
+		s.SyncStatus = SyncSynced
+		return
+	}
+	s.SyncStatus = SyncDirty
+}
@@ -0,0 +1,18 @@
+package moodle
+
+import "context"
+
+// MoodleAPI es el contrato que usan los servicios para hablar con el WebService de Moodle.
+// Client lo implementa contra un Moodle real y FakeClient lo implementa en memoria,
+// lo que permite probar la lógica de sincronización sin un servidor Moodle.
+type MoodleAPI interface {
+	// Call ejecuta la función del WebService indicada con los datos dados y
+	// decodifica la respuesta JSON en response. La llamada se aborta si ctx se cancela.
+	Call(ctx context.Context, function string, data interface{}, response interface{}) error
+}
+
+// Verificación en tiempo de compilación de que ambas implementaciones cumplen la interfaz.
+var (
+	_ MoodleAPI = (*Client)(nil)
+	_ MoodleAPI = (*FakeClient)(nil)
+)
@@ -0,0 +1,180 @@
+package moodle
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// defaultChunkSizes son los tamaños por función que se aplican si no se configuran otros.
+// La matrícula envía más campos por elemento, por eso usa lotes más pequeños.
+var defaultChunkSizes = map[string]int{
+	"enrol_manual_enrol_users": 50,
+}
+
+// ChunkPolicy decide en cuántos elementos se parte el array de una llamada. Cada elemento se
+// aplana en varios parámetros (users[i][username], users[i][email]...), así que un lote grande
+// puede superar max_input_vars de PHP o el tamaño máximo de la petición en Moodle.
+type ChunkPolicy struct {
+	DefaultSize int            // Tamaño para las funciones sin tamaño propio (0 = sin partir)
+	Sizes       map[string]int // Tamaño por función
+}
+
+// DefaultChunkPolicy lee la configuración del entorno:
+//   - MOODLE_CHUNK_SIZE: elementos por llamada para cualquier función (por defecto 100, 0 = sin partir).
+//   - MOODLE_CHUNK_SIZES: tamaños por función, p. ej. "core_user_create_users=200,enrol_manual_enrol_users=50".
+func DefaultChunkPolicy() *ChunkPolicy {
+	p := &ChunkPolicy{
+		DefaultSize: EnvInt("MOODLE_CHUNK_SIZE", 100),
+		Sizes:       make(map[string]int),
+	}
+	for fn, size := range defaultChunkSizes {
+		p.Sizes[fn] = size
+	}
+
+	raw := os.Getenv("MOODLE_CHUNK_SIZES")
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		fn, sizeStr, ok := strings.Cut(entry, "=")
+		size, err := strconv.Atoi(strings.TrimSpace(sizeStr))
+		if !ok || err != nil || size < 0 {
+			log.Printf("⚠️ Entrada inválida en MOODLE_CHUNK_SIZES (%q). Se ignora.", entry)
+			continue
+		}
+		p.Sizes[strings.TrimSpace(fn)] = size
+	}
+	return p
+}
+
+// SizeFor devuelve el tamaño de lote para function (0 = sin partir).
+func (p *ChunkPolicy) SizeFor(function string) int {
+	if p == nil {
+		return 0
+	}
+	if size, ok := p.Sizes[function]; ok {
+		return size
+	}
+	return p.DefaultSize
+}
+
+// ChunkError indica que una llamada partida en lotes falló a mitad. Los lotes anteriores ya se
+// aplicaron en Moodle y sus resultados están en la respuesta, en el mismo orden que los datos.
+type ChunkError struct {
+	Function  string
+	Completed int // Elementos procesados con éxito antes del fallo
+	Failed    int // Elementos del lote que falló (los siguientes no se enviaron)
+	Total     int
+	Err       error
+}
+
+func (e *ChunkError) Error() string {
+	return fmt.Sprintf("%s falló tras procesar %d de %d elementos: %v", e.Function, e.Completed, e.Total, e.Err)
+}
+
+func (e *ChunkError) Unwrap() error {
+	return e.Err
+}
+
+// callFunc es una llamada sin partir, como Client.call.
+type callFunc func(ctx context.Context, function string, data interface{}, response interface{}) error
+
+// callInChunks parte el único campo slice de data en lotes de size elementos, llama a call por cada
+// lote en orden y fusiona las respuestas en response: los slices se concatenan (también los de
+// dentro de un struct, como warnings) y el resto de valores se toma del último lote.
+// Si data no tiene exactamente un campo slice, o cabe en un lote, se hace una sola llamada.
+// Un campo slice con la etiqueta `moodle:"nochunk"` (p. ej. criterios de búsqueda) nunca se parte.
+func callInChunks(ctx context.Context, size int, function string, data interface{}, response interface{}, call callFunc) error {
+	params, field, ok := chunkableField(data)
+	if !ok || size <= 0 || params.Field(field).Len() <= size {
+		return call(ctx, function, data, response)
+	}
+
+	items := params.Field(field)
+	total := items.Len()
+	for start := 0; start < total; start += size {
+		end := min(start+size, total)
+
+		chunk := reflect.New(params.Type()).Elem()
+		chunk.Set(params)
+		chunk.Field(field).Set(items.Slice(start, end))
+
+		var part reflect.Value
+		var partResponse interface{}
+		if response != nil {
+			part = reflect.New(reflect.TypeOf(response).Elem())
+			partResponse = part.Interface()
+		}
+
+		if err := call(ctx, function, chunk.Interface(), partResponse); err != nil {
+			return &ChunkError{Function: function, Completed: start, Failed: end - start, Total: total, Err: err}
+		}
+		if response != nil {
+			mergeResponse(reflect.ValueOf(response).Elem(), part.Elem())
+		}
+	}
+	return nil
+}
+
+// chunkableField localiza el único campo slice partible del struct de parámetros.
+func chunkableField(data interface{}) (reflect.Value, int, bool) {
+	v := reflect.ValueOf(data)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return reflect.Value{}, 0, false
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return reflect.Value{}, 0, false
+	}
+
+	field := -1
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() || f.Type.Kind() != reflect.Slice || f.Tag.Get("moodle") == "nochunk" {
+			continue
+		}
+		if field >= 0 {
+			return reflect.Value{}, 0, false // Más de un array: no sabemos cuál partir
+		}
+		field = i
+	}
+	return v, field, field >= 0
+}
+
+// mergeResponse acumula en dst la respuesta src de un lote.
+func mergeResponse(dst, src reflect.Value) {
+	switch dst.Kind() {
+	case reflect.Slice:
+		dst.Set(reflect.AppendSlice(dst, src))
+	case reflect.Struct:
+		for i := 0; i < dst.NumField(); i++ {
+			if dst.Type().Field(i).IsExported() {
+				mergeResponse(dst.Field(i), src.Field(i))
+			}
+		}
+	case reflect.Interface:
+		// Respuestas decodificadas en interface{}: se concatenan si ambas son arrays JSON.
+		prev, okPrev := dst.Interface().([]interface{})
+		next, okNext := src.Interface().([]interface{})
+		if okPrev && okNext {
+			dst.Set(reflect.ValueOf(append(prev, next...)))
+			return
+		}
+		if !src.IsNil() {
+			dst.Set(src)
+		}
+	default:
+		if !src.IsZero() {
+			dst.Set(src)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package moodle
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// usersCall simula core_user_create_users: devuelve un usuario por elemento, con el ID igual a su
+// posición global, y falla en el lote número failOn (0 = nunca).
+func usersCall(calls *[]int, failOn int) callFunc {
+	next := uint(1)
+	return func(ctx context.Context, function string, data interface{}, response interface{}) error {
+		params := data.(CreateUsersParams)
+		*calls = append(*calls, len(params.Users))
+		if len(*calls) == failOn {
+			return &HTTPError{StatusCode: 500}
+		}
+		resp := response.(*[]UserResponse)
+		for _, u := range params.Users {
+			*resp = append(*resp, UserResponse{ID: next, Username: u.Username})
+			next++
+		}
+		return nil
+	}
+}
+
+func nUsers(n int) CreateUsersParams {
+	users := make([]UserRequest, n)
+	for i := range users {
+		users[i].Username = string(rune('a' + i))
+	}
+	return CreateUsersParams{Users: users}
+}
+
+func TestCallInChunks(t *testing.T) {
+	tests := []struct {
+		name          string
+		items         int
+		size          int
+		failOn        int
+		wantCalls     []int
+		wantResponse  int
+		wantCompleted int // -1: sin error
+	}{
+		{"cabe en un lote", 3, 5, 0, []int{3}, 3, -1},
+		{"sin partir (size 0)", 7, 0, 0, []int{7}, 7, -1},
+		{"lotes exactos", 6, 3, 0, []int{3, 3}, 6, -1},
+		{"último lote incompleto", 7, 3, 0, []int{3, 3, 1}, 7, -1},
+		{"falla el primer lote", 7, 3, 1, []int{3}, 0, 0},
+		{"falla el tercer lote", 7, 3, 3, []int{3, 3, 1}, 6, 6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls []int
+			var response []UserResponse
+			err := callInChunks(context.Background(), tt.size, "core_user_create_users", nUsers(tt.items), &response, usersCall(&calls, tt.failOn))
+
+			if len(calls) != len(tt.wantCalls) {
+				t.Fatalf("lotes = %v, se esperaban %v", calls, tt.wantCalls)
+			}
+			for i := range calls {
+				if calls[i] != tt.wantCalls[i] {
+					t.Fatalf("lotes = %v, se esperaban %v", calls, tt.wantCalls)
+				}
+			}
+			// Las respuestas de los lotes aplicados se conservan en orden, también si uno falla.
+			if len(response) != tt.wantResponse {
+				t.Fatalf("respuesta con %d usuarios, se esperaban %d", len(response), tt.wantResponse)
+			}
+			for i, u := range response {
+				if u.ID != uint(i+1) {
+					t.Errorf("respuesta[%d].ID = %d, se esperaba %d", i, u.ID, i+1)
+				}
+			}
+
+			if tt.wantCompleted < 0 {
+				if err != nil {
+					t.Fatalf("error inesperado: %v", err)
+				}
+				return
+			}
+			var chunkErr *ChunkError
+			if !errors.As(err, &chunkErr) {
+				t.Fatalf("se esperaba *ChunkError, se obtuvo %v", err)
+			}
+			if chunkErr.Completed != tt.wantCompleted || chunkErr.Total != tt.items {
+				t.Errorf("ChunkError = %d de %d, se esperaba %d de %d", chunkErr.Completed, chunkErr.Total, tt.wantCompleted, tt.items)
+			}
+			// El lote que falló es el último que se envió.
+			if last := tt.wantCalls[len(tt.wantCalls)-1]; chunkErr.Failed != last {
+				t.Errorf("ChunkError.Failed = %d, se esperaba %d", chunkErr.Failed, last)
+			}
+			// El error original sigue accesible para decidir si se reintenta.
+			if !IsTransient(err) {
+				t.Error("el ChunkError debería conservar el error transitorio del lote")
+			}
+		})
+	}
+}
+
+// Los avisos de cada lote se concatenan y los criterios de búsqueda nunca se parten.
+func TestCallInChunksMergeAndNoChunk(t *testing.T) {
+	type categoriesResponse struct {
+		Categories []Category `json:"categories"`
+		Warnings   []Warning  `json:"warnings"`
+	}
+	var calls int
+	call := func(ctx context.Context, function string, data interface{}, response interface{}) error {
+		calls++
+		resp := response.(*categoriesResponse)
+		resp.Categories = append(resp.Categories, Category{ID: uint(calls)})
+		resp.Warnings = append(resp.Warnings, Warning{ItemID: calls})
+		return nil
+	}
+
+	var response categoriesResponse
+	params := GetCategoriesParams{Criteria: []CategoryCriteria{{Key: "a"}, {Key: "b"}, {Key: "c"}}}
+	if err := callInChunks(context.Background(), 1, "core_course_get_categories", params, &response, call); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Errorf("los criterios nochunk se partieron en %d llamadas", calls)
+	}
+
+	calls = 0
+	response = categoriesResponse{}
+	multi := struct {
+		Items []int `json:"items"`
+	}{Items: []int{1, 2, 3}}
+	if err := callInChunks(context.Background(), 2, "f", multi, &response, call); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 || len(response.Categories) != 2 || len(response.Warnings) != 2 {
+		t.Errorf("llamadas = %d, respuesta = %+v; se esperaban 2 llamadas con sus categorías y avisos concatenados", calls, response)
+	}
+}
+
+func TestChunkPolicySizeFor(t *testing.T) {
+	t.Setenv("MOODLE_CHUNK_SIZE", "20")
+	t.Setenv("MOODLE_CHUNK_SIZES", "core_user_create_users=200, mal, otra=-1")
+	p := DefaultChunkPolicy()
+
+	tests := []struct {
+		function string
+		want     int
+	}{
+		{"core_user_create_users", 200},
+		{"enrol_manual_enrol_users", 50},
+		{"core_course_create_courses", 20},
+		{"otra", 20},
+	}
+	for _, tt := range tests {
+		if got := p.SizeFor(tt.function); got != tt.want {
+			t.Errorf("SizeFor(%s) = %d, se esperaba %d", tt.function, got, tt.want)
+		}
+	}
+	if got := (*ChunkPolicy)(nil).SizeFor("x"); got != 0 {
+		t.Errorf("una política nil no debería partir, SizeFor = %d", got)
+	}
+}
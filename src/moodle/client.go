@@ -1,63 +1,103 @@
 package moodle
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Client struct {
-	BaseURL string
-	Token   string
+	BaseURL    string
+	Token      string
+	HTTPClient *http.Client // Cliente HTTP compartido (pool de conexiones y timeouts)
+	Retry      *RetryPolicy // Política de reintentos ante fallos transitorios
+	Limiter    *Limiter     // Limitador de tasa y concurrencia (compartido por todo el proceso)
+	Logger     *CallLogger  // Registro de llamadas con secretos redactados
+	Chunking   *ChunkPolicy // Tamaño de lote por función para arrays grandes
+
+	site atomic.Pointer[SiteReport] // Última comprobación de funciones habilitadas (ver CheckSite)
 }
 
 func NewClient() *Client {
 	// Es crucial usar variables de entorno para estos valores
 	return &Client{
-		BaseURL: os.Getenv("MOODLE_URL"),
-		Token:   os.Getenv("MOODLE_TOKEN"),
+		BaseURL:    os.Getenv("MOODLE_URL"),
+		Token:      os.Getenv("MOODLE_TOKEN"),
+		HTTPClient: NewHTTPClient(),
+		Retry:      DefaultRetryPolicy(),
+		Limiter:    SharedLimiter(),
+		Logger:     NewCallLoggerFromEnv(),
+		Chunking:   DefaultChunkPolicy(),
+	}
+}
+
+// NewHTTPClient construye el cliente HTTP usado para hablar con Moodle. Se configura con:
+//   - MOODLE_CONNECT_TIMEOUT: tiempo máximo para abrir la conexión TCP/TLS (por defecto 10s).
+//   - MOODLE_READ_TIMEOUT: tiempo máximo de espera de la respuesta una vez enviada la petición (por defecto 60s).
+//   - MOODLE_REQUEST_TIMEOUT: tiempo total máximo de una llamada, incluida la lectura del cuerpo (por defecto 2m).
+//   - MOODLE_MAX_IDLE_CONNS: conexiones inactivas que se mantienen abiertas hacia Moodle (por defecto 20).
+func NewHTTPClient() *http.Client {
+	connectTimeout := EnvDuration("MOODLE_CONNECT_TIMEOUT", 10*time.Second)
+	readTimeout := EnvDuration("MOODLE_READ_TIMEOUT", 60*time.Second)
+	requestTimeout := EnvDuration("MOODLE_REQUEST_TIMEOUT", 2*time.Minute)
+	maxIdle := EnvInt("MOODLE_MAX_IDLE_CONNS", 20)
+
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   connectTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout:   connectTimeout,
+		ResponseHeaderTimeout: readTimeout,
+		MaxIdleConns:          maxIdle,
+		MaxIdleConnsPerHost:   maxIdle,
+		IdleConnTimeout:       90 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   requestTimeout,
 	}
 }
 
 // Call ejecuta una llamada genérica al WebService de Moodle.
-func (c *Client) Call(function string, data interface{}, response interface{}) error {
+// Si ctx se cancela (petición HTTP terminada o tarea cancelada) la llamada en curso se aborta.
+// Los arrays más grandes que el tamaño de lote de la función se envían en varias llamadas y las
+// respuestas se fusionan (ver ChunkPolicy); si falla un lote se devuelve un *ChunkError.
+func (c *Client) Call(ctx context.Context, function string, data interface{}, response interface{}) error {
 	if c.BaseURL == "" || c.Token == "" {
 		return fmt.Errorf("URL y Token de Moodle no configurados")
 	}
+	if report := c.site.Load(); report != nil && !report.Supports(function) {
+		return fmt.Errorf("%s no está habilitada para el token de Moodle: %w", function, ErrUnsupported)
+	}
+	return callInChunks(ctx, c.Chunking.SizeFor(function), function, data, response, c.call)
+}
 
-	log.Printf("URL: "+c.BaseURL, " Token: "+c.Token)
+// SetSiteReport asocia al cliente el resultado de CheckSite. Con un informe asignado, las funciones
+// que Moodle no tiene habilitadas para el token fallan con ErrUnsupported sin hacer la petición.
+func (c *Client) SetSiteReport(report *SiteReport) {
+	c.site.Store(report)
+}
 
-	// 1. Determinar la clave de la función
-	var functionKey string
-	switch function {
-	case "core_course_create_categories":
-		functionKey = "categories"
-	case "core_course_update_categories":
-		functionKey = "categories"
-	case "core_course_create_courses":
-		functionKey = "courses"
-	case "core_course_update_courses":
-		functionKey = "courses"
-	case "core_user_create_users":
-		functionKey = "users"
-	case "core_user_update_users":
-		functionKey = "users"
-	case "enrol_manual_enrol_users":
-		functionKey = "enrolments"
-	case "core_group_create_groups":
-		functionKey = "groups"
-	case "core_group_add_group_members":
-		functionKey = "members"
-	default:
-		return fmt.Errorf("función Moodle desconocida: %s. No se puede determinar la clave del payload", function)
-	}
+// SiteReport devuelve la última comprobación de capacidades, o nil si no se ha hecho ninguna.
+func (c *Client) SiteReport() *SiteReport {
+	return c.site.Load()
+}
 
-	log.Printf("Función Moodle: "+function, " Clave de datos: "+functionKey)
+// call envía una única llamada (sin partir en lotes), reintentando los fallos transitorios.
+func (c *Client) call(ctx context.Context, function string, data interface{}, response interface{}) error {
 
 	postBody := url.Values{}
 
@@ -65,247 +105,122 @@ func (c *Client) Call(function string, data interface{}, response interface{}) e
 	postBody.Set("wsfunction", function)
 	postBody.Set("moodlewsrestformat", "json")
 
-	log.Printf("Datos preparados para función %s: %s", function, postBody.Encode()) // Usar %s
-	urlMoodle := fmt.Sprintf("%s/webservice/rest/server.php", c.BaseURL)
-	// **NUEVA LÓGICA:** Aplanar la estructura de datos
-
-	switch function {
-	case "core_course_create_categories":
-		categories, ok := data.([]CategoryRequest)
-		if !ok {
-			return fmt.Errorf("error de tipo: se esperaba []CategoryRequest")
-		}
-		for i, cat := range categories {
-			prefix := fmt.Sprintf("%s[%d]", functionKey, i)
-			postBody.Set(fmt.Sprintf("%s[name]", prefix), cat.Name)
-			postBody.Set(fmt.Sprintf("%s[parent]", prefix), fmt.Sprintf("%d", cat.Parent))
-			if cat.IDNumber != "" {
-				postBody.Set(fmt.Sprintf("%s[idnumber]", prefix), cat.IDNumber)
-			}
-			if cat.Description != "" {
-				postBody.Set(fmt.Sprintf("%s[description]", prefix), cat.Description)
-			}
-		}
-		log.Printf("DEBUG: %d categorías codificadas.", len(categories))
-
-	case "core_course_create_courses":
-		courses, ok := data.([]CourseRequest)
-		if !ok {
-			return fmt.Errorf("error de tipo: se esperaba []CourseRequest")
-		}
-		for i, course := range courses {
-			prefix := fmt.Sprintf("%s[%d]", functionKey, i)
-			postBody.Set(fmt.Sprintf("%s[fullname]", prefix), course.Fullname)
-			postBody.Set(fmt.Sprintf("%s[shortname]", prefix), course.Shortname)
-			postBody.Set(fmt.Sprintf("%s[categoryid]", prefix), fmt.Sprintf("%d", course.Categoryid))
-			postBody.Set(fmt.Sprintf("%s[visible]", prefix), "1") // Forzar visibilidad
-			if course.IDNumber != "" {
-				postBody.Set(fmt.Sprintf("%s[idnumber]", prefix), course.IDNumber)
-			}
-			if course.Summary != "" {
-				postBody.Set(fmt.Sprintf("%s[summary]", prefix), course.Summary)
-			}
-		}
-
-	case "core_user_create_users":
-		users, ok := data.([]UserRequest)
-		if !ok {
-			return fmt.Errorf("error de tipo: se esperaba []UserRequest")
-		}
-		for i, user := range users {
-			prefix := fmt.Sprintf("%s[%d]", functionKey, i)
-			postBody.Set(fmt.Sprintf("%s[username]", prefix), user.Username)
-			postBody.Set(fmt.Sprintf("%s[password]", prefix), user.Password)
-			postBody.Set(fmt.Sprintf("%s[firstname]", prefix), user.Firstname)
-			postBody.Set(fmt.Sprintf("%s[lastname]", prefix), user.Lastname)
-			postBody.Set(fmt.Sprintf("%s[email]", prefix), user.Email)
-			if user.IDNumber != "" {
-				postBody.Set(fmt.Sprintf("%s[idnumber]", prefix), user.IDNumber)
-			}
-		}
-		log.Printf("DEBUG: %d usuarios codificados.", len(users))
-
-	case "core_user_update_users":
-		updates, ok := data.([]UserUpdateRequest)
-		if !ok {
-			return fmt.Errorf("error de tipo: se esperaba []UserUpdateRequest")
-		}
-		for i, user := range updates {
-			prefix := fmt.Sprintf("%s[%d]", functionKey, i)
-			postBody.Set(fmt.Sprintf("%s[id]", prefix), fmt.Sprintf("%d", user.ID))
-			if user.Username != "" {
-				postBody.Set(fmt.Sprintf("%s[username]", prefix), user.Username)
-			}
-			if user.Firstname != "" {
-				postBody.Set(fmt.Sprintf("%s[firstname]", prefix), user.Firstname)
-			}
-			if user.Lastname != "" {
-				postBody.Set(fmt.Sprintf("%s[lastname]", prefix), user.Lastname)
-			}
-			if user.Email != "" {
-				postBody.Set(fmt.Sprintf("%s[email]", prefix), user.Email)
-			}
-			if user.IDNumber != "" {
-				postBody.Set(fmt.Sprintf("%s[idnumber]", prefix), user.IDNumber)
-			}
-			if user.Password != "" {
-				postBody.Set(fmt.Sprintf("%s[password]", prefix), user.Password)
-			}
-		}
-		log.Printf("DEBUG: %d usuarios a actualizar.", len(updates))
-
-	case "core_course_update_categories":
-		updates, ok := data.([]CategoryUpdateRequest)
-		if !ok {
-			return fmt.Errorf("error de tipo: se esperaba []CategoryUpdateRequest")
-		}
-		for i, cat := range updates {
-			prefix := fmt.Sprintf("%s[%d]", functionKey, i)
-			postBody.Set(fmt.Sprintf("%s[id]", prefix), fmt.Sprintf("%d", cat.ID))
-			if cat.Name != "" {
-				postBody.Set(fmt.Sprintf("%s[name]", prefix), cat.Name)
-			}
-			if cat.IDNumber != "" {
-				postBody.Set(fmt.Sprintf("%s[idnumber]", prefix), cat.IDNumber)
-			}
-			if cat.Description != "" {
-				postBody.Set(fmt.Sprintf("%s[description]", prefix), cat.Description)
-			}
-		}
-		log.Printf("DEBUG: %d categorías a actualizar.", len(updates))
-
-	case "core_course_update_courses":
-		updates, ok := data.([]CourseUpdateRequest)
-		if !ok {
-			return fmt.Errorf("error de tipo: se esperaba []CourseUpdateRequest")
-		}
-		for i, course := range updates {
-			prefix := fmt.Sprintf("%s[%d]", functionKey, i)
-			postBody.Set(fmt.Sprintf("%s[id]", prefix), fmt.Sprintf("%d", course.ID))
-			if course.Fullname != "" {
-				postBody.Set(fmt.Sprintf("%s[fullname]", prefix), course.Fullname)
-			}
-			if course.Shortname != "" {
-				postBody.Set(fmt.Sprintf("%s[shortname]", prefix), course.Shortname)
-			}
-			if course.IDNumber != "" {
-				postBody.Set(fmt.Sprintf("%s[idnumber]", prefix), course.IDNumber)
-			}
-			if course.Summary != "" {
-				postBody.Set(fmt.Sprintf("%s[summary]", prefix), course.Summary)
-			}
-		}
-		log.Printf("DEBUG: %d cursos a actualizar.", len(updates))
-
-	case "enrol_manual_enrol_users":
-		enrolments, ok := data.([]EnrolmentRequest)
-		if !ok {
-			return fmt.Errorf("error de tipo: se esperaba []EnrolmentRequest")
+	// 1. Aplanar los parámetros de la función (key[i][campo]) a partir de las etiquetas del struct
+	if data != nil {
+		if err := EncodeParams(postBody, data); err != nil {
+			return fmt.Errorf("error al codificar parámetros para %s: %w", function, err)
 		}
-		// La clave real del payload es siempre 'enrolments[i][...]'.
-		for i, enrol := range enrolments {
-			prefix := fmt.Sprintf("enrolments[%d]", i) // Usamos la clave literal "enrolments"
-			postBody.Set(fmt.Sprintf("%s[roleid]", prefix), fmt.Sprintf("%d", enrol.RoleID))
-			postBody.Set(fmt.Sprintf("%s[userid]", prefix), fmt.Sprintf("%d", enrol.UserID))
-			postBody.Set(fmt.Sprintf("%s[courseid]", prefix), fmt.Sprintf("%d", enrol.CourseID))
-		}
-		log.Printf("DEBUG: %d matrículas codificadas.", len(enrolments))
+	}
 
-	case "core_group_create_groups":
-		groups, ok := data.([]GroupRequest)
-		if !ok {
-			return fmt.Errorf("error de tipo: se esperaba []GroupRequest")
-		}
-		for i, group := range groups {
-			prefix := fmt.Sprintf("%s[%d]", functionKey, i)
-			postBody.Set(fmt.Sprintf("%s[courseid]", prefix), fmt.Sprintf("%d", group.CourseID))
-			postBody.Set(fmt.Sprintf("%s[name]", prefix), group.Name)
-			postBody.Set(fmt.Sprintf("%s[description]", prefix), group.Description)
-			// 💡 NUEVOS CAMPOS OBLIGATORIOS/DEFAULT
-			postBody.Set(fmt.Sprintf("%s[descriptionformat]", prefix), fmt.Sprintf("%d", group.DescriptionFormat))
-			postBody.Set(fmt.Sprintf("%s[visibility]", prefix), fmt.Sprintf("%d", group.Visibility))
-			postBody.Set(fmt.Sprintf("%s[participation]", prefix), fmt.Sprintf("%d", group.Participation))
+	urlMoodle := fmt.Sprintf("%s/webservice/rest/server.php", c.BaseURL)
+	logger := c.logger()
+	logger.Request(function, postBody)
 
-			// Campos Opcionales
-			if group.Description != "" {
-				postBody.Set(fmt.Sprintf("%s[description]", prefix), group.Description)
-			}
-			if group.EnrolmentKey != "" {
-				postBody.Set(fmt.Sprintf("%s[enrolmentkey]", prefix), group.EnrolmentKey)
-			}
-			if group.IDNumber != "" {
-				postBody.Set(fmt.Sprintf("%s[idnumber]", prefix), group.IDNumber)
-			}
+	// 2. Enviar la petición, reintentando sólo los fallos transitorios
+	policy := c.Retry
+	if policy == nil {
+		policy = DefaultRetryPolicy()
+	}
+	encoded := postBody.Encode()
+	for attempt := 0; ; attempt++ {
+		err := c.limitedCall(ctx, function, urlMoodle, encoded, response)
+		if err == nil || !IsTransient(err) || attempt >= policy.MaxRetries {
+			return err
 		}
-		log.Printf("DEBUG: %d grupos codificados.", len(groups))
 
-	case "core_group_add_group_members":
-		members, ok := data.([]GroupMemberRequest)
-		if !ok {
-			return fmt.Errorf("error de tipo: se esperaba []GroupMemberRequest")
-		}
-		for i, member := range members {
-			prefix := fmt.Sprintf("%s[%d]", functionKey, i) // functionKey es "members"
-			postBody.Set(fmt.Sprintf("%s[groupid]", prefix), fmt.Sprintf("%d", member.GroupID))
-			postBody.Set(fmt.Sprintf("%s[userid]", prefix), fmt.Sprintf("%d", member.UserID))
+		delay := policy.Backoff(attempt)
+		logger.Infof("⚠️ Fallo transitorio en %s (intento %d de %d): %v. Reintentando en %s.", function, attempt+1, policy.MaxRetries+1, err, delay)
+		if err := sleepContext(ctx, delay); err != nil {
+			return fmt.Errorf("error al enviar petición a Moodle: %w", err)
 		}
-		log.Printf("DEBUG: %d miembros de grupo codificados.", len(members))
-
-	default:
+	}
+}
 
+// limitedCall espera turno en el limitador (si hay) antes de hacer el intento. Cada reintento
+// vuelve a pasar por el limitador para no saltarse el presupuesto global.
+func (c *Client) limitedCall(ctx context.Context, function, urlMoodle, encodedBody string, response interface{}) error {
+	if c.Limiter == nil {
+		return c.callOnce(ctx, function, urlMoodle, encodedBody, response)
+	}
+	release, err := c.Limiter.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("error al esperar turno para llamar a Moodle: %w", err)
 	}
+	defer release()
+	return c.callOnce(ctx, function, urlMoodle, encodedBody, response)
+}
 
-	log.Printf("URL Moodle: " + urlMoodle)
-	log.Printf("Body: %s", postBody.Encode())
-	resp, err := http.Post(
+// callOnce realiza un único intento de llamada HTTP a Moodle y decodifica la respuesta.
+func (c *Client) callOnce(ctx context.Context, function, urlMoodle, encodedBody string, response interface{}) error {
+	logger := c.logger()
+	start := time.Now()
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
 		urlMoodle,
-		"application/x-www-form-urlencoded",
-		strings.NewReader(postBody.Encode()), // Codifica los parámetros como 'key=value&key2=value2'
+		strings.NewReader(encodedBody), // Parámetros codificados como 'key=value&key2=value2'
 	)
 	if err != nil {
-		return fmt.Errorf("error al enviar petición a Moodle: %w", err)
+		return fmt.Errorf("error al construir petición a Moodle: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return &NetworkError{Err: err}
 	}
-	log.Printf("Respuesta HTTP de Moodle: %s", resp.Status)
 	defer resp.Body.Close()
 
-	body, _ := io.ReadAll(resp.Body)
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return &NetworkError{Err: fmt.Errorf("error al leer respuesta de Moodle: %w", err)}
+	}
+	logger.Response(function, resp.StatusCode, body, time.Since(start))
 
 	// 3. Manejo de errores de Moodle o HTTP
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("moodle devolvió un error HTTP %d: %s", resp.StatusCode, string(body))
+		// El cuerpo se guarda redactado y recortado: el error suele acabar en los logs.
+		return &HTTPError{StatusCode: resp.StatusCode, Body: logger.RedactBody(body)}
 	}
 
-	log.Printf("Cuerpo de respuesta de Moodle: %s", string(body))
-	// Moodle devuelve un array, o un objeto de error.
-	var moodleError struct {
-		Errorcode string `json:"errorcode"`
-		Message   string `json:"message"`
-		Exception string `json:"exception"`
-	}
-
-	isError := len(body) > 0 && body[0] == '{'
-	if isError {
-		if err := json.Unmarshal(body, &moodleError); err == nil && moodleError.Errorcode != "" {
-			// Si la decodificación tuvo éxito y Moodle devolvió un error de API
-			log.Printf("DEBUG ERROR CHECK Moodle: EXCEPTION: [%s], Código: [%s], Mensaje: [%s]", moodleError.Exception, moodleError.Errorcode, moodleError.Message)
-			return fmt.Errorf("error de API de Moodle (%s / %s): %s", moodleError.Exception, moodleError.Errorcode, moodleError.Message)
-		} else if err != nil {
-			log.Printf("ADVERTENCIA: Falló la decodificación JSON del cuerpo: %v. Cuerpo recibido: %s", err, string(body))
+	// Moodle devuelve un array, null, o un objeto; los errores siempre son un objeto con "exception".
+	if len(body) > 0 && body[0] == '{' {
+		var moodleError MoodleError
+		if err := json.Unmarshal(body, &moodleError); err == nil && (moodleError.ErrorCode != "" || moodleError.Exception != "") {
+			logger.Infof("Moodle %s devolvió una excepción: [%s] código [%s]: %s", function, moodleError.Exception, moodleError.ErrorCode, moodleError.Message)
+			return &moodleError
 		}
 	}
-	if err := json.Unmarshal(body, &moodleError); err == nil && moodleError.Errorcode != "" {
-		// Si la decodificación tiene éxito Y Moodle devuelve un error de API
-		log.Printf("DEBUG ERROR CHECK Moodle: EXCEPTION: [%s], Código: [%s], Mensaje: [%s]", moodleError.Exception, moodleError.Errorcode, moodleError.Message)
-		return fmt.Errorf("error de API de Moodle (%s / %s): %s", moodleError.Exception, moodleError.Errorcode, moodleError.Message)
-	} else if err != nil {
-		// Si la decodificación JSON falla (p.ej., el cuerpo es JSON inválido)
-		log.Printf("ADVERTENCIA: Falló la decodificación JSON del cuerpo: %v. Cuerpo recibido: %s", err, string(body))
-	}
-	log.Printf("No se detectaron errores en la respuesta de Moodle.")
+
 	// 4. Decodificar la respuesta exitosa
+	if response == nil {
+		return nil
+	}
 	if err := json.Unmarshal(body, response); err != nil {
-		return fmt.Errorf("error al decodificar respuesta de Moodle: %w. Cuerpo: %s", err, string(body))
+		return fmt.Errorf("error al decodificar respuesta de Moodle: %w. Cuerpo: %s", err, logger.RedactBody(body))
 	}
 
 	return nil
 }
+
+var (
+	defaultCallLogger     *CallLogger
+	defaultCallLoggerOnce sync.Once
+)
+
+// logger devuelve el CallLogger del cliente o, si no se asignó, uno compartido configurado desde el
+// entorno. No modifica el cliente: Call puede ejecutarse desde varias goroutines a la vez.
+func (c *Client) logger() *CallLogger {
+	if c.Logger != nil {
+		return c.Logger
+	}
+	defaultCallLoggerOnce.Do(func() {
+		defaultCallLogger = NewCallLoggerFromEnv()
+	})
+	return defaultCallLogger
+}
@@ -0,0 +1,51 @@
+package moodle
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// EnvDuration lee una duración (p. ej. "30s", "2m") de una variable de entorno.
+// Si la variable no existe o es inválida se usa el valor por defecto.
+func EnvDuration(key string, def time.Duration) time.Duration {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d < 0 {
+		log.Printf("⚠️ Valor inválido para %s (%q). Usando %s.", key, raw, def)
+		return def
+	}
+	return d
+}
+
+// EnvInt lee un entero no negativo de una variable de entorno con valor por defecto.
+func EnvInt(key string, def int) int {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 {
+		log.Printf("⚠️ Valor inválido para %s (%q). Usando %d.", key, raw, def)
+		return def
+	}
+	return n
+}
+
+// EnvFloat lee un número decimal no negativo de una variable de entorno con valor por defecto.
+func EnvFloat(key string, def float64) float64 {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(raw, 64)
+	if err != nil || f < 0 {
+		log.Printf("⚠️ Valor inválido para %s (%q). Usando %g.", key, raw, def)
+		return def
+	}
+	return f
+}
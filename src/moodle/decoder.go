@@ -0,0 +1,204 @@
+package moodle
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DecodeParams es la operación inversa de EncodeParams: reconstruye en dst (puntero a struct) los
+// parámetros estilo PHP recibidos (users[0][username]=...). Lo usa el servidor simulado de Moodle
+// para convertir el formulario de cada petición en el struct de parámetros de la función.
+//
+// Las claves que no corresponden a ningún campo se ignoran, igual que hace Moodle con los
+// parámetros sobrantes. Los punteros solo se asignan si la clave viene en la petición.
+func DecodeParams(values url.Values, dst interface{}) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("DecodeParams necesita un puntero a struct, se recibió %T", dst)
+	}
+
+	root := &paramNode{}
+	for key, vals := range values {
+		path, err := splitParamKey(key)
+		if err != nil {
+			return err
+		}
+		if len(vals) > 0 {
+			root.insert(path, vals[len(vals)-1])
+		}
+	}
+	return decodeStruct(root, "", v.Elem())
+}
+
+// paramNode es un nivel del árbol de parámetros: una hoja con valor o un conjunto de hijos.
+type paramNode struct {
+	value    *string
+	children map[string]*paramNode
+}
+
+func (n *paramNode) insert(path []string, value string) {
+	if len(path) == 0 {
+		n.value = &value
+		return
+	}
+	if n.children == nil {
+		n.children = make(map[string]*paramNode)
+	}
+	child, ok := n.children[path[0]]
+	if !ok {
+		child = &paramNode{}
+		n.children[path[0]] = child
+	}
+	child.insert(path[1:], value)
+}
+
+// splitParamKey separa "users[0][username]" en ["users", "0", "username"].
+func splitParamKey(key string) ([]string, error) {
+	name, rest, _ := strings.Cut(key, "[")
+	path := []string{name}
+	if rest == "" {
+		return path, nil
+	}
+	for _, part := range strings.Split("["+rest, "]") {
+		if part == "" {
+			continue
+		}
+		if !strings.HasPrefix(part, "[") {
+			return nil, fmt.Errorf("parámetro %q mal formado", key)
+		}
+		path = append(path, part[1:])
+	}
+	return path, nil
+}
+
+func decodeStruct(node *paramNode, key string, v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, skip := paramName(field)
+		if skip {
+			continue
+		}
+
+		fv := v.Field(i)
+		if field.Anonymous && field.Tag.Get("json") == "" && fv.Kind() == reflect.Struct {
+			if err := decodeStruct(node, key, fv); err != nil {
+				return err
+			}
+			continue
+		}
+
+		child, ok := node.children[name]
+		if !ok {
+			continue
+		}
+		childKey := name
+		if key != "" {
+			childKey = fmt.Sprintf("%s[%s]", key, name)
+		}
+		if err := decodeValue(child, childKey, fv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeValue(node *paramNode, key string, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return decodeValue(node, key, v.Elem())
+	case reflect.Struct:
+		return decodeStruct(node, key, v)
+	case reflect.Slice:
+		indexes, err := sortedIndexes(node, key)
+		if err != nil {
+			return err
+		}
+		slice := reflect.MakeSlice(v.Type(), len(indexes), len(indexes))
+		for i, idx := range indexes {
+			if err := decodeValue(node.children[idx], fmt.Sprintf("%s[%s]", key, idx), slice.Index(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+		return nil
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("parámetro %s: solo se admiten maps con clave string", key)
+		}
+		m := reflect.MakeMapWithSize(v.Type(), len(node.children))
+		for k, child := range node.children {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := decodeValue(child, fmt.Sprintf("%s[%s]", key, k), elem); err != nil {
+				return err
+			}
+			m.SetMapIndex(reflect.ValueOf(k).Convert(v.Type().Key()), elem)
+		}
+		v.Set(m)
+		return nil
+	}
+
+	if node.value == nil {
+		return fmt.Errorf("parámetro %s: se esperaba un valor simple", key)
+	}
+	raw := *node.value
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("parámetro %s: %q no es un booleano", key, raw)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("parámetro %s: %q no es un entero", key, raw)
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(raw, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("parámetro %s: %q no es un entero positivo", key, raw)
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(raw, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("parámetro %s: %q no es un número", key, raw)
+		}
+		v.SetFloat(n)
+	default:
+		return fmt.Errorf("parámetro %s: tipo %s no soportado por el decodificador de Moodle", key, v.Kind())
+	}
+	return nil
+}
+
+// sortedIndexes devuelve los índices de un array PHP en orden numérico.
+func sortedIndexes(node *paramNode, key string) ([]string, error) {
+	indexes := make([]string, 0, len(node.children))
+	for idx := range node.children {
+		if _, err := strconv.Atoi(idx); err != nil {
+			return nil, fmt.Errorf("parámetro %s: índice %q no numérico", key, idx)
+		}
+		indexes = append(indexes, idx)
+	}
+	sort.Slice(indexes, func(i, j int) bool {
+		a, _ := strconv.Atoi(indexes[i])
+		b, _ := strconv.Atoi(indexes[j])
+		return a < b
+	})
+	return indexes, nil
+}
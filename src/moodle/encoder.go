@@ -0,0 +1,142 @@
+package moodle
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// EncodeParams aplana data en los parámetros estilo PHP que espera el WebService REST de Moodle
+// (por ejemplo users[0][username]=jperez&users[0][preferences][0][type]=...).
+//
+// data debe ser un struct (o puntero a struct): cada campo exportado se convierte en un parámetro
+// de primer nivel usando el nombre de su etiqueta json. Reglas de codificación:
+//   - slices y arrays generan key[i]; structs anidados generan key[campo]; maps con clave string generan key[clave].
+//   - ",omitempty" omite el campo cuando tiene su valor cero.
+//   - un puntero nil siempre se omite; un puntero no nil se envía aunque apunte a un valor cero,
+//     lo que permite mandar explícitamente 0 o "" en campos opcionales.
+//   - los bool se envían como 1/0, que es lo que acepta PARAM_BOOL.
+func EncodeParams(values url.Values, data interface{}) error {
+	v := reflect.ValueOf(data)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return fmt.Errorf("los parámetros de Moodle deben ser un struct, se recibió %s", v.Kind())
+	}
+	return encodeStruct(values, "", v)
+}
+
+// encodeStruct codifica los campos de un struct. Con prefix vacío los campos son parámetros de primer nivel.
+func encodeStruct(values url.Values, prefix string, v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, omitEmpty, skip := paramName(field)
+		if skip {
+			continue
+		}
+
+		fv := v.Field(i)
+		// Los structs embebidos sin etiqueta se aplanan en el struct contenedor.
+		if field.Anonymous && field.Tag.Get("json") == "" && fv.Kind() == reflect.Struct {
+			if err := encodeStruct(values, prefix, fv); err != nil {
+				return err
+			}
+			continue
+		}
+
+		key := name
+		if prefix != "" {
+			key = fmt.Sprintf("%s[%s]", prefix, name)
+		}
+		if err := encodeValue(values, key, fv, omitEmpty); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeValue codifica un valor arbitrario bajo la clave indicada.
+func encodeValue(values url.Values, key string, v reflect.Value, omitEmpty bool) error {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		// Un puntero explícito significa "enviar este valor", incluso si es cero.
+		return encodeValue(values, key, v.Elem(), false)
+	}
+
+	if omitEmpty && v.IsZero() {
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		values.Set(key, v.String())
+	case reflect.Bool:
+		if v.Bool() {
+			values.Set(key, "1")
+		} else {
+			values.Set(key, "0")
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		values.Set(key, strconv.FormatInt(v.Int(), 10))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		values.Set(key, strconv.FormatUint(v.Uint(), 10))
+	case reflect.Float32, reflect.Float64:
+		values.Set(key, strconv.FormatFloat(v.Float(), 'f', -1, 64))
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := encodeValue(values, fmt.Sprintf("%s[%d]", key, i), v.Index(i), false); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		return encodeStruct(values, key, v)
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("parámetro %s: solo se admiten maps con clave string", key)
+		}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		for _, k := range keys {
+			if err := encodeValue(values, fmt.Sprintf("%s[%s]", key, k.String()), v.MapIndex(k), false); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("parámetro %s: tipo %s no soportado por el codificador de Moodle", key, v.Kind())
+	}
+	return nil
+}
+
+// paramName obtiene el nombre del parámetro y la opción omitempty a partir de la etiqueta json.
+func paramName(field reflect.StructField) (name string, omitEmpty bool, skip bool) {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false, true
+	}
+	parts := strings.Split(tag, ",")
+	name = parts[0]
+	if name == "" {
+		name = strings.ToLower(field.Name)
+	}
+	for _, opt := range parts[1:] {
+		if opt == "omitempty" {
+			omitEmpty = true
+		}
+	}
+	return name, omitEmpty, false
+}
@@ -0,0 +1,172 @@
+package moodle
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func intPtr(n int) *int { return &n }
+
+func TestEncodeParams(t *testing.T) {
+	tests := []struct {
+		name string
+		data interface{}
+		want url.Values
+	}{
+		{
+			name: "array de structs con slice anidado",
+			data: CreateUsersParams{Users: []UserRequest{{
+				Username:    "jperez",
+				Firstname:   "Juan",
+				Lastname:    "Pérez",
+				Email:       "jperez@example.com",
+				Auth:        "manual",
+				Preferences: []UserPreference{{Type: "auth_forcepasswordchange", Value: "1"}},
+			}}},
+			want: url.Values{
+				"users[0][username]":              {"jperez"},
+				"users[0][firstname]":             {"Juan"},
+				"users[0][lastname]":              {"Pérez"},
+				"users[0][email]":                 {"jperez@example.com"},
+				"users[0][auth]":                  {"manual"},
+				"users[0][preferences][0][type]":  {"auth_forcepasswordchange"},
+				"users[0][preferences][0][value]": {"1"},
+			},
+		},
+		{
+			name: "omitempty omite los valores cero",
+			data: UpdateUsersParams{Users: []UserUpdateRequest{{ID: 7, Email: "nuevo@example.com"}}},
+			want: url.Values{
+				"users[0][id]":    {"7"},
+				"users[0][email]": {"nuevo@example.com"},
+			},
+		},
+		{
+			name: "un puntero no nil se envía aunque apunte a cero",
+			data: UpdateUsersParams{Users: []UserUpdateRequest{{ID: 7, Suspended: intPtr(0)}}},
+			want: url.Values{
+				"users[0][id]":        {"7"},
+				"users[0][suspended]": {"0"},
+			},
+		},
+		{
+			name: "puntero a struct y bool",
+			data: &struct {
+				Visible bool   `json:"visible"`
+				Oculto  bool   `json:"oculto"`
+				Nombre  string `json:"-"`
+			}{Visible: true, Nombre: "no se envía"},
+			want: url.Values{
+				"visible": {"1"},
+				"oculto":  {"0"},
+			},
+		},
+		{
+			name: "maps con clave string en orden",
+			data: struct {
+				Options map[string]string `json:"options"`
+			}{Options: map[string]string{"b": "2", "a": "1"}},
+			want: url.Values{
+				"options[a]": {"1"},
+				"options[b]": {"2"},
+			},
+		},
+		{
+			name: "puntero nil no envía nada",
+			data: (*CreateUsersParams)(nil),
+			want: url.Values{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := url.Values{}
+			if err := EncodeParams(got, tt.data); err != nil {
+				t.Fatalf("EncodeParams: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("EncodeParams = %v, se esperaba %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodeParamsErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data interface{}
+	}{
+		{"no es un struct", []string{"a"}},
+		{"map con clave no string", struct {
+			M map[int]string `json:"m"`
+		}{M: map[int]string{1: "a"}}},
+		{"tipo no soportado", struct {
+			C chan int `json:"c"`
+		}{C: make(chan int)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := EncodeParams(url.Values{}, tt.data); err == nil {
+				t.Error("EncodeParams no devolvió error")
+			}
+		})
+	}
+}
+
+// Lo que codifica EncodeParams debe reconstruirse igual con DecodeParams (lo usa el servidor simulado).
+func TestDecodeParamsRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		data interface{}
+	}{
+		{"usuarios con preferencias", &CreateUsersParams{Users: []UserRequest{
+			{Username: "a", Firstname: "A", Lastname: "Uno", Email: "a@example.com", CreatePassword: 1},
+			{Username: "b", Firstname: "B", Lastname: "Dos", Email: "b@example.com",
+				Preferences: []UserPreference{{Type: "auth_forcepasswordchange", Value: "1"}}},
+		}}},
+		{"punteros opcionales", &UpdateUsersParams{Users: []UserUpdateRequest{
+			{ID: 1, Suspended: intPtr(1)},
+			{ID: 2, Firstname: "Sin suspender"},
+		}}},
+		{"criterios que no se parten", &GetCategoriesParams{Criteria: []CategoryCriteria{{Key: "idnumber", Value: "PROG-1"}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values := url.Values{}
+			if err := EncodeParams(values, tt.data); err != nil {
+				t.Fatalf("EncodeParams: %v", err)
+			}
+			got := reflect.New(reflect.TypeOf(tt.data).Elem())
+			if err := DecodeParams(values, got.Interface()); err != nil {
+				t.Fatalf("DecodeParams: %v", err)
+			}
+			if !reflect.DeepEqual(got.Interface(), tt.data) {
+				t.Errorf("DecodeParams = %+v, se esperaba %+v", got.Elem().Interface(), reflect.ValueOf(tt.data).Elem().Interface())
+			}
+		})
+	}
+}
+
+func TestDecodeParams(t *testing.T) {
+	// Los índices llegan desordenados y con claves sobrantes, que se ignoran como en Moodle.
+	values := url.Values{
+		"users[1][username]": {"segundo"},
+		"users[0][username]": {"primero"},
+		"users[0][ignorado]": {"x"},
+		"otro":               {"y"},
+	}
+	var got CreateUsersParams
+	if err := DecodeParams(values, &got); err != nil {
+		t.Fatalf("DecodeParams: %v", err)
+	}
+	if len(got.Users) != 2 || got.Users[0].Username != "primero" || got.Users[1].Username != "segundo" {
+		t.Errorf("DecodeParams = %+v, se esperaban los usuarios primero y segundo en orden", got.Users)
+	}
+
+	if err := DecodeParams(url.Values{"users[x][username]": {"a"}}, &got); err == nil {
+		t.Error("DecodeParams aceptó un índice no numérico")
+	}
+}
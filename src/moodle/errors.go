@@ -0,0 +1,136 @@
+package moodle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// MoodleError es la excepción que devuelve el WebService cuando una función falla.
+// Moodle responde HTTP 200 con un objeto {"exception", "errorcode", "message", "debuginfo"}.
+type MoodleError struct {
+	Exception string `json:"exception"`
+	ErrorCode string `json:"errorcode"`
+	Message   string `json:"message"`
+	DebugInfo string `json:"debuginfo,omitempty"`
+}
+
+func (e *MoodleError) Error() string {
+	return fmt.Sprintf("error de API de Moodle (%s / %s): %s", e.Exception, e.ErrorCode, e.Message)
+}
+
+// Is permite usar errors.Is(err, ErrUnsupported) cuando Moodle no conoce la función invocada.
+func (e *MoodleError) Is(target error) bool {
+	return target == ErrUnsupported && e.isMissingFunction()
+}
+
+// isMissingFunction detecta la excepción con la que Moodle responde a una función que no existe
+// en su versión: no encuentra el registro en la tabla external_functions.
+func (e *MoodleError) isMissingFunction() bool {
+	return e.ErrorCode == "invalidrecord" && strings.Contains(e.Message+" "+e.DebugInfo, "external_functions")
+}
+
+// ErrUnsupported indica que la versión de Moodle no tiene la función del WebService solicitada.
+var ErrUnsupported = errors.New("función no soportada por esta versión de Moodle")
+
+// HTTPError indica que Moodle (o un proxy delante de él) respondió con un estado HTTP distinto de 200.
+type HTTPError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("moodle devolvió un error HTTP %d: %s", e.StatusCode, e.Body)
+}
+
+// NetworkError envuelve los fallos de red (conexión rechazada, timeout, cuerpo truncado...).
+type NetworkError struct {
+	Err error
+}
+
+func (e *NetworkError) Error() string {
+	return fmt.Sprintf("error al enviar petición a Moodle: %v", e.Err)
+}
+
+func (e *NetworkError) Unwrap() error {
+	return e.Err
+}
+
+// moodleAPIError construye el error que se devuelve cuando Moodle responde con un objeto de excepción.
+func moodleAPIError(exception, errorcode, message string) error {
+	return &MoodleError{Exception: exception, ErrorCode: errorcode, Message: message}
+}
+
+// duplicateErrorCodes son los códigos con los que Moodle rechaza un registro que ya existe.
+var duplicateErrorCodes = map[string]bool{
+	"shortnametaken":        true,
+	"courseidnumbertaken":   true,
+	"categoryidnumbertaken": true,
+	"idnumbertaken":         true,
+	"usernameexists":        true,
+	"emailexists":           true,
+}
+
+// AsMoodleError extrae el MoodleError de la cadena de errores, si existe.
+func AsMoodleError(err error) (*MoodleError, bool) {
+	var me *MoodleError
+	if errors.As(err, &me) {
+		return me, true
+	}
+	return nil, false
+}
+
+// IsErrorCode indica si err es una excepción de Moodle con el errorcode dado.
+func IsErrorCode(err error, code string) bool {
+	me, ok := AsMoodleError(err)
+	return ok && me.ErrorCode == code
+}
+
+// IsDuplicate indica si Moodle rechazó la petición porque el registro ya existe
+// (shortname, idnumber o username repetidos, grupo con el mismo nombre...).
+func IsDuplicate(err error) bool {
+	me, ok := AsMoodleError(err)
+	if !ok {
+		return false
+	}
+	if duplicateErrorCodes[me.ErrorCode] {
+		return true
+	}
+	msg := strings.ToLower(me.Message)
+	return me.ErrorCode == "invalidparameter" &&
+		(strings.Contains(msg, "already exists") || strings.Contains(msg, "already used"))
+}
+
+// IsTransient indica si vale la pena reintentar la llamada: fallos de red, errores HTTP 5xx/429
+// y bloqueos de base de datos en Moodle (dml_write_exception por deadlock). Los errores de
+// validación (invalidparameter, duplicados, permisos...) son permanentes y nunca se reintentan.
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	// Una cancelación explícita del llamador nunca se reintenta.
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var netErr *NetworkError
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode >= 500 || httpErr.StatusCode == http.StatusTooManyRequests
+	}
+
+	if me, ok := AsMoodleError(err); ok {
+		if me.Exception != "dml_write_exception" {
+			return false
+		}
+		detail := strings.ToLower(me.Message + " " + me.DebugInfo)
+		return strings.Contains(detail, "deadlock") || strings.Contains(detail, "lock wait timeout")
+	}
+	return false
+}
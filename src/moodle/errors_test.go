@@ -0,0 +1,81 @@
+package moodle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"error de red", &NetworkError{Err: errors.New("connection refused")}, true},
+		{"error de red envuelto", fmt.Errorf("fallo al crear Usuario: %w", &NetworkError{Err: errors.New("EOF")}), true},
+		{"HTTP 500", &HTTPError{StatusCode: http.StatusInternalServerError}, true},
+		{"HTTP 503", &HTTPError{StatusCode: http.StatusServiceUnavailable}, true},
+		{"HTTP 429", &HTTPError{StatusCode: http.StatusTooManyRequests}, true},
+		{"HTTP 404", &HTTPError{StatusCode: http.StatusNotFound}, false},
+		{"HTTP 403", &HTTPError{StatusCode: http.StatusForbidden}, false},
+		{"deadlock", &MoodleError{Exception: "dml_write_exception", Message: "Error writing to database", DebugInfo: "Deadlock found when trying to get lock"}, true},
+		{"lock wait timeout", &MoodleError{Exception: "dml_write_exception", DebugInfo: "Lock wait timeout exceeded"}, true},
+		{"otro dml_write_exception", &MoodleError{Exception: "dml_write_exception", DebugInfo: "Duplicate entry"}, false},
+		{"invalidparameter", &MoodleError{Exception: "invalid_parameter_exception", ErrorCode: "invalidparameter"}, false},
+		{"duplicado", &MoodleError{Exception: "moodle_exception", ErrorCode: "shortnametaken"}, false},
+		{"función no soportada", fmt.Errorf("x: %w", ErrUnsupported), false},
+		{"cancelado", &NetworkError{Err: context.Canceled}, false},
+		{"error cualquiera", errors.New("fallo local"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsTransient(tt.err); got != tt.want {
+				t.Errorf("IsTransient(%v) = %v, se esperaba %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsDuplicate(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"shortname repetido", &MoodleError{ErrorCode: "shortnametaken"}, true},
+		{"idnumber de curso repetido", &MoodleError{ErrorCode: "courseidnumbertaken"}, true},
+		{"idnumber de categoría repetido", &MoodleError{ErrorCode: "categoryidnumbertaken"}, true},
+		{"username repetido", &MoodleError{ErrorCode: "usernameexists"}, true},
+		{"envuelto", fmt.Errorf("fallo al crear Asignatura: %w", &MoodleError{ErrorCode: "shortnametaken"}), true},
+		{"grupo con el mismo nombre", &MoodleError{ErrorCode: "invalidparameter", Message: "Group with the same name already exists in the course"}, true},
+		{"idnumber de grupo usado", &MoodleError{ErrorCode: "invalidparameter", Message: "The group ID number is already used"}, true},
+		{"otro invalidparameter", &MoodleError{ErrorCode: "invalidparameter", Message: "Invalid parameter value detected"}, false},
+		{"error HTTP", &HTTPError{StatusCode: http.StatusConflict}, false},
+		{"error cualquiera", errors.New("already exists"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsDuplicate(tt.err); got != tt.want {
+				t.Errorf("IsDuplicate(%v) = %v, se esperaba %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoodleErrorIsUnsupported(t *testing.T) {
+	missing := &MoodleError{ErrorCode: "invalidrecord", Message: "Can't find data record in database table external_functions."}
+	if !errors.Is(fmt.Errorf("x: %w", missing), ErrUnsupported) {
+		t.Error("una función inexistente debería ser ErrUnsupported")
+	}
+	other := &MoodleError{ErrorCode: "invalidrecord", Message: "Can't find data record in database table course."}
+	if errors.Is(other, ErrUnsupported) {
+		t.Error("un registro inexistente no es ErrUnsupported")
+	}
+}
@@ -0,0 +1,1508 @@
+package moodle
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// FakeCategory es una categoría almacenada por FakeClient.
+type FakeCategory struct {
+	ID          uint
+	Name        string
+	Parent      int
+	IDNumber    string
+	Description string
+}
+
+// FakeCourse es un curso almacenado por FakeClient.
+type FakeCourse struct {
+	ID         uint
+	Fullname   string
+	Shortname  string
+	CategoryID int
+	IDNumber   string
+	Summary    string
+	Format     string
+	Visible    int
+	StartDate  int64
+	EndDate    int64
+	// ContentFrom es el curso del que se copió el contenido (duplicado o importado); 0 si ninguno.
+	ContentFrom uint
+}
+
+// FakeUser es un usuario almacenado por FakeClient.
+type FakeUser struct {
+	ID        uint
+	Username  string
+	Password  string
+	Firstname string
+	Lastname  string
+	Email     string
+	IDNumber  string
+	Suspended bool
+	// Auth es el método de autenticación ("manual" si no se indicó).
+	Auth string
+	// PasswordCreated indica que se pidió createpassword: Moodle habría enviado la contraseña por correo.
+	PasswordCreated bool
+	// ForcePasswordChange indica la preferencia auth_forcepasswordchange.
+	ForcePasswordChange bool
+}
+
+// FakeGroup es un grupo almacenado por FakeClient.
+type FakeGroup struct {
+	ID                uint
+	CourseID          int
+	Name              string
+	Description       string
+	DescriptionFormat int
+	EnrolmentKey      string
+	IDNumber          string
+	Visibility        int
+	Participation     int
+}
+
+// FakeCohort es una cohorte almacenada por FakeClient. CategoryID es 0 en las cohortes de sistema.
+type FakeCohort struct {
+	ID          uint
+	CategoryID  uint
+	Name        string
+	IDNumber    string
+	Description string
+	Visible     bool
+}
+
+// FakeGradeItem es un elemento del libro de calificaciones de un curso simulado.
+type FakeGradeItem struct {
+	ID         uint
+	CourseID   uint
+	Name       string
+	ItemType   string // course | mod | manual
+	ItemModule string
+	GradeMin   float64
+	GradeMax   float64
+}
+
+// FakeGrade es la calificación de un usuario en un elemento.
+type FakeGrade struct {
+	Value  float64
+	Graded int64
+}
+
+// FakeEnrolment es una matrícula manual almacenada por FakeClient.
+type FakeEnrolment struct {
+	UserID    uint
+	CourseID  uint
+	RoleID    int
+	Timestart int64
+	Timeend   int64
+	Suspended bool
+}
+
+// FakeClient implementa MoodleAPI en memoria. Mantiene categorías, cursos, usuarios,
+// grupos y matrículas, y devuelve los mismos errores que Moodle (shortname duplicado,
+// grupo duplicado, registros inexistentes...) para poder probar los servicios sin red.
+type FakeClient struct {
+	mu     sync.Mutex
+	nextID uint
+
+	Categories    map[uint]*FakeCategory
+	Courses       map[uint]*FakeCourse
+	Users         map[uint]*FakeUser
+	Groups        map[uint]*FakeGroup
+	Enrolments    map[string]*FakeEnrolment // clave: "<courseid>:<userid>"
+	GroupMembers  map[uint]map[uint]bool    // groupid -> userids
+	Cohorts       map[uint]*FakeCohort
+	CohortMembers map[uint]map[uint]bool // cohortid -> userids
+	GradeItems    map[uint]*FakeGradeItem
+	Grades        map[string]*FakeGrade // clave: "<itemid>:<userid>"
+	Completions   map[string]int64      // clave: "<courseid>:<userid>" -> momento en que completó el curso
+	Roles         []Role                // Roles del sitio; por defecto los de una instalación estándar
+
+	// Calls registra, en orden, el nombre de cada función invocada.
+	Calls []string
+	// Errors permite forzar un error para una función concreta (se consume en la siguiente llamada).
+	Errors map[string]error
+	// Unsupported marca funciones como inexistentes, para simular versiones antiguas de Moodle.
+	Unsupported map[string]bool
+	// Chunking, si se asigna, parte los arrays en lotes igual que Client (cada lote queda en Calls).
+	Chunking *ChunkPolicy
+}
+
+// fakeHandlers son las funciones del WebService que simula FakeClient.
+var fakeHandlers = map[string]func(f *FakeClient, data interface{}) (interface{}, error){
+	"core_course_create_categories":                (*FakeClient).createCategories,
+	"core_course_update_categories":                (*FakeClient).updateCategories,
+	"core_course_create_courses":                   (*FakeClient).createCourses,
+	"core_course_update_courses":                   (*FakeClient).updateCourses,
+	"core_user_create_users":                       (*FakeClient).createUsers,
+	"core_user_update_users":                       (*FakeClient).updateUsers,
+	"enrol_manual_enrol_users":                     (*FakeClient).enrolUsers,
+	"enrol_manual_unenrol_users":                   (*FakeClient).unenrolUsers,
+	"core_group_delete_group_members":              (*FakeClient).deleteGroupMembers,
+	"core_group_create_groups":                     (*FakeClient).createGroups,
+	"core_group_add_group_members":                 (*FakeClient).addGroupMembers,
+	"core_course_delete_categories":                (*FakeClient).deleteCategories,
+	"core_course_delete_courses":                   (*FakeClient).deleteCourses,
+	"core_user_delete_users":                       (*FakeClient).deleteUsers,
+	"core_group_delete_groups":                     (*FakeClient).deleteGroups,
+	"core_course_get_categories":                   (*FakeClient).getCategories,
+	"core_course_get_courses_by_field":             (*FakeClient).getCoursesByField,
+	"core_user_get_users_by_field":                 (*FakeClient).getUsersByField,
+	"core_user_get_users":                          (*FakeClient).getUsers,
+	"core_group_get_course_groups":                 (*FakeClient).getCourseGroups,
+	"core_group_update_groups":                     (*FakeClient).updateGroups,
+	"core_cohort_create_cohorts":                   (*FakeClient).createCohorts,
+	"core_cohort_add_cohort_members":               (*FakeClient).addCohortMembers,
+	"core_cohort_search_cohorts":                   (*FakeClient).searchCohorts,
+	"core_cohort_get_cohort_members":               (*FakeClient).getCohortMembers,
+	"core_cohort_delete_cohort_members":            (*FakeClient).deleteCohortMembers,
+	"gradereport_user_get_grade_items":             (*FakeClient).getGradeItems,
+	"core_completion_get_course_completion_status": (*FakeClient).getCourseCompletionStatus,
+	RolesFunction:                                  (*FakeClient).getRoles,
+	"core_course_duplicate_course":                 (*FakeClient).duplicateCourse,
+	"core_course_import_course":                    (*FakeClient).importCourse,
+}
+
+// NewFakeClient crea un FakeClient vacío.
+func NewFakeClient() *FakeClient {
+	return &FakeClient{
+		nextID:        1,
+		Categories:    make(map[uint]*FakeCategory),
+		Courses:       make(map[uint]*FakeCourse),
+		Users:         make(map[uint]*FakeUser),
+		Groups:        make(map[uint]*FakeGroup),
+		Enrolments:    make(map[string]*FakeEnrolment),
+		GroupMembers:  make(map[uint]map[uint]bool),
+		Cohorts:       make(map[uint]*FakeCohort),
+		CohortMembers: make(map[uint]map[uint]bool),
+		GradeItems:    make(map[uint]*FakeGradeItem),
+		Grades:        make(map[string]*FakeGrade),
+		Completions:   make(map[string]int64),
+		Roles:         defaultFakeRoles(),
+		Errors:        make(map[string]error),
+		Unsupported:   make(map[string]bool),
+	}
+}
+
+// FailNext hace que la siguiente llamada a function devuelva err.
+func (f *FakeClient) FailNext(function string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Errors[function] = err
+}
+
+// Call despacha la función de Moodle simulada y codifica el resultado como lo haría el WebService.
+func (f *FakeClient) Call(ctx context.Context, function string, data interface{}, response interface{}) error {
+	return callInChunks(ctx, f.Chunking.SizeFor(function), function, data, response, f.call)
+}
+
+func (f *FakeClient) call(ctx context.Context, function string, data interface{}, response interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("error al enviar petición a Moodle: %w", err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.Calls = append(f.Calls, function)
+	if err, ok := f.Errors[function]; ok {
+		delete(f.Errors, function)
+		return err
+	}
+
+	if f.Unsupported[function] {
+		return missingFunction()
+	}
+
+	var (
+		result interface{}
+		err    error
+	)
+	if function == SiteInfoFunction {
+		result = f.siteInfo()
+	} else if handler, ok := fakeHandlers[function]; ok {
+		result, err = handler(f, data)
+	} else {
+		return missingFunction()
+	}
+	if err != nil {
+		return err
+	}
+
+	// Se pasa por JSON para que la respuesta tenga exactamente la forma que tendría con el cliente real.
+	body, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("error al codificar respuesta simulada: %w", err)
+	}
+	if response == nil {
+		return nil
+	}
+	if err := json.Unmarshal(body, response); err != nil {
+		return fmt.Errorf("error al decodificar respuesta de Moodle: %w. Cuerpo: %s", err, string(body))
+	}
+	return nil
+}
+
+// siteInfo responde core_webservice_get_site_info con todas las funciones simuladas salvo las
+// marcadas en Unsupported.
+func (f *FakeClient) siteInfo() SiteInfo {
+	names := []string{SiteInfoFunction}
+	for name := range fakeHandlers {
+		if !f.Unsupported[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	info := SiteInfo{
+		SiteName: "Moodle simulado",
+		SiteURL:  "http://localhost",
+		Username: "ws_fake",
+		UserID:   1,
+		Release:  "4.1 (Build: simulado)",
+		Version:  "2022112800",
+	}
+	for _, name := range names {
+		info.Functions = append(info.Functions, SiteFunctionInfo{Name: name, Version: info.Version})
+	}
+	return info
+}
+
+func (f *FakeClient) newID() uint {
+	f.nextID++
+	return f.nextID
+}
+
+func enrolmentKey(courseID, userID uint) string {
+	return fmt.Sprintf("%d:%d", courseID, userID)
+}
+
+func invalidParameter(detail string) error {
+	return moodleAPIError("invalid_parameter_exception", "invalidparameter", fmt.Sprintf("Invalid parameter value detected (%s)", detail))
+}
+
+func missingFunction() error {
+	return missingRecord("external_functions")
+}
+
+func missingRecord(table string) error {
+	return moodleAPIError("dml_missing_record_exception", "invalidrecord", fmt.Sprintf("Can't find data record in database table %s.", table))
+}
+
+func typeError(expected string) error {
+	return fmt.Errorf("error de tipo: se esperaba %s", expected)
+}
+
+func (f *FakeClient) createCategories(data interface{}) (interface{}, error) {
+	params, ok := data.(CreateCategoriesParams)
+	if !ok {
+		return nil, typeError("CreateCategoriesParams")
+	}
+	categories := params.Categories
+	for _, cat := range categories {
+		if strings.TrimSpace(cat.Name) == "" {
+			return nil, invalidParameter("name")
+		}
+		if cat.Parent != 0 {
+			if _, ok := f.Categories[uint(cat.Parent)]; !ok {
+				return nil, missingRecord("course_categories")
+			}
+		}
+		if cat.IDNumber != "" && f.categoryIDNumberTaken(cat.IDNumber, 0) {
+			return nil, moodleAPIError("moodle_exception", "categoryidnumbertaken", "ID number is already used for another category")
+		}
+	}
+
+	result := make([]CategoryResponse, 0, len(categories))
+	for _, cat := range categories {
+		c := &FakeCategory{ID: f.newID(), Name: cat.Name, Parent: cat.Parent, IDNumber: cat.IDNumber, Description: cat.Description}
+		f.Categories[c.ID] = c
+		result = append(result, CategoryResponse{ID: c.ID, Name: c.Name})
+	}
+	return result, nil
+}
+
+func (f *FakeClient) updateCategories(data interface{}) (interface{}, error) {
+	params, ok := data.(UpdateCategoriesParams)
+	if !ok {
+		return nil, typeError("UpdateCategoriesParams")
+	}
+	updates := params.Categories
+	for _, upd := range updates {
+		cat, ok := f.Categories[upd.ID]
+		if !ok {
+			return nil, missingRecord("course_categories")
+		}
+		if upd.IDNumber != "" && f.categoryIDNumberTaken(upd.IDNumber, upd.ID) {
+			return nil, moodleAPIError("moodle_exception", "categoryidnumbertaken", "ID number is already used for another category")
+		}
+		if upd.Name != "" {
+			cat.Name = upd.Name
+		}
+		if upd.IDNumber != "" {
+			cat.IDNumber = upd.IDNumber
+		}
+		if upd.Description != "" {
+			cat.Description = upd.Description
+		}
+	}
+	return nil, nil
+}
+
+func (f *FakeClient) categoryIDNumberTaken(idNumber string, exceptID uint) bool {
+	for _, c := range f.Categories {
+		if c.ID != exceptID && c.IDNumber == idNumber {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *FakeClient) createCourses(data interface{}) (interface{}, error) {
+	params, ok := data.(CreateCoursesParams)
+	if !ok {
+		return nil, typeError("CreateCoursesParams")
+	}
+	courses := params.Courses
+	for _, course := range courses {
+		if _, ok := f.Categories[uint(course.Categoryid)]; !ok {
+			return nil, missingRecord("course_categories")
+		}
+		if f.shortnameTaken(course.Shortname, 0) {
+			return nil, moodleAPIError("moodle_exception", "shortnametaken", fmt.Sprintf("Short name is already used for another course (%s)", course.Shortname))
+		}
+		if course.IDNumber != "" && f.courseIDNumberTaken(course.IDNumber, 0) {
+			return nil, moodleAPIError("moodle_exception", "courseidnumbertaken", fmt.Sprintf("ID number is already used for another course (%s)", course.IDNumber))
+		}
+	}
+
+	result := make([]CourseResponse, 0, len(courses))
+	for _, course := range courses {
+		c := &FakeCourse{
+			ID:         f.newID(),
+			Fullname:   course.Fullname,
+			Shortname:  course.Shortname,
+			CategoryID: course.Categoryid,
+			IDNumber:   course.IDNumber,
+			Summary:    course.Summary,
+			Format:     course.Format,
+			Visible:    course.Visible,
+			StartDate:  course.StartDate,
+			EndDate:    course.EndDate,
+		}
+		f.Courses[c.ID] = c
+		result = append(result, CourseResponse{ID: c.ID, Shortname: c.Shortname})
+	}
+	return result, nil
+}
+
+func (f *FakeClient) updateCourses(data interface{}) (interface{}, error) {
+	params, ok := data.(UpdateCoursesParams)
+	if !ok {
+		return nil, typeError("UpdateCoursesParams")
+	}
+	updates := params.Courses
+	for _, upd := range updates {
+		course, ok := f.Courses[upd.ID]
+		if !ok {
+			return nil, missingRecord("course")
+		}
+		if upd.Shortname != "" && f.shortnameTaken(upd.Shortname, upd.ID) {
+			return nil, moodleAPIError("moodle_exception", "shortnametaken", fmt.Sprintf("Short name is already used for another course (%s)", upd.Shortname))
+		}
+		if upd.Fullname != "" {
+			course.Fullname = upd.Fullname
+		}
+		if upd.Shortname != "" {
+			course.Shortname = upd.Shortname
+		}
+		if upd.IDNumber != "" {
+			course.IDNumber = upd.IDNumber
+		}
+		if upd.Summary != "" {
+			course.Summary = upd.Summary
+		}
+		if upd.Format != "" {
+			course.Format = upd.Format
+		}
+		if upd.Visible != nil {
+			course.Visible = *upd.Visible
+		}
+		if upd.StartDate != nil {
+			course.StartDate = *upd.StartDate
+		}
+		if upd.EndDate != nil {
+			course.EndDate = *upd.EndDate
+		}
+	}
+	return map[string]interface{}{"warnings": []interface{}{}}, nil
+}
+
+// duplicateCourse copia la plantilla en un curso nuevo: conserva formato y fechas, no matrículas.
+func (f *FakeClient) duplicateCourse(data interface{}) (interface{}, error) {
+	params, ok := data.(DuplicateCourseParams)
+	if !ok {
+		return nil, typeError("DuplicateCourseParams")
+	}
+	template, ok := f.Courses[params.CourseID]
+	if !ok {
+		return nil, missingRecord("course")
+	}
+	if _, ok := f.Categories[params.CategoryID]; !ok {
+		return nil, missingRecord("course_categories")
+	}
+	if f.shortnameTaken(params.Shortname, 0) {
+		return nil, moodleAPIError("moodle_exception", "shortnametaken", fmt.Sprintf("Short name is already used for another course (%s)", params.Shortname))
+	}
+	c := &FakeCourse{
+		ID:          f.newID(),
+		Fullname:    params.Fullname,
+		Shortname:   params.Shortname,
+		CategoryID:  int(params.CategoryID),
+		Summary:     template.Summary,
+		Format:      template.Format,
+		Visible:     params.Visible,
+		StartDate:   template.StartDate,
+		EndDate:     template.EndDate,
+		ContentFrom: template.ID,
+	}
+	f.Courses[c.ID] = c
+	return DuplicateCourseResponse{ID: c.ID, Shortname: c.Shortname}, nil
+}
+
+// importCourse copia el contenido de un curso en otro existente (sólo se registra el origen).
+func (f *FakeClient) importCourse(data interface{}) (interface{}, error) {
+	params, ok := data.(ImportCourseParams)
+	if !ok {
+		return nil, typeError("ImportCourseParams")
+	}
+	if _, ok := f.Courses[params.ImportFrom]; !ok {
+		return nil, missingRecord("course")
+	}
+	target, ok := f.Courses[params.ImportTo]
+	if !ok {
+		return nil, missingRecord("course")
+	}
+	target.ContentFrom = params.ImportFrom
+	return nil, nil
+}
+
+func (f *FakeClient) shortnameTaken(shortname string, exceptID uint) bool {
+	for _, c := range f.Courses {
+		if c.ID != exceptID && c.Shortname == shortname {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *FakeClient) courseIDNumberTaken(idNumber string, exceptID uint) bool {
+	for _, c := range f.Courses {
+		if c.ID != exceptID && c.IDNumber == idNumber {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *FakeClient) createUsers(data interface{}) (interface{}, error) {
+	params, ok := data.(CreateUsersParams)
+	if !ok {
+		return nil, typeError("CreateUsersParams")
+	}
+	users := params.Users
+	seen := make(map[string]bool)
+	for _, user := range users {
+		username := strings.ToLower(user.Username)
+		if username == "" {
+			return nil, invalidParameter("username")
+		}
+		if seen[username] || f.usernameTaken(username, 0) {
+			return nil, invalidParameter("Username already exists: " + username)
+		}
+		// Como Moodle: los métodos internos necesitan contraseña o createpassword
+		if auth := user.Auth; (auth == "" || auth == "manual" || auth == "email") && user.Password == "" && user.CreatePassword == 0 {
+			return nil, invalidParameter("Invalid password: you must provide a password, or set createpassword.")
+		}
+		seen[username] = true
+	}
+
+	result := make([]UserResponse, 0, len(users))
+	for _, user := range users {
+		u := &FakeUser{
+			ID:        f.newID(),
+			Username:  strings.ToLower(user.Username),
+			Password:  user.Password,
+			Firstname: user.Firstname,
+			Lastname:  user.Lastname,
+			Email:     user.Email,
+			IDNumber:  user.IDNumber,
+			Auth:      user.Auth,
+		}
+		u.PasswordCreated = user.CreatePassword == 1
+		if u.Auth == "" {
+			u.Auth = "manual"
+		}
+		for _, pref := range user.Preferences {
+			if pref.Type == "auth_forcepasswordchange" && pref.Value == "1" {
+				u.ForcePasswordChange = true
+			}
+		}
+		f.Users[u.ID] = u
+		result = append(result, UserResponse{ID: u.ID, Username: u.Username})
+	}
+	return result, nil
+}
+
+func (f *FakeClient) updateUsers(data interface{}) (interface{}, error) {
+	params, ok := data.(UpdateUsersParams)
+	if !ok {
+		return nil, typeError("UpdateUsersParams")
+	}
+	updates := params.Users
+	for _, upd := range updates {
+		user, ok := f.Users[upd.ID]
+		if !ok {
+			return nil, missingRecord("user")
+		}
+		if upd.Username != "" && f.usernameTaken(strings.ToLower(upd.Username), upd.ID) {
+			return nil, invalidParameter("Username already exists: " + upd.Username)
+		}
+		if upd.Username != "" {
+			user.Username = strings.ToLower(upd.Username)
+		}
+		if upd.Password != "" {
+			user.Password = upd.Password
+		}
+		if upd.Firstname != "" {
+			user.Firstname = upd.Firstname
+		}
+		if upd.Lastname != "" {
+			user.Lastname = upd.Lastname
+		}
+		if upd.Email != "" {
+			user.Email = upd.Email
+		}
+		if upd.IDNumber != "" {
+			user.IDNumber = upd.IDNumber
+		}
+		if upd.Suspended != nil {
+			user.Suspended = *upd.Suspended == 1
+		}
+	}
+	return nil, nil
+}
+
+func (f *FakeClient) usernameTaken(username string, exceptID uint) bool {
+	for _, u := range f.Users {
+		if u.ID != exceptID && u.Username == username {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *FakeClient) enrolUsers(data interface{}) (interface{}, error) {
+	params, ok := data.(EnrolUsersParams)
+	if !ok {
+		return nil, typeError("EnrolUsersParams")
+	}
+	enrolments := params.Enrolments
+	for _, enrol := range enrolments {
+		if _, ok := f.Courses[enrol.CourseID]; !ok {
+			return nil, moodleAPIError("moodle_exception", "wsnoinstance", fmt.Sprintf("Manual enrolment plugin instance doesn't exist or is disabled for the course (id = %d)", enrol.CourseID))
+		}
+		if _, ok := f.Users[enrol.UserID]; !ok {
+			return nil, missingRecord("user")
+		}
+		if enrol.RoleID <= 0 || !f.hasRole(uint(enrol.RoleID)) {
+			return nil, moodleAPIError("moodle_exception", "wsusercannotassign", "You don't have the permission to assign this role")
+		}
+		if enrol.Timestart != nil && enrol.Timeend != nil && *enrol.Timeend != 0 && *enrol.Timeend < *enrol.Timestart {
+			return nil, invalidParameter("timeend")
+		}
+	}
+	for _, enrol := range enrolments {
+		// Una matrícula existente se actualiza solo con los campos opcionales que se envían.
+		key := enrolmentKey(enrol.CourseID, enrol.UserID)
+		e, ok := f.Enrolments[key]
+		if !ok {
+			e = &FakeEnrolment{UserID: enrol.UserID, CourseID: enrol.CourseID}
+			f.Enrolments[key] = e
+		}
+		e.RoleID = enrol.RoleID
+		if enrol.Timestart != nil {
+			e.Timestart = *enrol.Timestart
+		}
+		if enrol.Timeend != nil {
+			e.Timeend = *enrol.Timeend
+		}
+		if enrol.Suspend != nil {
+			e.Suspended = *enrol.Suspend == 1
+		}
+	}
+	return nil, nil
+}
+
+func (f *FakeClient) unenrolUsers(data interface{}) (interface{}, error) {
+	params, ok := data.(UnenrolUsersParams)
+	if !ok {
+		return nil, typeError("UnenrolUsersParams")
+	}
+	for _, unenrol := range params.Enrolments {
+		if _, ok := f.Courses[unenrol.CourseID]; !ok {
+			return nil, moodleAPIError("moodle_exception", "wsnoinstance", fmt.Sprintf("Manual enrolment plugin instance doesn't exist or is disabled for the course (id = %d)", unenrol.CourseID))
+		}
+		if _, ok := f.Users[unenrol.UserID]; !ok {
+			return nil, missingRecord("user")
+		}
+	}
+	// Como Moodle, dar de baja a alguien que no está matriculado no es un error, y la baja
+	// también lo retira de los grupos del curso.
+	for _, unenrol := range params.Enrolments {
+		delete(f.Enrolments, enrolmentKey(unenrol.CourseID, unenrol.UserID))
+		for groupID, g := range f.Groups {
+			if uint(g.CourseID) == unenrol.CourseID {
+				delete(f.GroupMembers[groupID], unenrol.UserID)
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (f *FakeClient) createGroups(data interface{}) (interface{}, error) {
+	params, ok := data.(CreateGroupsParams)
+	if !ok {
+		return nil, typeError("CreateGroupsParams")
+	}
+	groups := params.Groups
+	for _, group := range groups {
+		if _, ok := f.Courses[uint(group.CourseID)]; !ok {
+			return nil, missingRecord("course")
+		}
+		if f.groupNameTaken(group.CourseID, group.Name, 0) {
+			return nil, invalidParameter("Group with the same name already exists in the course")
+		}
+		if group.IDNumber != "" && f.groupIDNumberTaken(group.CourseID, group.IDNumber, 0) {
+			return nil, invalidParameter("Group with the same idnumber already exists in the course")
+		}
+	}
+
+	result := make([]GroupResponse, 0, len(groups))
+	for _, group := range groups {
+		g := &FakeGroup{
+			ID:                f.newID(),
+			CourseID:          group.CourseID,
+			Name:              group.Name,
+			Description:       group.Description,
+			DescriptionFormat: group.DescriptionFormat,
+			EnrolmentKey:      group.EnrolmentKey,
+			IDNumber:          group.IDNumber,
+			Visibility:        group.Visibility,
+			Participation:     group.Participation,
+		}
+		f.Groups[g.ID] = g
+		result = append(result, GroupResponse{ID: int(g.ID), Name: g.Name, CourseID: g.CourseID, IDNumber: g.IDNumber})
+	}
+	return result, nil
+}
+
+func (f *FakeClient) updateGroups(data interface{}) (interface{}, error) {
+	params, ok := data.(UpdateGroupsParams)
+	if !ok {
+		return nil, typeError("UpdateGroupsParams")
+	}
+	for _, upd := range params.Groups {
+		group, ok := f.Groups[upd.ID]
+		if !ok {
+			return nil, missingRecord("groups")
+		}
+		if f.groupNameTaken(group.CourseID, upd.Name, upd.ID) {
+			return nil, invalidParameter("A group with the same name already exists in the course")
+		}
+		if upd.IDNumber != "" && f.groupIDNumberTaken(group.CourseID, upd.IDNumber, upd.ID) {
+			return nil, invalidParameter("A group with the same idnumber already exists in the course")
+		}
+	}
+	for _, upd := range params.Groups {
+		group := f.Groups[upd.ID]
+		group.Name = upd.Name
+		group.Description = upd.Description
+		group.DescriptionFormat = upd.DescriptionFormat
+		group.EnrolmentKey = upd.EnrolmentKey
+		group.IDNumber = upd.IDNumber
+		group.Visibility = upd.Visibility
+	}
+	return nil, nil
+}
+
+func (f *FakeClient) groupNameTaken(courseID int, name string, exceptID uint) bool {
+	for _, g := range f.Groups {
+		if g.ID != exceptID && g.CourseID == courseID && g.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *FakeClient) groupIDNumberTaken(courseID int, idNumber string, exceptID uint) bool {
+	for _, g := range f.Groups {
+		if g.ID != exceptID && g.CourseID == courseID && g.IDNumber == idNumber {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *FakeClient) addGroupMembers(data interface{}) (interface{}, error) {
+	params, ok := data.(AddGroupMembersParams)
+	if !ok {
+		return nil, typeError("AddGroupMembersParams")
+	}
+	members := params.Members
+	for _, member := range members {
+		group, ok := f.Groups[uint(member.GroupID)]
+		if !ok {
+			return nil, missingRecord("groups")
+		}
+		if _, ok := f.Users[uint(member.UserID)]; !ok {
+			return nil, missingRecord("user")
+		}
+		if _, ok := f.Enrolments[enrolmentKey(uint(group.CourseID), uint(member.UserID))]; !ok {
+			return nil, invalidParameter("Only enrolled users may be members of groups")
+		}
+	}
+	for _, member := range members {
+		if f.GroupMembers[uint(member.GroupID)] == nil {
+			f.GroupMembers[uint(member.GroupID)] = make(map[uint]bool)
+		}
+		f.GroupMembers[uint(member.GroupID)][uint(member.UserID)] = true
+	}
+	return nil, nil
+}
+
+func (f *FakeClient) getCategories(data interface{}) (interface{}, error) {
+	params, ok := data.(GetCategoriesParams)
+	if !ok {
+		return nil, typeError("GetCategoriesParams")
+	}
+	result := []Category{}
+	for _, id := range sortedKeys(f.Categories) {
+		cat := f.Categories[id]
+		if !categoryMatches(cat, params.Criteria) {
+			continue
+		}
+		courseCount := 0
+		for _, course := range f.Courses {
+			if uint(course.CategoryID) == cat.ID {
+				courseCount++
+			}
+		}
+		result = append(result, Category{
+			ID:          cat.ID,
+			Name:        cat.Name,
+			IDNumber:    cat.IDNumber,
+			Description: cat.Description,
+			Parent:      uint(cat.Parent),
+			CourseCount: courseCount,
+			Visible:     1,
+		})
+	}
+	return result, nil
+}
+
+func categoryMatches(cat *FakeCategory, criteria []CategoryCriteria) bool {
+	for _, c := range criteria {
+		switch c.Key {
+		case "id":
+			if strconv.FormatUint(uint64(cat.ID), 10) != c.Value {
+				return false
+			}
+		case "name":
+			if cat.Name != c.Value {
+				return false
+			}
+		case "idnumber":
+			if cat.IDNumber != c.Value {
+				return false
+			}
+		case "parent":
+			if strconv.Itoa(cat.Parent) != c.Value {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (f *FakeClient) getCoursesByField(data interface{}) (interface{}, error) {
+	params, ok := data.(GetCoursesByFieldParams)
+	if !ok {
+		return nil, typeError("GetCoursesByFieldParams")
+	}
+	if params.Field != "" && params.Value == "" {
+		return nil, invalidParameter("value")
+	}
+	courses := []Course{}
+	for _, id := range sortedKeys(f.Courses) {
+		c := f.Courses[id]
+		var match bool
+		switch params.Field {
+		case "":
+			match = true
+		case "id":
+			match = strconv.FormatUint(uint64(c.ID), 10) == params.Value
+		case "ids":
+			for _, v := range strings.Split(params.Value, ",") {
+				match = match || strings.TrimSpace(v) == strconv.FormatUint(uint64(c.ID), 10)
+			}
+		case "shortname":
+			match = c.Shortname == params.Value
+		case "idnumber":
+			match = c.IDNumber == params.Value
+		case "category":
+			match = strconv.Itoa(c.CategoryID) == params.Value
+		default:
+			return nil, invalidParameter("field")
+		}
+		if match {
+			courses = append(courses, Course{
+				ID:         c.ID,
+				Fullname:   c.Fullname,
+				Shortname:  c.Shortname,
+				CategoryID: uint(c.CategoryID),
+				IDNumber:   c.IDNumber,
+				Summary:    c.Summary,
+				Format:     c.Format,
+				Visible:    c.Visible,
+				StartDate:  c.StartDate,
+				EndDate:    c.EndDate,
+			})
+		}
+	}
+	return GetCoursesByFieldResponse{Courses: courses, Warnings: []Warning{}}, nil
+}
+
+func (f *FakeClient) getUsersByField(data interface{}) (interface{}, error) {
+	params, ok := data.(GetUsersByFieldParams)
+	if !ok {
+		return nil, typeError("GetUsersByFieldParams")
+	}
+	wanted := make(map[string]bool, len(params.Values))
+	for _, v := range params.Values {
+		wanted[v] = true
+	}
+	users := []User{}
+	for _, id := range sortedKeys(f.Users) {
+		u := f.Users[id]
+		var value string
+		switch params.Field {
+		case "id":
+			value = strconv.FormatUint(uint64(u.ID), 10)
+		case "idnumber":
+			value = u.IDNumber
+		case "username":
+			value = u.Username
+		case "email":
+			value = u.Email
+		default:
+			return nil, invalidParameter("field")
+		}
+		if wanted[value] {
+			users = append(users, User{ID: u.ID, Username: u.Username, Firstname: u.Firstname, Lastname: u.Lastname, Email: u.Email, IDNumber: u.IDNumber, Auth: u.Auth, Suspended: u.Suspended})
+		}
+	}
+	return users, nil
+}
+
+// getUsers simula core_user_get_users. El valor "%" coincide con cualquiera (como el LIKE de Moodle);
+// el resto se compara exacto.
+func (f *FakeClient) getUsers(data interface{}) (interface{}, error) {
+	params, ok := data.(GetUsersParams)
+	if !ok {
+		return nil, typeError("GetUsersParams")
+	}
+	users := []User{}
+	for _, id := range sortedKeys(f.Users) {
+		u := f.Users[id]
+		match := true
+		for _, c := range params.Criteria {
+			var value string
+			switch c.Key {
+			case "id":
+				value = strconv.FormatUint(uint64(u.ID), 10)
+			case "username":
+				value = u.Username
+			case "email":
+				value = u.Email
+			case "idnumber":
+				value = u.IDNumber
+			case "firstname":
+				value = u.Firstname
+			case "lastname":
+				value = u.Lastname
+			default:
+				return nil, invalidParameter("key")
+			}
+			if c.Value != "%" && c.Value != value {
+				match = false
+			}
+		}
+		if match {
+			users = append(users, User{ID: u.ID, Username: u.Username, Firstname: u.Firstname, Lastname: u.Lastname, Email: u.Email, IDNumber: u.IDNumber, Auth: u.Auth, Suspended: u.Suspended})
+		}
+	}
+	return GetUsersResponse{Users: users, Warnings: []Warning{}}, nil
+}
+
+func (f *FakeClient) getCourseGroups(data interface{}) (interface{}, error) {
+	params, ok := data.(GetCourseGroupsParams)
+	if !ok {
+		return nil, typeError("GetCourseGroupsParams")
+	}
+	if _, ok := f.Courses[uint(params.CourseID)]; !ok {
+		return nil, missingRecord("course")
+	}
+	groups := []GroupResponse{}
+	for _, id := range sortedKeys(f.Groups) {
+		g := f.Groups[id]
+		if g.CourseID == params.CourseID {
+			groups = append(groups, GroupResponse{
+				ID:            int(g.ID),
+				Name:          g.Name,
+				CourseID:      g.CourseID,
+				IDNumber:      g.IDNumber,
+				Description:   g.Description,
+				EnrolmentKey:  g.EnrolmentKey,
+				Visibility:    g.Visibility,
+				Participation: g.Participation,
+			})
+		}
+	}
+	return groups, nil
+}
+
+func (f *FakeClient) deleteCategories(data interface{}) (interface{}, error) {
+	params, ok := data.(DeleteCategoriesParams)
+	if !ok {
+		return nil, typeError("DeleteCategoriesParams")
+	}
+	for _, del := range params.Categories {
+		cat, ok := f.Categories[del.ID]
+		if !ok {
+			return nil, missingRecord("course_categories")
+		}
+		if del.Recursive == 1 {
+			f.deleteCategoryTree(del.ID)
+			continue
+		}
+		// Sin borrado recursivo Moodle mueve el contenido a newparent o, si no se indica, al padre.
+		target := uint(cat.Parent)
+		if del.NewParent != nil {
+			target = *del.NewParent
+		}
+		if f.categoryHasContent(del.ID) && target == 0 {
+			return nil, moodleAPIError("moodle_exception", "youcannotdeletecategory", fmt.Sprintf("You cannot delete category '%s' because you can neither delete the contents, nor move them elsewhere", cat.Name))
+		}
+		for _, c := range f.Categories {
+			if uint(c.Parent) == del.ID {
+				c.Parent = int(target)
+			}
+		}
+		for _, c := range f.Courses {
+			if uint(c.CategoryID) == del.ID {
+				c.CategoryID = int(target)
+			}
+		}
+		delete(f.Categories, del.ID)
+	}
+	return nil, nil
+}
+
+func (f *FakeClient) categoryHasContent(id uint) bool {
+	for _, c := range f.Categories {
+		if uint(c.Parent) == id {
+			return true
+		}
+	}
+	for _, c := range f.Courses {
+		if uint(c.CategoryID) == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *FakeClient) deleteCategoryTree(id uint) {
+	for childID, c := range f.Categories {
+		if uint(c.Parent) == id {
+			f.deleteCategoryTree(childID)
+		}
+	}
+	for courseID, c := range f.Courses {
+		if uint(c.CategoryID) == id {
+			f.deleteCourse(courseID)
+		}
+	}
+	delete(f.Categories, id)
+}
+
+func (f *FakeClient) deleteCourses(data interface{}) (interface{}, error) {
+	params, ok := data.(DeleteCoursesParams)
+	if !ok {
+		return nil, typeError("DeleteCoursesParams")
+	}
+	warnings := []Warning{}
+	for _, id := range params.CourseIDs {
+		if _, ok := f.Courses[id]; !ok {
+			warnings = append(warnings, Warning{Item: "course", ItemID: int(id), WarningCode: "unknowncourseidnumber", Message: "Unknown course ID " + strconv.FormatUint(uint64(id), 10)})
+			continue
+		}
+		f.deleteCourse(id)
+	}
+	return DeleteCoursesResponse{Warnings: warnings}, nil
+}
+
+// deleteCourse elimina un curso junto con sus matrículas y grupos, como hace Moodle.
+func (f *FakeClient) deleteCourse(id uint) {
+	for key, e := range f.Enrolments {
+		if e.CourseID == id {
+			delete(f.Enrolments, key)
+		}
+	}
+	for groupID, g := range f.Groups {
+		if uint(g.CourseID) == id {
+			delete(f.Groups, groupID)
+			delete(f.GroupMembers, groupID)
+		}
+	}
+	delete(f.Courses, id)
+}
+
+func (f *FakeClient) deleteUsers(data interface{}) (interface{}, error) {
+	params, ok := data.(DeleteUsersParams)
+	if !ok {
+		return nil, typeError("DeleteUsersParams")
+	}
+	for _, id := range params.UserIDs {
+		if _, ok := f.Users[id]; !ok {
+			return nil, missingRecord("user")
+		}
+	}
+	for _, id := range params.UserIDs {
+		for key, e := range f.Enrolments {
+			if e.UserID == id {
+				delete(f.Enrolments, key)
+			}
+		}
+		for _, members := range f.GroupMembers {
+			delete(members, id)
+		}
+		delete(f.Users, id)
+	}
+	return nil, nil
+}
+
+func (f *FakeClient) deleteGroups(data interface{}) (interface{}, error) {
+	params, ok := data.(DeleteGroupsParams)
+	if !ok {
+		return nil, typeError("DeleteGroupsParams")
+	}
+	for _, id := range params.GroupIDs {
+		if _, ok := f.Groups[id]; !ok {
+			return nil, missingRecord("groups")
+		}
+	}
+	for _, id := range params.GroupIDs {
+		delete(f.Groups, id)
+		delete(f.GroupMembers, id)
+	}
+	return nil, nil
+}
+
+// sortedKeys devuelve las claves de un mapa de entidades en orden, para que las consultas sean deterministas.
+func sortedKeys[T any](m map[uint]T) []uint {
+	keys := make([]uint, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
+func (f *FakeClient) deleteGroupMembers(data interface{}) (interface{}, error) {
+	params, ok := data.(DeleteGroupMembersParams)
+	if !ok {
+		return nil, typeError("DeleteGroupMembersParams")
+	}
+	for _, member := range params.Members {
+		if _, ok := f.Groups[uint(member.GroupID)]; !ok {
+			return nil, missingRecord("groups")
+		}
+		if _, ok := f.Users[uint(member.UserID)]; !ok {
+			return nil, missingRecord("user")
+		}
+	}
+	for _, member := range params.Members {
+		delete(f.GroupMembers[uint(member.GroupID)], uint(member.UserID))
+	}
+	return nil, nil
+}
+
+// GroupMemberIDs devuelve, ordenados, los IDs de Moodle de los miembros de un grupo.
+func (f *FakeClient) GroupMemberIDs(groupID uint) []uint {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ids := make([]uint, 0, len(f.GroupMembers[groupID]))
+	for id := range f.GroupMembers[groupID] {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func (f *FakeClient) createCohorts(data interface{}) (interface{}, error) {
+	params, ok := data.(CreateCohortsParams)
+	if !ok {
+		return nil, typeError("CreateCohortsParams")
+	}
+	categories := make([]uint, len(params.Cohorts))
+	for i, c := range params.Cohorts {
+		if strings.TrimSpace(c.Name) == "" {
+			return nil, invalidParameter("name")
+		}
+		if c.IDNumber != "" {
+			for _, existing := range f.Cohorts {
+				if existing.IDNumber == c.IDNumber {
+					return nil, invalidParameter("record already exists: idnumber " + c.IDNumber)
+				}
+			}
+		}
+		switch c.CategoryType.Type {
+		case "system":
+		case "id":
+			id, err := strconv.ParseUint(c.CategoryType.Value, 10, 64)
+			if err != nil {
+				return nil, invalidParameter("categorytype")
+			}
+			if _, ok := f.Categories[uint(id)]; !ok {
+				return nil, missingRecord("course_categories")
+			}
+			categories[i] = uint(id)
+		default:
+			return nil, invalidParameter("categorytype")
+		}
+	}
+
+	result := make([]Cohort, 0, len(params.Cohorts))
+	for i, c := range params.Cohorts {
+		cohort := &FakeCohort{ID: f.newID(), CategoryID: categories[i], Name: c.Name, IDNumber: c.IDNumber, Description: c.Description, Visible: c.Visible != 0}
+		f.Cohorts[cohort.ID] = cohort
+		result = append(result, fakeCohortResponse(cohort))
+	}
+	return result, nil
+}
+
+func fakeCohortResponse(c *FakeCohort) Cohort {
+	return Cohort{ID: c.ID, Name: c.Name, IDNumber: c.IDNumber, Description: c.Description, DescriptionFormat: 1, Visible: c.Visible}
+}
+
+func (f *FakeClient) addCohortMembers(data interface{}) (interface{}, error) {
+	params, ok := data.(AddCohortMembersParams)
+	if !ok {
+		return nil, typeError("AddCohortMembersParams")
+	}
+	response := AddCohortMembersResponse{Warnings: []Warning{}}
+	for _, m := range params.Members {
+		cohort := f.findCohort(m.CohortType)
+		if cohort == nil {
+			response.Warnings = append(response.Warnings, Warning{Item: "cohort", WarningCode: "1", Message: "Cohort not found: " + m.CohortType.Value})
+			continue
+		}
+		user := f.findUser(m.UserType)
+		if user == nil {
+			response.Warnings = append(response.Warnings, Warning{Item: "user", WarningCode: "2", Message: "User not found: " + m.UserType.Value})
+			continue
+		}
+		if f.CohortMembers[cohort.ID] == nil {
+			f.CohortMembers[cohort.ID] = make(map[uint]bool)
+		}
+		f.CohortMembers[cohort.ID][user.ID] = true
+	}
+	return response, nil
+}
+
+func (f *FakeClient) getCohortMembers(data interface{}) (interface{}, error) {
+	params, ok := data.(GetCohortMembersParams)
+	if !ok {
+		return nil, typeError("GetCohortMembersParams")
+	}
+	result := make([]CohortMembers, 0, len(params.CohortIDs))
+	for _, id := range params.CohortIDs {
+		if _, ok := f.Cohorts[id]; !ok {
+			return nil, missingRecord("cohort")
+		}
+		result = append(result, CohortMembers{CohortID: id, UserIDs: sortedKeys(f.CohortMembers[id])})
+	}
+	return result, nil
+}
+
+func (f *FakeClient) deleteCohortMembers(data interface{}) (interface{}, error) {
+	params, ok := data.(DeleteCohortMembersParams)
+	if !ok {
+		return nil, typeError("DeleteCohortMembersParams")
+	}
+	for _, m := range params.Members {
+		if _, ok := f.Cohorts[m.CohortID]; !ok {
+			return nil, missingRecord("cohort")
+		}
+		if _, ok := f.Users[m.UserID]; !ok {
+			return nil, missingRecord("user")
+		}
+	}
+	for _, m := range params.Members {
+		delete(f.CohortMembers[m.CohortID], m.UserID)
+	}
+	return nil, nil
+}
+
+func (f *FakeClient) findCohort(key CohortTypeValue) *FakeCohort {
+	for _, id := range sortedKeys(f.Cohorts) {
+		c := f.Cohorts[id]
+		if (key.Type == "id" && strconv.FormatUint(uint64(c.ID), 10) == key.Value) ||
+			(key.Type == "idnumber" && c.IDNumber == key.Value) {
+			return c
+		}
+	}
+	return nil
+}
+
+func (f *FakeClient) findUser(key CohortTypeValue) *FakeUser {
+	for _, id := range sortedKeys(f.Users) {
+		u := f.Users[id]
+		if (key.Type == "id" && strconv.FormatUint(uint64(u.ID), 10) == key.Value) ||
+			(key.Type == "username" && u.Username == key.Value) ||
+			(key.Type == "idnumber" && u.IDNumber == key.Value) {
+			return u
+		}
+	}
+	return nil
+}
+
+func (f *FakeClient) searchCohorts(data interface{}) (interface{}, error) {
+	params, ok := data.(SearchCohortsParams)
+	if !ok {
+		return nil, typeError("SearchCohortsParams")
+	}
+	query := strings.ToLower(params.Query)
+	response := SearchCohortsResponse{Cohorts: []Cohort{}}
+	for _, id := range sortedKeys(f.Cohorts) {
+		c := f.Cohorts[id]
+		if strings.Contains(strings.ToLower(c.Name), query) || strings.Contains(strings.ToLower(c.IDNumber), query) {
+			response.Cohorts = append(response.Cohorts, fakeCohortResponse(c))
+		}
+	}
+	return response, nil
+}
+
+// CohortMemberIDs devuelve los IDs de Moodle de los miembros de una cohorte, ordenados.
+func (f *FakeClient) CohortMemberIDs(cohortID uint) []uint {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return sortedKeys(f.CohortMembers[cohortID])
+}
+
+// SetGrade califica a un usuario en la actividad itemName de un curso (la crea, de 0 a 10, si no existe).
+// Devuelve el ID del elemento de calificación.
+func (f *FakeClient) SetGrade(courseID, userID uint, itemName string, grade float64) uint {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var item *FakeGradeItem
+	for _, id := range sortedKeys(f.GradeItems) {
+		if gi := f.GradeItems[id]; gi.CourseID == courseID && gi.Name == itemName && gi.ItemType == "mod" {
+			item = gi
+		}
+	}
+	if item == nil {
+		item = &FakeGradeItem{ID: f.newID(), CourseID: courseID, Name: itemName, ItemType: "mod", ItemModule: "assign", GradeMax: 10}
+		f.GradeItems[item.ID] = item
+	}
+	f.Grades[fmt.Sprintf("%d:%d", item.ID, userID)] = &FakeGrade{Value: grade, Graded: int64(1700000000 + item.ID)}
+	return item.ID
+}
+
+func (f *FakeClient) getGradeItems(data interface{}) (interface{}, error) {
+	params, ok := data.(GetGradeItemsParams)
+	if !ok {
+		return nil, typeError("GetGradeItemsParams")
+	}
+	if _, ok := f.Courses[params.CourseID]; !ok {
+		return nil, missingRecord("course")
+	}
+
+	var users []uint
+	if params.UserID != 0 {
+		if _, ok := f.Enrolments[enrolmentKey(params.CourseID, params.UserID)]; !ok {
+			return nil, invalidParameter("userid")
+		}
+		users = []uint{params.UserID}
+	} else {
+		// Sin usuario, Moodle devuelve a los alumnos (rol 5) del curso.
+		for _, key := range sortedEnrolmentKeys(f.Enrolments) {
+			if e := f.Enrolments[key]; e.CourseID == params.CourseID && e.RoleID == 5 {
+				users = append(users, e.UserID)
+			}
+		}
+	}
+
+	total := f.courseTotalItem(params.CourseID)
+	response := GetGradeItemsResponse{UserGrades: []UserGrades{}, Warnings: []Warning{}}
+	for _, userID := range users {
+		ug := UserGrades{CourseID: params.CourseID, UserID: userID, GradeItems: []GradeItem{}}
+		if u, ok := f.Users[userID]; ok {
+			ug.UserFullname = u.Firstname + " " + u.Lastname
+		}
+
+		var sum, maxGrade float64
+		graded := false
+		for _, id := range sortedKeys(f.GradeItems) {
+			item := f.GradeItems[id]
+			if item.CourseID != params.CourseID || item.ItemType == "course" {
+				continue
+			}
+			grade := f.Grades[fmt.Sprintf("%d:%d", item.ID, userID)]
+			ug.GradeItems = append(ug.GradeItems, fakeGradeItem(item, grade))
+			maxGrade += item.GradeMax
+			if grade != nil {
+				sum += grade.Value
+				graded = true
+			}
+		}
+		total.GradeMax = maxGrade
+		var totalGrade *FakeGrade
+		if graded {
+			totalGrade = &FakeGrade{Value: sum}
+		}
+		ug.GradeItems = append(ug.GradeItems, fakeGradeItem(total, totalGrade))
+		response.UserGrades = append(response.UserGrades, ug)
+	}
+	return response, nil
+}
+
+// courseTotalItem devuelve (creándolo si hace falta) el elemento con el total del curso.
+func (f *FakeClient) courseTotalItem(courseID uint) *FakeGradeItem {
+	for _, item := range f.GradeItems {
+		if item.CourseID == courseID && item.ItemType == "course" {
+			return item
+		}
+	}
+	item := &FakeGradeItem{ID: f.newID(), CourseID: courseID, ItemType: "course"}
+	f.GradeItems[item.ID] = item
+	return item
+}
+
+func fakeGradeItem(item *FakeGradeItem, grade *FakeGrade) GradeItem {
+	gi := GradeItem{
+		ID:             item.ID,
+		ItemName:       item.Name,
+		ItemType:       item.ItemType,
+		ItemModule:     item.ItemModule,
+		GradeMin:       item.GradeMin,
+		GradeMax:       item.GradeMax,
+		GradeFormatted: "-",
+	}
+	if grade != nil {
+		value := grade.Value
+		gi.GradeRaw = &value
+		gi.GradeFormatted = strconv.FormatFloat(value, 'f', 2, 64)
+		if grade.Graded != 0 {
+			graded := grade.Graded
+			gi.GradeDateGraded = &graded
+		}
+		if item.GradeMax > item.GradeMin {
+			gi.PercentageFormatted = strconv.FormatFloat((value-item.GradeMin)*100/(item.GradeMax-item.GradeMin), 'f', 2, 64) + " %"
+		}
+	}
+	return gi
+}
+
+func sortedEnrolmentKeys(m map[string]*FakeEnrolment) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// SetCourseCompleted marca que el usuario completó el curso en el momento timeCompleted (UNIX).
+func (f *FakeClient) SetCourseCompleted(courseID, userID uint, timeCompleted int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Completions[enrolmentKey(courseID, userID)] = timeCompleted
+}
+
+func (f *FakeClient) getCourseCompletionStatus(data interface{}) (interface{}, error) {
+	params, ok := data.(GetCourseCompletionStatusParams)
+	if !ok {
+		return nil, typeError("GetCourseCompletionStatusParams")
+	}
+	if _, ok := f.Courses[params.CourseID]; !ok {
+		return nil, missingRecord("course")
+	}
+	key := enrolmentKey(params.CourseID, params.UserID)
+	if _, ok := f.Enrolments[key]; !ok {
+		return nil, moodleAPIError("moodle_exception", "nottracked", "User is not tracked by completion in this course")
+	}
+
+	// Un único criterio: el alumno marca el curso como completado.
+	criterion := CompletionCriterion{Type: 1, Title: "Marcado como completado", Status: "No", Complete: false}
+	timeCompleted, completed := f.Completions[key]
+	if completed {
+		criterion.Status = "Sí"
+		criterion.Complete = true
+		criterion.TimeCompleted = &timeCompleted
+	}
+	return GetCourseCompletionStatusResponse{
+		CompletionStatus: CourseCompletionStatus{Completed: completed, Aggregation: 1, Completions: []CompletionCriterion{criterion}},
+		Warnings:         []Warning{},
+	}, nil
+}
+
+// defaultFakeRoles son los roles con los que se instala Moodle.
+func defaultFakeRoles() []Role {
+	return []Role{
+		{ID: 1, Name: "Manager", Shortname: "manager", Archetype: "manager", SortOrder: 1},
+		{ID: 2, Name: "Course creator", Shortname: "coursecreator", Archetype: "coursecreator", SortOrder: 2},
+		{ID: 3, Name: "Teacher", Shortname: "editingteacher", Archetype: "editingteacher", SortOrder: 3},
+		{ID: 4, Name: "Non-editing teacher", Shortname: "teacher", Archetype: "teacher", SortOrder: 4},
+		{ID: 5, Name: "Student", Shortname: "student", Archetype: "student", SortOrder: 5},
+		{ID: 6, Name: "Guest", Shortname: "guest", Archetype: "guest", SortOrder: 6},
+		{ID: 7, Name: "Authenticated user", Shortname: "user", Archetype: "user", SortOrder: 7},
+		{ID: 8, Name: "Authenticated user on frontpage", Shortname: "frontpage", Archetype: "frontpage", SortOrder: 8},
+	}
+}
+
+func (f *FakeClient) hasRole(id uint) bool {
+	for _, r := range f.Roles {
+		if r.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *FakeClient) getRoles(data interface{}) (interface{}, error) {
+	if _, ok := data.(GetRolesParams); !ok {
+		return nil, typeError("GetRolesParams")
+	}
+	roles := make([]Role, len(f.Roles))
+	copy(roles, f.Roles)
+	return roles, nil
+}
@@ -0,0 +1,184 @@
+package moodle
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limiter regula el tráfico saliente hacia Moodle con dos mecanismos:
+//   - un token bucket que limita las peticiones por segundo (con ráfagas de hasta Burst peticiones);
+//   - un semáforo que limita cuántas llamadas pueden estar en curso a la vez.
+//
+// Una única instancia (SharedLimiter) se comparte por todo el proceso, de modo que varias
+// sincronizaciones masivas simultáneas se reparten el mismo presupuesto.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64 // tokens por segundo; 0 = sin límite de tasa
+	burst  float64
+	tokens float64
+	last   time.Time
+
+	slots chan struct{} // nil = sin límite de concurrencia
+
+	// Métricas de espera
+	calls     int64
+	waited    int64
+	totalWait time.Duration
+	maxWait   time.Duration
+	inFlight  int
+}
+
+// LimiterStats es una foto de las métricas del limitador.
+type LimiterStats struct {
+	RatePerSecond float64 `json:"rate_per_second"`
+	Burst         int     `json:"burst"`
+	MaxConcurrent int     `json:"max_concurrent"`
+	InFlight      int     `json:"in_flight"`
+	Calls         int64   `json:"calls"`         // Llamadas que pasaron por el limitador
+	WaitedCalls   int64   `json:"waited_calls"`  // Llamadas que tuvieron que esperar
+	TotalWaitMs   float64 `json:"total_wait_ms"` // Tiempo total esperado
+	AvgWaitMs     float64 `json:"avg_wait_ms"`   // Espera media por llamada
+	MaxWaitMs     float64 `json:"max_wait_ms"`   // Espera máxima observada
+}
+
+var (
+	sharedLimiter     *Limiter
+	sharedLimiterOnce sync.Once
+)
+
+// SharedLimiter devuelve el limitador global del proceso, configurado con:
+//   - MOODLE_RATE_LIMIT_RPS: peticiones por segundo (por defecto 10; 0 desactiva el límite).
+//   - MOODLE_RATE_LIMIT_BURST: ráfaga máxima (por defecto igual a las RPS, mínimo 1).
+//   - MOODLE_MAX_CONCURRENT_CALLS: llamadas simultáneas (por defecto 4; 0 desactiva el límite).
+func SharedLimiter() *Limiter {
+	sharedLimiterOnce.Do(func() {
+		rps := EnvFloat("MOODLE_RATE_LIMIT_RPS", 10)
+		burst := EnvInt("MOODLE_RATE_LIMIT_BURST", int(rps))
+		maxConcurrent := EnvInt("MOODLE_MAX_CONCURRENT_CALLS", 4)
+		sharedLimiter = NewLimiter(rps, burst, maxConcurrent)
+	})
+	return sharedLimiter
+}
+
+// NewLimiter crea un limitador independiente.
+func NewLimiter(rps float64, burst, maxConcurrent int) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	l := &Limiter{
+		rate:   rps,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+	if maxConcurrent > 0 {
+		l.slots = make(chan struct{}, maxConcurrent)
+	}
+	return l
+}
+
+// Acquire bloquea hasta que la llamada puede salir hacia Moodle. Devuelve la función que
+// libera el hueco de concurrencia y debe invocarse al terminar la llamada.
+func (l *Limiter) Acquire(ctx context.Context) (func(), error) {
+	start := time.Now()
+
+	// 1. Hueco de concurrencia
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	release := func() {
+		l.mu.Lock()
+		l.inFlight--
+		l.mu.Unlock()
+		if l.slots != nil {
+			<-l.slots
+		}
+	}
+
+	// 2. Token de tasa: se reserva ahora y se espera lo que falte para que esté disponible
+	if wait := l.reserve(); wait > 0 {
+		if err := sleepContext(ctx, wait); err != nil {
+			l.cancelReservation()
+			if l.slots != nil {
+				<-l.slots
+			}
+			return nil, err
+		}
+	}
+
+	l.record(time.Since(start))
+	return release, nil
+}
+
+// reserve toma un token (pudiendo quedar en negativo) y devuelve cuánto hay que esperar.
+func (l *Limiter) reserve() time.Duration {
+	if l.rate <= 0 {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// cancelReservation devuelve el token reservado cuando la espera se aborta.
+func (l *Limiter) cancelReservation() {
+	if l.rate <= 0 {
+		return
+	}
+	l.mu.Lock()
+	l.tokens++
+	l.mu.Unlock()
+}
+
+func (l *Limiter) record(wait time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.calls++
+	l.inFlight++
+	// Esperas por debajo de 1ms son ruido del propio planificador.
+	if wait >= time.Millisecond {
+		l.waited++
+		l.totalWait += wait
+		if wait > l.maxWait {
+			l.maxWait = wait
+		}
+	}
+}
+
+// Stats devuelve las métricas acumuladas del limitador.
+func (l *Limiter) Stats() LimiterStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	stats := LimiterStats{
+		RatePerSecond: l.rate,
+		Burst:         int(l.burst),
+		MaxConcurrent: cap(l.slots),
+		InFlight:      l.inFlight,
+		Calls:         l.calls,
+		WaitedCalls:   l.waited,
+		TotalWaitMs:   float64(l.totalWait) / float64(time.Millisecond),
+		MaxWaitMs:     float64(l.maxWait) / float64(time.Millisecond),
+	}
+	if l.calls > 0 {
+		stats.AvgWaitMs = stats.TotalWaitMs / float64(l.calls)
+	}
+	return stats
+}
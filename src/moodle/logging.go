@@ -0,0 +1,171 @@
+package moodle
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// LogLevel controla cuánto detalle de las llamadas a Moodle se escribe en el log.
+type LogLevel int
+
+const (
+	LogOff   LogLevel = iota // Sin registro de llamadas
+	LogInfo                  // Función, estado HTTP, duración y tamaño
+	LogDebug                 // Además, cuerpos de petición y respuesta (redactados y recortados)
+)
+
+// defaultSensitiveKeys son los parámetros cuyo valor nunca se escribe en el log.
+var defaultSensitiveKeys = []string{"wstoken", "token", "privatetoken", "password", "newpassword", "enrolmentkey", "secret"}
+
+const redactedValue = "[REDACTADO]"
+
+// CallLogger registra las llamadas al WebService ocultando secretos: el wstoken, las
+// contraseñas y cualquier clave adicional configurada. Los cuerpos se recortan a MaxBytes.
+type CallLogger struct {
+	Level     LogLevel
+	MaxBytes  int
+	sensitive map[string]bool
+}
+
+// NewCallLogger crea un logger con las claves sensibles por defecto más las indicadas.
+func NewCallLogger(level LogLevel, maxBytes int, extraKeys ...string) *CallLogger {
+	l := &CallLogger{Level: level, MaxBytes: maxBytes, sensitive: make(map[string]bool)}
+	for _, k := range append(defaultSensitiveKeys, extraKeys...) {
+		if k = strings.ToLower(strings.TrimSpace(k)); k != "" {
+			l.sensitive[k] = true
+		}
+	}
+	return l
+}
+
+// NewCallLoggerFromEnv configura el logger con:
+//   - MOODLE_LOG_LEVEL: off, info (por defecto) o debug.
+//   - MOODLE_LOG_MAX_BYTES: bytes máximos de cada cuerpo registrado (por defecto 2048).
+//   - MOODLE_LOG_REDACT_KEYS: claves adicionales a ocultar, separadas por comas (p. ej. "email,idnumber").
+func NewCallLoggerFromEnv() *CallLogger {
+	level := LogInfo
+	switch strings.ToLower(os.Getenv("MOODLE_LOG_LEVEL")) {
+	case "off", "none":
+		level = LogOff
+	case "debug":
+		level = LogDebug
+	case "", "info":
+	default:
+		log.Printf("⚠️ Valor inválido para MOODLE_LOG_LEVEL (%q). Usando info.", os.Getenv("MOODLE_LOG_LEVEL"))
+	}
+
+	var extra []string
+	if raw := os.Getenv("MOODLE_LOG_REDACT_KEYS"); raw != "" {
+		extra = strings.Split(raw, ",")
+	}
+	return NewCallLogger(level, EnvInt("MOODLE_LOG_MAX_BYTES", 2048), extra...)
+}
+
+// paramLeafPattern extrae el nombre final de un parámetro aplanado: users[0][password] -> password.
+var paramLeafPattern = regexp.MustCompile(`\[([^\[\]]*)\]$`)
+
+func (l *CallLogger) isSensitive(key string) bool {
+	leaf := key
+	if m := paramLeafPattern.FindStringSubmatch(key); m != nil {
+		leaf = m[1]
+	}
+	return l.sensitive[strings.ToLower(leaf)]
+}
+
+// RedactValues devuelve los parámetros codificados con los valores sensibles ocultos y recortados.
+func (l *CallLogger) RedactValues(values url.Values) string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		for _, v := range values[k] {
+			if b.Len() > 0 {
+				b.WriteByte('&')
+			}
+			if l.isSensitive(k) {
+				v = redactedValue
+			}
+			b.WriteString(k)
+			b.WriteByte('=')
+			b.WriteString(v)
+		}
+	}
+	return l.truncate(b.String())
+}
+
+// RedactBody oculta las claves sensibles de una respuesta JSON y la recorta.
+// Si el cuerpo no es JSON válido sólo se recorta.
+func (l *CallLogger) RedactBody(body []byte) string {
+	var parsed interface{}
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return l.truncate(string(body))
+	}
+	redacted, err := json.Marshal(l.redactJSON(parsed))
+	if err != nil {
+		return l.truncate(string(body))
+	}
+	return l.truncate(string(redacted))
+}
+
+func (l *CallLogger) redactJSON(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, val := range t {
+			if l.sensitive[strings.ToLower(k)] {
+				t[k] = redactedValue
+			} else {
+				t[k] = l.redactJSON(val)
+			}
+		}
+	case []interface{}:
+		for i := range t {
+			t[i] = l.redactJSON(t[i])
+		}
+	}
+	return v
+}
+
+func (l *CallLogger) truncate(s string) string {
+	if l.MaxBytes <= 0 || len(s) <= l.MaxBytes {
+		return s
+	}
+	return fmt.Sprintf("%s…(%d bytes omitidos)", strings.ToValidUTF8(s[:l.MaxBytes], ""), len(s)-l.MaxBytes)
+}
+
+// Request registra la salida de una llamada.
+func (l *CallLogger) Request(function string, values url.Values) {
+	switch {
+	case l.Level >= LogDebug:
+		log.Printf("→ Moodle %s: %s", function, l.RedactValues(values))
+	case l.Level >= LogInfo:
+		log.Printf("→ Moodle %s (%d parámetros)", function, len(values))
+	}
+}
+
+// Response registra el resultado HTTP de una llamada.
+func (l *CallLogger) Response(function string, status int, body []byte, elapsed time.Duration) {
+	switch {
+	case l.Level >= LogDebug:
+		log.Printf("← Moodle %s: HTTP %d en %s (%d bytes): %s", function, status, elapsed.Round(time.Millisecond), len(body), l.RedactBody(body))
+	case l.Level >= LogInfo:
+		log.Printf("← Moodle %s: HTTP %d en %s (%d bytes)", function, status, elapsed.Round(time.Millisecond), len(body))
+	}
+}
+
+// Infof registra un mensaje de nivel info.
+func (l *CallLogger) Infof(format string, args ...interface{}) {
+	if l.Level >= LogInfo {
+		log.Printf(format, args...)
+	}
+}
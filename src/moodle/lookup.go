@@ -0,0 +1,245 @@
+package moodle
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Funciones de consulta sobre el WebService. Todas reciben un MoodleAPI para poder usarse
+// tanto con el cliente real como con FakeClient. Las funciones FindXxx devuelven (nil, nil)
+// cuando Moodle no tiene ninguna entidad que coincida.
+
+// GetCategories ejecuta core_course_get_categories con los criterios indicados
+// (sin criterios devuelve todas las categorías).
+func GetCategories(ctx context.Context, api MoodleAPI, criteria ...CategoryCriteria) ([]Category, error) {
+	var categories []Category
+	if err := api.Call(ctx, "core_course_get_categories", GetCategoriesParams{Criteria: criteria}, &categories); err != nil {
+		return nil, fmt.Errorf("fallo al consultar categorías en Moodle: %w", err)
+	}
+	return categories, nil
+}
+
+// FindCategoryByIDNumber busca una categoría por su idnumber.
+func FindCategoryByIDNumber(ctx context.Context, api MoodleAPI, idNumber string) (*Category, error) {
+	if idNumber == "" {
+		return nil, nil
+	}
+	categories, err := GetCategories(ctx, api, CategoryCriteria{Key: "idnumber", Value: idNumber})
+	if err != nil {
+		return nil, err
+	}
+	for i := range categories {
+		if categories[i].IDNumber == idNumber {
+			return &categories[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// FindCategoryByName busca una categoría por nombre dentro de la categoría padre indicada (0 = raíz).
+func FindCategoryByName(ctx context.Context, api MoodleAPI, parent uint, name string) (*Category, error) {
+	categories, err := GetCategories(ctx, api,
+		CategoryCriteria{Key: "name", Value: name},
+		CategoryCriteria{Key: "parent", Value: strconv.FormatUint(uint64(parent), 10)},
+	)
+	if err != nil {
+		return nil, err
+	}
+	for i := range categories {
+		if categories[i].Name == name && categories[i].Parent == parent {
+			return &categories[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// CategoryHasContent indica si una categoría contiene cursos o subcategorías.
+func CategoryHasContent(ctx context.Context, api MoodleAPI, categoryID uint) (bool, error) {
+	id := strconv.FormatUint(uint64(categoryID), 10)
+	categories, err := GetCategories(ctx, api, CategoryCriteria{Key: "id", Value: id})
+	if err != nil {
+		return false, err
+	}
+	for _, c := range categories {
+		if c.ID == categoryID && c.CourseCount > 0 {
+			return true, nil
+		}
+	}
+	children, err := GetCategories(ctx, api, CategoryCriteria{Key: "parent", Value: id})
+	if err != nil {
+		return false, err
+	}
+	return len(children) > 0, nil
+}
+
+// GetCoursesByField ejecuta core_course_get_courses_by_field.
+func GetCoursesByField(ctx context.Context, api MoodleAPI, field, value string) ([]Course, error) {
+	var response GetCoursesByFieldResponse
+	if err := api.Call(ctx, "core_course_get_courses_by_field", GetCoursesByFieldParams{Field: field, Value: value}, &response); err != nil {
+		return nil, fmt.Errorf("fallo al consultar cursos en Moodle por %s: %w", field, err)
+	}
+	return response.Courses, nil
+}
+
+// FindCourseByShortname busca un curso por su nombre corto.
+func FindCourseByShortname(ctx context.Context, api MoodleAPI, shortname string) (*Course, error) {
+	return findCourse(ctx, api, "shortname", shortname)
+}
+
+// FindCourseByIDNumber busca un curso por su idnumber.
+func FindCourseByIDNumber(ctx context.Context, api MoodleAPI, idNumber string) (*Course, error) {
+	return findCourse(ctx, api, "idnumber", idNumber)
+}
+
+// FindCourse busca un curso primero por idnumber (si se indica) y después por nombre corto.
+func FindCourse(ctx context.Context, api MoodleAPI, idNumber, shortname string) (*Course, error) {
+	course, err := FindCourseByIDNumber(ctx, api, idNumber)
+	if err != nil || course != nil {
+		return course, err
+	}
+	return FindCourseByShortname(ctx, api, shortname)
+}
+
+func findCourse(ctx context.Context, api MoodleAPI, field, value string) (*Course, error) {
+	if value == "" {
+		return nil, nil
+	}
+	courses, err := GetCoursesByField(ctx, api, field, value)
+	if err != nil {
+		return nil, err
+	}
+	if len(courses) == 0 {
+		return nil, nil
+	}
+	return &courses[0], nil
+}
+
+// GetUsersByField ejecuta core_user_get_users_by_field.
+func GetUsersByField(ctx context.Context, api MoodleAPI, field string, values ...string) ([]User, error) {
+	var users []User
+	if err := api.Call(ctx, "core_user_get_users_by_field", GetUsersByFieldParams{Field: field, Values: values}, &users); err != nil {
+		return nil, fmt.Errorf("fallo al consultar usuarios en Moodle por %s: %w", field, err)
+	}
+	return users, nil
+}
+
+// GetAllUsers ejecuta core_user_get_users con un criterio que coincide con todos los usuarios
+// (los eliminados no se devuelven).
+func GetAllUsers(ctx context.Context, api MoodleAPI) ([]User, error) {
+	var response GetUsersResponse
+	params := GetUsersParams{Criteria: []UserCriteria{{Key: "email", Value: "%"}}}
+	if err := api.Call(ctx, "core_user_get_users", params, &response); err != nil {
+		return nil, fmt.Errorf("fallo al consultar los usuarios de Moodle: %w", err)
+	}
+	return response.Users, nil
+}
+
+// FindUserByUsername busca un usuario por username (Moodle los guarda en minúsculas).
+func FindUserByUsername(ctx context.Context, api MoodleAPI, username string) (*User, error) {
+	return findUser(ctx, api, "username", strings.ToLower(username))
+}
+
+// FindUserByIDNumber busca un usuario por su idnumber.
+func FindUserByIDNumber(ctx context.Context, api MoodleAPI, idNumber string) (*User, error) {
+	return findUser(ctx, api, "idnumber", idNumber)
+}
+
+func findUser(ctx context.Context, api MoodleAPI, field, value string) (*User, error) {
+	if value == "" {
+		return nil, nil
+	}
+	users, err := GetUsersByField(ctx, api, field, value)
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, nil
+	}
+	return &users[0], nil
+}
+
+// GetCourseGroups ejecuta core_group_get_course_groups.
+func GetCourseGroups(ctx context.Context, api MoodleAPI, courseID int) ([]GroupResponse, error) {
+	var groups []GroupResponse
+	if err := api.Call(ctx, "core_group_get_course_groups", GetCourseGroupsParams{CourseID: courseID}, &groups); err != nil {
+		return nil, fmt.Errorf("fallo al consultar grupos del curso %d en Moodle: %w", courseID, err)
+	}
+	return groups, nil
+}
+
+// FindGroupByName busca un grupo por nombre dentro de un curso.
+func FindGroupByName(ctx context.Context, api MoodleAPI, courseID int, name string) (*GroupResponse, error) {
+	return FindGroup(ctx, api, courseID, "", name)
+}
+
+// FindGroup busca un grupo de un curso por idnumber (si se indica) o, en su defecto, por nombre.
+func FindGroup(ctx context.Context, api MoodleAPI, courseID int, idNumber, name string) (*GroupResponse, error) {
+	groups, err := GetCourseGroups(ctx, api, courseID)
+	if err != nil {
+		return nil, err
+	}
+	return MatchGroup(groups, idNumber, name), nil
+}
+
+// MatchGroup localiza en groups el grupo con el idnumber indicado o, si no hay, con el mismo nombre.
+// Sirve para reutilizar una única consulta de grupos del curso al procesar varios grupos.
+func MatchGroup(groups []GroupResponse, idNumber, name string) *GroupResponse {
+	if idNumber != "" {
+		for i := range groups {
+			if groups[i].IDNumber == idNumber {
+				return &groups[i]
+			}
+		}
+	}
+	for i := range groups {
+		if groups[i].Name == name {
+			return &groups[i]
+		}
+	}
+	return nil
+}
+
+// SearchCohorts ejecuta core_cohort_search_cohorts desde el contexto de sistema incluyendo las
+// cohortes de todas las categorías. Moodle busca query en el nombre y en el idnumber.
+func SearchCohorts(ctx context.Context, api MoodleAPI, query string) ([]Cohort, error) {
+	var response SearchCohortsResponse
+	params := SearchCohortsParams{Query: query, Context: CohortSearchContext{ContextID: 1}, Includes: "all"}
+	if err := api.Call(ctx, "core_cohort_search_cohorts", params, &response); err != nil {
+		return nil, fmt.Errorf("fallo al buscar cohortes en Moodle: %w", err)
+	}
+	return response.Cohorts, nil
+}
+
+// GetCohortMembers ejecuta core_cohort_get_cohort_members y devuelve los IDs de Moodle de los miembros
+// de una cohorte.
+func GetCohortMembers(ctx context.Context, api MoodleAPI, cohortID uint) ([]uint, error) {
+	var response []CohortMembers
+	if err := api.Call(ctx, "core_cohort_get_cohort_members", GetCohortMembersParams{CohortIDs: []uint{cohortID}}, &response); err != nil {
+		return nil, fmt.Errorf("fallo al consultar los miembros de la cohorte %d en Moodle: %w", cohortID, err)
+	}
+	for _, c := range response {
+		if c.CohortID == cohortID {
+			return c.UserIDs, nil
+		}
+	}
+	return nil, nil
+}
+
+// FindCohortByIDNumber busca una cohorte por su idnumber.
+func FindCohortByIDNumber(ctx context.Context, api MoodleAPI, idNumber string) (*Cohort, error) {
+	if idNumber == "" {
+		return nil, nil
+	}
+	cohorts, err := SearchCohorts(ctx, api, idNumber)
+	if err != nil {
+		return nil, err
+	}
+	for i := range cohorts {
+		if cohorts[i].IDNumber == idNumber {
+			return &cohorts[i], nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,50 @@
+package moodle
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+// RetryPolicy define cuántas veces y con qué espera se reintentan los fallos transitorios.
+type RetryPolicy struct {
+	MaxRetries int           // Reintentos después del primer intento (0 = sin reintentos)
+	BaseDelay  time.Duration // Espera base del primer reintento
+	MaxDelay   time.Duration // Tope de la espera entre reintentos
+}
+
+// DefaultRetryPolicy lee la política de las variables MOODLE_MAX_RETRIES (por defecto 3),
+// MOODLE_RETRY_BASE_DELAY (por defecto 500ms) y MOODLE_RETRY_MAX_DELAY (por defecto 10s).
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxRetries: EnvInt("MOODLE_MAX_RETRIES", 3),
+		BaseDelay:  EnvDuration("MOODLE_RETRY_BASE_DELAY", 500*time.Millisecond),
+		MaxDelay:   EnvDuration("MOODLE_RETRY_MAX_DELAY", 10*time.Second),
+	}
+}
+
+// Backoff calcula la espera antes del reintento número attempt (empezando en 0) con
+// crecimiento exponencial y "full jitter": un valor aleatorio entre 0 y BaseDelay*2^attempt,
+// limitado por MaxDelay, para que varias llamadas fallidas no reintenten a la vez.
+func (p *RetryPolicy) Backoff(attempt int) time.Duration {
+	ceiling := p.BaseDelay << uint(attempt)
+	if ceiling <= 0 || ceiling > p.MaxDelay {
+		ceiling = p.MaxDelay
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int64N(int64(ceiling) + 1))
+}
+
+// sleepContext espera d o hasta que ctx se cancele.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,31 @@
+package moodle
+
+import (
+	"context"
+	"fmt"
+)
+
+// RolesFunction lista los roles definidos en Moodle. No es una función del núcleo: la aporta el
+// plugin local_wsgetroles, por eso es opcional.
+const RolesFunction = "local_wsgetroles_get_roles"
+
+// GetRolesParams son los parámetros de local_wsgetroles_get_roles. Sin filtros devuelve todos los roles.
+type GetRolesParams struct{}
+
+// Role es un rol de Moodle tal como lo devuelve local_wsgetroles_get_roles.
+type Role struct {
+	ID        uint   `json:"id"`
+	Name      string `json:"name"`
+	Shortname string `json:"shortname"`
+	Archetype string `json:"archetype"`
+	SortOrder int    `json:"sortorder"`
+}
+
+// GetRoles ejecuta local_wsgetroles_get_roles.
+func GetRoles(ctx context.Context, api MoodleAPI) ([]Role, error) {
+	var roles []Role
+	if err := api.Call(ctx, RolesFunction, GetRolesParams{}, &roles); err != nil {
+		return nil, fmt.Errorf("fallo al obtener los roles de Moodle: %w", err)
+	}
+	return roles, nil
+}
@@ -0,0 +1,216 @@
+package moodle
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SiteInfoFunction es la función del WebService que informa de la versión de Moodle y de las
+// funciones habilitadas para el token. Moodle la permite siempre, sea cual sea el servicio.
+const SiteInfoFunction = "core_webservice_get_site_info"
+
+// RequiredFunctions son las funciones sin las que la sincronización no puede funcionar.
+var RequiredFunctions = []string{
+	SiteInfoFunction,
+	"core_course_create_categories",
+	"core_course_update_categories",
+	"core_course_get_categories",
+	"core_course_create_courses",
+	"core_course_update_courses",
+	"core_course_get_courses_by_field",
+	"core_user_create_users",
+	"core_user_update_users",
+	"core_user_get_users_by_field",
+	"enrol_manual_enrol_users",
+	"core_group_create_groups",
+	"core_group_get_course_groups",
+	"core_group_add_group_members",
+}
+
+// OptionalFunctions son funciones que la API usa sólo en algunas operaciones (eliminar, desmatricular,
+// actualizar grupos, cohortes, calificaciones, finalización, validar roles, plantillas de curso, conciliación...). Si faltan, esas operaciones responden 501 y el resto sigue funcionando.
+var OptionalFunctions = []string{
+	"core_course_delete_categories",
+	"core_course_delete_courses",
+	"core_user_delete_users",
+	"core_group_delete_groups",
+	"core_group_update_groups",
+	"core_group_delete_group_members",
+	"enrol_manual_unenrol_users",
+	"core_cohort_create_cohorts",
+	"core_cohort_add_cohort_members",
+	"core_cohort_search_cohorts",
+	"core_cohort_get_cohort_members",
+	"core_cohort_delete_cohort_members",
+	"gradereport_user_get_grade_items",
+	"core_completion_get_course_completion_status",
+	RolesFunction,
+	"core_course_duplicate_course",
+	"core_course_import_course",
+	"core_user_get_users",
+}
+
+// GetSiteInfoParams son los parámetros de core_webservice_get_site_info (no necesita ninguno).
+type GetSiteInfoParams struct{}
+
+// SiteFunctionInfo es una de las funciones habilitadas que lista core_webservice_get_site_info.
+type SiteFunctionInfo struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// SiteInfo es la respuesta de core_webservice_get_site_info (sólo los campos que usamos).
+type SiteInfo struct {
+	SiteName  string             `json:"sitename"`
+	SiteURL   string             `json:"siteurl"`
+	Username  string             `json:"username"`
+	UserID    uint               `json:"userid"`
+	Release   string             `json:"release"`
+	Version   string             `json:"version"`
+	Functions []SiteFunctionInfo `json:"functions"`
+}
+
+// GetSiteInfo ejecuta core_webservice_get_site_info.
+func GetSiteInfo(ctx context.Context, api MoodleAPI) (*SiteInfo, error) {
+	var info SiteInfo
+	if err := api.Call(ctx, SiteInfoFunction, GetSiteInfoParams{}, &info); err != nil {
+		return nil, fmt.Errorf("fallo al consultar la información del sitio Moodle: %w", err)
+	}
+	return &info, nil
+}
+
+// SiteReport resume la comprobación de capacidades: versión de Moodle y funciones que faltan.
+type SiteReport struct {
+	SiteName        string    `json:"sitename"`
+	Release         string    `json:"release"`
+	Version         string    `json:"version"`
+	Username        string    `json:"username"`
+	FunctionCount   int       `json:"function_count"`
+	MissingRequired []string  `json:"missing_required"`
+	MissingOptional []string  `json:"missing_optional"`
+	CheckedAt       time.Time `json:"checked_at"`
+
+	available map[string]bool
+}
+
+// NewSiteReport compara las funciones habilitadas en info con RequiredFunctions y OptionalFunctions.
+func NewSiteReport(info *SiteInfo) *SiteReport {
+	report := &SiteReport{
+		SiteName:        info.SiteName,
+		Release:         info.Release,
+		Version:         info.Version,
+		Username:        info.Username,
+		FunctionCount:   len(info.Functions),
+		MissingRequired: []string{},
+		MissingOptional: []string{},
+		CheckedAt:       time.Now(),
+		available:       map[string]bool{SiteInfoFunction: true},
+	}
+	for _, fn := range info.Functions {
+		report.available[fn.Name] = true
+	}
+	for _, fn := range RequiredFunctions {
+		if !report.available[fn] {
+			report.MissingRequired = append(report.MissingRequired, fn)
+		}
+	}
+	for _, fn := range OptionalFunctions {
+		if !report.available[fn] {
+			report.MissingOptional = append(report.MissingOptional, fn)
+		}
+	}
+	sort.Strings(report.MissingRequired)
+	sort.Strings(report.MissingOptional)
+	return report
+}
+
+// Degraded indica si falta alguna función imprescindible.
+func (r *SiteReport) Degraded() bool {
+	return len(r.MissingRequired) > 0
+}
+
+// Supports indica si function está habilitada para el token.
+func (r *SiteReport) Supports(function string) bool {
+	return r.available[function]
+}
+
+// CheckSite consulta core_webservice_get_site_info y construye el informe de capacidades.
+// Si api es un *Client, el informe queda asociado a él: a partir de entonces las llamadas a
+// funciones no habilitadas fallan con ErrUnsupported sin llegar a Moodle.
+func CheckSite(ctx context.Context, api MoodleAPI) (*SiteReport, error) {
+	info, err := GetSiteInfo(ctx, api)
+	if err != nil {
+		return nil, err
+	}
+	report := NewSiteReport(info)
+	if c, ok := api.(*Client); ok {
+		c.SetSiteReport(report)
+	}
+	return report, nil
+}
+
+// StartupCheckMode indica qué hacer al arrancar si Moodle no responde o le faltan funciones.
+type StartupCheckMode string
+
+const (
+	StartupCheckStrict   StartupCheckMode = "strict"   // No arranca
+	StartupCheckDegraded StartupCheckMode = "degraded" // Arranca y registra qué funciones faltan
+	StartupCheckOff      StartupCheckMode = "off"      // No comprueba nada
+)
+
+// StartupCheckModeFromEnv lee MOODLE_STARTUP_CHECK (strict | degraded | off, por defecto degraded).
+func StartupCheckModeFromEnv() StartupCheckMode {
+	switch raw := strings.ToLower(os.Getenv("MOODLE_STARTUP_CHECK")); raw {
+	case "", string(StartupCheckDegraded):
+		return StartupCheckDegraded
+	case string(StartupCheckStrict):
+		return StartupCheckStrict
+	case string(StartupCheckOff):
+		return StartupCheckOff
+	default:
+		log.Printf("⚠️ Valor inválido para MOODLE_STARTUP_CHECK (%q). Usando degraded.", raw)
+		return StartupCheckDegraded
+	}
+}
+
+// StartupCheck comprueba al arrancar que MOODLE_URL y MOODLE_TOKEN funcionan y que el token tiene
+// habilitadas todas las funciones que usa la API. Registra la versión de Moodle y las funciones que
+// faltan. Sólo devuelve error en modo strict, cuando la API no debería arrancar.
+func StartupCheck(ctx context.Context, c *Client, mode StartupCheckMode) error {
+	if mode == StartupCheckOff {
+		log.Println("Comprobación de Moodle al arrancar desactivada (MOODLE_STARTUP_CHECK=off).")
+		return nil
+	}
+
+	report, err := CheckSite(ctx, c)
+	if err != nil {
+		if mode == StartupCheckStrict {
+			return fmt.Errorf("no se pudo verificar la conexión con Moodle: %w", err)
+		}
+		log.Printf("⚠️ No se pudo verificar la conexión con Moodle (%v). Se arranca sin comprobar funciones.", err)
+		return nil
+	}
+
+	log.Printf("✅ Moodle %s (versión %s) en '%s' como '%s': %d funciones habilitadas.",
+		report.Release, report.Version, report.SiteName, report.Username, report.FunctionCount)
+	if len(report.MissingOptional) > 0 {
+		log.Printf("⚠️ Funciones opcionales no habilitadas (esas operaciones responderán 501): %s",
+			strings.Join(report.MissingOptional, ", "))
+	}
+	if !report.Degraded() {
+		return nil
+	}
+
+	missing := strings.Join(report.MissingRequired, ", ")
+	if mode == StartupCheckStrict {
+		return fmt.Errorf("faltan funciones imprescindibles en el servicio web de Moodle: %s", missing)
+	}
+	log.Printf("⚠️ MODO DEGRADADO: faltan funciones imprescindibles en el servicio web de Moodle: %s. "+
+		"Añádelas al servicio externo del token; mientras tanto las llamadas a ellas fallarán.", missing)
+	return nil
+}
@@ -1,16 +1,33 @@
 package moodle
 
+// Cada función del WebService se describe con un struct de parámetros (xxxParams) cuyos campos,
+// mediante sus etiquetas json, se aplanan con EncodeParams al formato key[i][campo] de Moodle.
+// Para añadir una función nueva basta con declarar aquí sus structs de petición y de respuesta.
+
 // Estructura para crear un Usuario en Moodle (core_user_create_users)
 type UserRequest struct {
 	Username  string `json:"username"` // Debe ser único (ej: matrícula, email)
-	Password  string `json:"password"`
 	Firstname string `json:"firstname"`
 	Lastname  string `json:"lastname"`
 	Email     string `json:"email"`
 
 	// Opcionales
-	IDNumber string `json:"idnumber,omitempty"` // ID externo (ej: ID de empleado o alumno)
-	// Auth        string `json:"auth,omitempty"`     // Método de autenticación (ej: 'manual')
+	IDNumber       string           `json:"idnumber,omitempty"`       // ID externo (ej: ID de empleado o alumno)
+	Auth           string           `json:"auth,omitempty"`           // Método de autenticación (manual por defecto, ldap, oauth2, nologin...)
+	Password       string           `json:"password,omitempty"`       // Requerida con autenticación manual salvo con CreatePassword
+	CreatePassword int              `json:"createpassword,omitempty"` // 1: Moodle genera la contraseña y la envía por correo
+	Preferences    []UserPreference `json:"preferences,omitempty"`
+}
+
+// UserPreference es una preferencia de usuario de core_user_create_users (ej: auth_forcepasswordchange).
+type UserPreference struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// CreateUsersParams son los parámetros de core_user_create_users.
+type CreateUsersParams struct {
+	Users []UserRequest `json:"users"`
 }
 
 // Estructura para actualizar un Usuario en Moodle (core_user_update_users)
@@ -22,6 +39,12 @@ type UserUpdateRequest struct {
 	Lastname  string `json:"lastname,omitempty"`  // Nuevo apellido
 	Email     string `json:"email,omitempty"`     // Nuevo email
 	IDNumber  string `json:"idnumber,omitempty"`  // Nuevo ID externo
+	Suspended *int   `json:"suspended,omitempty"` // 1: suspendido, 0: activo (nil: sin cambios)
+}
+
+// UpdateUsersParams son los parámetros de core_user_update_users.
+type UpdateUsersParams struct {
+	Users []UserUpdateRequest `json:"users"`
 }
 
 // Estructura de respuesta después de crear un usuario
@@ -35,7 +58,29 @@ type EnrolmentRequest struct {
 	RoleID   int  `json:"roleid"`   // 5: Estudiante, 3: Profesor
 	UserID   uint `json:"userid"`   // ID de Moodle del Usuario
 	CourseID uint `json:"courseid"` // ID de Moodle del Curso (Asignatura)
-	// Timestart y Timeend (Opcionales para definir un periodo de matrícula)
+
+	// Opcionales: periodo de matrícula (timestamps UNIX) y suspensión (1: suspendida, 0: activa).
+	// Al volver a matricular a alguien ya matriculado Moodle actualiza estos valores.
+	Timestart *int64 `json:"timestart,omitempty"`
+	Timeend   *int64 `json:"timeend,omitempty"`
+	Suspend   *int   `json:"suspend,omitempty"`
+}
+
+// EnrolUsersParams son los parámetros de enrol_manual_enrol_users.
+type EnrolUsersParams struct {
+	Enrolments []EnrolmentRequest `json:"enrolments"`
+}
+
+// UnenrolmentRequest describe una baja manual (enrol_manual_unenrol_users).
+type UnenrolmentRequest struct {
+	UserID   uint `json:"userid"`           // ID de Moodle del Usuario
+	CourseID uint `json:"courseid"`         // ID de Moodle del Curso (Asignatura)
+	RoleID   int  `json:"roleid,omitempty"` // Opcional: solo retira este rol
+}
+
+// UnenrolUsersParams son los parámetros de enrol_manual_unenrol_users.
+type UnenrolUsersParams struct {
+	Enrolments []UnenrolmentRequest `json:"enrolments"`
 }
 
 // CategoryRequest representa la estructura esperada por core_course_create_categories.
@@ -47,6 +92,11 @@ type CategoryRequest struct {
 	Description string `json:"description,omitempty"`
 }
 
+// CreateCategoriesParams son los parámetros de core_course_create_categories.
+type CreateCategoriesParams struct {
+	Categories []CategoryRequest `json:"categories"`
+}
+
 // CategoryUpdateRequest para actualizar categorías en Moodle
 type CategoryUpdateRequest struct {
 	ID          uint   `json:"id"`                    // ID de Moodle (requerido)
@@ -55,6 +105,11 @@ type CategoryUpdateRequest struct {
 	Description string `json:"description,omitempty"` // Nueva descripción
 }
 
+// UpdateCategoriesParams son los parámetros de core_course_update_categories.
+type UpdateCategoriesParams struct {
+	Categories []CategoryUpdateRequest `json:"categories"`
+}
+
 // CategoryResponse representa la estructura que Moodle devuelve al crear una categoría.
 type CategoryResponse struct {
 	ID       uint   `json:"id"` // El ID de Moodle que necesitamos guardar
@@ -71,9 +126,17 @@ type CourseRequest struct {
 	Categoryid int    `json:"categoryid"` // Este será el ID_Moodle del Cuatrimestre padre
 
 	// Opcionales/Recomendados
-	IDNumber string `json:"idnumber,omitempty"` // ID Externo (para evitar duplicados)
-	Summary  string `json:"summary,omitempty"`  // Resumen/Descripción
-	Format   string `json:"format,omitempty"`   // 'topics', 'weeks', etc.
+	IDNumber  string `json:"idnumber,omitempty"`  // ID Externo (para evitar duplicados)
+	Summary   string `json:"summary,omitempty"`   // Resumen/Descripción
+	Format    string `json:"format,omitempty"`    // 'topics', 'weeks', etc.
+	Visible   int    `json:"visible"`             // 1: visible, 0: oculto
+	StartDate int64  `json:"startdate,omitempty"` // Inicio del curso (UNIX timestamp)
+	EndDate   int64  `json:"enddate,omitempty"`   // Fin del curso (UNIX timestamp)
+}
+
+// CreateCoursesParams son los parámetros de core_course_create_courses.
+type CreateCoursesParams struct {
+	Courses []CourseRequest `json:"courses"`
 }
 
 // CourseUpdateRequest para actualizar cursos en Moodle
@@ -83,6 +146,28 @@ type CourseUpdateRequest struct {
 	Shortname string `json:"shortname,omitempty"` // Nuevo nombre corto
 	IDNumber  string `json:"idnumber,omitempty"`  // Nuevo ID externo
 	Summary   string `json:"summary,omitempty"`   // Nuevo resumen
+	Format    string `json:"format,omitempty"`    // Nuevo formato
+	Visible   *int   `json:"visible,omitempty"`   // 1: visible, 0: oculto (nil no lo cambia)
+	StartDate *int64 `json:"startdate,omitempty"` // Nuevo inicio (UNIX timestamp; nil no lo cambia, 0 lo quita)
+	EndDate   *int64 `json:"enddate,omitempty"`   // Nuevo fin (UNIX timestamp; nil no lo cambia, 0 lo quita)
+}
+
+// UpdateCoursesParams son los parámetros de core_course_update_courses.
+type UpdateCoursesParams struct {
+	Courses []CourseUpdateRequest `json:"courses"`
+}
+
+// Warning es el aviso que Moodle incluye en las respuestas que no fallan por completo.
+type Warning struct {
+	Item        string `json:"item"`
+	ItemID      int    `json:"itemid"`
+	WarningCode string `json:"warningcode"`
+	Message     string `json:"message"`
+}
+
+// CourseUpdateResponse es la respuesta de core_course_update_courses (solo avisos).
+type CourseUpdateResponse struct {
+	Warnings []Warning `json:"warnings"`
 }
 
 // Estructura de respuesta después de crear un curso
@@ -103,11 +188,38 @@ type GroupRequest struct {
 	Participation     int    `json:"participation,omitempty"` // 👈 NUEVO: 1=Habilitado
 }
 
+// CreateGroupsParams son los parámetros de core_group_create_groups.
+type CreateGroupsParams struct {
+	Groups []GroupRequest `json:"groups"`
+}
+
+// GroupResponse es un grupo tal como lo devuelven core_group_create_groups y core_group_get_course_groups.
 type GroupResponse struct {
-	ID       int    `json:"id"`
-	Name     string `json:"name"`
-	CourseID int    `json:"courseid"`
-	IDNumber string `json:"idnumber"`
+	ID            int    `json:"id"`
+	Name          string `json:"name"`
+	CourseID      int    `json:"courseid"`
+	IDNumber      string `json:"idnumber"`
+	Description   string `json:"description,omitempty"`
+	EnrolmentKey  string `json:"enrolmentkey,omitempty"`
+	Visibility    int    `json:"visibility,omitempty"`
+	Participation int    `json:"participation,omitempty"`
+}
+
+// GroupUpdateRequest para actualizar grupos con core_group_update_groups (Moodle 4.2+).
+// Todos los campos se envían: la BD local es la fuente de verdad, así que un valor vacío borra el de Moodle.
+type GroupUpdateRequest struct {
+	ID                uint   `json:"id"` // ID de Moodle (requerido)
+	Name              string `json:"name"`
+	Description       string `json:"description"`
+	DescriptionFormat int    `json:"descriptionformat"`
+	EnrolmentKey      string `json:"enrolmentkey"`
+	IDNumber          string `json:"idnumber"`
+	Visibility        int    `json:"visibility"` // 0: visible a todos, 1: solo miembros, 2: solo ver pertenencia propia, 3: oculto
+}
+
+// UpdateGroupsParams son los parámetros de core_group_update_groups.
+type UpdateGroupsParams struct {
+	Groups []GroupUpdateRequest `json:"groups"`
 }
 
 // Definiciones para core_group_add_group_members
@@ -115,3 +227,331 @@ type GroupMemberRequest struct {
 	GroupID int `json:"groupid"`
 	UserID  int `json:"userid"`
 }
+
+// AddGroupMembersParams son los parámetros de core_group_add_group_members.
+type AddGroupMembersParams struct {
+	Members []GroupMemberRequest `json:"members"`
+}
+
+// DeleteGroupMembersParams son los parámetros de core_group_delete_group_members.
+type DeleteGroupMembersParams struct {
+	Members []GroupMemberRequest `json:"members"`
+}
+
+// --- Consultas (lectura) ---
+
+// CategoryCriteria es un criterio de búsqueda de core_course_get_categories
+// (key: id, ids, name, parent, idnumber, visible...).
+type CategoryCriteria struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// GetCategoriesParams son los parámetros de core_course_get_categories.
+type GetCategoriesParams struct {
+	Criteria         []CategoryCriteria `json:"criteria,omitempty" moodle:"nochunk"` // Los criterios se combinan con AND: nunca se parten
+	AddSubcategories *int               `json:"addsubcategories,omitempty"`          // 1 (por defecto en Moodle): incluir subcategorías
+}
+
+// Category es una categoría devuelta por core_course_get_categories.
+type Category struct {
+	ID           uint   `json:"id"`
+	Name         string `json:"name"`
+	IDNumber     string `json:"idnumber"`
+	Description  string `json:"description"`
+	Parent       uint   `json:"parent"`
+	CourseCount  int    `json:"coursecount"`
+	Visible      int    `json:"visible"`
+	Depth        int    `json:"depth"`
+	Path         string `json:"path"`
+	SortOrder    int    `json:"sortorder"`
+	TimeModified int64  `json:"timemodified"`
+}
+
+// GetCoursesByFieldParams son los parámetros de core_course_get_courses_by_field.
+// Field admite id, ids, shortname, idnumber o category; sin Field se devuelven todos los cursos.
+type GetCoursesByFieldParams struct {
+	Field string `json:"field,omitempty"`
+	Value string `json:"value,omitempty"`
+}
+
+// Course es un curso devuelto por core_course_get_courses_by_field.
+type Course struct {
+	ID         uint   `json:"id"`
+	Fullname   string `json:"fullname"`
+	Shortname  string `json:"shortname"`
+	CategoryID uint   `json:"categoryid"`
+	IDNumber   string `json:"idnumber"`
+	Summary    string `json:"summary"`
+	Format     string `json:"format"`
+	Visible    int    `json:"visible"`
+	StartDate  int64  `json:"startdate"`
+	EndDate    int64  `json:"enddate"`
+}
+
+// GetCoursesByFieldResponse es la respuesta de core_course_get_courses_by_field.
+type GetCoursesByFieldResponse struct {
+	Courses  []Course  `json:"courses"`
+	Warnings []Warning `json:"warnings"`
+}
+
+// GetUsersByFieldParams son los parámetros de core_user_get_users_by_field.
+// Field admite id, idnumber, username o email.
+type GetUsersByFieldParams struct {
+	Field  string   `json:"field"`
+	Values []string `json:"values"`
+}
+
+// User es un usuario devuelto por core_user_get_users_by_field.
+type User struct {
+	ID        uint   `json:"id"`
+	Username  string `json:"username"`
+	Firstname string `json:"firstname"`
+	Lastname  string `json:"lastname"`
+	Email     string `json:"email"`
+	IDNumber  string `json:"idnumber"`
+	Auth      string `json:"auth"`
+	Suspended bool   `json:"suspended"`
+}
+
+// UserCriteria es un criterio de búsqueda de core_user_get_users (key: id, username, email, idnumber,
+// firstname, lastname...). Moodle compara con LIKE, así que {"email", "%"} devuelve todos los usuarios.
+type UserCriteria struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// GetUsersParams son los parámetros de core_user_get_users.
+type GetUsersParams struct {
+	Criteria []UserCriteria `json:"criteria" moodle:"nochunk"` // Los criterios se combinan con AND: nunca se parten
+}
+
+// GetUsersResponse es la respuesta de core_user_get_users.
+type GetUsersResponse struct {
+	Users    []User    `json:"users"`
+	Warnings []Warning `json:"warnings"`
+}
+
+// GetCourseGroupsParams son los parámetros de core_group_get_course_groups.
+type GetCourseGroupsParams struct {
+	CourseID int `json:"courseid"`
+}
+
+// --- Eliminaciones ---
+
+// CategoryDeleteRequest describe una categoría a eliminar con core_course_delete_categories.
+type CategoryDeleteRequest struct {
+	ID        uint  `json:"id"`
+	NewParent *uint `json:"newparent,omitempty"` // Categoría a la que mover el contenido (si Recursive = 0)
+	Recursive int   `json:"recursive"`           // 1: borrar también el contenido, 0: moverlo
+}
+
+// DeleteCategoriesParams son los parámetros de core_course_delete_categories.
+type DeleteCategoriesParams struct {
+	Categories []CategoryDeleteRequest `json:"categories"`
+}
+
+// DeleteCoursesParams son los parámetros de core_course_delete_courses.
+type DeleteCoursesParams struct {
+	CourseIDs []uint `json:"courseids"`
+}
+
+// DeleteCoursesResponse es la respuesta de core_course_delete_courses (avisos por curso no eliminado).
+type DeleteCoursesResponse struct {
+	Warnings []Warning `json:"warnings"`
+}
+
+// DeleteUsersParams son los parámetros de core_user_delete_users.
+type DeleteUsersParams struct {
+	UserIDs []uint `json:"userids"`
+}
+
+// DeleteGroupsParams son los parámetros de core_group_delete_groups.
+type DeleteGroupsParams struct {
+	GroupIDs []uint `json:"groupids"`
+}
+
+// CohortTypeValue identifica una cohorte, un usuario o una categoría por tipo de clave
+// (id, idnumber, username o system) y valor, como piden las funciones de cohortes.
+type CohortTypeValue struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// CohortRequest es una cohorte a crear con core_cohort_create_cohorts.
+type CohortRequest struct {
+	CategoryType      CohortTypeValue `json:"categorytype"` // {system, ""} o {id, <categoría>}
+	Name              string          `json:"name"`
+	IDNumber          string          `json:"idnumber"`
+	Description       string          `json:"description,omitempty"`
+	DescriptionFormat int             `json:"descriptionformat"`
+	Visible           int             `json:"visible"`
+}
+
+// CreateCohortsParams son los parámetros de core_cohort_create_cohorts.
+type CreateCohortsParams struct {
+	Cohorts []CohortRequest `json:"cohorts"`
+}
+
+// Cohort es una cohorte tal como la devuelve Moodle.
+type Cohort struct {
+	ID                uint   `json:"id"`
+	Name              string `json:"name"`
+	IDNumber          string `json:"idnumber"`
+	Description       string `json:"description"`
+	DescriptionFormat int    `json:"descriptionformat"`
+	Visible           bool   `json:"visible"`
+}
+
+// CohortMemberRequest añade un usuario a una cohorte (core_cohort_add_cohort_members).
+type CohortMemberRequest struct {
+	CohortType CohortTypeValue `json:"cohorttype"`
+	UserType   CohortTypeValue `json:"usertype"`
+}
+
+// AddCohortMembersParams son los parámetros de core_cohort_add_cohort_members.
+type AddCohortMembersParams struct {
+	Members []CohortMemberRequest `json:"members"`
+}
+
+// AddCohortMembersResponse es la respuesta de core_cohort_add_cohort_members (avisos por miembro no añadido).
+type AddCohortMembersResponse struct {
+	Warnings []Warning `json:"warnings"`
+}
+
+// CohortMemberID es un miembro a quitar de una cohorte (core_cohort_delete_cohort_members).
+type CohortMemberID struct {
+	CohortID uint `json:"cohortid"`
+	UserID   uint `json:"userid"`
+}
+
+// DeleteCohortMembersParams son los parámetros de core_cohort_delete_cohort_members.
+type DeleteCohortMembersParams struct {
+	Members []CohortMemberID `json:"members"`
+}
+
+// GetCohortMembersParams son los parámetros de core_cohort_get_cohort_members.
+type GetCohortMembersParams struct {
+	CohortIDs []uint `json:"cohortids"`
+}
+
+// CohortMembers son los IDs de los miembros de una cohorte, como los devuelve core_cohort_get_cohort_members.
+type CohortMembers struct {
+	CohortID uint   `json:"cohortid"`
+	UserIDs  []uint `json:"userids"`
+}
+
+// CohortSearchContext es el contexto desde el que se buscan cohortes (1 = sistema).
+type CohortSearchContext struct {
+	ContextID int `json:"contextid"`
+}
+
+// SearchCohortsParams son los parámetros de core_cohort_search_cohorts.
+type SearchCohortsParams struct {
+	Query    string              `json:"query"`
+	Context  CohortSearchContext `json:"context"`
+	Includes string              `json:"includes"` // self | parents | all
+	LimitNum int                 `json:"limitnum,omitempty"`
+}
+
+// SearchCohortsResponse es la respuesta de core_cohort_search_cohorts.
+type SearchCohortsResponse struct {
+	Cohorts []Cohort `json:"cohorts"`
+}
+
+// GetGradeItemsParams son los parámetros de gradereport_user_get_grade_items.
+// UserID 0 devuelve las calificaciones de todos los alumnos del curso.
+type GetGradeItemsParams struct {
+	CourseID uint `json:"courseid"`
+	UserID   uint `json:"userid"`
+}
+
+// GradeItem es un elemento del libro de calificaciones con la calificación del usuario.
+// Moodle omite o envía null en graderaw y gradedategraded si el elemento aún no está calificado.
+type GradeItem struct {
+	ID                  uint     `json:"id"`
+	ItemName            string   `json:"itemname"`
+	ItemType            string   `json:"itemtype"`
+	ItemModule          string   `json:"itemmodule"`
+	IDNumber            string   `json:"idnumber"`
+	GradeRaw            *float64 `json:"graderaw"`
+	GradeDateGraded     *int64   `json:"gradedategraded"`
+	GradeFormatted      string   `json:"gradeformatted"`
+	GradeMin            float64  `json:"grademin"`
+	GradeMax            float64  `json:"grademax"`
+	PercentageFormatted string   `json:"percentageformatted"`
+	Feedback            string   `json:"feedback"`
+}
+
+// UserGrades son los elementos de calificación de un usuario en un curso.
+type UserGrades struct {
+	CourseID     uint        `json:"courseid"`
+	UserID       uint        `json:"userid"`
+	UserFullname string      `json:"userfullname"`
+	GradeItems   []GradeItem `json:"gradeitems"`
+}
+
+// GetGradeItemsResponse es la respuesta de gradereport_user_get_grade_items.
+type GetGradeItemsResponse struct {
+	UserGrades []UserGrades `json:"usergrades"`
+	Warnings   []Warning    `json:"warnings"`
+}
+
+// GetCourseCompletionStatusParams son los parámetros de core_completion_get_course_completion_status.
+type GetCourseCompletionStatusParams struct {
+	CourseID uint `json:"courseid"`
+	UserID   uint `json:"userid"`
+}
+
+// CompletionCriterion es el estado de un criterio de finalización del curso para el usuario.
+type CompletionCriterion struct {
+	Type          int    `json:"type"`
+	Title         string `json:"title"`
+	Status        string `json:"status"`
+	Complete      bool   `json:"complete"`
+	TimeCompleted *int64 `json:"timecompleted"`
+}
+
+// CourseCompletionStatus indica si el usuario completó el curso y el estado de cada criterio.
+type CourseCompletionStatus struct {
+	Completed   bool                  `json:"completed"`
+	Aggregation int                   `json:"aggregation"` // 1 = todos los criterios, 2 = cualquiera
+	Completions []CompletionCriterion `json:"completions"`
+}
+
+// GetCourseCompletionStatusResponse es la respuesta de core_completion_get_course_completion_status.
+type GetCourseCompletionStatusResponse struct {
+	CompletionStatus CourseCompletionStatus `json:"completionstatus"`
+	Warnings         []Warning              `json:"warnings"`
+}
+
+// DuplicateCourseOption es una opción de copia de core_course_duplicate_course (activities, blocks, users...).
+type DuplicateCourseOption struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// DuplicateCourseParams son los parámetros de core_course_duplicate_course.
+type DuplicateCourseParams struct {
+	CourseID   uint                    `json:"courseid"` // Curso plantilla
+	Fullname   string                  `json:"fullname"`
+	Shortname  string                  `json:"shortname"`
+	CategoryID uint                    `json:"categoryid"`
+	Visible    int                     `json:"visible"`
+	Options    []DuplicateCourseOption `json:"options,omitempty"`
+}
+
+// DuplicateCourseResponse es la respuesta de core_course_duplicate_course.
+type DuplicateCourseResponse struct {
+	ID        uint   `json:"id"`
+	Shortname string `json:"shortname"`
+}
+
+// ImportCourseParams son los parámetros de core_course_import_course: copia el contenido de
+// ImportFrom en el curso ImportTo, que ya existe.
+type ImportCourseParams struct {
+	ImportFrom    uint                    `json:"importfrom"`
+	ImportTo      uint                    `json:"importto"`
+	DeleteContent int                     `json:"deletecontent"` // 1 borra antes el contenido de ImportTo
+	Options       []DuplicateCourseOption `json:"options,omitempty"`
+}
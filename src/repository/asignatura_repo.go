@@ -14,6 +14,11 @@ func NewAsignaturaRepository(db *gorm.DB) *AsignaturaRepository {
 	return &AsignaturaRepository{DB: db}
 }
 
+// WithTx devuelve el repositorio sobre la transacción tx.
+func (r *AsignaturaRepository) WithTx(tx *gorm.DB) *AsignaturaRepository {
+	return &AsignaturaRepository{DB: tx}
+}
+
 // Create crea una nueva Asignatura en la BD local.
 func (r *AsignaturaRepository) Create(a *models.Asignatura) error {
 	return r.DB.Create(a).Error
@@ -34,6 +39,13 @@ func (r *AsignaturaRepository) GetByID(id uint) (models.Asignatura, error) {
 	return asignatura, err
 }
 
+// GetByMoodleID obtiene la Asignatura vinculada con un curso de Moodle, aunque se haya eliminado en local.
+func (r *AsignaturaRepository) GetByMoodleID(moodleID uint) (models.Asignatura, error) {
+	var asignatura models.Asignatura
+	err := r.DB.Unscoped().Preload("Cuatrimestre.ProgramaEstudio").Where("id_moodle = ?", moodleID).First(&asignatura).Error
+	return asignatura, err
+}
+
 // Update actualiza una Asignatura.
 func (r *AsignaturaRepository) Update(a *models.Asignatura) error {
 	return r.DB.Save(a).Error
@@ -51,3 +63,10 @@ func (r *AsignaturaRepository) GetUnsynced() ([]models.Asignatura, error) {
 	err := r.DB.Preload("Cuatrimestre.ProgramaEstudio").Where("id_moodle IS NULL").Find(&asignaturas).Error
 	return asignaturas, err
 }
+
+// GetByCuatrimestre obtiene las asignaturas de un cuatrimestre.
+func (r *AsignaturaRepository) GetByCuatrimestre(cuatrimestreID uint) ([]models.Asignatura, error) {
+	var asignaturas []models.Asignatura
+	err := r.DB.Where("cuatrimestre_id = ?", cuatrimestreID).Order("id").Find(&asignaturas).Error
+	return asignaturas, err
+}
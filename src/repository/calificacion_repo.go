@@ -0,0 +1,43 @@
+package repository
+
+import (
+	"api_concurrencia/src/models"
+
+	"gorm.io/gorm"
+)
+
+type CalificacionRepository struct {
+	DB *gorm.DB
+}
+
+func NewCalificacionRepository(db *gorm.DB) *CalificacionRepository {
+	return &CalificacionRepository{DB: db}
+}
+
+// ReplaceForMatricula sustituye las calificaciones guardadas de una matrícula por las importadas.
+// Así desaparecen también los elementos que se borraron del libro de calificaciones en Moodle.
+func (r *CalificacionRepository) ReplaceForMatricula(matriculaID uint, calificaciones []models.Calificacion) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("matricula_id = ?", matriculaID).Delete(&models.Calificacion{}).Error; err != nil {
+			return err
+		}
+		if len(calificaciones) == 0 {
+			return nil
+		}
+		return tx.Create(&calificaciones).Error
+	})
+}
+
+// GetByUsuario obtiene las calificaciones de un usuario en todas sus asignaturas.
+func (r *CalificacionRepository) GetByUsuario(usuarioID uint) ([]models.Calificacion, error) {
+	var calificaciones []models.Calificacion
+	err := r.DB.Where("usuario_id = ?", usuarioID).Order("asignatura_id, total_curso, id").Find(&calificaciones).Error
+	return calificaciones, err
+}
+
+// GetByAsignatura obtiene las calificaciones de todos los alumnos de una asignatura.
+func (r *CalificacionRepository) GetByAsignatura(asignaturaID uint) ([]models.Calificacion, error) {
+	var calificaciones []models.Calificacion
+	err := r.DB.Where("asignatura_id = ?", asignaturaID).Order("usuario_id, total_curso, id").Find(&calificaciones).Error
+	return calificaciones, err
+}
@@ -14,6 +14,11 @@ func NewCuatrimestreRepository(db *gorm.DB) *CuatrimestreRepository {
 	return &CuatrimestreRepository{DB: db}
 }
 
+// WithTx devuelve el repositorio sobre la transacción tx.
+func (r *CuatrimestreRepository) WithTx(tx *gorm.DB) *CuatrimestreRepository {
+	return &CuatrimestreRepository{DB: tx}
+}
+
 // Create crea un nuevo Cuatrimestre en la BD local.
 func (r *CuatrimestreRepository) Create(c *models.Cuatrimestre) error {
 	return r.DB.Create(c).Error
@@ -51,3 +56,22 @@ func (r *CuatrimestreRepository) GetUnsynced() ([]models.Cuatrimestre, error) {
 	err := r.DB.Preload("ProgramaEstudio").Where("id_moodle IS NULL").Find(&cuatrimestres).Error
 	return cuatrimestres, err
 }
+
+// GetByProgramaEstudio obtiene los cuatrimestres de un programa de estudio.
+func (r *CuatrimestreRepository) GetByProgramaEstudio(programaID uint) ([]models.Cuatrimestre, error) {
+	var cuatrimestres []models.Cuatrimestre
+	err := r.DB.Where("programa_estudio_id = ?", programaID).Order("id").Find(&cuatrimestres).Error
+	return cuatrimestres, err
+}
+
+// GetAlumnos obtiene los usuarios matriculados en alguna asignatura del cuatrimestre con alguno de los
+// roles de alumno indicados (IDs de rol de Moodle).
+func (r *CuatrimestreRepository) GetAlumnos(cuatrimestreID uint, rolesAlumno []uint) ([]models.Usuario, error) {
+	var usuarios []models.Usuario
+	matriculados := r.DB.Model(&models.Matricula{}).
+		Select("matriculas.usuario_id").
+		Joins("JOIN asignaturas a ON a.id = matriculas.asignatura_id AND a.deleted_at IS NULL").
+		Where("a.cuatrimestre_id = ? AND matriculas.role_id IN ?", cuatrimestreID, rolesAlumno)
+	err := r.DB.Where("id IN (?)", matriculados).Find(&usuarios).Error
+	return usuarios, err
+}
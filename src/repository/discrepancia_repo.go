@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"api_concurrencia/src/models"
+
+	"gorm.io/gorm"
+)
+
+type DiscrepanciaRepository struct {
+	DB *gorm.DB
+}
+
+func NewDiscrepanciaRepository(db *gorm.DB) *DiscrepanciaRepository {
+	return &DiscrepanciaRepository{DB: db}
+}
+
+// WithTx devuelve el repositorio sobre la transacción tx.
+func (r *DiscrepanciaRepository) WithTx(tx *gorm.DB) *DiscrepanciaRepository {
+	return &DiscrepanciaRepository{DB: tx}
+}
+
+// CreateAll guarda las discrepancias de una conciliación.
+func (r *DiscrepanciaRepository) CreateAll(discrepancias []models.Discrepancia) error {
+	if len(discrepancias) == 0 {
+		return nil
+	}
+	return r.DB.CreateInBatches(discrepancias, 100).Error
+}
+
+// GetByID obtiene una discrepancia.
+func (r *DiscrepanciaRepository) GetByID(id uint) (models.Discrepancia, error) {
+	var d models.Discrepancia
+	err := r.DB.First(&d, id).Error
+	return d, err
+}
+
+// Update guarda una discrepancia.
+func (r *DiscrepanciaRepository) Update(d *models.Discrepancia) error {
+	return r.DB.Save(d).Error
+}
+
+// GetAll obtiene las discrepancias en orden de detección. Los filtros vacíos (o jobID 0) no filtran;
+// limit <= 0 no limita.
+func (r *DiscrepanciaRepository) GetAll(jobID uint, entidad, estado string, limit int) ([]models.Discrepancia, error) {
+	var discrepancias []models.Discrepancia
+	query := r.DB.Order("id")
+	if jobID != 0 {
+		query = query.Where("job_id = ?", jobID)
+	}
+	if entidad != "" {
+		query = query.Where("entidad = ?", entidad)
+	}
+	if estado != "" {
+		query = query.Where("estado = ?", estado)
+	}
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	err := query.Find(&discrepancias).Error
+	return discrepancias, err
+}
+
+// MarkOpenObsolete marca como obsoletas las discrepancias abiertas de conciliaciones anteriores a jobID.
+func (r *DiscrepanciaRepository) MarkOpenObsolete(jobID uint) (int64, error) {
+	res := r.DB.Model(&models.Discrepancia{}).
+		Where("estado = ? AND job_id <> ?", models.DiscrepanciaAbierta, jobID).
+		Update("estado", models.DiscrepanciaObsoleta)
+	return res.RowsAffected, res.Error
+}
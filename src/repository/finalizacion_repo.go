@@ -0,0 +1,55 @@
+package repository
+
+import (
+	"api_concurrencia/src/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type FinalizacionRepository struct {
+	DB *gorm.DB
+}
+
+func NewFinalizacionRepository(db *gorm.DB) *FinalizacionRepository {
+	return &FinalizacionRepository{DB: db}
+}
+
+// Upsert crea o actualiza el estado de finalización de una matrícula.
+func (r *FinalizacionRepository) Upsert(f *models.Finalizacion) error {
+	return r.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "matricula_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"usuario_id", "asignatura_id", "completada", "fecha_finalizacion", "criterios_totales", "criterios_completados", "importada_en"}),
+	}).Create(f).Error
+}
+
+// GetByMatricula obtiene el estado de finalización guardado de una matrícula.
+func (r *FinalizacionRepository) GetByMatricula(matriculaID uint) (models.Finalizacion, error) {
+	var finalizacion models.Finalizacion
+	err := r.DB.Where("matricula_id = ?", matriculaID).First(&finalizacion).Error
+	return finalizacion, err
+}
+
+// GetByUsuario obtiene el estado de finalización de un usuario en todas sus asignaturas.
+func (r *FinalizacionRepository) GetByUsuario(usuarioID uint) ([]models.Finalizacion, error) {
+	var finalizaciones []models.Finalizacion
+	err := r.DB.Where("usuario_id = ?", usuarioID).Order("asignatura_id").Find(&finalizaciones).Error
+	return finalizaciones, err
+}
+
+// GetByAsignatura obtiene el estado de finalización de todos los alumnos de una asignatura.
+func (r *FinalizacionRepository) GetByAsignatura(asignaturaID uint) ([]models.Finalizacion, error) {
+	var finalizaciones []models.Finalizacion
+	err := r.DB.Where("asignatura_id = ?", asignaturaID).Order("usuario_id").Find(&finalizaciones).Error
+	return finalizaciones, err
+}
+
+// GetByCuatrimestre obtiene el estado de finalización de todas las asignaturas del cuatrimestre.
+func (r *FinalizacionRepository) GetByCuatrimestre(cuatrimestreID uint) ([]models.Finalizacion, error) {
+	var finalizaciones []models.Finalizacion
+	err := r.DB.Joins("JOIN asignaturas a ON a.id = finalizacions.asignatura_id AND a.deleted_at IS NULL").
+		Where("a.cuatrimestre_id = ?", cuatrimestreID).
+		Order("finalizacions.usuario_id, finalizacions.asignatura_id").
+		Find(&finalizaciones).Error
+	return finalizaciones, err
+}
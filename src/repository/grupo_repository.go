@@ -14,6 +14,11 @@ func NewGrupoRepository(db *gorm.DB) *GrupoRepository {
 	return &GrupoRepository{DB: db}
 }
 
+// WithTx devuelve el repositorio sobre la transacción tx.
+func (r *GrupoRepository) WithTx(tx *gorm.DB) *GrupoRepository {
+	return &GrupoRepository{DB: tx}
+}
+
 // Create crea un nuevo Grupo en la BD local.
 func (r *GrupoRepository) Create(g *models.Grupo) error {
 	return r.DB.Create(g).Error
@@ -60,6 +65,18 @@ func (r *GrupoRepository) AddMembers(grupoID uint, usuarioIDs []uint) error {
 	return r.DB.Model(&grupo).Association("Usuarios").Append(usuarios)
 }
 
+// RemoveMembers quita usuarios de un grupo (solo borra filas de la tabla de unión 'usuario_grupos').
+func (r *GrupoRepository) RemoveMembers(grupoID uint, usuarioIDs []uint) error {
+	grupo := models.Grupo{}
+	grupo.ID = grupoID
+
+	usuarios := make([]models.Usuario, len(usuarioIDs))
+	for i, id := range usuarioIDs {
+		usuarios[i].ID = id
+	}
+	return r.DB.Model(&grupo).Association("Usuarios").Delete(usuarios)
+}
+
 // GetMembers obtiene todos los usuarios de un grupo, incluyendo sus IDs de Moodle.
 func (r *GrupoRepository) GetMembers(grupoID uint) ([]models.Usuario, error) {
 	var grupo models.Grupo
@@ -84,3 +101,10 @@ func (r *GrupoRepository) GetByCourseID(courseID uint) (models.Grupo, error) {
 	err := r.DB.Where("course_id = ?", courseID).First(&grupo).Error
 	return grupo, err
 }
+
+// GetAllByCourseID obtiene todos los grupos de una asignatura.
+func (r *GrupoRepository) GetAllByCourseID(courseID uint) ([]models.Grupo, error) {
+	var grupos []models.Grupo
+	err := r.DB.Where("course_id = ?", courseID).Order("id").Find(&grupos).Error
+	return grupos, err
+}
@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"api_concurrencia/src/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type OutboxRepository struct {
+	DB *gorm.DB
+}
+
+func NewOutboxRepository(db *gorm.DB) *OutboxRepository {
+	return &OutboxRepository{DB: db}
+}
+
+// WithTx devuelve el repositorio sobre la transacción tx.
+func (r *OutboxRepository) WithTx(tx *gorm.DB) *OutboxRepository {
+	return &OutboxRepository{DB: tx}
+}
+
+// Add registra un evento.
+func (r *OutboxRepository) Add(e *models.OutboxEvent) error {
+	return r.DB.Create(e).Error
+}
+
+// Update guarda el estado de un evento.
+func (r *OutboxRepository) Update(e *models.OutboxEvent) error {
+	return r.DB.Save(e).Error
+}
+
+// GetByID obtiene un evento.
+func (r *OutboxRepository) GetByID(id uint) (models.OutboxEvent, error) {
+	var e models.OutboxEvent
+	err := r.DB.First(&e, id).Error
+	return e, err
+}
+
+// GetAll obtiene los eventos más recientes primero. estado vacío no filtra; limit <= 0 no limita.
+func (r *OutboxRepository) GetAll(estado string, limit int) ([]models.OutboxEvent, error) {
+	var events []models.OutboxEvent
+	query := r.DB.Order("id DESC")
+	if estado != "" {
+		query = query.Where("estado = ?", estado)
+	}
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	err := query.Find(&events).Error
+	return events, err
+}
+
+// GetDeliverable obtiene, en orden, los eventos que se pueden entregar ya: el primero sin enviar de cada
+// registro, si está pendiente y le toca reintentar. Un evento fallido bloquea los posteriores de su registro.
+func (r *OutboxRepository) GetDeliverable(now time.Time, limit int) ([]models.OutboxEvent, error) {
+	var events []models.OutboxEvent
+	primeros := r.DB.Model(&models.OutboxEvent{}).
+		Select("MIN(id)").
+		Where("estado IN ?", []string{models.OutboxPendiente, models.OutboxFallido}).
+		Group("entidad, entidad_id")
+	err := r.DB.Where("id IN (?)", primeros).
+		Where("estado = ? AND siguiente_intento <= ?", models.OutboxPendiente, now).
+		Order("id").
+		Limit(limit).
+		Find(&events).Error
+	return events, err
+}
@@ -13,6 +13,11 @@ func NewProgramaEstudioRepository(db *gorm.DB) *ProgramaEstudioRepository {
 	return &ProgramaEstudioRepository{DB: db}
 }
 
+// WithTx devuelve el repositorio sobre la transacción tx.
+func (r *ProgramaEstudioRepository) WithTx(tx *gorm.DB) *ProgramaEstudioRepository {
+	return &ProgramaEstudioRepository{DB: tx}
+}
+
 // Create crea un nuevo Programa de Estudio en la BD local.
 func (r *ProgramaEstudioRepository) Create(pe *models.ProgramaEstudio) error {
 	return r.DB.Create(pe).Error
@@ -40,4 +45,17 @@ func (r *ProgramaEstudioRepository) Update(pe *models.ProgramaEstudio) error {
 // Delete elimina un Programa de Estudio de la BD local.
 func (r *ProgramaEstudioRepository) Delete(id uint) error {
 	return r.DB.Delete(&models.ProgramaEstudio{}, id).Error
-}
\ No newline at end of file
+}
+
+// GetAlumnos obtiene los usuarios matriculados en alguna asignatura de los cuatrimestres del programa
+// con alguno de los roles de alumno indicados (IDs de rol de Moodle).
+func (r *ProgramaEstudioRepository) GetAlumnos(programaID uint, rolesAlumno []uint) ([]models.Usuario, error) {
+	var usuarios []models.Usuario
+	matriculados := r.DB.Model(&models.Matricula{}).
+		Select("matriculas.usuario_id").
+		Joins("JOIN asignaturas a ON a.id = matriculas.asignatura_id AND a.deleted_at IS NULL").
+		Joins("JOIN cuatrimestres c ON c.id = a.cuatrimestre_id AND c.deleted_at IS NULL").
+		Where("c.programa_estudio_id = ? AND matriculas.role_id IN ?", programaID, rolesAlumno)
+	err := r.DB.Where("id IN (?)", matriculados).Find(&usuarios).Error
+	return usuarios, err
+}
@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"api_concurrencia/src/models"
+
+	"gorm.io/gorm"
+)
+
+type RolMoodleRepository struct {
+	DB *gorm.DB
+}
+
+func NewRolMoodleRepository(db *gorm.DB) *RolMoodleRepository {
+	return &RolMoodleRepository{DB: db}
+}
+
+// Create crea una nueva correspondencia de rol.
+func (r *RolMoodleRepository) Create(rol *models.RolMoodle) error {
+	return r.DB.Create(rol).Error
+}
+
+// GetAll obtiene todas las correspondencias de rol.
+func (r *RolMoodleRepository) GetAll() ([]models.RolMoodle, error) {
+	var roles []models.RolMoodle
+	err := r.DB.Order("id").Find(&roles).Error
+	return roles, err
+}
+
+// GetByID obtiene una correspondencia por ID local.
+func (r *RolMoodleRepository) GetByID(id uint) (models.RolMoodle, error) {
+	var rol models.RolMoodle
+	err := r.DB.First(&rol, id).Error
+	return rol, err
+}
+
+// GetByNombre obtiene la correspondencia de un rol local.
+func (r *RolMoodleRepository) GetByNombre(nombre string) (models.RolMoodle, error) {
+	var rol models.RolMoodle
+	err := r.DB.Where("nombre = ?", nombre).First(&rol).Error
+	return rol, err
+}
+
+// Update guarda los cambios de una correspondencia.
+func (r *RolMoodleRepository) Update(rol *models.RolMoodle) error {
+	return r.DB.Save(rol).Error
+}
+
+// Delete elimina una correspondencia.
+func (r *RolMoodleRepository) Delete(id uint) error {
+	return r.DB.Delete(&models.RolMoodle{}, id).Error
+}
+
+// CountUsuarios cuenta los usuarios cuyo Rol local es nombre.
+func (r *RolMoodleRepository) CountUsuarios(nombre string) (int64, error) {
+	var count int64
+	err := r.DB.Model(&models.Usuario{}).Where("rol = ?", nombre).Count(&count).Error
+	return count, err
+}
@@ -0,0 +1,65 @@
+package repository
+
+import (
+	"api_concurrencia/src/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type SyncJobRepository struct {
+	DB *gorm.DB
+}
+
+func NewSyncJobRepository(db *gorm.DB) *SyncJobRepository {
+	return &SyncJobRepository{DB: db}
+}
+
+// Create registra un trabajo nuevo.
+func (r *SyncJobRepository) Create(job *models.SyncJob) error {
+	return r.DB.Omit(clause.Associations).Create(job).Error
+}
+
+// Update guarda el estado y los contadores del trabajo (los errores se añaden con AddError).
+func (r *SyncJobRepository) Update(job *models.SyncJob) error {
+	return r.DB.Omit(clause.Associations).Save(job).Error
+}
+
+// AddError guarda el error de un registro del trabajo.
+func (r *SyncJobRepository) AddError(e *models.SyncJobError) error {
+	return r.DB.Create(e).Error
+}
+
+// GetByID obtiene un trabajo con sus errores por registro.
+func (r *SyncJobRepository) GetByID(id uint) (models.SyncJob, error) {
+	var job models.SyncJob
+	err := r.DB.Preload("Detalle", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).First(&job, id).Error
+	return job, err
+}
+
+// GetAll obtiene los trabajos más recientes primero, sin el detalle de errores. tipo y estado
+// vacíos no filtran; limit <= 0 no limita.
+func (r *SyncJobRepository) GetAll(tipo, estado string, limit int) ([]models.SyncJob, error) {
+	var jobs []models.SyncJob
+	query := r.DB.Order("id DESC")
+	if tipo != "" {
+		query = query.Where("tipo = ?", tipo)
+	}
+	if estado != "" {
+		query = query.Where("estado = ?", estado)
+	}
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	err := query.Find(&jobs).Error
+	return jobs, err
+}
+
+// FailUnfinished marca como fallidos los trabajos que quedaron pendientes o en curso, por ejemplo
+// porque la API se detuvo mientras se ejecutaban. Devuelve cuántos se marcaron.
+func (r *SyncJobRepository) FailUnfinished(mensaje string) (int64, error) {
+	result := r.DB.Model(&models.SyncJob{}).
+		Where("estado IN ?", []string{models.SyncJobPendiente, models.SyncJobEnCurso}).
+		Updates(map[string]interface{}{"estado": models.SyncJobFallido, "mensaje": mensaje, "finalizado_en": gorm.Expr("CURRENT_TIMESTAMP")})
+	return result.RowsAffected, result.Error
+}
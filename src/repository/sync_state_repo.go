@@ -0,0 +1,19 @@
+package repository
+
+import (
+	"gorm.io/gorm"
+)
+
+// SyncStateRepository consulta cualquier modelo que embeba models.SyncState por su estado de sincronización.
+type SyncStateRepository struct {
+	DB *gorm.DB
+}
+
+func NewSyncStateRepository(db *gorm.DB) *SyncStateRepository {
+	return &SyncStateRepository{DB: db}
+}
+
+// GetByStatus carga en dest (puntero a un slice de modelos) los registros con el estado indicado.
+func (r *SyncStateRepository) GetByStatus(estado string, dest interface{}) error {
+	return r.DB.Where("sync_status = ?", estado).Order("id").Find(dest).Error
+}
@@ -14,6 +14,11 @@ func NewUsuarioRepository(db *gorm.DB) *UsuarioRepository {
 	return &UsuarioRepository{DB: db}
 }
 
+// WithTx devuelve el repositorio sobre la transacción tx.
+func (r *UsuarioRepository) WithTx(tx *gorm.DB) *UsuarioRepository {
+	return &UsuarioRepository{DB: tx}
+}
+
 // Create crea un nuevo Usuario en la BD local.
 func (r *UsuarioRepository) Create(u *models.Usuario) error {
 	return r.DB.Create(u).Error
@@ -54,6 +59,91 @@ func (r *UsuarioRepository) SaveMatricula(matricula models.Matricula) error {
 	return r.DB.Create(&matricula).Error
 }
 
+// GetMatricula obtiene la matrícula local de un usuario en una asignatura.
+func (r *UsuarioRepository) GetMatricula(usuarioID, asignaturaID uint) (models.Matricula, error) {
+	var matricula models.Matricula
+	err := r.DB.Where("usuario_id = ? AND asignatura_id = ?", usuarioID, asignaturaID).First(&matricula).Error
+	return matricula, err
+}
+
+// GetMatriculaByMoodleIDs obtiene la matrícula local de un usuario en un curso por sus IDs de Moodle.
+func (r *UsuarioRepository) GetMatriculaByMoodleIDs(courseMoodleID, userMoodleID uint) (models.Matricula, error) {
+	var matricula models.Matricula
+	err := r.DB.Where("course_moodle_id = ? AND user_moodle_id = ?", courseMoodleID, userMoodleID).First(&matricula).Error
+	return matricula, err
+}
+
+// GetMatriculasByUsuario obtiene todas las matrículas locales de un usuario.
+func (r *UsuarioRepository) GetMatriculasByUsuario(usuarioID uint) ([]models.Matricula, error) {
+	var matriculas []models.Matricula
+	err := r.DB.Where("usuario_id = ?", usuarioID).Find(&matriculas).Error
+	return matriculas, err
+}
+
+// GetMatriculasByAsignatura obtiene todas las matrículas locales de una asignatura.
+func (r *UsuarioRepository) GetMatriculasByAsignatura(asignaturaID uint) ([]models.Matricula, error) {
+	var matriculas []models.Matricula
+	err := r.DB.Where("asignatura_id = ?", asignaturaID).Find(&matriculas).Error
+	return matriculas, err
+}
+
+// GetMatriculasByCuatrimestre obtiene las matrículas locales de todas las asignaturas de un cuatrimestre.
+func (r *UsuarioRepository) GetMatriculasByCuatrimestre(cuatrimestreID uint) ([]models.Matricula, error) {
+	var matriculas []models.Matricula
+	err := r.DB.Joins("JOIN asignaturas a ON a.id = matriculas.asignatura_id AND a.deleted_at IS NULL").
+		Where("a.cuatrimestre_id = ?", cuatrimestreID).
+		Find(&matriculas).Error
+	return matriculas, err
+}
+
+// CountMatriculasEnCuatrimestre cuenta las matrículas del usuario de Moodle userMoodleID con alguno de
+// los roles indicados en asignaturas del cuatrimestre.
+func (r *UsuarioRepository) CountMatriculasEnCuatrimestre(userMoodleID, cuatrimestreID uint, roles []uint) (int64, error) {
+	var n int64
+	err := r.DB.Model(&models.Matricula{}).
+		Joins("JOIN asignaturas a ON a.id = matriculas.asignatura_id AND a.deleted_at IS NULL").
+		Where("matriculas.user_moodle_id = ? AND a.cuatrimestre_id = ? AND matriculas.role_id IN ?", userMoodleID, cuatrimestreID, roles).
+		Count(&n).Error
+	return n, err
+}
+
+// CountMatriculasEnPrograma cuenta las matrículas del usuario de Moodle userMoodleID con alguno de los
+// roles indicados en asignaturas de los cuatrimestres del programa.
+func (r *UsuarioRepository) CountMatriculasEnPrograma(userMoodleID, programaID uint, roles []uint) (int64, error) {
+	var n int64
+	err := r.DB.Model(&models.Matricula{}).
+		Joins("JOIN asignaturas a ON a.id = matriculas.asignatura_id AND a.deleted_at IS NULL").
+		Joins("JOIN cuatrimestres c ON c.id = a.cuatrimestre_id AND c.deleted_at IS NULL").
+		Where("matriculas.user_moodle_id = ? AND c.programa_estudio_id = ? AND matriculas.role_id IN ?", userMoodleID, programaID, roles).
+		Count(&n).Error
+	return n, err
+}
+
+// GetAllMatriculas obtiene todas las matrículas locales.
+func (r *UsuarioRepository) GetAllMatriculas() ([]models.Matricula, error) {
+	var matriculas []models.Matricula
+	err := r.DB.Order("asignatura_id, usuario_id").Find(&matriculas).Error
+	return matriculas, err
+}
+
+// UpdateMatricula guarda los cambios de una matrícula existente.
+func (r *UsuarioRepository) UpdateMatricula(matricula *models.Matricula) error {
+	return r.DB.Save(matricula).Error
+}
+
+// DeleteMatricula elimina una matrícula de la BD local junto con la pertenencia del usuario
+// a los grupos de esa asignatura, igual que hace Moodle al dar de baja.
+func (r *UsuarioRepository) DeleteMatricula(matricula models.Matricula) error {
+	return r.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec("DELETE FROM usuario_grupos WHERE usuario_id = ? AND grupo_id IN (SELECT id FROM grupos WHERE course_id = ?)",
+			matricula.UsuarioID, matricula.AsignaturaID).Error
+		if err != nil {
+			return err
+		}
+		return tx.Delete(&models.Matricula{}, matricula.ID).Error
+	})
+}
+
 // GetAll obtiene todos los Usuarios.
 func (r *UsuarioRepository) GetAll() ([]models.Usuario, error) {
 	var usuarios []models.Usuario
@@ -4,28 +4,42 @@ import (
 	"api_concurrencia/src/models"
 	"api_concurrencia/src/moodle"
 	"api_concurrencia/src/repository"
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"strings"
+	"time"
 	"unicode/utf8"
+
+	"gorm.io/gorm"
 )
 
+// ErrSinPlantilla indica que la asignatura no tiene curso plantilla configurado.
+var ErrSinPlantilla = errors.New("sin curso plantilla")
+
 type AsignaturaService struct {
 	Repo         *repository.AsignaturaRepository
-	MoodleClient *moodle.Client
+	MoodleClient moodle.MoodleAPI
+	Outbox       *OutboxService
 }
 
-func NewAsignaturaService(repo *repository.AsignaturaRepository, moodleClient *moodle.Client) *AsignaturaService {
-	return &AsignaturaService{Repo: repo, MoodleClient: moodleClient}
+func NewAsignaturaService(repo *repository.AsignaturaRepository, moodleClient moodle.MoodleAPI, outbox *OutboxService) *AsignaturaService {
+	return &AsignaturaService{Repo: repo, MoodleClient: moodleClient, Outbox: outbox}
 }
 
-// CreateLocal crea el registro en la BD local.
+// CreateLocal crea el registro en la BD local y registra su creación en el outbox.
 func (s *AsignaturaService) CreateLocal(a *models.Asignatura) error {
 	if err := s.validateAsignatura(a); err != nil {
 		return err
 	}
-	return s.Repo.Create(a)
+	a.SyncState = models.SyncState{}
+	return s.Outbox.Record(func(tx *gorm.DB) (*models.OutboxEvent, error) {
+		if err := s.Repo.WithTx(tx).Create(a); err != nil {
+			return nil, err
+		}
+		return models.NewOutboxEvent(models.OutboxAsignatura, a.ID, models.OutboxCreate), nil
+	})
 }
 
 // GetAll recupera todas las Asignaturas.
@@ -38,7 +52,7 @@ func (s *AsignaturaService) GetByID(id uint) (models.Asignatura, error) {
 	return s.Repo.GetByID(id)
 }
 
-// UpdateLocal actualiza el registro en la BD local.
+// UpdateLocal actualiza el registro en la BD local y registra el cambio en el outbox.
 func (s *AsignaturaService) UpdateLocal(a *models.Asignatura) error {
 	if a.ID == 0 {
 		return errors.New("ID de Asignatura inválido")
@@ -46,19 +60,65 @@ func (s *AsignaturaService) UpdateLocal(a *models.Asignatura) error {
 	if err := s.validateAsignatura(a); err != nil {
 		return err
 	}
-	return s.Repo.Update(a)
+	current, err := s.Repo.GetByID(a.ID)
+	if err != nil {
+		return fmt.Errorf("asignatura no encontrada en BD local: %w", err)
+	}
+	// El estado de sincronización lo mantiene el servicio: se ignora el que venga en la petición.
+	// Si cambia de cuatrimestre, los valores por defecto del curso cambian y queda pendiente.
+	a.SyncState = current.SyncState
+	if a.CuatrimestreID == current.CuatrimestreID {
+		a.Cuatrimestre = current.Cuatrimestre
+	}
+	a.MarkDirty(asignaturaHash(*a))
+	return s.Outbox.Record(func(tx *gorm.DB) (*models.OutboxEvent, error) {
+		if err := s.Repo.WithTx(tx).Update(a); err != nil {
+			return nil, err
+		}
+		return models.NewOutboxEvent(models.OutboxAsignatura, a.ID, models.OutboxUpdate), nil
+	})
 }
 
-// DeleteLocal elimina el registro en la BD local.
-func (s *AsignaturaService) DeleteLocal(id uint) error {
+// syncFailed guarda el fallo de sincronización de la asignatura y devuelve err.
+func (s *AsignaturaService) syncFailed(a *models.Asignatura, err error) error {
+	return markSyncFailed(&a.SyncState, func() error { return s.Repo.Update(a) }, err)
+}
+
+// Delete elimina la Asignatura local y registra el borrado en el outbox. Si propagate es true y está
+// sincronizada, el despachador elimina después su curso de Moodle (con sus matrículas y grupos).
+func (s *AsignaturaService) Delete(id uint, propagate bool) error {
 	if id == 0 {
 		return errors.New("ID de Asignatura inválido")
 	}
-	return s.Repo.Delete(id)
+	asignatura, err := s.Repo.GetByID(id)
+	if err != nil {
+		return fmt.Errorf("asignatura no encontrada en BD local: %w", err)
+	}
+	return s.Outbox.Record(func(tx *gorm.DB) (*models.OutboxEvent, error) {
+		if err := s.Repo.WithTx(tx).Delete(id); err != nil {
+			return nil, err
+		}
+		return deleteEvent(models.OutboxAsignatura, id, asignatura.ID_Moodle, propagate), nil
+	})
+}
+
+// DeleteInMoodle elimina el curso de una asignatura ya eliminada localmente.
+func (s *AsignaturaService) DeleteInMoodle(ctx context.Context, moodleID uint) error {
+	var response moodle.DeleteCoursesResponse
+	err := s.MoodleClient.Call(ctx, "core_course_delete_courses", moodle.DeleteCoursesParams{CourseIDs: []uint{moodleID}}, &response)
+	if err != nil {
+		return fmt.Errorf("fallo al eliminar Curso/Asignatura en Moodle: %w", err)
+	}
+	// Moodle no falla si el curso ya no existe: solo devuelve un aviso, y en ese caso no queda nada que hacer.
+	for _, w := range response.Warnings {
+		log.Printf("⚠️ Aviso de Moodle al eliminar curso %d: [%s] %s", moodleID, w.WarningCode, w.Message)
+	}
+	log.Printf("🗑️ Asignatura eliminada de Moodle (Curso ID: %d)", moodleID)
+	return nil
 }
 
 // SyncToMoodle simula la lógica de sincronización para Asignatura (Curso).
-func (s *AsignaturaService) SyncToMoodle(id uint) error {
+func (s *AsignaturaService) SyncToMoodle(ctx context.Context, id uint) error {
 	asignatura, err := s.Repo.GetByID(id)
 	if err != nil {
 		return fmt.Errorf("asignatura no encontrada en BD local: %w", err)
@@ -67,43 +127,48 @@ func (s *AsignaturaService) SyncToMoodle(id uint) error {
 	// 0. Validación Clave: El Cuatrimestre padre debe estar sincronizado
 	// NOTA: Asegúrate que tu Repo.GetByID precarga la relación Cuatrimestre, y este precarga el ID_Moodle.
 	if asignatura.Cuatrimestre.ID_Moodle == nil { // 👈 VERIFICAMOS EL CUATRIMESTRE
-		return fmt.Errorf("error: El Cuatrimestre padre (ID: %d) no ha sido sincronizado con Moodle (ID_Moodle es nulo)", asignatura.CuatrimestreID)
+		return s.syncFailed(&asignatura, fmt.Errorf("error: El Cuatrimestre padre (ID: %d) no ha sido sincronizado con Moodle: %w", asignatura.CuatrimestreID, ErrParentNotSynced))
 	}
 
 	// Si ya tiene ID_Moodle, actualizamos en lugar de crear
 	if asignatura.ID_Moodle != nil {
 		log.Printf("Asignatura ID %d ya sincronizada (Moodle ID: %d). Actualizando en Moodle.", id, *asignatura.ID_Moodle)
-		return s.UpdateInMoodle(&asignatura)
+		return s.UpdateInMoodle(ctx, &asignatura)
 	}
 
-	// 1. Construir el array de datos para la función de Moodle
-	moodleParentID := *asignatura.Cuatrimestre.ID_Moodle
-
-	// **USAMOS EL STRUCT DE CURSO (CourseRequest)**
-	data := []moodle.CourseRequest{
-		{
-			Fullname:   asignatura.NombreCompleto, // 👈 DATOS DE LA ASIGNATURA
-			Shortname:  asignatura.NombreCorto,    // 👈 REQUERIDO: Nombre corto único
-			Categoryid: int(moodleParentID),       // 👈 ID MOODLE del Cuatrimestre padre
-			IDNumber:   safeString(asignatura.ID_Externo),
-			Summary:    safeString(asignatura.Resumen),
-		},
+	// Con plantilla, el curso se crea duplicando el curso plantilla en lugar de vacío
+	if asignatura.PlantillaMoodleID != nil {
+		return s.createFromTemplate(ctx, &asignatura)
 	}
 
+	// 1. Construir el array de datos para la función de Moodle
+	// **USAMOS EL STRUCT DE CURSO (CourseRequest)**: formato, visibilidad y fechas salen de configuracionCurso
+	data := []moodle.CourseRequest{courseRequest(asignatura)}
+
 	// 2. Ejecutar la llamada a la API de Moodle
-	var response []moodle.CourseResponse                                     // 👈 USAMOS EL STRUCT DE RESPUESTA DE CURSO
-	err = s.MoodleClient.Call("core_course_create_courses", data, &response) // 👈 USAMOS LA FUNCIÓN DE CURSOS
+	var response []moodle.CourseResponse                                                                               // 👈 USAMOS EL STRUCT DE RESPUESTA DE CURSO
+	err = s.MoodleClient.Call(ctx, "core_course_create_courses", moodle.CreateCoursesParams{Courses: data}, &response) // 👈 USAMOS LA FUNCIÓN DE CURSOS
 	if err != nil {
-		return fmt.Errorf("fallo al crear Curso/Asignatura en Moodle: %w", err)
+		if !moodle.IsDuplicate(err) {
+			return s.syncFailed(&asignatura, fmt.Errorf("fallo al crear Curso/Asignatura en Moodle: %w", err))
+		}
+		// El curso ya existe (shortname o idnumber ocupado): lo buscamos y vinculamos su ID.
+		existing, lookupErr := moodle.FindCourse(ctx, s.MoodleClient, safeString(asignatura.ID_Externo), asignatura.NombreCorto)
+		if lookupErr != nil || existing == nil {
+			return s.syncFailed(&asignatura, fmt.Errorf("el curso '%s' ya existe en Moodle y no se pudo recuperar su ID: %w", asignatura.NombreCorto, err))
+		}
+		log.Printf("⚠️ Asignatura '%s' ya existía en Moodle (Curso ID: %d). Vinculando.", asignatura.NombreCorto, existing.ID)
+		response = []moodle.CourseResponse{{ID: existing.ID, Shortname: existing.Shortname}}
 	}
 
 	// 3. Procesar la respuesta y actualizar el ID_Moodle local
 	if len(response) == 0 {
-		return fmt.Errorf("moodle no devolvió ningún Curso/Asignatura creado")
+		return s.syncFailed(&asignatura, fmt.Errorf("moodle no devolvió ningún Curso/Asignatura creado"))
 	}
 
 	moodleID := response[0].ID
 	asignatura.ID_Moodle = &moodleID
+	asignatura.MarkSynced(asignaturaHash(asignatura))
 
 	if err := s.Repo.Update(&asignatura); err != nil {
 		return fmt.Errorf("falla al actualizar ID Moodle local para Asignatura ID %d: %w", id, err)
@@ -113,6 +178,177 @@ func (s *AsignaturaService) SyncToMoodle(id uint) error {
 	return nil
 }
 
+// cursoMoodle es la configuración efectiva del curso de una asignatura.
+type cursoMoodle struct {
+	Formato   string
+	Visible   int
+	StartDate int64
+	EndDate   int64
+}
+
+// configuracionCurso combina la configuración de la asignatura con la de su cuatrimestre (que debe
+// estar precargado): lo que la asignatura no indica se toma del cuatrimestre. Por defecto es visible.
+func configuracionCurso(a models.Asignatura) cursoMoodle {
+	c := cursoMoodle{Visible: 1}
+	if a.Visible != nil && !*a.Visible {
+		c.Visible = 0
+	}
+
+	c.Formato = safeString(a.Formato)
+	if c.Formato == "" {
+		c.Formato = safeString(a.Cuatrimestre.FormatoCurso)
+	}
+	inicio, fin := a.FechaInicio, a.FechaFin
+	if inicio == nil {
+		inicio = a.Cuatrimestre.FechaInicio
+	}
+	if fin == nil {
+		fin = a.Cuatrimestre.FechaFin
+	}
+	if inicio != nil {
+		c.StartDate = inicio.Unix()
+	}
+	if fin != nil {
+		c.EndDate = fin.Unix()
+	}
+	return c
+}
+
+// optionalTimestamp devuelve nil para una fecha vacía (0), que así no cambia la del curso.
+func optionalTimestamp(t int64) *int64 {
+	if t == 0 {
+		return nil
+	}
+	return &t
+}
+
+// courseRequest construye la petición de core_course_create_courses de la asignatura.
+func courseRequest(a models.Asignatura) moodle.CourseRequest {
+	curso := configuracionCurso(a)
+	return moodle.CourseRequest{
+		Fullname:   a.NombreCompleto,               // 👈 DATOS DE LA ASIGNATURA
+		Shortname:  a.NombreCorto,                  // 👈 REQUERIDO: Nombre corto único
+		Categoryid: int(*a.Cuatrimestre.ID_Moodle), // 👈 ID MOODLE del Cuatrimestre padre
+		IDNumber:   safeString(a.ID_Externo),
+		Summary:    safeString(a.Resumen),
+		Format:     curso.Formato,
+		Visible:    curso.Visible,
+		StartDate:  curso.StartDate,
+		EndDate:    curso.EndDate,
+	}
+}
+
+// opcionesPlantilla son las opciones de copia de las plantillas: contenido sí, usuarios y su actividad no.
+var opcionesPlantilla = []moodle.DuplicateCourseOption{
+	{Name: "activities", Value: "1"},
+	{Name: "blocks", Value: "1"},
+	{Name: "filters", Value: "1"},
+	{Name: "users", Value: "0"},
+}
+
+// createFromTemplate crea el curso de la asignatura duplicando su curso plantilla y después le aplica
+// los datos que core_course_duplicate_course no admite (idnumber, resumen, fechas y, si la asignatura
+// lo indica, formato; si no, se conserva el de la plantilla).
+func (s *AsignaturaService) createFromTemplate(ctx context.Context, a *models.Asignatura) error {
+	curso := configuracionCurso(*a)
+	params := moodle.DuplicateCourseParams{
+		CourseID:   *a.PlantillaMoodleID,
+		Fullname:   a.NombreCompleto,
+		Shortname:  a.NombreCorto,
+		CategoryID: *a.Cuatrimestre.ID_Moodle,
+		Visible:    curso.Visible,
+		Options:    opcionesPlantilla,
+	}
+
+	var response moodle.DuplicateCourseResponse
+	err := s.MoodleClient.Call(ctx, "core_course_duplicate_course", params, &response)
+	if err != nil {
+		if !moodle.IsDuplicate(err) {
+			return s.syncFailed(a, fmt.Errorf("fallo al copiar el curso plantilla %d para '%s': %w", *a.PlantillaMoodleID, a.NombreCorto, err))
+		}
+		existing, lookupErr := moodle.FindCourse(ctx, s.MoodleClient, safeString(a.ID_Externo), a.NombreCorto)
+		if lookupErr != nil || existing == nil {
+			return s.syncFailed(a, fmt.Errorf("el curso '%s' ya existe en Moodle y no se pudo recuperar su ID: %w", a.NombreCorto, err))
+		}
+		log.Printf("⚠️ Asignatura '%s' ya existía en Moodle (Curso ID: %d). Vinculando sin copiar la plantilla.", a.NombreCorto, existing.ID)
+		response = moodle.DuplicateCourseResponse{ID: existing.ID, Shortname: existing.Shortname}
+	}
+
+	// El ID se guarda antes de completar el curso: si lo siguiente falla, volver a sincronizar lo actualiza.
+	moodleID := response.ID
+	a.ID_Moodle = &moodleID
+	if err := s.Repo.Update(a); err != nil {
+		return fmt.Errorf("falla al actualizar ID Moodle local para Asignatura ID %d: %w", a.ID, err)
+	}
+
+	update := moodle.CourseUpdateRequest{
+		ID:        moodleID,
+		IDNumber:  safeString(a.ID_Externo),
+		Summary:   safeString(a.Resumen),
+		Format:    safeString(a.Formato),
+		StartDate: optionalTimestamp(curso.StartDate),
+		EndDate:   optionalTimestamp(curso.EndDate),
+	}
+	var updateResponse moodle.CourseUpdateResponse
+	err = s.MoodleClient.Call(ctx, "core_course_update_courses", moodle.UpdateCoursesParams{Courses: []moodle.CourseUpdateRequest{update}}, &updateResponse)
+	if err != nil {
+		return s.syncFailed(a, fmt.Errorf("curso %d copiado de la plantilla, pero falló aplicar sus datos (se reintentará al sincronizar): %w", moodleID, err))
+	}
+	a.MarkSynced(asignaturaHash(*a))
+	if err := s.Repo.Update(a); err != nil {
+		return fmt.Errorf("curso %d creado desde la plantilla, pero falló guardar el estado de sincronización: %w", moodleID, err)
+	}
+
+	log.Printf("✅ Asignatura '%s' (ID local: %d) creada en Moodle como Curso de ID %d a partir de la plantilla %d", a.NombreCompleto, a.ID, moodleID, *a.PlantillaMoodleID)
+	return nil
+}
+
+// ImportarPlantilla copia el contenido del curso plantilla en el curso ya existente de la asignatura
+// (core_course_import_course). Con reemplazar=true se borra antes el contenido actual del curso.
+func (s *AsignaturaService) ImportarPlantilla(ctx context.Context, id uint, reemplazar bool) error {
+	asignatura, err := s.Repo.GetByID(id)
+	if err != nil {
+		return fmt.Errorf("asignatura (ID: %d) no encontrada: %w", id, err)
+	}
+	if asignatura.ID_Moodle == nil {
+		return fmt.Errorf("la asignatura '%s' no está sincronizada con Moodle (ID_Moodle local es nulo)", asignatura.NombreCompleto)
+	}
+	if asignatura.PlantillaMoodleID == nil {
+		return fmt.Errorf("%w: la asignatura '%s' no tiene curso plantilla", ErrSinPlantilla, asignatura.NombreCompleto)
+	}
+
+	deleteContent := 0
+	if reemplazar {
+		deleteContent = 1
+	}
+	params := moodle.ImportCourseParams{
+		ImportFrom:    *asignatura.PlantillaMoodleID,
+		ImportTo:      *asignatura.ID_Moodle,
+		DeleteContent: deleteContent,
+		Options:       opcionesPlantilla,
+	}
+	if err := s.MoodleClient.Call(ctx, "core_course_import_course", params, nil); err != nil {
+		return fmt.Errorf("fallo al importar la plantilla %d en el curso '%s': %w", *asignatura.PlantillaMoodleID, asignatura.NombreCorto, err)
+	}
+	log.Printf("✅ Plantilla %d importada en el curso '%s' (Moodle ID: %d, reemplazar: %t)", *asignatura.PlantillaMoodleID, asignatura.NombreCorto, *asignatura.ID_Moodle, reemplazar)
+	return nil
+}
+
+// validateCursoConfig valida el formato y las fechas de curso de una asignatura o cuatrimestre.
+func validateCursoConfig(formato *string, inicio, fin *time.Time) error {
+	if formato != nil {
+		trimmed := strings.TrimSpace(*formato)
+		if utf8.RuneCountInString(trimmed) > 21 {
+			return errors.New("el formato de curso excede el máximo de 21 caracteres")
+		}
+		*formato = trimmed
+	}
+	if inicio != nil && fin != nil && !fin.After(*inicio) {
+		return errors.New("la fecha de fin debe ser posterior a la fecha de inicio")
+	}
+	return nil
+}
+
 // validateAsignatura aplica validaciones de negocio y límites de longitud
 func (s *AsignaturaService) validateAsignatura(a *models.Asignatura) error {
 	a.NombreCompleto = strings.TrimSpace(a.NombreCompleto)
@@ -146,115 +382,165 @@ func (s *AsignaturaService) validateAsignatura(a *models.Asignatura) error {
 		trimmed := strings.TrimSpace(*a.Resumen)
 		*a.Resumen = trimmed
 	}
-	return nil
+	return validateCursoConfig(a.Formato, a.FechaInicio, a.FechaFin)
 }
 
-// UpdateInMoodle actualiza una asignatura existente en Moodle
-func (s *AsignaturaService) UpdateInMoodle(a *models.Asignatura) error {
+// courseUpdateRequest construye la actualización completa del curso de una asignatura (con el
+// cuatrimestre precargado). El ID de Moodle queda a 0 si la asignatura aún no está sincronizada.
+func courseUpdateRequest(a models.Asignatura) moodle.CourseUpdateRequest {
+	curso := configuracionCurso(a)
+	req := moodle.CourseUpdateRequest{
+		Fullname:  a.NombreCompleto,
+		Shortname: a.NombreCorto,
+		IDNumber:  safeString(a.ID_Externo),
+		Summary:   safeString(a.Resumen),
+		Format:    curso.Formato,
+		Visible:   &curso.Visible,
+		StartDate: &curso.StartDate, // 0 si no hay fecha: así se quita la que tuviera el curso
+		EndDate:   &curso.EndDate,
+	}
+	if a.ID_Moodle != nil {
+		req.ID = *a.ID_Moodle
+	}
+	return req
+}
+
+// UpdateInMoodle actualiza una asignatura existente en Moodle y guarda el resultado en su estado de sincronización.
+func (s *AsignaturaService) UpdateInMoodle(ctx context.Context, a *models.Asignatura) error {
 	if a.ID_Moodle == nil {
 		return errors.New("la asignatura no tiene ID_Moodle, no se puede actualizar")
 	}
 
-	data := []moodle.CourseUpdateRequest{
-		{
-			ID:        uint(*a.ID_Moodle),
-			Fullname:  a.NombreCompleto,
-			Shortname: a.NombreCorto,
-			IDNumber:  safeString(a.ID_Externo),
-			Summary:   safeString(a.Resumen),
-		},
+	// El cuatrimestre (para los valores por defecto) sólo viene precargado si la asignatura se leyó con GetByID
+	if a.Cuatrimestre.ID != a.CuatrimestreID {
+		if stored, err := s.Repo.GetByID(a.ID); err == nil {
+			a.Cuatrimestre = stored.Cuatrimestre
+		}
 	}
+	data := []moodle.CourseUpdateRequest{courseUpdateRequest(*a)}
 
-	var response []moodle.CourseResponse
-	err := s.MoodleClient.Call("core_course_update_courses", data, &response)
+	var response moodle.CourseUpdateResponse
+	err := s.MoodleClient.Call(ctx, "core_course_update_courses", moodle.UpdateCoursesParams{Courses: data}, &response)
 	if err != nil {
-		return fmt.Errorf("fallo al actualizar curso/asignatura en Moodle: %w", err)
+		return s.syncFailed(a, fmt.Errorf("fallo al actualizar curso/asignatura en Moodle: %w", err))
+	}
+
+	a.MarkSynced(asignaturaHash(*a))
+	if err := s.Repo.Update(a); err != nil {
+		return fmt.Errorf("asignatura actualizada en Moodle, pero falló guardar el estado de sincronización: %w", err)
 	}
 
 	log.Printf(" Asignatura '%s' (ID local: %d, Moodle ID: %d) actualizada exitosamente en Moodle", a.NombreCompleto, a.ID, *a.ID_Moodle)
 	return nil
 }
 
-// BulkSyncToMoodle sincroniza todas las asignaturas sin ID_Moodle a Moodle
-func (s *AsignaturaService) BulkSyncToMoodle() {
-	go func() {
-		log.Println(" Iniciando sincronización masiva de Asignaturas a Moodle...")
+// BulkSyncToMoodle sincroniza todas las asignaturas sin ID_Moodle a Moodle y anota el resultado de
+// cada una en progress. Si ctx se cancela, la llamada en curso se aborta y no se procesan más cuatrimestres.
+func (s *AsignaturaService) BulkSyncToMoodle(ctx context.Context, progress *SyncProgress) error {
+	log.Println(" Iniciando sincronización masiva de Asignaturas a Moodle...")
 
-		// Obtener todas las asignaturas sin ID_Moodle
-		asignaturas, err := s.Repo.GetUnsynced()
-		if err != nil {
-			log.Printf(" Error al obtener asignaturas sin sincronizar: %v", err)
-			return
-		}
-
-		if len(asignaturas) == 0 {
-			log.Println(" No hay asignaturas pendientes de sincronización")
-			return
-		}
+	// Obtener todas las asignaturas sin ID_Moodle
+	asignaturas, err := s.Repo.GetUnsynced()
+	if err != nil {
+		return fmt.Errorf("error al obtener asignaturas sin sincronizar: %w", err)
+	}
+	progress.SetTotal(len(asignaturas))
 
-		log.Printf(" Encontradas %d asignaturas para sincronizar", len(asignaturas))
+	if len(asignaturas) == 0 {
+		log.Println(" No hay asignaturas pendientes de sincronización")
+		return nil
+	}
 
-		// Agrupar asignaturas por CuatrimestreID para sincronización eficiente
-		cuatrimestreGroups := make(map[uint][]models.Asignatura)
-		for _, asignatura := range asignaturas {
-			cuatrimestreGroups[asignatura.CuatrimestreID] = append(cuatrimestreGroups[asignatura.CuatrimestreID], asignatura)
-		}
+	log.Printf(" Encontradas %d asignaturas para sincronizar", len(asignaturas))
 
-		successCount := 0
-		errorCount := 0
+	// Agrupar asignaturas por CuatrimestreID para sincronización eficiente
+	cuatrimestreGroups := make(map[uint][]models.Asignatura)
+	for _, asignatura := range asignaturas {
+		cuatrimestreGroups[asignatura.CuatrimestreID] = append(cuatrimestreGroups[asignatura.CuatrimestreID], asignatura)
+	}
 
-		// Procesar cada grupo de asignaturas por cuatrimestre
-		for cuatrimestreID, group := range cuatrimestreGroups {
-			log.Printf(" Procesando %d asignaturas del Cuatrimestre ID: %d", len(group), cuatrimestreID)
+	successCount := 0
+	errorCount := 0
+	fail := func(a models.Asignatura, err error) {
+		errorCount++
+		progress.Failure("asignatura", a.ID, err)
+		s.syncFailed(&a, err)
+	}
 
-			// Validar que el cuatrimestre padre esté sincronizado
-			if group[0].Cuatrimestre.ID_Moodle == nil {
-				log.Printf("  Cuatrimestre ID %d no tiene ID_Moodle. Saltando %d asignaturas.", cuatrimestreID, len(group))
-				errorCount += len(group)
-				continue
-			}
+	// Procesar cada grupo de asignaturas por cuatrimestre
+	for cuatrimestreID, group := range cuatrimestreGroups {
+		if ctx.Err() != nil {
+			log.Printf(" Sincronización masiva de asignaturas cancelada: %v", ctx.Err())
+			return fmt.Errorf("sincronización cancelada: %w", ctx.Err())
+		}
+		log.Printf(" Procesando %d asignaturas del Cuatrimestre ID: %d", len(group), cuatrimestreID)
 
-			// Construir array de CourseRequest para este grupo
-			data := make([]moodle.CourseRequest, len(group))
-			for i, asignatura := range group {
-				data[i] = moodle.CourseRequest{
-					Fullname:   asignatura.NombreCompleto,
-					Shortname:  asignatura.NombreCorto,
-					Categoryid: int(*asignatura.Cuatrimestre.ID_Moodle),
-					IDNumber:   safeString(asignatura.ID_Externo),
-					Summary:    safeString(asignatura.Resumen),
-				}
+		// Validar que el cuatrimestre padre esté sincronizado
+		if group[0].Cuatrimestre.ID_Moodle == nil {
+			log.Printf("  Cuatrimestre ID %d no tiene ID_Moodle. Saltando %d asignaturas.", cuatrimestreID, len(group))
+			for _, asignatura := range group {
+				fail(asignatura, fmt.Errorf("el cuatrimestre %d no está sincronizado con Moodle", cuatrimestreID))
 			}
+			continue
+		}
 
-			// Llamar a la API de Moodle para crear cursos en batch
-			var response []moodle.CourseResponse
-			err := s.MoodleClient.Call("core_course_create_courses", data, &response)
-			if err != nil {
-				log.Printf(" Error al crear cursos en Moodle para Cuatrimestre ID %d: %v", cuatrimestreID, err)
-				errorCount += len(group)
+		// Las asignaturas con plantilla se copian una a una; el resto se crea en batch
+		var batch []models.Asignatura
+		for i := range group {
+			if group[i].PlantillaMoodleID == nil {
+				batch = append(batch, group[i])
 				continue
 			}
+			if err := s.createFromTemplate(ctx, &group[i]); err != nil {
+				log.Printf(" Error al crear Asignatura ID %d desde su plantilla: %v", group[i].ID, err)
+				fail(group[i], err)
+			} else {
+				successCount++
+				progress.Success()
+			}
+		}
+		group = batch
+		if len(group) == 0 {
+			continue
+		}
+
+		// Construir array de CourseRequest para este grupo
+		data := make([]moodle.CourseRequest, len(group))
+		for i, asignatura := range group {
+			data[i] = courseRequest(asignatura)
+		}
+
+		// Llamar a la API de Moodle para crear cursos en batch
+		var response []moodle.CourseResponse
+		err := s.MoodleClient.Call(ctx, "core_course_create_courses", moodle.CreateCoursesParams{Courses: data}, &response)
+		if err != nil {
+			log.Printf(" Error al crear cursos en Moodle para Cuatrimestre ID %d: %v", cuatrimestreID, err)
+			// Con lotes, la respuesta conserva lo creado antes del fallo: se enlaza igualmente
+			// y las asignaturas sin respuesta se cuentan como error más abajo.
+		}
 
-			// Actualizar ID_Moodle en la base de datos local
-			for i, asignatura := range group {
-				if i < len(response) {
-					moodleID := response[i].ID
-					asignatura.ID_Moodle = &moodleID
-
-					if err := s.Repo.Update(&asignatura); err != nil {
-						log.Printf(" Error al actualizar ID_Moodle para Asignatura ID %d: %v", asignatura.ID, err)
-						errorCount++
-					} else {
-						log.Printf(" Asignatura '%s' (ID local: %d) sincronizada con Moodle ID: %d", asignatura.NombreCompleto, asignatura.ID, moodleID)
-						successCount++
-					}
+		// Actualizar ID_Moodle en la base de datos local
+		for i, asignatura := range group {
+			if i < len(response) {
+				moodleID := response[i].ID
+				asignatura.ID_Moodle = &moodleID
+				asignatura.MarkSynced(asignaturaHash(asignatura))
+
+				if err := s.Repo.Update(&asignatura); err != nil {
+					log.Printf(" Error al actualizar ID_Moodle para Asignatura ID %d: %v", asignatura.ID, err)
+					fail(asignatura, fmt.Errorf("creada en Moodle (ID %d) pero falló guardar el ID local: %w", moodleID, err))
 				} else {
-					log.Printf(" No se recibió respuesta de Moodle para Asignatura ID %d", asignatura.ID)
-					errorCount++
+					log.Printf(" Asignatura '%s' (ID local: %d) sincronizada con Moodle ID: %d", asignatura.NombreCompleto, asignatura.ID, moodleID)
+					successCount++
+					progress.Success()
 				}
+			} else {
+				log.Printf(" No se recibió respuesta de Moodle para Asignatura ID %d", asignatura.ID)
+				fail(asignatura, sinRespuesta(err))
 			}
 		}
+	}
 
-		log.Printf(" Sincronización masiva completada: %d exitosas, %d errores", successCount, errorCount)
-	}()
+	log.Printf(" Sincronización masiva completada: %d exitosas, %d errores", successCount, errorCount)
+	return nil
 }
@@ -0,0 +1,81 @@
+package services
+
+import (
+	"api_concurrencia/src/models"
+	"testing"
+	"time"
+)
+
+// Los cursos heredan fechas y formato del cuatrimestre: cambiar los valores por defecto actualiza solo
+// los cursos que los heredan, y una fecha borrada se quita también en Moodle.
+func TestCursoHeredaDelCuatrimestre(t *testing.T) {
+	svc, fake, _ := newTestServices(t)
+	inicio := time.Date(2025, 1, 6, 0, 0, 0, 0, time.UTC)
+	fin := time.Date(2025, 4, 30, 0, 0, 0, 0, time.UTC)
+	propioFin := time.Date(2025, 3, 31, 0, 0, 0, 0, time.UTC)
+
+	pe := models.ProgramaEstudio{Nombre: "Ingeniería"}
+	if err := svc.Programas.CreateLocal(&pe); err != nil {
+		t.Fatal(err)
+	}
+	c := models.Cuatrimestre{Nombre: "Primero", ProgramaEstudioID: pe.ID, FechaInicio: &inicio, FechaFin: &fin, FormatoCurso: strPtr("weeks")}
+	if err := svc.Cuatrimestres.CreateLocal(&c); err != nil {
+		t.Fatal(err)
+	}
+	hereda := models.Asignatura{NombreCompleto: "Cálculo", NombreCorto: "CALC", CuatrimestreID: c.ID}
+	propia := models.Asignatura{NombreCompleto: "Física", NombreCorto: "FIS", CuatrimestreID: c.ID, Formato: strPtr("topics"), FechaInicio: &inicio, FechaFin: &propioFin}
+	for _, a := range []*models.Asignatura{&hereda, &propia} {
+		if err := svc.Asignaturas.CreateLocal(a); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dispatchAll(t, svc)
+
+	curso := func(a models.Asignatura) (string, int64, int64) {
+		t.Helper()
+		stored, err := svc.Asignaturas.GetByID(a.ID)
+		if err != nil || stored.ID_Moodle == nil {
+			t.Fatalf("asignatura %s sin curso en Moodle: %v", a.NombreCorto, err)
+		}
+		fc := fake.Courses[*stored.ID_Moodle]
+		return fc.Format, fc.StartDate, fc.EndDate
+	}
+	if f, i, e := curso(hereda); f != "weeks" || i != inicio.Unix() || e != fin.Unix() {
+		t.Fatalf("curso heredado = %s %d..%d, se esperaba weeks %d..%d", f, i, e, inicio.Unix(), fin.Unix())
+	}
+
+	// Nuevo formato y sin fecha de fin: solo cambia el curso que los hereda.
+	c.FormatoCurso = strPtr("topics")
+	c.FechaFin = nil
+	if err := svc.Cuatrimestres.UpdateLocal(&c); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(eventsOf(t, svc, models.OutboxAsignatura, propia.ID)); n != 1 {
+		t.Errorf("la asignatura con valores propios tiene %d eventos, se esperaba solo el de creación", n)
+	}
+	stored, err := svc.Asignaturas.GetByID(hereda.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.SyncStatus != models.SyncDirty {
+		t.Errorf("la asignatura que hereda quedó %s, se esperaba dirty", stored.SyncStatus)
+	}
+	dispatchAll(t, svc)
+	if f, i, e := curso(hereda); f != "topics" || i != inicio.Unix() || e != 0 {
+		t.Errorf("curso heredado tras el cambio = %s %d..%d, se esperaba topics %d..0", f, i, e, inicio.Unix())
+	}
+
+	// Borrar la fecha propia de la asignatura también la quita del curso.
+	stored, err = svc.Asignaturas.GetByID(propia.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored.FechaFin = nil
+	if err := svc.Asignaturas.UpdateLocal(&stored); err != nil {
+		t.Fatal(err)
+	}
+	dispatchAll(t, svc)
+	if _, _, e := curso(propia); e != 0 {
+		t.Errorf("fin del curso propio = %d, se esperaba 0 tras borrarlo", e)
+	}
+}
@@ -0,0 +1,183 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"api_concurrencia/src/models"
+	"api_concurrencia/src/moodle"
+	"api_concurrencia/src/repository"
+)
+
+// CalificacionService importa calificaciones del libro de calificaciones de Moodle
+// (gradereport_user_get_grade_items) y las guarda localmente por matrícula.
+type CalificacionService struct {
+	Repo           *repository.CalificacionRepository
+	UsuarioRepo    *repository.UsuarioRepository
+	AsignaturaRepo *repository.AsignaturaRepository
+	MoodleClient   moodle.MoodleAPI
+	Roles          *RolMoodleService // Indica qué roles son de alumno: sólo los alumnos tienen calificaciones
+}
+
+func NewCalificacionService(repo *repository.CalificacionRepository, usuarioRepo *repository.UsuarioRepository, asignaturaRepo *repository.AsignaturaRepository, moodleClient moodle.MoodleAPI, roles *RolMoodleService) *CalificacionService {
+	return &CalificacionService{Repo: repo, UsuarioRepo: usuarioRepo, AsignaturaRepo: asignaturaRepo, MoodleClient: moodleClient, Roles: roles}
+}
+
+// ImportacionCalificaciones resume una importación de calificaciones.
+type ImportacionCalificaciones struct {
+	Matriculas     int      `json:"matriculas"`        // Matrículas de alumnos actualizadas
+	Calificaciones int      `json:"calificaciones"`    // Elementos de calificación guardados
+	SinDatos       int      `json:"sin_datos"`         // Matrículas para las que Moodle no devolvió calificaciones
+	Errores        []string `json:"errores,omitempty"` // Matrículas que no se pudieron importar
+}
+
+// GetByUsuario devuelve las calificaciones guardadas de un usuario.
+func (s *CalificacionService) GetByUsuario(usuarioID uint) ([]models.Calificacion, error) {
+	return s.Repo.GetByUsuario(usuarioID)
+}
+
+// GetByAsignatura devuelve las calificaciones guardadas de una asignatura.
+func (s *CalificacionService) GetByAsignatura(asignaturaID uint) ([]models.Calificacion, error) {
+	return s.Repo.GetByAsignatura(asignaturaID)
+}
+
+// ImportarAsignatura importa en una sola llamada las calificaciones de todos los alumnos de la asignatura.
+func (s *CalificacionService) ImportarAsignatura(ctx context.Context, asignaturaID uint) (*ImportacionCalificaciones, error) {
+	asignatura, err := s.AsignaturaRepo.GetByID(asignaturaID)
+	if err != nil {
+		return nil, fmt.Errorf("asignatura (ID: %d) no encontrada: %w", asignaturaID, err)
+	}
+	if asignatura.ID_Moodle == nil {
+		return nil, fmt.Errorf("la asignatura '%s' no está sincronizada con Moodle (ID_Moodle local es nulo)", asignatura.NombreCompleto)
+	}
+	matriculas, err := s.UsuarioRepo.GetMatriculasByAsignatura(asignaturaID)
+	if err != nil {
+		return nil, fmt.Errorf("no se pudieron obtener las matrículas de la asignatura %d: %w", asignaturaID, err)
+	}
+	rolesAlumno, err := s.Roles.RolesEstudiante()
+	if err != nil {
+		return nil, err
+	}
+
+	var response moodle.GetGradeItemsResponse
+	params := moodle.GetGradeItemsParams{CourseID: *asignatura.ID_Moodle}
+	if err := s.MoodleClient.Call(ctx, "gradereport_user_get_grade_items", params, &response); err != nil {
+		return nil, fmt.Errorf("fallo al obtener calificaciones del curso '%s' en Moodle: %w", asignatura.NombreCompleto, err)
+	}
+	porUsuario := make(map[uint]moodle.UserGrades, len(response.UserGrades))
+	for _, ug := range response.UserGrades {
+		porUsuario[ug.UserID] = ug
+	}
+
+	result := &ImportacionCalificaciones{}
+	importadaEn := time.Now()
+	for _, m := range matriculas {
+		if !rolesAlumno[m.RoleID] {
+			continue
+		}
+		ug, ok := porUsuario[m.UserMoodleID]
+		if !ok {
+			result.SinDatos++
+			continue
+		}
+		s.guardar(m, ug.GradeItems, importadaEn, result)
+	}
+
+	log.Printf("📊 Calificaciones de '%s' importadas: %d matrículas, %d elementos, %d sin datos.",
+		asignatura.NombreCompleto, result.Matriculas, result.Calificaciones, result.SinDatos)
+	return result, nil
+}
+
+// ImportarUsuario importa las calificaciones de un alumno en cada asignatura en la que está matriculado.
+// Un fallo en una asignatura no detiene las demás; sólo se devuelve error si no se pudo importar ninguna.
+func (s *CalificacionService) ImportarUsuario(ctx context.Context, usuarioID uint) (*ImportacionCalificaciones, error) {
+	usuario, err := s.UsuarioRepo.GetByID(usuarioID)
+	if err != nil {
+		return nil, fmt.Errorf("usuario (ID: %d) no encontrado: %w", usuarioID, err)
+	}
+	matriculas, err := s.UsuarioRepo.GetMatriculasByUsuario(usuarioID)
+	if err != nil {
+		return nil, fmt.Errorf("no se pudieron obtener las matrículas del usuario %d: %w", usuarioID, err)
+	}
+	rolesAlumno, err := s.Roles.RolesEstudiante()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ImportacionCalificaciones{}
+	importadaEn := time.Now()
+	var lastErr error
+	for _, m := range matriculas {
+		if !rolesAlumno[m.RoleID] {
+			continue
+		}
+		if ctx.Err() != nil {
+			lastErr = ctx.Err()
+			break
+		}
+
+		var response moodle.GetGradeItemsResponse
+		params := moodle.GetGradeItemsParams{CourseID: m.CourseMoodleID, UserID: m.UserMoodleID}
+		if err := s.MoodleClient.Call(ctx, "gradereport_user_get_grade_items", params, &response); err != nil {
+			lastErr = err
+			result.Errores = append(result.Errores, fmt.Sprintf("asignatura %d: %v", m.AsignaturaID, err))
+			continue
+		}
+		if len(response.UserGrades) == 0 {
+			result.SinDatos++
+			continue
+		}
+		s.guardar(m, response.UserGrades[0].GradeItems, importadaEn, result)
+	}
+
+	if lastErr != nil && result.Matriculas == 0 {
+		return result, fmt.Errorf("no se pudo importar ninguna calificación de '%s': %w", usuario.Username, lastErr)
+	}
+	log.Printf("📊 Calificaciones de %s importadas: %d matrículas, %d elementos, %d errores.",
+		usuario.Username, result.Matriculas, result.Calificaciones, len(result.Errores))
+	return result, nil
+}
+
+// guardar sustituye las calificaciones de la matrícula por las recibidas y actualiza el resumen.
+func (s *CalificacionService) guardar(m models.Matricula, items []moodle.GradeItem, importadaEn time.Time, result *ImportacionCalificaciones) {
+	calificaciones := make([]models.Calificacion, 0, len(items))
+	for _, item := range items {
+		calificaciones = append(calificaciones, calificacionFromMoodle(m, item, importadaEn))
+	}
+	if err := s.Repo.ReplaceForMatricula(m.ID, calificaciones); err != nil {
+		result.Errores = append(result.Errores, fmt.Sprintf("asignatura %d: error al guardar: %v", m.AsignaturaID, err))
+		return
+	}
+	result.Matriculas++
+	result.Calificaciones += len(calificaciones)
+}
+
+func calificacionFromMoodle(m models.Matricula, item moodle.GradeItem, importadaEn time.Time) models.Calificacion {
+	c := models.Calificacion{
+		MatriculaID:     m.ID,
+		UsuarioID:       m.UsuarioID,
+		AsignaturaID:    m.AsignaturaID,
+		ItemMoodleID:    item.ID,
+		Nombre:          item.ItemName,
+		Tipo:            item.ItemType,
+		Modulo:          item.ItemModule,
+		TotalCurso:      item.ItemType == "course",
+		Valor:           item.GradeRaw,
+		ValorFormateado: item.GradeFormatted,
+		Minimo:          item.GradeMin,
+		Maximo:          item.GradeMax,
+		Porcentaje:      item.PercentageFormatted,
+		ImportadaEn:     importadaEn,
+	}
+	if feedback := strings.TrimSpace(item.Feedback); feedback != "" {
+		c.Retroalimentacion = &feedback
+	}
+	if item.GradeDateGraded != nil && *item.GradeDateGraded > 0 {
+		fecha := time.Unix(*item.GradeDateGraded, 0)
+		c.FechaCalificacion = &fecha
+	}
+	return c
+}
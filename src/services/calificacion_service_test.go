@@ -0,0 +1,82 @@
+package services
+
+import (
+	"api_concurrencia/src/models"
+	"api_concurrencia/src/moodle"
+	"context"
+	"fmt"
+	"testing"
+)
+
+// Importar dos veces sustituye las calificaciones en lugar de duplicarlas, ignora a los docentes y
+// conserva lo importado cuando el alumno se da de baja.
+func TestImportarCalificacionesAsignatura(t *testing.T) {
+	svc, fake, db := newTestServices(t)
+	if err := db.AutoMigrate(&models.Calificacion{}); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	// Ambos lados ya sincronizados: el curso 500 con una alumna (701) y un docente (702).
+	if err := svc.Roles.Create(&models.RolMoodle{Nombre: "Alumno", MoodleRoleID: 5, Shortname: "student", Estudiante: true}); err != nil {
+		t.Fatal(err)
+	}
+	fake.Courses[500] = &moodle.FakeCourse{ID: 500, Fullname: "Cálculo", Shortname: "CALC"}
+	courseID := uint(500)
+	asignatura := models.Asignatura{NombreCompleto: "Cálculo", NombreCorto: "CALC", ID_Moodle: &courseID}
+	if err := db.Create(&asignatura).Error; err != nil {
+		t.Fatal(err)
+	}
+	alumna := models.Matricula{AsignaturaID: asignatura.ID, UsuarioID: 1, CourseMoodleID: 500, UserMoodleID: 701, RoleID: 5}
+	docente := models.Matricula{AsignaturaID: asignatura.ID, UsuarioID: 2, CourseMoodleID: 500, UserMoodleID: 702, RoleID: 3}
+	for _, m := range []*models.Matricula{&alumna, &docente} {
+		if err := db.Create(m).Error; err != nil {
+			t.Fatal(err)
+		}
+		fake.Enrolments[fmt.Sprintf("500:%d", m.UserMoodleID)] = &moodle.FakeEnrolment{CourseID: 500, UserID: m.UserMoodleID, RoleID: int(m.RoleID)}
+	}
+
+	fake.SetGrade(500, 701, "Tarea 1", 6)
+	fake.SetGrade(500, 701, "Examen", 9)
+	fake.SetGrade(500, 702, "Tarea 1", 10)
+	if _, err := svc.Calificaciones.ImportarAsignatura(ctx, asignatura.ID); err != nil {
+		t.Fatal(err)
+	}
+	fake.SetGrade(500, 701, "Tarea 1", 7.5)
+	result, err := svc.Calificaciones.ImportarAsignatura(ctx, asignatura.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Matriculas != 1 || result.Calificaciones != 3 || len(result.Errores) != 0 {
+		t.Fatalf("resumen = %+v, se esperaba 1 matrícula con 3 calificaciones (2 actividades y el total)", *result)
+	}
+
+	calificaciones, err := svc.Calificaciones.GetByAsignatura(asignatura.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(calificaciones) != 3 {
+		t.Fatalf("hay %d calificaciones guardadas, se esperaban 3 (sin duplicados ni docentes)", len(calificaciones))
+	}
+	valores := map[string]float64{}
+	for _, c := range calificaciones {
+		if c.MatriculaID != alumna.ID || c.Valor == nil {
+			t.Fatalf("calificación inesperada: %+v", c)
+		}
+		nombre := c.Nombre
+		if c.TotalCurso {
+			nombre = "total"
+		}
+		valores[nombre] = *c.Valor
+	}
+	if valores["Tarea 1"] != 7.5 || valores["Examen"] != 9 || valores["total"] != 16.5 {
+		t.Errorf("valores = %v, se esperaba Tarea 1 = 7.5, Examen = 9 y total = 16.5", valores)
+	}
+
+	if err := db.Delete(&alumna).Error; err != nil {
+		t.Fatal(err)
+	}
+	if guardadas, err := svc.Calificaciones.GetByUsuario(alumna.UsuarioID); err != nil || len(guardadas) != 3 {
+		t.Errorf("tras la baja quedan %d calificaciones (%v), se esperaban las 3 importadas", len(guardadas), err)
+	}
+}
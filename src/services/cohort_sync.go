@@ -0,0 +1,181 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+
+	"api_concurrencia/src/models"
+	"api_concurrencia/src/moodle"
+)
+
+// CohortSyncResult resume la sincronización de la cohorte de un Programa de Estudio o Cuatrimestre.
+type CohortSyncResult struct {
+	CohortID uint             `json:"cohort_id"`
+	Created  bool             `json:"created"`            // La cohorte se creó (o vinculó) en esta llamada
+	Members  int              `json:"members"`            // Alumnos enviados a la cohorte
+	Removed  int              `json:"removed"`            // Miembros quitados por no estar ya matriculados
+	Skipped  int              `json:"skipped"`            // Alumnos sin ID_Moodle (aún no sincronizados)
+	Warnings []moodle.Warning `json:"warnings,omitempty"` // Miembros que Moodle no pudo añadir
+}
+
+// cohortSpec describe la cohorte que corresponde a una entidad local.
+type cohortSpec struct {
+	Name        string
+	IDNumber    string
+	Description string
+	CategoryID  *uint // Categoría de Moodle de la entidad; nil crea la cohorte en el contexto de sistema
+}
+
+// cohortIDNumber construye el idnumber de la cohorte. Lleva prefijo para que un programa y un
+// cuatrimestre con el mismo ID_Externo no compartan cohorte (el idnumber es único en todo Moodle).
+func cohortIDNumber(prefix string, id uint, idExterno *string) string {
+	if ext := safeString(idExterno); ext != "" {
+		return prefix + "-" + ext
+	}
+	return fmt.Sprintf("%s-%d", prefix, id)
+}
+
+// ensureCohort devuelve el ID de la cohorte ya asignada o la crea con core_cohort_create_cohorts.
+// Si Moodle ya tiene una cohorte con el mismo idnumber, la vincula en lugar de fallar.
+func ensureCohort(ctx context.Context, api moodle.MoodleAPI, current *uint, spec cohortSpec) (uint, bool, error) {
+	if current != nil {
+		return *current, false, nil
+	}
+
+	category := moodle.CohortTypeValue{Type: "system"}
+	if spec.CategoryID != nil {
+		category = moodle.CohortTypeValue{Type: "id", Value: strconv.FormatUint(uint64(*spec.CategoryID), 10)}
+	}
+	data := []moodle.CohortRequest{{
+		CategoryType:      category,
+		Name:              spec.Name,
+		IDNumber:          spec.IDNumber,
+		Description:       spec.Description,
+		DescriptionFormat: 1, // HTML
+		Visible:           1,
+	}}
+
+	var response []moodle.Cohort
+	err := api.Call(ctx, "core_cohort_create_cohorts", moodle.CreateCohortsParams{Cohorts: data}, &response)
+	if err != nil {
+		if !moodle.IsDuplicate(err) {
+			return 0, false, fmt.Errorf("fallo al crear la cohorte '%s' en Moodle: %w", spec.Name, err)
+		}
+		existing, lookupErr := moodle.FindCohortByIDNumber(ctx, api, spec.IDNumber)
+		if lookupErr != nil || existing == nil {
+			return 0, false, fmt.Errorf("la cohorte ya existe en Moodle y no se pudo recuperar su ID: %w", err)
+		}
+		log.Printf("⚠️ La cohorte '%s' ya existía en Moodle (Cohorte ID: %d). Vinculando.", spec.IDNumber, existing.ID)
+		return existing.ID, true, nil
+	}
+	if len(response) == 0 {
+		return 0, false, fmt.Errorf("moodle no devolvió ninguna cohorte creada")
+	}
+	return response[0].ID, true, nil
+}
+
+// addCohortMembers añade a la cohorte los alumnos que ya están en Moodle. Moodle ignora a los que
+// ya son miembros, así que puede llamarse cada vez que cambian las matrículas.
+func addCohortMembers(ctx context.Context, api moodle.MoodleAPI, cohortID uint, alumnos []models.Usuario, result *CohortSyncResult) error {
+	cohort := moodle.CohortTypeValue{Type: "id", Value: strconv.FormatUint(uint64(cohortID), 10)}
+	members := make([]moodle.CohortMemberRequest, 0, len(alumnos))
+	for _, u := range alumnos {
+		if u.ID_Moodle == nil {
+			result.Skipped++
+			continue
+		}
+		members = append(members, moodle.CohortMemberRequest{
+			CohortType: cohort,
+			UserType:   moodle.CohortTypeValue{Type: "id", Value: strconv.FormatUint(uint64(*u.ID_Moodle), 10)},
+		})
+	}
+	if len(members) == 0 {
+		return nil
+	}
+
+	var response moodle.AddCohortMembersResponse
+	if err := api.Call(ctx, "core_cohort_add_cohort_members", moodle.AddCohortMembersParams{Members: members}, &response); err != nil {
+		return fmt.Errorf("fallo al añadir miembros a la cohorte %d en Moodle: %w", cohortID, err)
+	}
+	result.Members = len(members) - len(response.Warnings)
+	result.Warnings = response.Warnings
+	return nil
+}
+
+// removeStaleCohortMembers quita de la cohorte (core_cohort_delete_cohort_members) a los miembros de
+// Moodle que no están entre los alumnos, es decir, los que dejaron de estar matriculados.
+func removeStaleCohortMembers(ctx context.Context, api moodle.MoodleAPI, cohortID uint, alumnos []models.Usuario, result *CohortSyncResult) error {
+	members, err := moodle.GetCohortMembers(ctx, api, cohortID)
+	if err != nil {
+		return err
+	}
+	actuales := make(map[uint]bool, len(alumnos))
+	for _, u := range alumnos {
+		if u.ID_Moodle != nil {
+			actuales[*u.ID_Moodle] = true
+		}
+	}
+	var stale []moodle.CohortMemberID
+	for _, userID := range members {
+		if !actuales[userID] {
+			stale = append(stale, moodle.CohortMemberID{CohortID: cohortID, UserID: userID})
+		}
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+	if err := api.Call(ctx, "core_cohort_delete_cohort_members", moodle.DeleteCohortMembersParams{Members: stale}, nil); err != nil {
+		return fmt.Errorf("fallo al quitar miembros de la cohorte %d en Moodle: %w", cohortID, err)
+	}
+	result.Removed = len(stale)
+	return nil
+}
+
+// addToAsignaturaCohorts añade un alumno recién matriculado a las cohortes del cuatrimestre y del
+// programa de la asignatura, si existen. Es un paso auxiliar: los fallos sólo se registran.
+func addToAsignaturaCohorts(ctx context.Context, api moodle.MoodleAPI, usuario models.Usuario, asignatura models.Asignatura) {
+	for _, cohortID := range []*uint{asignatura.Cuatrimestre.CohortMoodleID, asignatura.Cuatrimestre.ProgramaEstudio.CohortMoodleID} {
+		if cohortID == nil {
+			continue
+		}
+		var result CohortSyncResult
+		if err := addCohortMembers(ctx, api, *cohortID, []models.Usuario{usuario}, &result); err != nil {
+			log.Printf("⚠️ No se pudo añadir a %s a la cohorte %d: %v", usuario.Username, *cohortID, err)
+		}
+	}
+}
+
+// removeFromAsignaturaCohorts quita a un usuario dado de baja de la asignatura de las cohortes de su
+// cuatrimestre y de su programa, salvo que siga matriculado como alumno en otra asignatura de ellos.
+// enOtra indica, para la cohorte del cuatrimestre (programa false) o del programa, si sigue
+// matriculado. Es un paso auxiliar: los fallos sólo se registran.
+func removeFromAsignaturaCohorts(ctx context.Context, api moodle.MoodleAPI, userMoodleID uint, asignatura models.Asignatura, enOtra func(programa bool) (bool, error)) {
+	cohortes := []struct {
+		id       *uint
+		programa bool
+	}{
+		{asignatura.Cuatrimestre.CohortMoodleID, false},
+		{asignatura.Cuatrimestre.ProgramaEstudio.CohortMoodleID, true},
+	}
+	for _, c := range cohortes {
+		if c.id == nil {
+			continue
+		}
+		sigue, err := enOtra(c.programa)
+		if err != nil {
+			log.Printf("⚠️ No se pudo comprobar si el usuario (Moodle ID: %d) sigue en la cohorte %d: %v", userMoodleID, *c.id, err)
+			continue
+		}
+		if sigue {
+			continue
+		}
+		params := moodle.DeleteCohortMembersParams{Members: []moodle.CohortMemberID{{CohortID: *c.id, UserID: userMoodleID}}}
+		if err := api.Call(ctx, "core_cohort_delete_cohort_members", params, nil); err != nil {
+			log.Printf("⚠️ No se pudo quitar al usuario (Moodle ID: %d) de la cohorte %d: %v", userMoodleID, *c.id, err)
+			continue
+		}
+		log.Printf("🗑️ Usuario (Moodle ID: %d) quitado de la cohorte %d.", userMoodleID, *c.id)
+	}
+}
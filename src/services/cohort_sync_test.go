@@ -0,0 +1,115 @@
+package services
+
+import (
+	"api_concurrencia/src/models"
+	"api_concurrencia/src/moodle"
+	"context"
+	"fmt"
+	"testing"
+)
+
+// Un alumno sale de las cohortes al dejar su última matrícula del cuatrimestre o del programa, y volver
+// a sincronizar la cohorte quita a quien ya no está matriculado.
+func TestCohortMembersFollowEnrolments(t *testing.T) {
+	svc, fake, _ := newTestServices(t)
+	ctx := context.Background()
+	for _, rol := range []models.RolMoodle{
+		{Nombre: "Alumno", MoodleRoleID: 5, Shortname: "student", Estudiante: true},
+		{Nombre: "Docente", MoodleRoleID: 3, Shortname: "editingteacher"},
+	} {
+		if err := svc.Roles.Create(&rol); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pe := models.ProgramaEstudio{Nombre: "Ingeniería", ID_Externo: strPtr("ING")}
+	if err := svc.Programas.CreateLocal(&pe); err != nil {
+		t.Fatal(err)
+	}
+	c := models.Cuatrimestre{Nombre: "Primero", ProgramaEstudioID: pe.ID}
+	if err := svc.Cuatrimestres.CreateLocal(&c); err != nil {
+		t.Fatal(err)
+	}
+	calculo := models.Asignatura{NombreCompleto: "Cálculo", NombreCorto: "CALC", CuatrimestreID: c.ID}
+	fisica := models.Asignatura{NombreCompleto: "Física", NombreCorto: "FIS", CuatrimestreID: c.ID}
+	for _, a := range []*models.Asignatura{&calculo, &fisica} {
+		if err := svc.Asignaturas.CreateLocal(a); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ana := models.Usuario{Username: "ana", Password: "Segura123#", FirstName: "Ana", LastName: "López", Email: "ana@example.com", Rol: "Alumno"}
+	luis := models.Usuario{Username: "luis", Password: "Segura123#", FirstName: "Luis", LastName: "Gil", Email: "luis@example.com", Rol: "Alumno"}
+	profe := models.Usuario{Username: "marta", Password: "Segura123#", FirstName: "Marta", LastName: "Ruiz", Email: "marta@example.com", Rol: "Docente"}
+	for _, u := range []*models.Usuario{&ana, &luis, &profe} {
+		if err := svc.Usuarios.CreateLocal(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dispatchAll(t, svc)
+
+	matricular := func(u models.Usuario, a models.Asignatura) {
+		t.Helper()
+		if _, err := svc.Usuarios.MatricularUsuario(u.ID, a.ID, OpcionesMatricula{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	matricular(ana, calculo)
+	matricular(ana, fisica)
+	matricular(luis, calculo)
+	matricular(profe, calculo)
+	dispatchAll(t, svc)
+
+	cuatri, err := svc.Cuatrimestres.SyncCohort(ctx, c.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	programa, err := svc.Programas.SyncCohort(ctx, pe.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	moodleID := func(u models.Usuario) uint {
+		t.Helper()
+		stored, err := svc.Usuarios.Repo.GetByID(u.ID)
+		if err != nil || stored.ID_Moodle == nil {
+			t.Fatalf("usuario %s sin ID de Moodle: %v", u.Username, err)
+		}
+		return *stored.ID_Moodle
+	}
+	miembros := func(cohortID uint) string { return fmt.Sprint(fake.CohortMemberIDs(cohortID)) }
+	ambos := fmt.Sprint([]uint{moodleID(ana), moodleID(luis)})
+	if got := miembros(cuatri.CohortID); got != ambos {
+		t.Fatalf("cohorte del cuatrimestre = %s, se esperaba %s (sin la docente)", got, ambos)
+	}
+
+	// Ana sigue matriculada en Física: se queda. Luis no tiene otra asignatura: sale de las dos.
+	if err := svc.Usuarios.DesmatricularUsuario(ana.ID, calculo.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.Usuarios.DesmatricularUsuario(luis.ID, calculo.ID); err != nil {
+		t.Fatal(err)
+	}
+	dispatchAll(t, svc)
+	soloAna := fmt.Sprint([]uint{moodleID(ana)})
+	for nombre, id := range map[string]uint{"cuatrimestre": cuatri.CohortID, "programa": programa.CohortID} {
+		if got := miembros(id); got != soloAna {
+			t.Errorf("cohorte del %s tras las bajas = %s, se esperaba %s", nombre, got, soloAna)
+		}
+	}
+
+	// Un miembro añadido a mano en Moodle no está matriculado: la sincronización lo quita.
+	var creados []moodle.UserResponse
+	err = fake.Call(ctx, "core_user_create_users", moodle.CreateUsersParams{Users: []moodle.UserRequest{
+		{Username: "intruso", Firstname: "In", Lastname: "Truso", Email: "intruso@example.com", CreatePassword: 1},
+	}}, &creados)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.CohortMembers[cuatri.CohortID][creados[0].ID] = true
+	again, err := svc.Cuatrimestres.SyncCohort(ctx, c.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Removed != 1 || miembros(cuatri.CohortID) != soloAna {
+		t.Errorf("resincronizar quitó %d miembros, quedan %s; se esperaba quitar 1 y dejar %s", again.Removed, miembros(cuatri.CohortID), soloAna)
+	}
+}
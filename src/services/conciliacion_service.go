@@ -0,0 +1,831 @@
+package services
+
+import (
+	"api_concurrencia/src/models"
+	"api_concurrencia/src/moodle"
+	"api_concurrencia/src/repository"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrDiscrepanciaCerrada se devuelve al resolver una discrepancia que ya no está abierta.
+	ErrDiscrepanciaCerrada = errors.New("la discrepancia ya no está abierta")
+	// ErrAccionNoPermitida se devuelve al resolver una discrepancia con una acción que no ofrece.
+	ErrAccionNoPermitida = errors.New("acción no disponible para esta discrepancia")
+	// ErrEntidadIDRequerido se devuelve al vincular una entidad solo_en_moodle sin indicar el registro local.
+	ErrEntidadIDRequerido = errors.New("hay que indicar entidad_id: el registro local con el que vincularla")
+)
+
+// Campos que se comparan entre la BD local y Moodle.
+const (
+	campoNombre         = "nombre"
+	campoIDNumber       = "idnumber"
+	campoDescripcion    = "descripcion"
+	campoNombreCompleto = "nombre_completo"
+	campoNombreCorto    = "nombre_corto"
+	campoResumen        = "resumen"
+	campoUsername       = "username"
+	campoApellidos      = "apellidos"
+	campoEmail          = "email"
+)
+
+// campoConciliado es el valor de un campo en un lado de la comparación.
+type campoConciliado struct {
+	nombre string
+	valor  string
+}
+
+// registroConciliado es un registro local o una entidad de Moodle preparados para compararse. claves
+// son, en orden de preferencia, los valores con los que se emparejan si el ID_Moodle no basta (el
+// idnumber y, después, el nombre corto o el username, como al vincular duplicados al sincronizar).
+// Los campos de los dos lados de un mismo tipo de entidad van en el mismo orden.
+type registroConciliado struct {
+	id       uint
+	moodleID *uint  // Solo en locales
+	entidad  string // Solo en Moodle: entidad local que le correspondería
+	nombre   string
+	claves   []string
+	campos   []campoConciliado
+}
+
+// indiceMoodle localiza las entidades de Moodle por ID y por clave y recuerda cuáles ya se emparejaron.
+type indiceMoodle struct {
+	registros []registroConciliado
+	porID     map[uint]int
+	porClave  []map[string]int
+	usados    map[int]bool
+}
+
+func nuevoIndiceMoodle(registros []registroConciliado) *indiceMoodle {
+	ix := &indiceMoodle{registros: registros, porID: make(map[uint]int, len(registros)), usados: make(map[int]bool)}
+	for i, r := range registros {
+		ix.porID[r.id] = i
+		for k, clave := range r.claves {
+			for len(ix.porClave) <= k {
+				ix.porClave = append(ix.porClave, make(map[string]int))
+			}
+			if _, repetida := ix.porClave[k][clave]; clave != "" && !repetida {
+				ix.porClave[k][clave] = i
+			}
+		}
+	}
+	return ix
+}
+
+// porMoodleID empareja el registro local con la entidad a la que apunta su ID_Moodle, si existe.
+func (ix *indiceMoodle) porMoodleID(l registroConciliado) (int, bool) {
+	if l.moodleID == nil {
+		return 0, false
+	}
+	i, ok := ix.porID[*l.moodleID]
+	if !ok || ix.usados[i] {
+		return 0, false
+	}
+	ix.usados[i] = true
+	return i, true
+}
+
+// porClaves empareja el registro local con la primera entidad libre que comparte una de sus claves.
+func (ix *indiceMoodle) porClaves(l registroConciliado) (int, bool) {
+	for k, clave := range l.claves {
+		if clave == "" || k >= len(ix.porClave) {
+			continue
+		}
+		if i, ok := ix.porClave[k][clave]; ok && !ix.usados[i] {
+			ix.usados[i] = true
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// conciliacion acumula el resultado de comparar todas las entidades.
+type conciliacion struct {
+	coincidentes  int
+	discrepancias []models.Discrepancia
+	fallos        []models.SyncJobError
+}
+
+// comparar empareja los registros locales de una entidad con las entidades de Moodle del índice (primero
+// todos por ID_Moodle y después por claves, para que un vínculo existente no lo robe otro registro) y
+// anota las discrepancias. Devuelve, por ID local, el ID de Moodle con el que se emparejó cada registro.
+func (c *conciliacion) comparar(entidad string, locales []registroConciliado, ix *indiceMoodle) map[uint]uint {
+	pares := make(map[uint]uint, len(locales))
+	pendientes := make([]registroConciliado, 0, len(locales))
+	for _, l := range locales {
+		i, ok := ix.porMoodleID(l)
+		if !ok {
+			pendientes = append(pendientes, l)
+			continue
+		}
+		m := ix.registros[i]
+		pares[l.id] = m.id
+		difs := diferencias(l, m)
+		if len(difs) == 0 {
+			c.coincidentes++
+			continue
+		}
+		c.discrepancias = append(c.discrepancias, nuevaDiscrepancia(entidad, models.DiscrepanciaCamposDistintos, &l, &m, difs, models.AccionPush, models.AccionPull))
+	}
+
+	for _, l := range pendientes {
+		i, ok := ix.porClaves(l)
+		if !ok {
+			c.discrepancias = append(c.discrepancias, nuevaDiscrepancia(entidad, models.DiscrepanciaFaltaEnMoodle, &l, nil, nil, models.AccionPush))
+			continue
+		}
+		m := ix.registros[i]
+		pares[l.id] = m.id
+		c.discrepancias = append(c.discrepancias, nuevaDiscrepancia(entidad, models.DiscrepanciaSinVincular, &l, &m, diferencias(l, m), models.AccionLink, models.AccionPush, models.AccionPull))
+	}
+	return pares
+}
+
+// desconocidas anota como solo_en_moodle las entidades del índice que no se emparejaron.
+func (c *conciliacion) desconocidas(ix *indiceMoodle) {
+	for i := range ix.registros {
+		if ix.usados[i] {
+			continue
+		}
+		m := ix.registros[i]
+		c.discrepancias = append(c.discrepancias, nuevaDiscrepancia(m.entidad, models.DiscrepanciaSoloEnMoodle, nil, &m, nil, models.AccionLink))
+	}
+}
+
+func diferencias(l, m registroConciliado) []models.DiferenciaCampo {
+	var difs []models.DiferenciaCampo
+	for i, campo := range l.campos {
+		moodleValor := m.campos[i].valor
+		if strings.TrimSpace(campo.valor) != strings.TrimSpace(moodleValor) {
+			difs = append(difs, models.DiferenciaCampo{Campo: campo.nombre, Local: campo.valor, Moodle: moodleValor})
+		}
+	}
+	return difs
+}
+
+func nuevaDiscrepancia(entidad, tipo string, l, m *registroConciliado, difs []models.DiferenciaCampo, acciones ...string) models.Discrepancia {
+	d := models.Discrepancia{Entidad: entidad, Tipo: tipo, Diferencias: difs, Acciones: acciones, Estado: models.DiscrepanciaAbierta}
+	if l != nil {
+		id := l.id
+		d.EntidadID = &id
+		d.Nombre = l.nombre
+	}
+	if m != nil {
+		id := m.id
+		d.MoodleID = &id
+		if d.Nombre == "" {
+			d.Nombre = m.nombre
+		}
+	}
+	return d
+}
+
+// describir resume la discrepancia para el detalle del trabajo.
+func describir(d models.Discrepancia) string {
+	msg := fmt.Sprintf("%s: %s", d.Tipo, d.Nombre)
+	if d.MoodleID != nil {
+		msg += fmt.Sprintf(" (Moodle ID: %d)", *d.MoodleID)
+	}
+	if len(d.Diferencias) > 0 {
+		campos := make([]string, len(d.Diferencias))
+		for i, dif := range d.Diferencias {
+			campos[i] = dif.Campo
+		}
+		msg += " — difieren: " + strings.Join(campos, ", ")
+	}
+	return msg
+}
+
+// --- Registros de cada entidad ---
+
+func camposCategoria(nombre, idNumber, descripcion string) []campoConciliado {
+	return []campoConciliado{{campoNombre, nombre}, {campoIDNumber, idNumber}, {campoDescripcion, descripcion}}
+}
+
+func programaConciliado(pe models.ProgramaEstudio) registroConciliado {
+	idNumber := safeString(pe.ID_Externo)
+	return registroConciliado{id: pe.ID, moodleID: pe.ID_Moodle, nombre: pe.Nombre, claves: []string{idNumber},
+		campos: camposCategoria(pe.Nombre, idNumber, safeString(pe.Descripcion))}
+}
+
+func cuatrimestreConciliado(c models.Cuatrimestre) registroConciliado {
+	idNumber := safeString(c.ID_Externo)
+	return registroConciliado{id: c.ID, moodleID: c.ID_Moodle, nombre: c.Nombre, claves: []string{idNumber},
+		campos: camposCategoria(c.Nombre, idNumber, safeString(c.Descripcion))}
+}
+
+// categoriaConciliada corresponde a un programa si está en la raíz y a un cuatrimestre si no, igual que
+// las crea la sincronización.
+func categoriaConciliada(c moodle.Category) registroConciliado {
+	entidad := models.OutboxCuatrimestre
+	if c.Parent == 0 {
+		entidad = models.OutboxProgramaEstudio
+	}
+	return registroConciliado{id: c.ID, entidad: entidad, nombre: c.Name, claves: []string{c.IDNumber},
+		campos: camposCategoria(c.Name, c.IDNumber, c.Description)}
+}
+
+func camposCurso(nombreCompleto, nombreCorto, idNumber, resumen string) []campoConciliado {
+	return []campoConciliado{{campoNombreCompleto, nombreCompleto}, {campoNombreCorto, nombreCorto}, {campoIDNumber, idNumber}, {campoResumen, resumen}}
+}
+
+func asignaturaConciliada(a models.Asignatura) registroConciliado {
+	idNumber := safeString(a.ID_Externo)
+	return registroConciliado{id: a.ID, moodleID: a.ID_Moodle, nombre: a.NombreCorto, claves: []string{idNumber, a.NombreCorto},
+		campos: camposCurso(a.NombreCompleto, a.NombreCorto, idNumber, safeString(a.Resumen))}
+}
+
+func cursoConciliado(c moodle.Course) registroConciliado {
+	return registroConciliado{id: c.ID, entidad: models.OutboxAsignatura, nombre: c.Shortname, claves: []string{c.IDNumber, c.Shortname},
+		campos: camposCurso(c.Fullname, c.Shortname, c.IDNumber, c.Summary)}
+}
+
+func camposUsuario(username, nombre, apellidos, email, idNumber string) []campoConciliado {
+	return []campoConciliado{{campoUsername, username}, {campoNombre, nombre}, {campoApellidos, apellidos}, {campoEmail, email}, {campoIDNumber, idNumber}}
+}
+
+// usuarioConciliado compara el username en minúsculas, como lo guarda Moodle.
+func usuarioConciliado(u models.Usuario) registroConciliado {
+	username := strings.ToLower(u.Username)
+	idNumber := safeString(u.Matricula)
+	return registroConciliado{id: u.ID, moodleID: u.ID_Moodle, nombre: u.Username, claves: []string{idNumber, username},
+		campos: camposUsuario(username, u.FirstName, u.LastName, u.Email, idNumber)}
+}
+
+func usuarioMoodleConciliado(u moodle.User) registroConciliado {
+	return registroConciliado{id: u.ID, entidad: models.OutboxUsuario, nombre: u.Username, claves: []string{u.IDNumber, u.Username},
+		campos: camposUsuario(u.Username, u.Firstname, u.Lastname, u.Email, u.IDNumber)}
+}
+
+// Los grupos se emparejan por su idnumber, pero no se compara: se deriva del ID local y del nombre, así
+// que un cambio de nombre ya aparece como diferencia en el nombre.
+func grupoConciliado(g models.Grupo) registroConciliado {
+	return registroConciliado{id: g.ID, moodleID: g.ID_Moodle, nombre: g.Nombre, claves: []string{grupoIDNumber(g)},
+		campos: []campoConciliado{{campoNombre, g.Nombre}, {campoDescripcion, g.Description}}}
+}
+
+func grupoMoodleConciliado(g moodle.GroupResponse) registroConciliado {
+	return registroConciliado{id: uint(g.ID), entidad: models.OutboxGrupo, nombre: g.Name, claves: []string{g.IDNumber},
+		campos: []campoConciliado{{campoNombre, g.Name}, {campoDescripcion, g.Description}}}
+}
+
+// --- Servicio ---
+
+// ConciliacionService compara la BD local con Moodle, guarda las diferencias como discrepancias y las
+// resuelve enviando los datos locales, copiando los de Moodle o vinculando los registros.
+type ConciliacionService struct {
+	Repo          *repository.DiscrepanciaRepository
+	MoodleClient  moodle.MoodleAPI
+	Programas     *ProgramaEstudioService
+	Cuatrimestres *CuatrimestreService
+	Asignaturas   *AsignaturaService
+	Usuarios      *UsuarioService
+	Grupos        *GrupoService
+	Outbox        *OutboxService
+}
+
+func NewConciliacionService(repo *repository.DiscrepanciaRepository, moodleClient moodle.MoodleAPI, pe *ProgramaEstudioService, c *CuatrimestreService, a *AsignaturaService, u *UsuarioService, g *GrupoService, outbox *OutboxService) *ConciliacionService {
+	return &ConciliacionService{Repo: repo, MoodleClient: moodleClient, Programas: pe, Cuatrimestres: c, Asignaturas: a, Usuarios: u, Grupos: g, Outbox: outbox}
+}
+
+// Conciliar es la tarea del trabajo de conciliación. Lee de Moodle categorías, cursos, usuarios y los
+// grupos de los cursos que corresponden a una asignatura, los empareja con los registros locales y guarda
+// las discrepancias (las abiertas de conciliaciones anteriores pasan a obsoletas). En el trabajo, cada
+// registro sin diferencias cuenta como exitoso y cada discrepancia como error con su descripción.
+func (s *ConciliacionService) Conciliar(ctx context.Context, progress *SyncProgress) error {
+	log.Println("Iniciando conciliación de la BD local con Moodle...")
+
+	categorias, err := moodle.GetCategories(ctx, s.MoodleClient)
+	if err != nil {
+		return err
+	}
+	cursos, err := moodle.GetCoursesByField(ctx, s.MoodleClient, "", "")
+	if err != nil {
+		return err
+	}
+
+	programas, err := s.Programas.Repo.GetAll()
+	if err != nil {
+		return fmt.Errorf("no se pudieron obtener los programas de estudio: %w", err)
+	}
+	cuatrimestres, err := s.Cuatrimestres.Repo.GetAll()
+	if err != nil {
+		return fmt.Errorf("no se pudieron obtener los cuatrimestres: %w", err)
+	}
+	asignaturas, err := s.Asignaturas.Repo.GetAll()
+	if err != nil {
+		return fmt.Errorf("no se pudieron obtener las asignaturas: %w", err)
+	}
+	usuarios, err := s.Usuarios.Repo.GetAll()
+	if err != nil {
+		return fmt.Errorf("no se pudieron obtener los usuarios: %w", err)
+	}
+	grupos, err := s.Grupos.Repo.GetAll()
+	if err != nil {
+		return fmt.Errorf("no se pudieron obtener los grupos: %w", err)
+	}
+	usuariosMoodle, todos, err := s.usuariosMoodle(ctx, usuarios)
+	if err != nil {
+		return err
+	}
+
+	var c conciliacion
+
+	// Categorías: programas y cuatrimestres comparten el índice
+	var regs []registroConciliado
+	for _, cat := range categorias {
+		regs = append(regs, categoriaConciliada(cat))
+	}
+	ixCategorias := nuevoIndiceMoodle(regs)
+	regs = nil
+	for _, pe := range programas {
+		regs = append(regs, programaConciliado(pe))
+	}
+	c.comparar(models.OutboxProgramaEstudio, regs, ixCategorias)
+	regs = nil
+	for _, cu := range cuatrimestres {
+		regs = append(regs, cuatrimestreConciliado(cu))
+	}
+	c.comparar(models.OutboxCuatrimestre, regs, ixCategorias)
+	c.desconocidas(ixCategorias)
+
+	// Cursos (sin el curso del sitio, que no tiene categoría)
+	regs = nil
+	for _, curso := range cursos {
+		if curso.CategoryID != 0 {
+			regs = append(regs, cursoConciliado(curso))
+		}
+	}
+	ixCursos := nuevoIndiceMoodle(regs)
+	regs = nil
+	for _, a := range asignaturas {
+		regs = append(regs, asignaturaConciliada(a))
+	}
+	cursoDeAsignatura := c.comparar(models.OutboxAsignatura, regs, ixCursos)
+	c.desconocidas(ixCursos)
+
+	// Usuarios (sin la cuenta de invitado de Moodle)
+	regs = nil
+	for _, u := range usuariosMoodle {
+		if u.Username != "guest" {
+			regs = append(regs, usuarioMoodleConciliado(u))
+		}
+	}
+	ixUsuarios := nuevoIndiceMoodle(regs)
+	regs = nil
+	for _, u := range usuarios {
+		regs = append(regs, usuarioConciliado(u))
+	}
+	c.comparar(models.OutboxUsuario, regs, ixUsuarios)
+	if todos {
+		c.desconocidas(ixUsuarios)
+	}
+
+	// Grupos: solo los de las asignaturas que tienen curso en Moodle
+	regs = nil
+	for _, a := range asignaturas {
+		courseID, ok := cursoDeAsignatura[a.ID]
+		if !ok {
+			continue
+		}
+		gruposCurso, err := moodle.GetCourseGroups(ctx, s.MoodleClient, int(courseID))
+		if err != nil {
+			c.fallos = append(c.fallos, models.SyncJobError{Entidad: models.OutboxAsignatura, EntidadID: a.ID, Mensaje: err.Error()})
+			delete(cursoDeAsignatura, a.ID)
+			continue
+		}
+		for _, g := range gruposCurso {
+			regs = append(regs, grupoMoodleConciliado(g))
+		}
+	}
+	ixGrupos := nuevoIndiceMoodle(regs)
+	regs = nil
+	for _, g := range grupos {
+		if _, ok := cursoDeAsignatura[g.CourseID]; ok {
+			regs = append(regs, grupoConciliado(g))
+		}
+	}
+	c.comparar(models.OutboxGrupo, regs, ixGrupos)
+	c.desconocidas(ixGrupos)
+
+	return s.guardar(progress, &c)
+}
+
+// usuariosMoodle lee todos los usuarios de Moodle con core_user_get_users. Si la función no está
+// habilitada, consulta solo los que corresponden a usuarios locales (por ID y por username) y devuelve
+// todos=false: así no se pueden detectar los usuarios que solo existen en Moodle.
+func (s *ConciliacionService) usuariosMoodle(ctx context.Context, locales []models.Usuario) ([]moodle.User, bool, error) {
+	usuarios, err := moodle.GetAllUsers(ctx, s.MoodleClient)
+	if err == nil {
+		return usuarios, true, nil
+	}
+	if !errors.Is(err, moodle.ErrUnsupported) {
+		return nil, false, err
+	}
+	log.Println("⚠️ core_user_get_users no está habilitada: se concilian solo los usuarios locales (sin detectar los que solo están en Moodle).")
+
+	var ids, usernames []string
+	for _, u := range locales {
+		if u.ID_Moodle != nil {
+			ids = append(ids, fmt.Sprint(*u.ID_Moodle))
+		}
+		usernames = append(usernames, strings.ToLower(u.Username))
+	}
+	vistos := make(map[uint]bool)
+	for _, consulta := range []struct {
+		campo   string
+		valores []string
+	}{{"id", ids}, {"username", usernames}} {
+		if len(consulta.valores) == 0 {
+			continue
+		}
+		encontrados, err := moodle.GetUsersByField(ctx, s.MoodleClient, consulta.campo, consulta.valores...)
+		if err != nil {
+			return nil, false, err
+		}
+		for _, u := range encontrados {
+			if !vistos[u.ID] {
+				vistos[u.ID] = true
+				usuarios = append(usuarios, u)
+			}
+		}
+	}
+	return usuarios, false, nil
+}
+
+// guardar registra las discrepancias de la conciliación y anota el resultado en el trabajo.
+func (s *ConciliacionService) guardar(progress *SyncProgress, c *conciliacion) error {
+	jobID := progress.JobID()
+	if n, err := s.Repo.MarkOpenObsolete(jobID); err != nil {
+		log.Printf("⚠️ No se pudieron marcar como obsoletas las discrepancias anteriores: %v", err)
+	} else if n > 0 {
+		log.Printf("%d discrepancias abiertas de conciliaciones anteriores marcadas como obsoletas.", n)
+	}
+	for i := range c.discrepancias {
+		c.discrepancias[i].JobID = jobID
+	}
+	if err := s.Repo.CreateAll(c.discrepancias); err != nil {
+		return fmt.Errorf("no se pudieron guardar las discrepancias: %w", err)
+	}
+
+	progress.SetTotal(c.coincidentes + len(c.discrepancias) + len(c.fallos))
+	for i := 0; i < c.coincidentes; i++ {
+		progress.Success()
+	}
+	for _, d := range c.discrepancias {
+		var id uint
+		if d.EntidadID != nil {
+			id = *d.EntidadID
+		}
+		progress.Failure(d.Entidad, id, errors.New(describir(d)))
+	}
+	for _, f := range c.fallos {
+		progress.Failure(f.Entidad, f.EntidadID, errors.New(f.Mensaje))
+	}
+	log.Printf("✅ Conciliación finalizada: %d registros coinciden, %d discrepancias.", c.coincidentes, len(c.discrepancias))
+	return nil
+}
+
+// GetDiscrepancias devuelve las discrepancias filtradas por trabajo, entidad y estado.
+func (s *ConciliacionService) GetDiscrepancias(jobID uint, entidad, estado string, limit int) ([]models.Discrepancia, error) {
+	return s.Repo.GetAll(jobID, entidad, estado, limit)
+}
+
+// --- Resolución ---
+
+// vinculo indica cómo queda un registro al vincularlo con una entidad de Moodle.
+type vinculo int
+
+const (
+	vinculoEnviar vinculo = iota // dirty: el outbox envía los datos locales a Moodle
+	vinculoIgual                 // synced: Moodle ya tiene los datos locales
+	vinculoSoloID                // synced sin hash: solo se fija el ID, no se envía nada aunque los datos difieran
+)
+
+// conciliable aplica las acciones de resolución a los registros de una entidad. cerrar se ejecuta en
+// la misma transacción que guarda el registro y actualiza la discrepancia.
+type conciliable struct {
+	// modelo es el modelo de la entidad, para comprobar los ID de Moodle ya usados.
+	modelo interface{}
+	// vincular fija el ID de Moodle del registro (nil lo desvincula y lo deja pendiente) y su estado.
+	vincular func(id uint, moodleID *uint, v vinculo, cerrar func(tx *gorm.DB) error) error
+	// traer copia en el registro los valores de Moodle, lo vincula y lo deja synced.
+	traer func(id, moodleID uint, difs []models.DiferenciaCampo, cerrar func(tx *gorm.DB) error) error
+}
+
+// ajustarVinculo fija el ID de Moodle y el estado de sincronización de un registro.
+func ajustarVinculo(idMoodle **uint, state *models.SyncState, moodleID *uint, v vinculo, hash func() string) {
+	*idMoodle = moodleID
+	switch {
+	case moodleID == nil:
+		*state = models.SyncState{SyncStatus: models.SyncPending}
+	case v == vinculoIgual:
+		state.MarkSynced(hash())
+	case v == vinculoSoloID:
+		// Sin hash, el próximo cambio local lo deja dirty y la próxima conciliación muestra lo que difiera.
+		*state = models.SyncState{SyncStatus: models.SyncSynced}
+	default:
+		state.SyncStatus = models.SyncDirty
+	}
+}
+
+// registrar guarda un registro conciliado a través del outbox, como cualquier otro cambio local, y
+// actualiza la discrepancia en la misma transacción. Si no quedó synced (dirty, o pendiente tras
+// desvincularlo) registra además un evento update para que el despachador lleve sus datos a Moodle.
+func (s *ConciliacionService) registrar(entidad string, id uint, state models.SyncState, cerrar, update func(tx *gorm.DB) error) error {
+	return s.Outbox.Record(func(tx *gorm.DB) (*models.OutboxEvent, error) {
+		if err := update(tx); err != nil {
+			return nil, err
+		}
+		if err := cerrar(tx); err != nil {
+			return nil, fmt.Errorf("no se pudo actualizar la discrepancia: %w", err)
+		}
+		if state.SyncStatus == models.SyncSynced {
+			return nil, nil
+		}
+		return models.NewOutboxEvent(entidad, id, models.OutboxUpdate), nil
+	})
+}
+
+// comprobarVinculo rechaza vincular el registro local id con moodleID si otro registro local ya tiene
+// ese ID de Moodle o, con mismoVinculo, si el registro ya está vinculado con otra entidad de Moodle.
+func (s *ConciliacionService) comprobarVinculo(modelo interface{}, id, moodleID uint, mismoVinculo bool) error {
+	var otros int64
+	if err := s.Repo.DB.Unscoped().Model(modelo).Where("id_moodle = ? AND id <> ?", moodleID, id).Count(&otros).Error; err != nil {
+		return fmt.Errorf("error al comprobar el ID de Moodle %d: %w", moodleID, err)
+	}
+	if otros > 0 {
+		return fmt.Errorf("%w: otro registro local ya está vinculado con el ID de Moodle %d", ErrAccionNoPermitida, moodleID)
+	}
+	if !mismoVinculo {
+		return nil
+	}
+	var actual struct {
+		IDMoodle *uint `gorm:"column:id_moodle"`
+	}
+	if err := s.Repo.DB.Model(modelo).Select("id_moodle").Where("id = ?", id).Scan(&actual).Error; err != nil {
+		return fmt.Errorf("error al leer el registro local %d: %w", id, err)
+	}
+	if actual.IDMoodle != nil && *actual.IDMoodle != moodleID {
+		return fmt.Errorf("%w: el registro local %d ya está vinculado con el ID de Moodle %d", ErrAccionNoPermitida, id, *actual.IDMoodle)
+	}
+	return nil
+}
+
+// optionalString convierte un valor de Moodle en un campo local opcional (vacío = nil).
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+func (s *ConciliacionService) conciliable(entidad string) (conciliable, bool) {
+	switch entidad {
+	case models.OutboxProgramaEstudio:
+		return conciliable{
+			modelo: &models.ProgramaEstudio{},
+			vincular: func(id uint, moodleID *uint, v vinculo, cerrar func(tx *gorm.DB) error) error {
+				pe, err := s.Programas.GetByID(id)
+				if err != nil {
+					return fmt.Errorf("PE no encontrado en BD local: %w", err)
+				}
+				ajustarVinculo(&pe.ID_Moodle, &pe.SyncState, moodleID, v, func() string { return programaHash(pe) })
+				return s.registrar(models.OutboxProgramaEstudio, pe.ID, pe.SyncState, cerrar, func(tx *gorm.DB) error { return s.Programas.Repo.WithTx(tx).Update(&pe) })
+			},
+			traer: func(id, moodleID uint, difs []models.DiferenciaCampo, cerrar func(tx *gorm.DB) error) error {
+				pe, err := s.Programas.GetByID(id)
+				if err != nil {
+					return fmt.Errorf("PE no encontrado en BD local: %w", err)
+				}
+				for _, d := range difs {
+					switch d.Campo {
+					case campoNombre:
+						pe.Nombre = d.Moodle
+					case campoIDNumber:
+						pe.ID_Externo = optionalString(d.Moodle)
+					case campoDescripcion:
+						pe.Descripcion = optionalString(d.Moodle)
+					}
+				}
+				ajustarVinculo(&pe.ID_Moodle, &pe.SyncState, &moodleID, vinculoIgual, func() string { return programaHash(pe) })
+				return s.registrar(models.OutboxProgramaEstudio, pe.ID, pe.SyncState, cerrar, func(tx *gorm.DB) error { return s.Programas.Repo.WithTx(tx).Update(&pe) })
+			},
+		}, true
+
+	case models.OutboxCuatrimestre:
+		return conciliable{
+			modelo: &models.Cuatrimestre{},
+			vincular: func(id uint, moodleID *uint, v vinculo, cerrar func(tx *gorm.DB) error) error {
+				c, err := s.Cuatrimestres.GetByID(id)
+				if err != nil {
+					return fmt.Errorf("cuatrimestre no encontrado en BD local: %w", err)
+				}
+				ajustarVinculo(&c.ID_Moodle, &c.SyncState, moodleID, v, func() string { return cuatrimestreHash(c) })
+				return s.registrar(models.OutboxCuatrimestre, c.ID, c.SyncState, cerrar, func(tx *gorm.DB) error { return s.Cuatrimestres.Repo.WithTx(tx).Update(&c) })
+			},
+			traer: func(id, moodleID uint, difs []models.DiferenciaCampo, cerrar func(tx *gorm.DB) error) error {
+				c, err := s.Cuatrimestres.GetByID(id)
+				if err != nil {
+					return fmt.Errorf("cuatrimestre no encontrado en BD local: %w", err)
+				}
+				for _, d := range difs {
+					switch d.Campo {
+					case campoNombre:
+						c.Nombre = d.Moodle
+					case campoIDNumber:
+						c.ID_Externo = optionalString(d.Moodle)
+					case campoDescripcion:
+						c.Descripcion = optionalString(d.Moodle)
+					}
+				}
+				ajustarVinculo(&c.ID_Moodle, &c.SyncState, &moodleID, vinculoIgual, func() string { return cuatrimestreHash(c) })
+				return s.registrar(models.OutboxCuatrimestre, c.ID, c.SyncState, cerrar, func(tx *gorm.DB) error { return s.Cuatrimestres.Repo.WithTx(tx).Update(&c) })
+			},
+		}, true
+
+	case models.OutboxAsignatura:
+		return conciliable{
+			modelo: &models.Asignatura{},
+			vincular: func(id uint, moodleID *uint, v vinculo, cerrar func(tx *gorm.DB) error) error {
+				a, err := s.Asignaturas.GetByID(id)
+				if err != nil {
+					return fmt.Errorf("asignatura no encontrada en BD local: %w", err)
+				}
+				ajustarVinculo(&a.ID_Moodle, &a.SyncState, moodleID, v, func() string { return asignaturaHash(a) })
+				return s.registrar(models.OutboxAsignatura, a.ID, a.SyncState, cerrar, func(tx *gorm.DB) error { return s.Asignaturas.Repo.WithTx(tx).Update(&a) })
+			},
+			traer: func(id, moodleID uint, difs []models.DiferenciaCampo, cerrar func(tx *gorm.DB) error) error {
+				a, err := s.Asignaturas.GetByID(id)
+				if err != nil {
+					return fmt.Errorf("asignatura no encontrada en BD local: %w", err)
+				}
+				for _, d := range difs {
+					switch d.Campo {
+					case campoNombreCompleto:
+						a.NombreCompleto = d.Moodle
+					case campoNombreCorto:
+						a.NombreCorto = d.Moodle
+					case campoIDNumber:
+						a.ID_Externo = optionalString(d.Moodle)
+					case campoResumen:
+						a.Resumen = optionalString(d.Moodle)
+					}
+				}
+				ajustarVinculo(&a.ID_Moodle, &a.SyncState, &moodleID, vinculoIgual, func() string { return asignaturaHash(a) })
+				return s.registrar(models.OutboxAsignatura, a.ID, a.SyncState, cerrar, func(tx *gorm.DB) error { return s.Asignaturas.Repo.WithTx(tx).Update(&a) })
+			},
+		}, true
+
+	case models.OutboxUsuario:
+		return conciliable{
+			modelo: &models.Usuario{},
+			vincular: func(id uint, moodleID *uint, v vinculo, cerrar func(tx *gorm.DB) error) error {
+				u, err := s.Usuarios.GetByID(id)
+				if err != nil {
+					return fmt.Errorf("usuario (ID: %d) no encontrado en BD local: %w", id, err)
+				}
+				ajustarVinculo(&u.ID_Moodle, &u.SyncState, moodleID, v, func() string { return usuarioHash(u) })
+				return s.registrar(models.OutboxUsuario, u.ID, u.SyncState, cerrar, func(tx *gorm.DB) error { return s.Usuarios.Repo.WithTx(tx).Update(&u) })
+			},
+			traer: func(id, moodleID uint, difs []models.DiferenciaCampo, cerrar func(tx *gorm.DB) error) error {
+				u, err := s.Usuarios.GetByID(id)
+				if err != nil {
+					return fmt.Errorf("usuario (ID: %d) no encontrado en BD local: %w", id, err)
+				}
+				for _, d := range difs {
+					switch d.Campo {
+					case campoUsername:
+						u.Username = d.Moodle
+					case campoNombre:
+						u.FirstName = d.Moodle
+					case campoApellidos:
+						u.LastName = d.Moodle
+					case campoEmail:
+						u.Email = d.Moodle
+					case campoIDNumber:
+						u.Matricula = optionalString(d.Moodle)
+					}
+				}
+				ajustarVinculo(&u.ID_Moodle, &u.SyncState, &moodleID, vinculoIgual, func() string { return usuarioHash(u) })
+				return s.registrar(models.OutboxUsuario, u.ID, u.SyncState, cerrar, func(tx *gorm.DB) error { return s.Usuarios.Repo.WithTx(tx).Update(&u) })
+			},
+		}, true
+
+	case models.OutboxGrupo:
+		return conciliable{
+			modelo: &models.Grupo{},
+			vincular: func(id uint, moodleID *uint, v vinculo, cerrar func(tx *gorm.DB) error) error {
+				g, err := s.Grupos.GetByID(id)
+				if err != nil {
+					return fmt.Errorf("grupo (ID: %d) no encontrado en BD local: %w", id, err)
+				}
+				ajustarVinculo(&g.ID_Moodle, &g.SyncState, moodleID, v, func() string { return grupoHash(g) })
+				return s.registrar(models.OutboxGrupo, g.ID, g.SyncState, cerrar, func(tx *gorm.DB) error { return s.Grupos.Repo.WithTx(tx).Update(&g) })
+			},
+			traer: func(id, moodleID uint, difs []models.DiferenciaCampo, cerrar func(tx *gorm.DB) error) error {
+				g, err := s.Grupos.GetByID(id)
+				if err != nil {
+					return fmt.Errorf("grupo (ID: %d) no encontrado en BD local: %w", id, err)
+				}
+				for _, d := range difs {
+					switch d.Campo {
+					case campoNombre:
+						g.Nombre = d.Moodle
+					case campoDescripcion:
+						g.Description = d.Moodle
+					}
+				}
+				ajustarVinculo(&g.ID_Moodle, &g.SyncState, &moodleID, vinculoIgual, func() string { return grupoHash(g) })
+				return s.registrar(models.OutboxGrupo, g.ID, g.SyncState, cerrar, func(tx *gorm.DB) error { return s.Grupos.Repo.WithTx(tx).Update(&g) })
+			},
+		}, true
+	}
+	return conciliable{}, false
+}
+
+// Resolver aplica una acción a una discrepancia abierta y la marca resuelta en la misma transacción
+// en que se guarda el registro:
+//   - link: solo fija el ID de Moodle del registro local (en solo_en_moodle hay que indicarlo con
+//     entidadID); no envía nada. Si los datos difieren, la discrepancia sigue abierta como
+//     campos_distintos, con push y pull. Se rechaza si otro registro ya tiene ese ID de Moodle o, en solo_en_moodle, si
+//     el registro ya está vinculado con otra entidad.
+//   - push: deja el registro pendiente de enviar (vinculándolo antes si hace falta) y el outbox lleva
+//     sus datos a Moodle. Si la entidad vinculada ya no existe en Moodle, se crea de nuevo.
+//   - pull: copia en local los valores de Moodle de los campos que difieren y deja el registro synced.
+//
+// Como cualquier cambio local, se guarda a través del outbox.
+func (s *ConciliacionService) Resolver(id uint, accion string, entidadID uint) (models.Discrepancia, error) {
+	d, err := s.Repo.GetByID(id)
+	if err != nil {
+		return d, fmt.Errorf("discrepancia %d no encontrada: %w", id, err)
+	}
+	if d.Estado != models.DiscrepanciaAbierta {
+		return d, fmt.Errorf("%w: la discrepancia %d está %s", ErrDiscrepanciaCerrada, id, d.Estado)
+	}
+	if !d.Permite(accion) {
+		return d, fmt.Errorf("%w: %s no es posible en una discrepancia %s (disponibles: %s)", ErrAccionNoPermitida, accion, d.Tipo, strings.Join(d.Acciones, ", "))
+	}
+	e, ok := s.conciliable(d.Entidad)
+	if !ok {
+		return d, fmt.Errorf("entidad desconocida %q", d.Entidad)
+	}
+
+	localID := entidadID
+	if d.EntidadID != nil {
+		localID = *d.EntidadID
+	}
+	if localID == 0 {
+		return d, ErrEntidadIDRequerido
+	}
+	if d.MoodleID != nil {
+		if err := s.comprobarVinculo(e.modelo, localID, *d.MoodleID, d.Tipo == models.DiscrepanciaSoloEnMoodle); err != nil {
+			return d, err
+		}
+	}
+
+	resuelta := time.Now()
+	cerrada := d
+	cerrada.EntidadID = &localID
+	if accion == models.AccionLink && len(d.Diferencias) > 0 {
+		// Ya vinculados, las diferencias siguen pendientes de resolver con push o pull.
+		cerrada.Tipo = models.DiscrepanciaCamposDistintos
+		cerrada.Acciones = []string{models.AccionPush, models.AccionPull}
+	} else {
+		cerrada.Estado = models.DiscrepanciaResuelta
+		cerrada.Accion = &accion
+		cerrada.ResueltaEn = &resuelta
+	}
+	cerrar := func(tx *gorm.DB) error { return s.Repo.WithTx(tx).Update(&cerrada) }
+
+	switch accion {
+	case models.AccionLink:
+		v := vinculoSoloID
+		if d.Tipo == models.DiscrepanciaSinVincular && len(d.Diferencias) == 0 {
+			v = vinculoIgual
+		}
+		err = e.vincular(localID, d.MoodleID, v, cerrar)
+	case models.AccionPull:
+		err = e.traer(localID, *d.MoodleID, d.Diferencias, cerrar)
+	case models.AccionPush:
+		// Se desvincula si la entidad ya no existe en Moodle; si no, se vincula como dirty para que
+		// el despachador actualice en lugar de crear o saltarse el registro.
+		err = e.vincular(localID, d.MoodleID, vinculoEnviar, cerrar)
+	}
+	if err != nil {
+		return d, err
+	}
+	if cerrada.Estado == models.DiscrepanciaAbierta {
+		log.Printf("🔗 Discrepancia %d (%s %s) vinculada; quedan %d campos distintos.", cerrada.ID, cerrada.Entidad, cerrada.Nombre, len(cerrada.Diferencias))
+	} else {
+		log.Printf("✅ Discrepancia %d (%s %s) resuelta con %s.", cerrada.ID, cerrada.Entidad, cerrada.Nombre, accion)
+	}
+	return cerrada, nil
+}
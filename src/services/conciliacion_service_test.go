@@ -0,0 +1,138 @@
+package services
+
+import (
+	"api_concurrencia/src/models"
+	"errors"
+	"testing"
+)
+
+func uintPtr(n uint) *uint { return &n }
+
+// Un ID_Moodle existente gana a una coincidencia por clave aunque el registro que coincide por
+// clave se compare antes: el vínculo no se lo roba otro registro.
+func TestCompararEmparejaPrimeroPorID(t *testing.T) {
+	campos := func(nombre string) []campoConciliado { return []campoConciliado{{campoNombre, nombre}} }
+	locales := []registroConciliado{
+		{id: 1, nombre: "Nuevo", claves: []string{"ING"}, campos: campos("Nuevo")},
+		{id: 2, moodleID: uintPtr(90), nombre: "Ingeniería", claves: []string{"ING-OLD"}, campos: campos("Ingeniería")},
+		{id: 3, nombre: "Medicina", claves: []string{"MED"}, campos: campos("Medicina")},
+	}
+	ix := nuevoIndiceMoodle([]registroConciliado{
+		{id: 90, entidad: models.OutboxProgramaEstudio, nombre: "Ingeniería", claves: []string{"ING"}, campos: campos("Ingeniería")},
+		{id: 91, entidad: models.OutboxProgramaEstudio, nombre: "Medicina (2025)", claves: []string{"MED"}, campos: campos("Medicina (2025)")},
+		{id: 92, entidad: models.OutboxProgramaEstudio, nombre: "Huérfana", campos: campos("Huérfana")},
+	})
+
+	var c conciliacion
+	pares := c.comparar(models.OutboxProgramaEstudio, locales, ix)
+	c.desconocidas(ix)
+
+	if pares[2] != 90 || pares[3] != 91 {
+		t.Errorf("pares = %v, se esperaba 2→90 y 3→91", pares)
+	}
+	if _, ok := pares[1]; ok {
+		t.Errorf("el registro 1 no debería emparejarse con la entidad ya vinculada al 2: %v", pares)
+	}
+	if c.coincidentes != 1 {
+		t.Errorf("coincidentes = %d, se esperaba 1 (el vinculado por ID sin diferencias)", c.coincidentes)
+	}
+	tipos := map[string]string{}
+	for _, d := range c.discrepancias {
+		tipos[d.Nombre] = d.Tipo
+	}
+	want := map[string]string{
+		"Nuevo":    models.DiscrepanciaFaltaEnMoodle,
+		"Medicina": models.DiscrepanciaSinVincular,
+		"Huérfana": models.DiscrepanciaSoloEnMoodle,
+	}
+	if len(tipos) != len(want) {
+		t.Fatalf("discrepancias = %v, se esperaban %v", tipos, want)
+	}
+	for nombre, tipo := range want {
+		if tipos[nombre] != tipo {
+			t.Errorf("%s: %s, se esperaba %s", nombre, tipos[nombre], tipo)
+		}
+	}
+}
+
+func TestResolverDiscrepancia(t *testing.T) {
+	svc, _, db := newTestServices(t)
+	if err := db.AutoMigrate(&models.Discrepancia{}); err != nil {
+		t.Fatal(err)
+	}
+	programa := func(nombre string, moodleID *uint) models.ProgramaEstudio {
+		t.Helper()
+		pe := models.ProgramaEstudio{Nombre: nombre, ID_Moodle: moodleID}
+		if err := db.Create(&pe).Error; err != nil {
+			t.Fatal(err)
+		}
+		return pe
+	}
+	abrir := func(d models.Discrepancia) models.Discrepancia {
+		t.Helper()
+		d.Entidad, d.Estado = models.OutboxProgramaEstudio, models.DiscrepanciaAbierta
+		if err := db.Create(&d).Error; err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	dif := []models.DiferenciaCampo{{Campo: campoNombre, Local: "Medicina", Moodle: "Medicina (2025)"}}
+
+	t.Run("link con diferencias no envía nada y las deja abiertas", func(t *testing.T) {
+		pe := programa("Medicina", nil)
+		d := abrir(models.Discrepancia{Tipo: models.DiscrepanciaSinVincular, EntidadID: &pe.ID, MoodleID: uintPtr(91), Diferencias: dif,
+			Acciones: []string{models.AccionLink, models.AccionPush, models.AccionPull}})
+
+		got, err := svc.Conciliacion.Resolver(d.ID, models.AccionLink, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Estado != models.DiscrepanciaAbierta || got.Tipo != models.DiscrepanciaCamposDistintos || got.Permite(models.AccionLink) {
+			t.Errorf("discrepancia = %s/%s %v, se esperaba abierta como campos_distintos con push y pull", got.Estado, got.Tipo, got.Acciones)
+		}
+		stored, _ := svc.Programas.GetByID(pe.ID)
+		if stored.ID_Moodle == nil || *stored.ID_Moodle != 91 || stored.SyncStatus != models.SyncSynced {
+			t.Errorf("programa = %v/%s, se esperaba vinculado a 91 y synced", stored.ID_Moodle, stored.SyncStatus)
+		}
+		if events := eventsOf(t, svc, models.OutboxProgramaEstudio, pe.ID); len(events) != 0 {
+			t.Errorf("link registró %d eventos: no debe enviar los datos locales", len(events))
+		}
+
+		// Lo que queda abierto se resuelve con pull, en la misma transacción que el registro.
+		got, err = svc.Conciliacion.Resolver(d.ID, models.AccionPull, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored, _ = svc.Programas.GetByID(pe.ID)
+		persisted, _ := svc.Conciliacion.Repo.GetByID(d.ID)
+		if stored.Nombre != "Medicina (2025)" || persisted.Estado != models.DiscrepanciaResuelta || *persisted.Accion != models.AccionPull {
+			t.Errorf("tras pull: nombre %q, discrepancia %s; se esperaba el nombre de Moodle y resuelta con pull", stored.Nombre, persisted.Estado)
+		}
+	})
+
+	t.Run("solo_en_moodle rechaza vínculos ocupados", func(t *testing.T) {
+		vinculado := programa("Derecho", uintPtr(95))
+		libre := programa("Arquitectura", nil)
+		programa("Ocupa el 96", uintPtr(96))
+
+		ajena := abrir(models.Discrepancia{Tipo: models.DiscrepanciaSoloEnMoodle, MoodleID: uintPtr(97), Acciones: []string{models.AccionLink}})
+		if _, err := svc.Conciliacion.Resolver(ajena.ID, models.AccionLink, vinculado.ID); !errors.Is(err, ErrAccionNoPermitida) {
+			t.Errorf("vincular un registro ya vinculado a otra entidad = %v, se esperaba ErrAccionNoPermitida", err)
+		}
+		repetida := abrir(models.Discrepancia{Tipo: models.DiscrepanciaSoloEnMoodle, MoodleID: uintPtr(96), Acciones: []string{models.AccionLink}})
+		if _, err := svc.Conciliacion.Resolver(repetida.ID, models.AccionLink, libre.ID); !errors.Is(err, ErrAccionNoPermitida) {
+			t.Errorf("vincular un ID de Moodle que ya tiene otro registro = %v, se esperaba ErrAccionNoPermitida", err)
+		}
+		if persisted, _ := svc.Conciliacion.Repo.GetByID(repetida.ID); persisted.Estado != models.DiscrepanciaAbierta {
+			t.Errorf("una resolución rechazada dejó la discrepancia %s", persisted.Estado)
+		}
+
+		got, err := svc.Conciliacion.Resolver(ajena.ID, models.AccionLink, libre.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Estado != models.DiscrepanciaResuelta || got.EntidadID == nil || *got.EntidadID != libre.ID {
+			t.Errorf("discrepancia = %+v, se esperaba resuelta con el registro %d", got, libre.ID)
+		}
+	})
+}
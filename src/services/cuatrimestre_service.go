@@ -4,28 +4,41 @@ import (
 	"api_concurrencia/src/models"
 	"api_concurrencia/src/moodle"
 	"api_concurrencia/src/repository"
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"strings"
 	"unicode/utf8"
+
+	"gorm.io/gorm"
 )
 
 type CuatrimestreService struct {
 	Repo         *repository.CuatrimestreRepository
-	MoodleClient *moodle.Client
+	MoodleClient moodle.MoodleAPI
+	Outbox       *OutboxService
+	Roles        *RolMoodleService // Indica qué roles son de alumno (miembros de la cohorte)
+	// Asignaturas del cuatrimestre, cuyos cursos toman de él sus valores por defecto
+	AsignaturaRepo *repository.AsignaturaRepository
 }
 
-func NewCuatrimestreService(repo *repository.CuatrimestreRepository, moodleClient *moodle.Client) *CuatrimestreService {
-	return &CuatrimestreService{Repo: repo, MoodleClient: moodleClient}
+func NewCuatrimestreService(repo *repository.CuatrimestreRepository, moodleClient moodle.MoodleAPI, outbox *OutboxService, roles *RolMoodleService, asignaturaRepo *repository.AsignaturaRepository) *CuatrimestreService {
+	return &CuatrimestreService{Repo: repo, MoodleClient: moodleClient, Outbox: outbox, Roles: roles, AsignaturaRepo: asignaturaRepo}
 }
 
-// CreateLocal crea el registro en la BD local.
+// CreateLocal crea el registro en la BD local y registra su creación en el outbox.
 func (s *CuatrimestreService) CreateLocal(c *models.Cuatrimestre) error {
 	if err := s.validateCuatrimestre(c); err != nil {
 		return err
 	}
-	return s.Repo.Create(c)
+	c.SyncState = models.SyncState{}
+	return s.Outbox.Record(func(tx *gorm.DB) (*models.OutboxEvent, error) {
+		if err := s.Repo.WithTx(tx).Create(c); err != nil {
+			return nil, err
+		}
+		return models.NewOutboxEvent(models.OutboxCuatrimestre, c.ID, models.OutboxCreate), nil
+	})
 }
 
 // GetAll recupera todos los Cuatrimestres.
@@ -38,7 +51,7 @@ func (s *CuatrimestreService) GetByID(id uint) (models.Cuatrimestre, error) {
 	return s.Repo.GetByID(id)
 }
 
-// UpdateLocal actualiza el registro en la BD local.
+// UpdateLocal actualiza el registro en la BD local y registra el cambio en el outbox.
 func (s *CuatrimestreService) UpdateLocal(c *models.Cuatrimestre) error {
 	if c.ID == 0 {
 		return errors.New("ID de Cuatrimestre inválido")
@@ -46,19 +59,94 @@ func (s *CuatrimestreService) UpdateLocal(c *models.Cuatrimestre) error {
 	if err := s.validateCuatrimestre(c); err != nil {
 		return err
 	}
-	return s.Repo.Update(c)
+	current, err := s.Repo.GetByID(c.ID)
+	if err != nil {
+		return fmt.Errorf("cuatrimestre no encontrado en BD local: %w", err)
+	}
+	// El estado de sincronización lo mantiene el servicio: se ignora el que venga en la petición.
+	c.SyncState = current.SyncState
+	c.MarkDirty(cuatrimestreHash(*c))
+	return s.Outbox.RecordAll(func(tx *gorm.DB) ([]*models.OutboxEvent, error) {
+		if err := s.Repo.WithTx(tx).Update(c); err != nil {
+			return nil, err
+		}
+		events := []*models.OutboxEvent{models.NewOutboxEvent(models.OutboxCuatrimestre, c.ID, models.OutboxUpdate)}
+		heredados, err := s.marcarAsignaturas(tx, current, *c)
+		if err != nil {
+			return nil, err
+		}
+		return append(events, heredados...), nil
+	})
+}
+
+// marcarAsignaturas deja pendientes de actualizar en Moodle las asignaturas cuyo curso cambia con los
+// nuevos valores por defecto del cuatrimestre (fechas y formato que la asignatura no fija) y devuelve
+// sus eventos update.
+func (s *CuatrimestreService) marcarAsignaturas(tx *gorm.DB, antes, despues models.Cuatrimestre) ([]*models.OutboxEvent, error) {
+	asignaturas, err := s.AsignaturaRepo.WithTx(tx).GetByCuatrimestre(despues.ID)
+	if err != nil {
+		return nil, fmt.Errorf("no se pudieron obtener las asignaturas del cuatrimestre %d: %w", despues.ID, err)
+	}
+	var events []*models.OutboxEvent
+	for _, a := range asignaturas {
+		conAntes, conDespues := a, a
+		conAntes.Cuatrimestre, conDespues.Cuatrimestre = antes, despues
+		hash := asignaturaHash(conDespues)
+		if asignaturaHash(conAntes) == hash {
+			continue
+		}
+		a.MarkDirty(hash)
+		if err := s.AsignaturaRepo.WithTx(tx).Update(&a); err != nil {
+			return nil, fmt.Errorf("no se pudo marcar la asignatura %d para actualizarla: %w", a.ID, err)
+		}
+		if a.SyncStatus == models.SyncDirty {
+			events = append(events, models.NewOutboxEvent(models.OutboxAsignatura, a.ID, models.OutboxUpdate))
+		}
+	}
+	return events, nil
+}
+
+// syncFailed guarda el fallo de sincronización del cuatrimestre y devuelve err.
+func (s *CuatrimestreService) syncFailed(c *models.Cuatrimestre, err error) error {
+	return markSyncFailed(&c.SyncState, func() error { return s.Repo.Update(c) }, err)
 }
 
-// DeleteLocal elimina el registro en la BD local.
-func (s *CuatrimestreService) DeleteLocal(id uint) error {
+// Delete elimina el Cuatrimestre local y registra el borrado en el outbox. Si propagate es true y está
+// sincronizado, el despachador elimina después su subcategoría de Moodle. Si la subcategoría aún tiene
+// cursos devuelve ErrCategoryNotEmpty y el registro local se conserva.
+func (s *CuatrimestreService) Delete(ctx context.Context, id uint, propagate bool) error {
 	if id == 0 {
 		return errors.New("ID de Cuatrimestre inválido")
 	}
-	return s.Repo.Delete(id)
+	cuatrimestre, err := s.Repo.GetByID(id)
+	if err != nil {
+		return fmt.Errorf("cuatrimestre no encontrado en BD local: %w", err)
+	}
+	if propagate && cuatrimestre.ID_Moodle != nil {
+		if err := ensureCategoryEmpty(ctx, s.MoodleClient, *cuatrimestre.ID_Moodle); err != nil {
+			return err
+		}
+	}
+	return s.Outbox.Record(func(tx *gorm.DB) (*models.OutboxEvent, error) {
+		if err := s.Repo.WithTx(tx).Delete(id); err != nil {
+			return nil, err
+		}
+		return deleteEvent(models.OutboxCuatrimestre, id, cuatrimestre.ID_Moodle, propagate), nil
+	})
+}
+
+// DeleteInMoodle elimina la subcategoría de un cuatrimestre ya eliminado localmente. Si entretanto
+// recibió cursos devuelve ErrCategoryNotEmpty y el evento queda fallido.
+func (s *CuatrimestreService) DeleteInMoodle(ctx context.Context, moodleID uint) error {
+	if err := deleteCategoryInMoodle(ctx, s.MoodleClient, moodleID); err != nil {
+		return err
+	}
+	log.Printf("🗑️ Cuatrimestre eliminado de Moodle (Categoría ID: %d)", moodleID)
+	return nil
 }
 
 // SyncToMoodle simula la lógica de sincronización para Cuatrimestre.
-func (s *CuatrimestreService) SyncToMoodle(id uint) error {
+func (s *CuatrimestreService) SyncToMoodle(ctx context.Context, id uint) error {
 	cuatrimestre, err := s.Repo.GetByID(id)
 	if err != nil {
 		return fmt.Errorf("cuatrimestre no encontrado en BD local: %w", err)
@@ -66,13 +154,13 @@ func (s *CuatrimestreService) SyncToMoodle(id uint) error {
 
 	// 0. Validación Clave: El ProgramaEstudio padre debe estar sincronizado
 	if cuatrimestre.ProgramaEstudio.ID_Moodle == nil {
-		return fmt.Errorf("error: El ProgramaEstudio padre (ID: %d) no ha sido sincronizado con Moodle (ID_Moodle es nulo)", cuatrimestre.ProgramaEstudioID)
+		return s.syncFailed(&cuatrimestre, fmt.Errorf("error: El ProgramaEstudio padre (ID: %d) no ha sido sincronizado con Moodle: %w", cuatrimestre.ProgramaEstudioID, ErrParentNotSynced))
 	}
 
 	// Si ya tiene ID_Moodle, llamamos a UPDATE en lugar de CREATE
 	if cuatrimestre.ID_Moodle != nil {
 		log.Printf("Cuatrimestre ID %d ya sincronizado (Moodle ID: %d). Actualizando en Moodle...", id, *cuatrimestre.ID_Moodle)
-		return s.UpdateInMoodle(&cuatrimestre)
+		return s.UpdateInMoodle(ctx, &cuatrimestre)
 	}
 
 	// 1. Construir el array de datos para la función de Moodle
@@ -90,18 +178,28 @@ func (s *CuatrimestreService) SyncToMoodle(id uint) error {
 
 	// 2. Ejecutar la llamada a la API de Moodle
 	var response []moodle.CategoryResponse
-	err = s.MoodleClient.Call("core_course_create_categories", data, &response)
+	err = s.MoodleClient.Call(ctx, "core_course_create_categories", moodle.CreateCategoriesParams{Categories: data}, &response)
 	if err != nil {
-		return fmt.Errorf("fallo al crear subcategoría en Moodle: %w", err)
+		if !moodle.IsDuplicate(err) {
+			return s.syncFailed(&cuatrimestre, fmt.Errorf("fallo al crear subcategoría en Moodle: %w", err))
+		}
+		// El idnumber ya está usado por otra categoría: la vinculamos en lugar de fallar.
+		existing, lookupErr := moodle.FindCategoryByIDNumber(ctx, s.MoodleClient, safeString(cuatrimestre.ID_Externo))
+		if lookupErr != nil || existing == nil {
+			return s.syncFailed(&cuatrimestre, fmt.Errorf("la subcategoría ya existe en Moodle y no se pudo recuperar su ID: %w", err))
+		}
+		log.Printf("⚠️ Cuatrimestre '%s' ya existía en Moodle (Categoría ID: %d). Vinculando.", cuatrimestre.Nombre, existing.ID)
+		response = []moodle.CategoryResponse{{ID: existing.ID, Name: existing.Name, IDNumber: existing.IDNumber}}
 	}
 
 	// 3. Procesar la respuesta y actualizar el ID_Moodle local
 	if len(response) == 0 {
-		return fmt.Errorf("moodle no devolvió ninguna subcategoría creada")
+		return s.syncFailed(&cuatrimestre, fmt.Errorf("moodle no devolvió ninguna subcategoría creada"))
 	}
 
 	moodleID := response[0].ID
 	cuatrimestre.ID_Moodle = &moodleID
+	cuatrimestre.MarkSynced(cuatrimestreHash(cuatrimestre))
 
 	if err := s.Repo.Update(&cuatrimestre); err != nil {
 		return fmt.Errorf("falla al actualizar ID Moodle local para Cuatrimestre ID %d: %w", id, err)
@@ -111,8 +209,8 @@ func (s *CuatrimestreService) SyncToMoodle(id uint) error {
 	return nil
 }
 
-// UpdateInMoodle actualiza un cuatrimestre que ya existe en Moodle
-func (s *CuatrimestreService) UpdateInMoodle(cuatrimestre *models.Cuatrimestre) error {
+// UpdateInMoodle actualiza un cuatrimestre que ya existe en Moodle y guarda el resultado en su estado de sincronización.
+func (s *CuatrimestreService) UpdateInMoodle(ctx context.Context, cuatrimestre *models.Cuatrimestre) error {
 	if cuatrimestre.ID_Moodle == nil {
 		return fmt.Errorf("el cuatrimestre no tiene ID de Moodle, debe crearse primero")
 	}
@@ -127,89 +225,109 @@ func (s *CuatrimestreService) UpdateInMoodle(cuatrimestre *models.Cuatrimestre)
 	}
 
 	var response interface{}
-	err := s.MoodleClient.Call("core_course_update_categories", data, &response)
+	err := s.MoodleClient.Call(ctx, "core_course_update_categories", moodle.UpdateCategoriesParams{Categories: data}, &response)
 	if err != nil {
-		return fmt.Errorf("fallo al actualizar Cuatrimestre en Moodle: %w", err)
+		return s.syncFailed(cuatrimestre, fmt.Errorf("fallo al actualizar Cuatrimestre en Moodle: %w", err))
+	}
+
+	cuatrimestre.MarkSynced(cuatrimestreHash(*cuatrimestre))
+	if err := s.Repo.Update(cuatrimestre); err != nil {
+		return fmt.Errorf("cuatrimestre actualizado en Moodle, pero falló guardar el estado de sincronización: %w", err)
 	}
 
 	log.Printf(" Cuatrimestre '%s' (Moodle ID: %d) actualizado exitosamente en Moodle", cuatrimestre.Nombre, *cuatrimestre.ID_Moodle)
 	return nil
 }
 
-// BulkSyncToMoodle sincroniza masivamente todos los cuatrimestres no sincronizados
-func (s *CuatrimestreService) BulkSyncToMoodle() {
-	go func() {
-		cuatrimestres, err := s.Repo.GetUnsynced()
-		if err != nil {
-			log.Printf("ERROR: No se pudieron obtener cuatrimestres no sincronizados: %v", err)
-			return
-		}
+// BulkSyncToMoodle sincroniza masivamente todos los cuatrimestres no sincronizados y anota el resultado
+// de cada uno en progress. Si ctx se cancela, la llamada en curso se aborta y no se procesan más programas.
+func (s *CuatrimestreService) BulkSyncToMoodle(ctx context.Context, progress *SyncProgress) error {
+	cuatrimestres, err := s.Repo.GetUnsynced()
+	if err != nil {
+		return fmt.Errorf("no se pudieron obtener cuatrimestres no sincronizados: %w", err)
+	}
+	progress.SetTotal(len(cuatrimestres))
 
-		if len(cuatrimestres) == 0 {
-			log.Printf("No hay cuatrimestres pendientes de sincronizar.")
-			return
-		}
+	if len(cuatrimestres) == 0 {
+		log.Printf("No hay cuatrimestres pendientes de sincronizar.")
+		return nil
+	}
 
-		log.Printf("Iniciando sincronización masiva para %d cuatrimestres...", len(cuatrimestres))
+	log.Printf("Iniciando sincronización masiva para %d cuatrimestres...", len(cuatrimestres))
 
-		// Separar por programa de estudio para sincronizar en grupos
-		programaGroups := make(map[uint][]models.Cuatrimestre)
-		for _, c := range cuatrimestres {
-			programaGroups[c.ProgramaEstudioID] = append(programaGroups[c.ProgramaEstudioID], c)
-		}
+	// Separar por programa de estudio para sincronizar en grupos
+	programaGroups := make(map[uint][]models.Cuatrimestre)
+	for _, c := range cuatrimestres {
+		programaGroups[c.ProgramaEstudioID] = append(programaGroups[c.ProgramaEstudioID], c)
+	}
 
-		successCount := 0
-		errorCount := 0
+	successCount := 0
+	errorCount := 0
+	fail := func(c models.Cuatrimestre, err error) {
+		errorCount++
+		progress.Failure("cuatrimestre", c.ID, err)
+		s.syncFailed(&c, err)
+	}
 
-		for programaID, group := range programaGroups {
-			log.Printf("Procesando %d cuatrimestres del Programa ID %d...", len(group), programaID)
+	for programaID, group := range programaGroups {
+		if ctx.Err() != nil {
+			log.Printf(" Sincronización masiva de cuatrimestres cancelada: %v", ctx.Err())
+			return fmt.Errorf("sincronización cancelada: %w", ctx.Err())
+		}
+		log.Printf("Procesando %d cuatrimestres del Programa ID %d...", len(group), programaID)
 
-			// Verificar que el programa padre esté sincronizado
-			if len(group) > 0 && group[0].ProgramaEstudio.ID_Moodle == nil {
-				log.Printf(" ADVERTENCIA: ProgramaEstudio ID %d no está sincronizado. Saltando %d cuatrimestres.", programaID, len(group))
-				errorCount += len(group)
-				continue
+		// Verificar que el programa padre esté sincronizado
+		if len(group) > 0 && group[0].ProgramaEstudio.ID_Moodle == nil {
+			log.Printf(" ADVERTENCIA: ProgramaEstudio ID %d no está sincronizado. Saltando %d cuatrimestres.", programaID, len(group))
+			for _, c := range group {
+				fail(c, fmt.Errorf("el programa de estudio %d no está sincronizado con Moodle", programaID))
 			}
+			continue
+		}
 
-			// Construir array para batch create
-			parentID := *group[0].ProgramaEstudio.ID_Moodle
-			data := make([]moodle.CategoryRequest, len(group))
-			for i, c := range group {
-				data[i] = moodle.CategoryRequest{
-					Name:        c.Nombre,
-					Parent:      int(parentID),
-					IDNumber:    safeString(c.ID_Externo),
-					Description: safeString(c.Descripcion),
-				}
+		// Construir array para batch create
+		parentID := *group[0].ProgramaEstudio.ID_Moodle
+		data := make([]moodle.CategoryRequest, len(group))
+		for i, c := range group {
+			data[i] = moodle.CategoryRequest{
+				Name:        c.Nombre,
+				Parent:      int(parentID),
+				IDNumber:    safeString(c.ID_Externo),
+				Description: safeString(c.Descripcion),
 			}
+		}
 
-			// Llamar a Moodle
-			var response []moodle.CategoryResponse
-			err := s.MoodleClient.Call("core_course_create_categories", data, &response)
-			if err != nil {
-				log.Printf(" Error al procesar cuatrimestres del Programa ID %d: %v", programaID, err)
-				errorCount += len(group)
+		// Llamar a Moodle
+		var response []moodle.CategoryResponse
+		err := s.MoodleClient.Call(ctx, "core_course_create_categories", moodle.CreateCategoriesParams{Categories: data}, &response)
+		if err != nil {
+			log.Printf(" Error al procesar cuatrimestres del Programa ID %d: %v", programaID, err)
+			// Con lotes, la respuesta conserva lo creado antes del fallo: se enlaza igualmente
+			// y los cuatrimestres sin respuesta se cuentan como error más abajo.
+		}
+
+		// Actualizar IDs en BD local
+		for i := range group {
+			if i >= len(response) {
+				fail(group[i], sinRespuesta(err))
 				continue
 			}
-
-			// Actualizar IDs en BD local
-			for i, categoryResp := range response {
-				if i < len(group) {
-					moodleID := categoryResp.ID
-					group[i].ID_Moodle = &moodleID
-					if err := s.Repo.Update(&group[i]); err != nil {
-						log.Printf(" Error al actualizar cuatrimestre ID %d con Moodle ID %d: %v", group[i].ID, moodleID, err)
-						errorCount++
-					} else {
-						log.Printf(" Cuatrimestre '%s' sincronizado con Moodle ID: %d", group[i].Nombre, moodleID)
-						successCount++
-					}
-				}
+			moodleID := response[i].ID
+			group[i].ID_Moodle = &moodleID
+			group[i].MarkSynced(cuatrimestreHash(group[i]))
+			if err := s.Repo.Update(&group[i]); err != nil {
+				log.Printf(" Error al actualizar cuatrimestre ID %d con Moodle ID %d: %v", group[i].ID, moodleID, err)
+				fail(group[i], fmt.Errorf("creado en Moodle (ID %d) pero falló guardar el ID local: %w", moodleID, err))
+			} else {
+				log.Printf(" Cuatrimestre '%s' sincronizado con Moodle ID: %d", group[i].Nombre, moodleID)
+				successCount++
+				progress.Success()
 			}
 		}
+	}
 
-		log.Printf(" Sincronización masiva de cuatrimestres finalizada. Exitosos: %d, Errores: %d", successCount, errorCount)
-	}()
+	log.Printf(" Sincronización masiva de cuatrimestres finalizada. Exitosos: %d, Errores: %d", successCount, errorCount)
+	return nil
 }
 
 // validateCuatrimestre aplica validaciones de negocio y límites de longitud
@@ -235,5 +353,49 @@ func (s *CuatrimestreService) validateCuatrimestre(c *models.Cuatrimestre) error
 		trimmed := strings.TrimSpace(*c.Descripcion)
 		*c.Descripcion = trimmed
 	}
-	return nil
+	return validateCursoConfig(c.FormatoCurso, c.FechaInicio, c.FechaFin)
+}
+
+// SyncCohort crea, si aún no existe, la cohorte de Moodle del cuatrimestre (en su subcategoría si ya
+// está sincronizado) y le añade los alumnos matriculados en sus asignaturas que ya están en Moodle.
+func (s *CuatrimestreService) SyncCohort(ctx context.Context, id uint) (*CohortSyncResult, error) {
+	c, err := s.Repo.GetByID(id)
+	if err != nil {
+		return nil, fmt.Errorf("cuatrimestre no encontrado en BD local: %w", err)
+	}
+
+	cohortID, created, err := ensureCohort(ctx, s.MoodleClient, c.CohortMoodleID, cohortSpec{
+		Name:        c.Nombre,
+		IDNumber:    cohortIDNumber("CUATR", c.ID, c.ID_Externo),
+		Description: safeString(c.Descripcion),
+		CategoryID:  c.ID_Moodle,
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := &CohortSyncResult{CohortID: cohortID, Created: created}
+	if created {
+		c.CohortMoodleID = &cohortID
+		if err := s.Repo.Update(&c); err != nil {
+			return nil, fmt.Errorf("cohorte %d creada en Moodle pero falló guardar su ID para Cuatrimestre ID %d: %w", cohortID, id, err)
+		}
+		log.Printf("✅ Cohorte del Cuatrimestre '%s' (ID local: %d) creada en Moodle con ID: %d", c.Nombre, id, cohortID)
+	}
+
+	rolesAlumno, err := s.Roles.IDsRolesEstudiante()
+	if err != nil {
+		return result, err
+	}
+	alumnos, err := s.Repo.GetAlumnos(id, rolesAlumno)
+	if err != nil {
+		return result, fmt.Errorf("no se pudieron obtener los alumnos del Cuatrimestre ID %d: %w", id, err)
+	}
+	if err := addCohortMembers(ctx, s.MoodleClient, cohortID, alumnos, result); err != nil {
+		return result, err
+	}
+	if err := removeStaleCohortMembers(ctx, s.MoodleClient, cohortID, alumnos, result); err != nil {
+		return result, err
+	}
+	log.Printf("✅ Cohorte %d del Cuatrimestre '%s': %d alumnos enviados, %d quitados, %d sin sincronizar.", cohortID, c.Nombre, result.Members, result.Removed, result.Skipped)
+	return result, nil
 }
@@ -0,0 +1,78 @@
+package services
+
+import (
+	"api_concurrencia/src/moodle"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ErrCategoryNotEmpty se devuelve al intentar borrar en Moodle una categoría que aún contiene
+// cursos o subcategorías: Moodle movería ese contenido a otra categoría sin que lo sepamos.
+var ErrCategoryNotEmpty = errors.New("la categoría de Moodle todavía contiene cursos o subcategorías")
+
+// UserDeletePolicy indica qué se hace en Moodle al eliminar un usuario local.
+type UserDeletePolicy string
+
+const (
+	UserDeleteSuspend UserDeletePolicy = "suspend" // Suspende la cuenta (conserva historial y calificaciones)
+	UserDeleteDelete  UserDeletePolicy = "delete"  // Elimina la cuenta con core_user_delete_users
+)
+
+// PropagateDeletesByDefault indica si los DELETE locales se propagan a Moodle cuando la petición
+// no incluye el parámetro propagate. Se controla con MOODLE_DELETE_PROPAGATE (por defecto false).
+func PropagateDeletesByDefault() bool {
+	raw := os.Getenv("MOODLE_DELETE_PROPAGATE")
+	if raw == "" {
+		return false
+	}
+	v, err := strconv.ParseBool(raw)
+	if err != nil {
+		log.Printf("⚠️ Valor inválido para MOODLE_DELETE_PROPAGATE (%q). Usando false.", raw)
+		return false
+	}
+	return v
+}
+
+// userDeletePolicyFromEnv lee MOODLE_USER_DELETE_POLICY (suspend por defecto, o delete).
+func userDeletePolicyFromEnv() UserDeletePolicy {
+	switch raw := strings.ToLower(os.Getenv("MOODLE_USER_DELETE_POLICY")); raw {
+	case "", string(UserDeleteSuspend):
+		return UserDeleteSuspend
+	case string(UserDeleteDelete):
+		return UserDeleteDelete
+	default:
+		log.Printf("⚠️ Valor inválido para MOODLE_USER_DELETE_POLICY (%q). Usando suspend.", raw)
+		return UserDeleteSuspend
+	}
+}
+
+// ensureCategoryEmpty devuelve ErrCategoryNotEmpty si la categoría de Moodle tiene cursos o subcategorías.
+// Se comprueba antes de eliminar el registro local para no dejar en Moodle una categoría huérfana.
+func ensureCategoryEmpty(ctx context.Context, api moodle.MoodleAPI, categoryID uint) error {
+	hasContent, err := moodle.CategoryHasContent(ctx, api, categoryID)
+	if err != nil {
+		return err
+	}
+	if hasContent {
+		return fmt.Errorf("categoría %d: %w", categoryID, ErrCategoryNotEmpty)
+	}
+	return nil
+}
+
+// deleteCategoryInMoodle elimina una categoría vacía de Moodle. Si tiene contenido devuelve ErrCategoryNotEmpty.
+func deleteCategoryInMoodle(ctx context.Context, api moodle.MoodleAPI, categoryID uint) error {
+	if err := ensureCategoryEmpty(ctx, api, categoryID); err != nil {
+		return err
+	}
+
+	data := moodle.DeleteCategoriesParams{Categories: []moodle.CategoryDeleteRequest{{ID: categoryID, Recursive: 0}}}
+	if err := api.Call(ctx, "core_course_delete_categories", data, nil); err != nil {
+		return fmt.Errorf("fallo al eliminar la categoría %d en Moodle: %w", categoryID, err)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package services
+
+import (
+	"api_concurrencia/src/models"
+	"api_concurrencia/src/moodle"
+	"context"
+	"testing"
+)
+
+// Si Moodle rechaza la creación porque el registro ya existe, SyncToMoodle vincula el existente en
+// lugar de fallar y deja el registro local sincronizado.
+func TestSyncToMoodleLinksDuplicates(t *testing.T) {
+	tests := []struct {
+		name string
+		// existing crea el registro en Moodle antes de sincronizar y devuelve su ID.
+		existing func(t *testing.T, fake *moodle.FakeClient) uint
+		// sync crea el registro local, lo sincroniza y devuelve su ID de Moodle y estado tras recargarlo.
+		sync func(t *testing.T, svc *Services) (*uint, models.SyncState, error)
+	}{
+		{
+			name: "programa con idnumber de categoría ocupado",
+			existing: func(t *testing.T, fake *moodle.FakeClient) uint {
+				var resp []moodle.CategoryResponse
+				err := fake.Call(context.Background(), "core_course_create_categories", moodle.CreateCategoriesParams{
+					Categories: []moodle.CategoryRequest{{Name: "Creada a mano", IDNumber: "PROG-ISC"}},
+				}, &resp)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return resp[0].ID
+			},
+			sync: func(t *testing.T, svc *Services) (*uint, models.SyncState, error) {
+				pe := models.ProgramaEstudio{Nombre: "Ingeniería en Sistemas", ID_Externo: strPtr("PROG-ISC")}
+				if err := svc.Programas.CreateLocal(&pe); err != nil {
+					t.Fatal(err)
+				}
+				err := svc.Programas.SyncToMoodle(context.Background(), pe.ID)
+				got, getErr := svc.Programas.GetByID(pe.ID)
+				if getErr != nil {
+					t.Fatal(getErr)
+				}
+				return got.ID_Moodle, got.SyncState, err
+			},
+		},
+		{
+			name: "usuario con username ocupado",
+			existing: func(t *testing.T, fake *moodle.FakeClient) uint {
+				var resp []moodle.UserResponse
+				err := fake.Call(context.Background(), "core_user_create_users", moodle.CreateUsersParams{
+					Users: []moodle.UserRequest{{Username: "jperez", Firstname: "Juan", Lastname: "Pérez", Email: "otro@example.com", Password: "Segura123#"}},
+				}, &resp)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return resp[0].ID
+			},
+			sync: func(t *testing.T, svc *Services) (*uint, models.SyncState, error) {
+				u := models.Usuario{Username: "jperez", Password: "Segura123#", FirstName: "Juan", LastName: "Pérez", Email: "jperez@example.com", Rol: "Alumno"}
+				if err := svc.Usuarios.CreateLocal(&u); err != nil {
+					t.Fatal(err)
+				}
+				err := svc.Usuarios.SyncToMoodle(context.Background(), u.ID)
+				got, getErr := svc.Usuarios.GetByID(u.ID)
+				if getErr != nil {
+					t.Fatal(getErr)
+				}
+				return got.ID_Moodle, got.SyncState, err
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, fake, _ := newTestServices(t)
+			existingID := tt.existing(t, fake)
+
+			moodleID, state, err := tt.sync(t, svc)
+			if err != nil {
+				t.Fatalf("SyncToMoodle: %v", err)
+			}
+			if moodleID == nil || *moodleID != existingID {
+				t.Errorf("ID_Moodle = %v, se esperaba el del registro existente (%d)", moodleID, existingID)
+			}
+			if state.SyncStatus != models.SyncSynced || state.PayloadHash == nil {
+				t.Errorf("estado = %+v, se esperaba synced con hash", state)
+			}
+		})
+	}
+}
+
+// Un error que no es de duplicado no se resuelve vinculando: el registro queda fallido y sin ID.
+func TestSyncToMoodleDoesNotLinkOtherErrors(t *testing.T) {
+	svc, fake, _ := newTestServices(t)
+	pe := models.ProgramaEstudio{Nombre: "Ingeniería en Sistemas", ID_Externo: strPtr("PROG-ISC")}
+	if err := svc.Programas.CreateLocal(&pe); err != nil {
+		t.Fatal(err)
+	}
+	fake.FailNext("core_course_create_categories", &moodle.MoodleError{ErrorCode: "invalidparameter", Message: "Invalid parameter value detected"})
+
+	if err := svc.Programas.SyncToMoodle(context.Background(), pe.ID); err == nil {
+		t.Fatal("SyncToMoodle no devolvió el error de Moodle")
+	}
+	got, err := svc.Programas.GetByID(pe.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID_Moodle != nil || got.SyncStatus != models.SyncFailed {
+		t.Errorf("ID_Moodle = %v, estado = %s; se esperaba sin vincular y failed", got.ID_Moodle, got.SyncStatus)
+	}
+}
@@ -1,45 +1,69 @@
-// internal/services/enrolment_service.go (Nuevo Servicio para Matrícula)
-
 package services
 
 import (
-	"log"
-	"sync"
 	"api_concurrencia/src/models"
+	"api_concurrencia/src/moodle"
+	"api_concurrencia/src/repository"
+	"context"
+	"errors"
+	"fmt"
+	"log"
 )
 
-// Este servicio gestionaría las llamadas a enrol_manual_enrol_users
+// EnrolmentService gestiona las matrículas masivas con enrol_manual_enrol_users.
 type EnrolmentService struct {
-	// Repositorio y Cliente Moodle
+	Repo         *repository.UsuarioRepository
+	MoodleClient moodle.MoodleAPI
+}
+
+func NewEnrolmentService(repo *repository.UsuarioRepository, moodleClient moodle.MoodleAPI) *EnrolmentService {
+	return &EnrolmentService{Repo: repo, MoodleClient: moodleClient}
 }
 
-// ProcessBulkEnrolment matricula a los alumnos en paralelo.
-func (s *EnrolmentService) ProcessBulkEnrolment(enrollments []models.Matricula) {
-	var wg sync.WaitGroup // Usamos WaitGroup para esperar que todas las goroutines terminen
-	
-	batchSize := 50 // Llamar a Moodle con lotes de 50 matrículas a la vez
-	
-	for i := 0; i < len(enrollments); i += batchSize {
-		end := i + batchSize
-		if end > len(enrollments) {
-			end = len(enrollments)
+// ProcessBulkEnrolment matricula en Moodle todas las matrículas recibidas y guarda su referencia local.
+// Las matrículas deben traer ya UserMoodleID, CourseMoodleID y RoleID. El cliente de Moodle parte la
+// llamada en lotes (por defecto 50 para enrol_manual_enrol_users); si un lote falla, las matrículas
+// de los lotes anteriores ya existen en Moodle y también se guardan localmente.
+func (s *EnrolmentService) ProcessBulkEnrolment(ctx context.Context, enrollments []models.Matricula) error {
+	if len(enrollments) == 0 {
+		return nil
+	}
+
+	data := make([]moodle.EnrolmentRequest, len(enrollments))
+	for i, m := range enrollments {
+		data[i] = moodle.EnrolmentRequest{
+			RoleID:    int(m.RoleID),
+			UserID:    m.UserMoodleID,
+			CourseID:  m.CourseMoodleID,
+			Timestart: m.Timestart,
+			Timeend:   m.Timeend,
+			Suspend:   suspendFlag(m.Suspended),
 		}
-		
-		batch := enrollments[i:end]
-		wg.Add(1)
-		
-		// Lanzamos una goroutine para procesar cada lote de enrolamiento
-		go func(b []models.Matricula) {
-			defer wg.Done()
-			
-			// **Lógica de llamada a Moodle:**
-			// 1. Convertir 'b' a la estructura requerida por enrol_manual_enrol_users
-			// 2. Llamar a la API de Moodle.
-			log.Printf("Procesando lote de %d matrículas...", len(b))
-			
-		}(batch)
 	}
-	
-	wg.Wait() // Bloquea hasta que todas las goroutines llamen a Done()
+
+	log.Printf("Procesando %d matrículas...", len(enrollments))
+
+	// enrol_manual_enrol_users no devuelve cuerpo: lo completado se deduce del error.
+	completed := len(enrollments)
+	err := s.MoodleClient.Call(ctx, "enrol_manual_enrol_users", moodle.EnrolUsersParams{Enrolments: data}, nil)
+	if err != nil {
+		completed = 0
+		var chunkErr *moodle.ChunkError
+		if errors.As(err, &chunkErr) {
+			completed = chunkErr.Completed
+		}
+	}
+
+	for _, m := range enrollments[:completed] {
+		m.MarkSynced(matriculaHash(m))
+		if saveErr := s.Repo.SaveMatricula(m); saveErr != nil {
+			log.Printf("⚠️ Matrícula de usuario %d en asignatura %d creada en Moodle, pero falló la referencia local: %v", m.UsuarioID, m.AsignaturaID, saveErr)
+		}
+	}
+
+	if err != nil {
+		return fmt.Errorf("matrícula masiva incompleta (%d de %d): %w", completed, len(enrollments), err)
+	}
 	log.Println("✅ Matrícula masiva finalizada.")
-}
\ No newline at end of file
+	return nil
+}
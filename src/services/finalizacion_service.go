@@ -0,0 +1,333 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"api_concurrencia/src/models"
+	"api_concurrencia/src/moodle"
+	"api_concurrencia/src/repository"
+
+	"gorm.io/gorm"
+)
+
+// ErrImportacionEnCurso indica que ya hay una importación completa de finalización en marcha.
+var ErrImportacionEnCurso = errors.New("ya hay una importación de finalización en curso")
+
+// FinalizacionService importa de Moodle (core_completion_get_course_completion_status) si cada
+// alumno completó el curso de cada asignatura y lo guarda localmente por matrícula.
+type FinalizacionService struct {
+	Repo             *repository.FinalizacionRepository
+	UsuarioRepo      *repository.UsuarioRepository
+	AsignaturaRepo   *repository.AsignaturaRepository
+	CuatrimestreRepo *repository.CuatrimestreRepository
+	MoodleClient     moodle.MoodleAPI
+	Roles            *RolMoodleService // Indica qué roles son de alumno
+
+	running atomic.Bool // Evita que la importación periódica y la manual se solapen
+}
+
+func NewFinalizacionService(repo *repository.FinalizacionRepository, usuarioRepo *repository.UsuarioRepository, asignaturaRepo *repository.AsignaturaRepository, cuatrimestreRepo *repository.CuatrimestreRepository, moodleClient moodle.MoodleAPI, roles *RolMoodleService) *FinalizacionService {
+	return &FinalizacionService{Repo: repo, UsuarioRepo: usuarioRepo, AsignaturaRepo: asignaturaRepo, CuatrimestreRepo: cuatrimestreRepo, MoodleClient: moodleClient, Roles: roles}
+}
+
+// ImportacionFinalizacion resume una importación del estado de finalización.
+type ImportacionFinalizacion struct {
+	Matriculas  int      `json:"matriculas"`        // Matrículas de alumnos actualizadas
+	Completadas int      `json:"completadas"`       // De ellas, las que tienen el curso completado
+	SinDatos    int      `json:"sin_datos"`         // Cursos sin seguimiento de finalización o alumnos sin seguimiento
+	Errores     []string `json:"errores,omitempty"` // Matrículas que no se pudieron importar
+}
+
+// FinalizacionAlumno es la fila de un alumno en el informe de finalización de un cuatrimestre.
+type FinalizacionAlumno struct {
+	UsuarioID   uint   `json:"usuario_id"`
+	Username    string `json:"username"`
+	Matriculado int    `json:"matriculado"`          // Asignaturas del cuatrimestre en las que está matriculado
+	Completadas int    `json:"completadas"`          // Asignaturas completadas según la última importación
+	Pendientes  []uint `json:"pendientes,omitempty"` // IDs de las asignaturas aún no completadas
+	Completo    bool   `json:"completo"`             // Completó todas sus asignaturas del cuatrimestre
+}
+
+// InformeFinalizacionCuatrimestre indica qué alumnos completaron todas sus asignaturas de un cuatrimestre.
+type InformeFinalizacionCuatrimestre struct {
+	CuatrimestreID uint                 `json:"cuatrimestre_id"`
+	Nombre         string               `json:"nombre"`
+	Completos      int                  `json:"completos"` // Alumnos que completaron todas sus asignaturas
+	Alumnos        []FinalizacionAlumno `json:"alumnos"`
+}
+
+// GetByUsuario devuelve el estado de finalización guardado de un usuario.
+func (s *FinalizacionService) GetByUsuario(usuarioID uint) ([]models.Finalizacion, error) {
+	return s.Repo.GetByUsuario(usuarioID)
+}
+
+// GetByAsignatura devuelve el estado de finalización guardado de una asignatura.
+func (s *FinalizacionService) GetByAsignatura(asignaturaID uint) ([]models.Finalizacion, error) {
+	return s.Repo.GetByAsignatura(asignaturaID)
+}
+
+// InformeCuatrimestre construye, con los datos ya importados, el informe de qué alumnos
+// completaron todas las asignaturas del cuatrimestre en las que están matriculados.
+func (s *FinalizacionService) InformeCuatrimestre(cuatrimestreID uint) (*InformeFinalizacionCuatrimestre, error) {
+	cuatrimestre, err := s.CuatrimestreRepo.GetByID(cuatrimestreID)
+	if err != nil {
+		return nil, fmt.Errorf("cuatrimestre (ID: %d) no encontrado: %w", cuatrimestreID, err)
+	}
+	idsAlumno, err := s.Roles.IDsRolesEstudiante()
+	if err != nil {
+		return nil, err
+	}
+	alumnos, err := s.CuatrimestreRepo.GetAlumnos(cuatrimestreID, idsAlumno)
+	if err != nil {
+		return nil, fmt.Errorf("no se pudieron obtener los alumnos del cuatrimestre %d: %w", cuatrimestreID, err)
+	}
+	matriculas, err := s.UsuarioRepo.GetMatriculasByCuatrimestre(cuatrimestreID)
+	if err != nil {
+		return nil, fmt.Errorf("no se pudieron obtener las matrículas del cuatrimestre %d: %w", cuatrimestreID, err)
+	}
+	finalizaciones, err := s.Repo.GetByCuatrimestre(cuatrimestreID)
+	if err != nil {
+		return nil, fmt.Errorf("no se pudo obtener la finalización del cuatrimestre %d: %w", cuatrimestreID, err)
+	}
+	rolesAlumno, err := s.Roles.RolesEstudiante()
+	if err != nil {
+		return nil, err
+	}
+
+	completadas := make(map[uint]bool, len(finalizaciones))
+	for _, f := range finalizaciones {
+		completadas[f.MatriculaID] = f.Completada
+	}
+	porUsuario := make(map[uint][]models.Matricula)
+	for _, m := range matriculas {
+		if rolesAlumno[m.RoleID] {
+			porUsuario[m.UsuarioID] = append(porUsuario[m.UsuarioID], m)
+		}
+	}
+
+	informe := &InformeFinalizacionCuatrimestre{CuatrimestreID: cuatrimestre.ID, Nombre: cuatrimestre.Nombre, Alumnos: []FinalizacionAlumno{}}
+	for _, u := range alumnos {
+		fila := FinalizacionAlumno{UsuarioID: u.ID, Username: u.Username}
+		for _, m := range porUsuario[u.ID] {
+			fila.Matriculado++
+			if completadas[m.ID] {
+				fila.Completadas++
+			} else {
+				fila.Pendientes = append(fila.Pendientes, m.AsignaturaID)
+			}
+		}
+		fila.Completo = fila.Matriculado > 0 && fila.Completadas == fila.Matriculado
+		if fila.Completo {
+			informe.Completos++
+		}
+		informe.Alumnos = append(informe.Alumnos, fila)
+	}
+	return informe, nil
+}
+
+// ImportarAsignatura importa el estado de finalización de todos los alumnos de la asignatura.
+func (s *FinalizacionService) ImportarAsignatura(ctx context.Context, asignaturaID uint) (*ImportacionFinalizacion, error) {
+	asignatura, err := s.AsignaturaRepo.GetByID(asignaturaID)
+	if err != nil {
+		return nil, fmt.Errorf("asignatura (ID: %d) no encontrada: %w", asignaturaID, err)
+	}
+	if asignatura.ID_Moodle == nil {
+		return nil, fmt.Errorf("la asignatura '%s' no está sincronizada con Moodle (ID_Moodle local es nulo)", asignatura.NombreCompleto)
+	}
+	matriculas, err := s.UsuarioRepo.GetMatriculasByAsignatura(asignaturaID)
+	if err != nil {
+		return nil, fmt.Errorf("no se pudieron obtener las matrículas de la asignatura %d: %w", asignaturaID, err)
+	}
+
+	result, err := s.importar(ctx, matriculas)
+	if err != nil {
+		return result, fmt.Errorf("no se pudo importar la finalización de '%s': %w", asignatura.NombreCompleto, err)
+	}
+	log.Printf("🎓 Finalización de '%s' importada: %d matrículas, %d completadas, %d sin datos.",
+		asignatura.NombreCompleto, result.Matriculas, result.Completadas, result.SinDatos)
+	return result, nil
+}
+
+// ImportarCuatrimestre importa el estado de finalización de todas las asignaturas del cuatrimestre.
+func (s *FinalizacionService) ImportarCuatrimestre(ctx context.Context, cuatrimestreID uint) (*ImportacionFinalizacion, error) {
+	cuatrimestre, err := s.CuatrimestreRepo.GetByID(cuatrimestreID)
+	if err != nil {
+		return nil, fmt.Errorf("cuatrimestre (ID: %d) no encontrado: %w", cuatrimestreID, err)
+	}
+	matriculas, err := s.UsuarioRepo.GetMatriculasByCuatrimestre(cuatrimestreID)
+	if err != nil {
+		return nil, fmt.Errorf("no se pudieron obtener las matrículas del cuatrimestre %d: %w", cuatrimestreID, err)
+	}
+
+	result, err := s.importar(ctx, matriculas)
+	if err != nil {
+		return result, fmt.Errorf("no se pudo importar la finalización del cuatrimestre '%s': %w", cuatrimestre.Nombre, err)
+	}
+	log.Printf("🎓 Finalización del cuatrimestre '%s' importada: %d matrículas, %d completadas, %d errores.",
+		cuatrimestre.Nombre, result.Matriculas, result.Completadas, len(result.Errores))
+	return result, nil
+}
+
+// ImportarTodo importa el estado de finalización de todas las matrículas de alumnos. Si ya hay
+// una importación completa en marcha devuelve ErrImportacionEnCurso sin hacer nada.
+func (s *FinalizacionService) ImportarTodo(ctx context.Context) (*ImportacionFinalizacion, error) {
+	if !s.running.CompareAndSwap(false, true) {
+		return nil, ErrImportacionEnCurso
+	}
+	defer s.running.Store(false)
+
+	matriculas, err := s.UsuarioRepo.GetAllMatriculas()
+	if err != nil {
+		return nil, fmt.Errorf("no se pudieron obtener las matrículas: %w", err)
+	}
+	log.Printf("Iniciando importación de finalización para %d matrículas...", len(matriculas))
+
+	result, err := s.importar(ctx, matriculas)
+	if err != nil {
+		return result, fmt.Errorf("no se pudo importar la finalización: %w", err)
+	}
+	log.Printf("🎓 Importación de finalización completada: %d matrículas, %d completadas, %d sin datos, %d errores.",
+		result.Matriculas, result.Completadas, result.SinDatos, len(result.Errores))
+	return result, nil
+}
+
+// RunPeriodicImport ejecuta ImportarTodo cada interval hasta que ctx se cancela.
+// Con interval <= 0 no hace nada.
+func (s *FinalizacionService) RunPeriodicImport(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	log.Printf("Importación periódica de finalización activada cada %s.", interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.ImportarTodo(ctx); err != nil {
+				log.Printf("⚠️ Importación periódica de finalización: %v", err)
+			}
+		}
+	}
+}
+
+// importar consulta Moodle para cada matrícula de alumno (una llamada por matrícula: la función no
+// admite varios usuarios). Un fallo en una matrícula no detiene las demás; sólo se devuelve error si
+// no se pudo importar ninguna.
+func (s *FinalizacionService) importar(ctx context.Context, matriculas []models.Matricula) (*ImportacionFinalizacion, error) {
+	rolesAlumno, err := s.Roles.RolesEstudiante()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ImportacionFinalizacion{}
+	importadaEn := time.Now()
+	var lastErr error
+	for _, m := range matriculas {
+		if !rolesAlumno[m.RoleID] {
+			continue
+		}
+		if ctx.Err() != nil {
+			lastErr = ctx.Err()
+			break
+		}
+
+		var response moodle.GetCourseCompletionStatusResponse
+		params := moodle.GetCourseCompletionStatusParams{CourseID: m.CourseMoodleID, UserID: m.UserMoodleID}
+		if err := s.MoodleClient.Call(ctx, "core_completion_get_course_completion_status", params, &response); err != nil {
+			if sinSeguimiento(err) {
+				result.SinDatos++
+				continue
+			}
+			lastErr = err
+			result.Errores = append(result.Errores, fmt.Sprintf("matrícula %d (asignatura %d): %v", m.ID, m.AsignaturaID, err))
+			if errors.Is(err, moodle.ErrUnsupported) {
+				break // No tiene sentido seguir: fallaría igual para todas
+			}
+			continue
+		}
+
+		f := s.finalizacionFromMoodle(m, response.CompletionStatus, importadaEn)
+		if err := s.Repo.Upsert(&f); err != nil {
+			lastErr = err
+			result.Errores = append(result.Errores, fmt.Sprintf("matrícula %d: error al guardar: %v", m.ID, err))
+			continue
+		}
+		result.Matriculas++
+		if f.Completada {
+			result.Completadas++
+		}
+	}
+
+	if lastErr != nil && result.Matriculas == 0 {
+		return result, lastErr
+	}
+	return result, nil
+}
+
+// finalizacionFromMoodle construye el registro local. Como Moodle no devuelve la fecha de finalización
+// del curso, se toma la del último criterio completado; si ninguno la tiene, se conserva la ya guardada
+// o se usa el momento de la importación.
+func (s *FinalizacionService) finalizacionFromMoodle(m models.Matricula, status moodle.CourseCompletionStatus, importadaEn time.Time) models.Finalizacion {
+	f := models.Finalizacion{
+		MatriculaID:      m.ID,
+		UsuarioID:        m.UsuarioID,
+		AsignaturaID:     m.AsignaturaID,
+		Completada:       status.Completed,
+		CriteriosTotales: len(status.Completions),
+		ImportadaEn:      importadaEn,
+	}
+	var ultima int64
+	for _, c := range status.Completions {
+		if !c.Complete {
+			continue
+		}
+		f.CriteriosCompletados++
+		if c.TimeCompleted != nil && *c.TimeCompleted > ultima {
+			ultima = *c.TimeCompleted
+		}
+	}
+	if !f.Completada {
+		return f
+	}
+
+	switch previa, err := s.Repo.GetByMatricula(m.ID); {
+	case ultima > 0:
+		fecha := time.Unix(ultima, 0)
+		f.FechaFinalizacion = &fecha
+	case err == nil && previa.Completada && previa.FechaFinalizacion != nil:
+		f.FechaFinalizacion = previa.FechaFinalizacion
+	default:
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("⚠️ No se pudo leer la finalización previa de la matrícula %d: %v", m.ID, err)
+		}
+		f.FechaFinalizacion = &importadaEn
+	}
+	return f
+}
+
+// sinSeguimiento indica si Moodle rechazó la consulta porque el curso no tiene activado el seguimiento
+// de finalización, no tiene criterios, o el usuario no está sujeto a seguimiento.
+func sinSeguimiento(err error) bool {
+	me, ok := moodle.AsMoodleError(err)
+	if !ok {
+		return false
+	}
+	switch me.ErrorCode {
+	case "completionnotenabled", "nocriteriaset", "nottracked":
+		return true
+	}
+	return false
+}
+
+// CompletionImportIntervalFromEnv lee MOODLE_COMPLETION_IMPORT_INTERVAL (p. ej. "6h"). Vacío o 0
+// desactiva la importación periódica.
+func CompletionImportIntervalFromEnv() time.Duration {
+	return moodle.EnvDuration("MOODLE_COMPLETION_IMPORT_INTERVAL", 0)
+}
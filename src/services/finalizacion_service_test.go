@@ -0,0 +1,102 @@
+package services
+
+import (
+	"api_concurrencia/src/models"
+	"api_concurrencia/src/moodle"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// El informe del cuatrimestre sale de la última importación: completo solo quien terminó todas las
+// asignaturas en las que está matriculado, con la fecha del criterio que lo completó.
+func TestInformeFinalizacionCuatrimestre(t *testing.T) {
+	svc, fake, db := newTestServices(t)
+	if err := db.AutoMigrate(&models.Finalizacion{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.Roles.Create(&models.RolMoodle{Nombre: "Alumno", MoodleRoleID: 5, Shortname: "student", Estudiante: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	c := models.Cuatrimestre{Nombre: "Primero"}
+	if err := db.Create(&c).Error; err != nil {
+		t.Fatal(err)
+	}
+	asignaturas := map[uint]*models.Asignatura{}
+	for courseID, corto := range map[uint]string{500: "CALC", 501: "FIS"} {
+		id := courseID
+		a := &models.Asignatura{NombreCompleto: corto, NombreCorto: corto, CuatrimestreID: c.ID, ID_Moodle: &id}
+		if err := db.Create(a).Error; err != nil {
+			t.Fatal(err)
+		}
+		fake.Courses[courseID] = &moodle.FakeCourse{ID: courseID, Shortname: corto}
+		asignaturas[courseID] = a
+	}
+	usuarios := map[uint]*models.Usuario{}
+	for userID, username := range map[uint]string{701: "ana", 702: "luis"} {
+		id := userID
+		u := &models.Usuario{Username: username, Email: username + "@example.com", Rol: "Alumno", ID_Moodle: &id}
+		if err := db.Create(u).Error; err != nil {
+			t.Fatal(err)
+		}
+		usuarios[userID] = u
+		for courseID, a := range asignaturas {
+			m := models.Matricula{AsignaturaID: a.ID, UsuarioID: u.ID, CourseMoodleID: courseID, UserMoodleID: userID, RoleID: 5}
+			if err := db.Create(&m).Error; err != nil {
+				t.Fatal(err)
+			}
+			fake.Enrolments[fmt.Sprintf("%d:%d", courseID, userID)] = &moodle.FakeEnrolment{CourseID: courseID, UserID: userID, RoleID: 5}
+		}
+	}
+	// Luis no tiene seguimiento en Física: cuenta como sin datos, no como error.
+	delete(fake.Enrolments, "501:702")
+
+	terminado := time.Date(2025, 4, 28, 10, 0, 0, 0, time.UTC).Unix()
+	fake.SetCourseCompleted(500, 701, terminado)
+	fake.SetCourseCompleted(501, 701, terminado+3600)
+	fake.SetCourseCompleted(500, 702, terminado)
+
+	result, err := svc.Finalizacion.ImportarCuatrimestre(context.Background(), c.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Matriculas != 3 || result.Completadas != 3 || result.SinDatos != 1 || len(result.Errores) != 0 {
+		t.Fatalf("importación = %+v, se esperaban 3 matrículas completadas y 1 sin datos", *result)
+	}
+
+	informe, err := svc.Finalizacion.InformeCuatrimestre(c.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if informe.Completos != 1 {
+		t.Errorf("completos = %d, se esperaba 1 (solo Ana)", informe.Completos)
+	}
+	for _, fila := range informe.Alumnos {
+		switch fila.UsuarioID {
+		case usuarios[701].ID:
+			if !fila.Completo || fila.Completadas != 2 {
+				t.Errorf("Ana = %+v, debería tener completas sus 2 asignaturas", fila)
+			}
+		case usuarios[702].ID:
+			if fila.Completo || fmt.Sprint(fila.Pendientes) != fmt.Sprint([]uint{asignaturas[501].ID}) {
+				t.Errorf("Luis = %+v, debería tener pendiente solo Física", fila)
+			}
+		}
+	}
+
+	guardadas, err := svc.Finalizacion.GetByUsuario(usuarios[701].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range guardadas {
+		want := terminado
+		if f.AsignaturaID == asignaturas[501].ID {
+			want += 3600
+		}
+		if f.FechaFinalizacion == nil || f.FechaFinalizacion.Unix() != want {
+			t.Errorf("asignatura %d: fecha de finalización = %v, se esperaba %s", f.AsignaturaID, f.FechaFinalizacion, time.Unix(want, 0).UTC())
+		}
+	}
+}
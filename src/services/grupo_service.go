@@ -4,26 +4,31 @@ import (
 	"api_concurrencia/src/models"
 	"api_concurrencia/src/moodle"
 	"api_concurrencia/src/repository"
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"strings"
 	"unicode/utf8"
+
+	"gorm.io/gorm"
 )
 
 type GrupoService struct {
 	Repo           *repository.GrupoRepository
-	MoodleClient   *moodle.Client
+	MoodleClient   moodle.MoodleAPI
 	AsignaturaRepo *repository.AsignaturaRepository // Necesario para obtener el CourseID de Moodle
 	UsuarioRepo    *repository.UsuarioRepository    // Necesario para obtener el UserID de Moodle
+	Outbox         *OutboxService
 }
 
-func NewGrupoService(repo *repository.GrupoRepository, moodleClient *moodle.Client, aRepo *repository.AsignaturaRepository, uRepo *repository.UsuarioRepository) *GrupoService {
+func NewGrupoService(repo *repository.GrupoRepository, moodleClient moodle.MoodleAPI, aRepo *repository.AsignaturaRepository, uRepo *repository.UsuarioRepository, outbox *OutboxService) *GrupoService {
 	return &GrupoService{
 		Repo:           repo,
 		MoodleClient:   moodleClient,
 		AsignaturaRepo: aRepo,
 		UsuarioRepo:    uRepo,
+		Outbox:         outbox,
 	}
 }
 
@@ -35,16 +40,27 @@ func (s *GrupoService) GetAll() ([]models.Grupo, error) {
 	return s.Repo.GetAll()
 }
 
-// CreateLocal crea el registro en la BD local con validaciones
+// CreateLocal crea el registro en la BD local con validaciones y registra su creación en el outbox.
 func (s *GrupoService) CreateLocal(g *models.Grupo) error {
 	if err := s.validateGrupo(g); err != nil {
 		return err
 	}
-	return s.Repo.Create(g)
+	g.SyncState = models.SyncState{}
+	return s.Outbox.Record(func(tx *gorm.DB) (*models.OutboxEvent, error) {
+		if err := s.Repo.WithTx(tx).Create(g); err != nil {
+			return nil, err
+		}
+		return models.NewOutboxEvent(models.OutboxGrupo, g.ID, models.OutboxCreate), nil
+	})
+}
+
+// syncFailed guarda el fallo de sincronización del grupo y devuelve err.
+func (s *GrupoService) syncFailed(g *models.Grupo, err error) error {
+	return markSyncFailed(&g.SyncState, func() error { return s.Repo.Update(g) }, err)
 }
 
 // SyncGroupToMoodle crea un grupo en Moodle y actualiza el ID_Moodle local.
-func (s *GrupoService) SyncToMoodle(grupoID uint) error {
+func (s *GrupoService) SyncToMoodle(ctx context.Context, grupoID uint) error {
 	grupo, err := s.Repo.GetByID(grupoID)
 	if err != nil {
 		return fmt.Errorf("grupo (ID: %d) no encontrado en BD local: %w", grupoID, err)
@@ -57,18 +73,22 @@ func (s *GrupoService) SyncToMoodle(grupoID uint) error {
 		return fmt.Errorf("asignatura (ID: %d) no encontrada para el grupo: %w", grupo.CourseID, err)
 	}
 	if asignatura.ID_Moodle == nil {
-		return fmt.Errorf("la asignatura '%s' no está sincronizada con Moodle (ID_Moodle nulo)", asignatura.NombreCompleto)
+		return s.syncFailed(&grupo, fmt.Errorf("la asignatura '%s' no está sincronizada con Moodle: %w", asignatura.NombreCompleto, ErrParentNotSynced))
 	}
 
-	// A. VERIFICACIÓN: Si ya tiene ID_Moodle, asumimos que está sincronizado.
+	// A. VERIFICACIÓN: Si ya tiene ID_Moodle y Moodle tiene los datos actuales, no hay nada que hacer.
+	// Si se modificó o falló la última sincronización, se actualiza en Moodle.
 	if grupo.ID_Moodle != nil {
-		log.Printf("Grupo ID %d ya sincronizado (Moodle ID: %d). Saltando creación.", grupoID, *grupo.ID_Moodle)
-		return nil
+		if grupo.SyncStatus == models.SyncSynced {
+			log.Printf("Grupo ID %d ya sincronizado (Moodle ID: %d). Saltando creación.", grupoID, *grupo.ID_Moodle)
+			return nil
+		}
+		return s.UpdateInMoodle(ctx, &grupo)
 	}
 
 	// 2. Preparar la petición
 	moodleCourseID := int(*asignatura.ID_Moodle)
-	idNumber := fmt.Sprintf("G-%d-%s", grupoID, grupo.Nombre)
+	idNumber := grupoIDNumber(grupo)
 
 	data := []moodle.GroupRequest{
 		{
@@ -77,7 +97,8 @@ func (s *GrupoService) SyncToMoodle(grupoID uint) error {
 			IDNumber:          idNumber,
 			Description:       grupo.Description,
 			DescriptionFormat: 1, // HTML
-			Visibility:        0, // Visible a todos
+			EnrolmentKey:      grupo.EnrolmentKey,
+			Visibility:        grupo.Visibility,
 			Participation:     1, // Actividad habilitada
 		},
 	}
@@ -86,38 +107,35 @@ func (s *GrupoService) SyncToMoodle(grupoID uint) error {
 
 	// 3. Ejecutar la llamada a la API de Moodle
 	var response []moodle.GroupResponse
-	err = s.MoodleClient.Call("core_group_create_groups", data, &response)
+	err = s.MoodleClient.Call(ctx, "core_group_create_groups", moodle.CreateGroupsParams{Groups: data}, &response)
 
 	// 🛑 B. MANEJO DE ERRORES: Verificar si la falla se debe a un duplicado de nombre
 	if err != nil {
-		// Verificar si el error es de duplicidad (el error que viste antes)
-		if strings.Contains(err.Error(), "Group with the same name already exists in the course") {
-			log.Printf("⚠️ Advertencia: Grupo '%s' ya existe en Moodle. Intentando recuperar ID.", grupo.Nombre)
-
-			// Llama a una función auxiliar para buscar el grupo por nombre/idnumber.
-			// NOTA: Moodle no tiene una función sencilla para buscar grupos por nombre y curso,
-			// pero podemos asumir que el grupo existe y forzar un ID de Moodle para continuar.
-			// Para simplificar, asumimos que si el error es DUPLICADO, debemos actualizar el ID.
-
-			// *****************************************************************
-			// 💡 NOTA: La forma 100% correcta requiere implementar core_group_get_groups
-			// para buscar el grupo por nombre y obtener el ID.
-			// Por ahora, para avanzar, si falla por duplicado, salimos.
-			// *****************************************************************
-
-			return fmt.Errorf("el grupo ya existe en Moodle. Por favor, actualice manualmente el ID_Moodle local o implemente la búsqueda de grupo en Moodle.")
+		// Verificar si el error es de duplicidad (excepción tipada de Moodle)
+		if !moodle.IsDuplicate(err) {
+			return s.syncFailed(&grupo, fmt.Errorf("fallo al crear Grupo en Moodle: %w", err))
+		}
+		log.Printf("⚠️ Advertencia: Grupo '%s' ya existe en Moodle. Intentando recuperar ID.", grupo.Nombre)
 
+		// Buscamos el grupo existente en el curso (por idnumber y, si no, por nombre) y lo vinculamos.
+		existing, lookupErr := moodle.FindGroup(ctx, s.MoodleClient, moodleCourseID, idNumber, grupo.Nombre)
+		if lookupErr != nil {
+			return s.syncFailed(&grupo, fmt.Errorf("el grupo ya existe en Moodle y no se pudo recuperar su ID: %w", lookupErr))
 		}
-		return fmt.Errorf("fallo al crear Grupo en Moodle: %w", err)
+		if existing == nil {
+			return s.syncFailed(&grupo, fmt.Errorf("el grupo ya existe en Moodle pero no se encontró en el curso %d: %w", moodleCourseID, err))
+		}
+		response = []moodle.GroupResponse{*existing}
 	}
 
 	// C. PROCESAR RESPUESTA EXITOSA
 	if len(response) == 0 {
-		return fmt.Errorf("moodle no devolvió ningún Grupo creado")
+		return s.syncFailed(&grupo, fmt.Errorf("moodle no devolvió ningún Grupo creado"))
 	}
 
 	moodleID := uint(response[0].ID)
 	grupo.ID_Moodle = &moodleID
+	grupo.MarkSynced(grupoHash(grupo))
 
 	// 🛑 D. CORRECCIÓN CRÍTICA: Usar Save para actualizar el registro existente.
 	// Esto resuelve el "Duplicate entry '3' for key 'grupos.PRIMARY'" que viste.
@@ -130,15 +148,26 @@ func (s *GrupoService) SyncToMoodle(grupoID uint) error {
 	return nil
 }
 
-// SyncMembersToMoodle añade todos los usuarios locales del grupo a Moodle.
-func (s *GrupoService) SyncMembersToMoodle(grupoID uint) error {
+// AddMembers añade usuarios al grupo local y registra en el outbox que hay que llevar sus miembros a Moodle.
+func (s *GrupoService) AddMembers(grupoID uint, usuarioIDs []uint) error {
+	return s.Outbox.Record(func(tx *gorm.DB) (*models.OutboxEvent, error) {
+		if err := s.Repo.WithTx(tx).AddMembers(grupoID, usuarioIDs); err != nil {
+			return nil, err
+		}
+		return models.NewOutboxEvent(models.OutboxGrupoMiembros, grupoID, models.OutboxUpdate), nil
+	})
+}
+
+// SyncMembersToMoodle añade todos los usuarios locales del grupo a Moodle. Los miembros que todavía no
+// están en Moodle no se pueden añadir: se añade el resto y se devuelve ErrParentNotSynced para reintentarlo.
+func (s *GrupoService) SyncMembersToMoodle(ctx context.Context, grupoID uint) error {
 	grupo, err := s.Repo.GetByID(grupoID)
 	if err != nil {
 		return fmt.Errorf("grupo (ID: %d) no encontrado: %w", grupoID, err)
 	}
 
 	if grupo.ID_Moodle == nil {
-		return fmt.Errorf("el grupo '%s' no está sincronizado con Moodle (ID_Moodle nulo)", grupo.Nombre)
+		return fmt.Errorf("el grupo '%s' no está sincronizado con Moodle: %w", grupo.Nombre, ErrParentNotSynced)
 	}
 
 	// 1. Obtener los usuarios locales que son miembros de este grupo
@@ -162,29 +191,78 @@ func (s *GrupoService) SyncMembersToMoodle(grupoID uint) error {
 		})
 	}
 
+	if len(memberRequests) > 0 {
+		// 3. Ejecutar la llamada a la API de Moodle
+		// core_group_add_group_members no devuelve cuerpo, solo éxito o error.
+		var response interface{}
+		err = s.MoodleClient.Call(ctx, "core_group_add_group_members", moodle.AddGroupMembersParams{Members: memberRequests}, &response)
+		if err != nil {
+			return fmt.Errorf("fallo al añadir miembros al grupo '%s' (Moodle ID: %d): %w", grupo.Nombre, *grupo.ID_Moodle, err)
+		}
+		log.Printf("✅ %d miembros añadidos con éxito al grupo '%s' (Moodle ID: %d).", len(memberRequests), grupo.Nombre, *grupo.ID_Moodle)
+	} else {
+		log.Printf("No hay miembros válidos para sincronizar en el Grupo %d.", grupoID)
+	}
+
 	if len(missingMoodleIDs) > 0 {
-		log.Printf("⚠️ Advertencia: %d miembros del grupo %d no pudieron ser sincronizados (ID_Moodle nulo).", len(missingMoodleIDs), grupoID)
-		// Puedes decidir si retornar un error fatal o continuar con los que sí tienen ID
+		return fmt.Errorf("%d miembros del grupo %d no están en Moodle (usuarios %v): %w", len(missingMoodleIDs), grupoID, missingMoodleIDs, ErrParentNotSynced)
 	}
+	return nil
+}
 
-	if len(memberRequests) == 0 {
-		log.Printf("No hay miembros válidos para sincronizar en el Grupo %d.", grupoID)
-		return nil
+// RemoveMembers quita usuarios del grupo local ('usuario_grupos') y, si el grupo está en Moodle,
+// registra en el outbox la baja de cada uno que también esté en Moodle.
+func (s *GrupoService) RemoveMembers(grupoID uint, usuarioIDs []uint) error {
+	grupo, err := s.Repo.GetByID(grupoID)
+	if err != nil {
+		return fmt.Errorf("grupo (ID: %d) no encontrado: %w", grupoID, err)
 	}
 
-	// 3. Ejecutar la llamada a la API de Moodle
-	// core_group_add_group_members no devuelve cuerpo, solo éxito o error.
-	var response interface{}
-	err = s.MoodleClient.Call("core_group_add_group_members", memberRequests, &response)
+	var events []*models.OutboxEvent
+	if grupo.ID_Moodle != nil {
+		for _, usuarioID := range usuarioIDs {
+			usuario, err := s.UsuarioRepo.GetByID(usuarioID)
+			if err != nil {
+				return fmt.Errorf("usuario (ID: %d) no encontrado: %w", usuarioID, err)
+			}
+			// Un usuario sin ID_Moodle no puede ser miembro del grupo en Moodle.
+			if usuario.ID_Moodle == nil {
+				continue
+			}
+			event := models.NewOutboxEvent(models.OutboxGrupoMiembros, grupoID, models.OutboxDelete)
+			event.MoodleID = grupo.ID_Moodle
+			event.UserMoodleID = usuario.ID_Moodle
+			events = append(events, event)
+		}
+	}
+
+	err = s.Outbox.RecordAll(func(tx *gorm.DB) ([]*models.OutboxEvent, error) {
+		if err := s.Repo.WithTx(tx).RemoveMembers(grupoID, usuarioIDs); err != nil {
+			return nil, err
+		}
+		return events, nil
+	})
 	if err != nil {
-		return fmt.Errorf("fallo al añadir miembros al grupo '%s' (Moodle ID: %d): %w", grupo.Nombre, *grupo.ID_Moodle, err)
+		return fmt.Errorf("no se pudieron quitar los miembros del grupo %d: %w", grupoID, err)
 	}
 
-	log.Printf("✅ %d miembros añadidos con éxito al grupo '%s' (Moodle ID: %d).", len(memberRequests), grupo.Nombre, *grupo.ID_Moodle)
+	log.Printf("✅ %d miembros quitados del grupo '%s' (ID local: %d).", len(usuarioIDs), grupo.Nombre, grupoID)
+	return nil
+}
+
+// RemoveMemberInMoodle quita a un usuario de un grupo de Moodle (core_group_delete_group_members).
+func (s *GrupoService) RemoveMemberInMoodle(ctx context.Context, groupMoodleID, userMoodleID uint) error {
+	members := []moodle.GroupMemberRequest{{GroupID: int(groupMoodleID), UserID: int(userMoodleID)}}
+	err := s.MoodleClient.Call(ctx, "core_group_delete_group_members", moodle.DeleteGroupMembersParams{Members: members}, nil)
+	if err != nil {
+		return fmt.Errorf("fallo al quitar al usuario (Moodle ID: %d) del grupo (Moodle ID: %d): %w", userMoodleID, groupMoodleID, err)
+	}
+	log.Printf("🗑️ Usuario (Moodle ID: %d) quitado del grupo (Moodle ID: %d).", userMoodleID, groupMoodleID)
 	return nil
 }
 
-// UpdateLocal actualiza el registro en la BD local.
+// UpdateLocal actualiza el registro en la BD local y registra el cambio en el outbox.
+// El ID_Moodle no llega en el cuerpo del PUT, así que se conserva el guardado para no perder la vinculación.
 func (s *GrupoService) UpdateLocal(pe *models.Grupo) error {
 	if pe.ID == 0 {
 		return errors.New("ID de Grupo inválido")
@@ -192,15 +270,49 @@ func (s *GrupoService) UpdateLocal(pe *models.Grupo) error {
 	if err := s.validateGrupo(pe); err != nil {
 		return err
 	}
-	return s.Repo.Update(pe)
+	current, err := s.Repo.GetByID(pe.ID)
+	if err != nil {
+		return fmt.Errorf("grupo (ID: %d) no encontrado: %w", pe.ID, err)
+	}
+	pe.ID_Moodle = current.ID_Moodle
+	pe.CreatedAt = current.CreatedAt
+	// Lo mismo con el estado de sincronización, que lo mantiene el servicio.
+	pe.SyncState = current.SyncState
+	pe.MarkDirty(grupoHash(*pe))
+	return s.Outbox.Record(func(tx *gorm.DB) (*models.OutboxEvent, error) {
+		if err := s.Repo.WithTx(tx).Update(pe); err != nil {
+			return nil, err
+		}
+		return models.NewOutboxEvent(models.OutboxGrupo, pe.ID, models.OutboxUpdate), nil
+	})
 }
 
-// DeleteLocal elimina el registro en la BD local.
-func (s *GrupoService) DeleteLocal(id uint) error {
+// Delete elimina el Grupo local y registra el borrado en el outbox. Si propagate es true y está
+// sincronizado, el despachador elimina después su grupo de Moodle.
+func (s *GrupoService) Delete(id uint, propagate bool) error {
 	if id == 0 {
 		return errors.New("ID de Grupo inválido")
 	}
-	return s.Repo.Delete(id)
+	grupo, err := s.Repo.GetByID(id)
+	if err != nil {
+		return fmt.Errorf("grupo no encontrado en BD local: %w", err)
+	}
+	return s.Outbox.Record(func(tx *gorm.DB) (*models.OutboxEvent, error) {
+		if err := s.Repo.WithTx(tx).Delete(id); err != nil {
+			return nil, err
+		}
+		return deleteEvent(models.OutboxGrupo, id, grupo.ID_Moodle, propagate), nil
+	})
+}
+
+// DeleteInMoodle elimina el grupo de Moodle de un grupo ya eliminado localmente.
+func (s *GrupoService) DeleteInMoodle(ctx context.Context, moodleID uint) error {
+	err := s.MoodleClient.Call(ctx, "core_group_delete_groups", moodle.DeleteGroupsParams{GroupIDs: []uint{moodleID}}, nil)
+	if err != nil {
+		return fmt.Errorf("fallo al eliminar Grupo en Moodle (ID: %d): %w", moodleID, err)
+	}
+	log.Printf("🗑️ Grupo eliminado de Moodle (Group ID: %d)", moodleID)
+	return nil
 }
 
 // validateGrupo aplica validaciones de negocio y límites
@@ -216,111 +328,210 @@ func (s *GrupoService) validateGrupo(g *models.Grupo) error {
 	if utf8.RuneCountInString(g.Nombre) > 255 {
 		return errors.New("Nombre excede el máximo de 255 caracteres")
 	}
+	if g.Visibility < 0 || g.Visibility > 3 {
+		return errors.New("Visibility debe ser 0, 1, 2 o 3")
+	}
+	g.EnrolmentKey = strings.TrimSpace(g.EnrolmentKey)
 	// Description: sin límite estricto, normalizamos espacios
 	return nil
 }
 
-// UpdateInMoodle actualiza un grupo existente en Moodle (Nota: Moodle no tiene API para actualizar grupos directamente)
-// Esta función es placeholder para consistencia con otros servicios
-func (s *GrupoService) UpdateInMoodle(g *models.Grupo) error {
+// UpdateInMoodle actualiza un grupo existente en Moodle con core_group_update_groups.
+// Esa función existe desde Moodle 4.2; en versiones anteriores el error cumple errors.Is(err, moodle.ErrUnsupported).
+func (s *GrupoService) UpdateInMoodle(ctx context.Context, g *models.Grupo) error {
 	if g.ID_Moodle == nil {
 		return errors.New("el grupo no tiene ID_Moodle, no se puede actualizar")
 	}
-	log.Printf("Advertencia: Moodle no tiene API para actualizar grupos. Grupo '%s' (ID local: %d, Moodle ID: %d) no puede ser actualizado automáticamente.", g.Nombre, g.ID, *g.ID_Moodle)
+
+	data := []moodle.GroupUpdateRequest{groupUpdateRequest(*g)}
+
+	err := s.MoodleClient.Call(ctx, "core_group_update_groups", moodle.UpdateGroupsParams{Groups: data}, nil)
+	if err != nil {
+		if errors.Is(err, moodle.ErrUnsupported) {
+			log.Printf("⚠️ Moodle no soporta core_group_update_groups. Grupo '%s' (Moodle ID: %d) no actualizado.", g.Nombre, *g.ID_Moodle)
+		}
+		return s.syncFailed(g, fmt.Errorf("fallo al actualizar Grupo en Moodle (ID: %d): %w", *g.ID_Moodle, err))
+	}
+
+	g.MarkSynced(grupoHash(*g))
+	if err := s.Repo.Update(g); err != nil {
+		return fmt.Errorf("grupo actualizado en Moodle, pero falló guardar el estado de sincronización: %w", err)
+	}
+
+	log.Printf("✅ Grupo '%s' (ID local: %d, Moodle ID: %d) actualizado exitosamente en Moodle", g.Nombre, g.ID, *g.ID_Moodle)
 	return nil
 }
 
-// BulkSyncToMoodle sincroniza todos los grupos sin ID_Moodle a Moodle
-func (s *GrupoService) BulkSyncToMoodle() {
-	go func() {
-		log.Println("Iniciando sincronización masiva de Grupos a Moodle...")
+// groupUpdateRequest construye la actualización completa de un grupo. El ID de Moodle queda a 0 si el
+// grupo aún no está sincronizado.
+func groupUpdateRequest(g models.Grupo) moodle.GroupUpdateRequest {
+	req := moodle.GroupUpdateRequest{
+		Name:              g.Nombre,
+		Description:       g.Description,
+		DescriptionFormat: 1, // HTML, igual que al crear
+		EnrolmentKey:      g.EnrolmentKey,
+		IDNumber:          grupoIDNumber(g),
+		Visibility:        g.Visibility,
+	}
+	if g.ID_Moodle != nil {
+		req.ID = *g.ID_Moodle
+	}
+	return req
+}
 
-		// Obtener todos los grupos sin ID_Moodle
-		grupos, err := s.Repo.GetUnsynced()
-		if err != nil {
-			log.Printf("Error al obtener grupos sin sincronizar: %v", err)
-			return
+// grupoIDNumberMax es la longitud máxima del idnumber de un grupo en Moodle (groups.idnumber).
+const grupoIDNumberMax = 100
+
+// grupoIDNumber genera el idnumber con el que el grupo se identifica en Moodle. Se recorta a
+// grupoIDNumberMax caracteres: el nombre admite 255 y Moodle rechazaría el grupo con invalidparameter.
+func grupoIDNumber(g models.Grupo) string {
+	idNumber := fmt.Sprintf("G-%d-%s", g.ID, g.Nombre)
+	if runes := []rune(idNumber); len(runes) > grupoIDNumberMax {
+		idNumber = string(runes[:grupoIDNumberMax])
+	}
+	return idNumber
+}
+
+// BulkSyncToMoodle sincroniza todos los grupos sin ID_Moodle a Moodle y anota el resultado de cada uno
+// en progress. Si ctx se cancela, la llamada en curso se aborta y no se procesan más asignaturas.
+func (s *GrupoService) BulkSyncToMoodle(ctx context.Context, progress *SyncProgress) error {
+	log.Println("Iniciando sincronización masiva de Grupos a Moodle...")
+
+	// Obtener todos los grupos sin ID_Moodle
+	grupos, err := s.Repo.GetUnsynced()
+	if err != nil {
+		return fmt.Errorf("error al obtener grupos sin sincronizar: %w", err)
+	}
+	progress.SetTotal(len(grupos))
+
+	if len(grupos) == 0 {
+		log.Println("No hay grupos pendientes de sincronización")
+		return nil
+	}
+
+	log.Printf("Encontrados %d grupos para sincronizar", len(grupos))
+	// Agrupar grupos por CourseID (Asignatura) para sincronización eficiente
+	courseGroups := make(map[uint][]models.Grupo)
+	for _, grupo := range grupos {
+		courseGroups[grupo.CourseID] = append(courseGroups[grupo.CourseID], grupo)
+	}
+
+	successCount := 0
+	errorCount := 0
+	fail := func(g models.Grupo, err error) {
+		errorCount++
+		progress.Failure("grupo", g.ID, err)
+		s.syncFailed(&g, err)
+	}
+	failAll := func(list []models.Grupo, err error) {
+		for _, g := range list {
+			fail(g, err)
 		}
+	}
 
-		if len(grupos) == 0 {
-			log.Println("No hay grupos pendientes de sincronización")
-			return
+	// Procesar cada grupo de grupos por asignatura
+	for courseID, groupList := range courseGroups {
+		if ctx.Err() != nil {
+			log.Printf("Sincronización masiva de grupos cancelada: %v", ctx.Err())
+			return fmt.Errorf("sincronización cancelada: %w", ctx.Err())
 		}
+		log.Printf("Procesando %d grupos para Asignatura ID: %d", len(groupList), courseID)
 
-		log.Printf("Encontrados %d grupos para sincronizar", len(grupos))
-		// Agrupar grupos por CourseID (Asignatura) para sincronización eficiente
-		courseGroups := make(map[uint][]models.Grupo)
-		for _, grupo := range grupos {
-			courseGroups[grupo.CourseID] = append(courseGroups[grupo.CourseID], grupo)
+		// Validar que la asignatura esté sincronizada
+		asignatura, err := s.AsignaturaRepo.GetByID(courseID)
+		if err != nil {
+			log.Printf("Asigantura ID %d no encontrada. Saltando %d grupos.", courseID, len(groupList))
+			failAll(groupList, fmt.Errorf("asignatura %d no encontrada: %w", courseID, err))
+			continue
 		}
 
-		successCount := 0
-		errorCount := 0
+		if asignatura.ID_Moodle == nil {
+			log.Printf("Asignatura ID %d no tiene ID_Moodle. Saltando %d grupos.", courseID, len(groupList))
+			failAll(groupList, fmt.Errorf("la asignatura %d no está sincronizada con Moodle", courseID))
+			continue
+		}
 
-		// Procesar cada grupo de grupos por asignatura
-		for courseID, groupList := range courseGroups {
-			log.Printf("Procesando %d grupos para Asignatura ID: %d", len(groupList), courseID)
+		moodleCourseID := int(*asignatura.ID_Moodle)
 
-			// Validar que la asignatura esté sincronizada
-			asignatura, err := s.AsignaturaRepo.GetByID(courseID)
-			if err != nil {
-				log.Printf("Asigantura ID %d no encontrada. Saltando %d grupos.", courseID, len(groupList))
-				errorCount += len(groupList)
-				continue
-			}
+		// Los grupos que ya existen en el curso (mismo idnumber o nombre) se vinculan en lugar de crearse,
+		// porque un solo duplicado haría fallar todo el lote.
+		existingGroups, err := moodle.GetCourseGroups(ctx, s.MoodleClient, moodleCourseID)
+		if err != nil {
+			log.Printf("Error al consultar grupos existentes de Asignatura ID %d: %v", courseID, err)
+			failAll(groupList, err)
+			continue
+		}
 
-			if asignatura.ID_Moodle == nil {
-				log.Printf("Asignatura ID %d no tiene ID_Moodle. Saltando %d grupos.", courseID, len(groupList))
-				errorCount += len(groupList)
+		var pending []models.Grupo
+		for _, grupo := range groupList {
+			existing := moodle.MatchGroup(existingGroups, grupoIDNumber(grupo), grupo.Nombre)
+			if existing == nil {
+				pending = append(pending, grupo)
 				continue
 			}
-
-			moodleCourseID := int(*asignatura.ID_Moodle)
-
-			// Construir array de GroupRequest para este curso
-			data := make([]moodle.GroupRequest, len(groupList))
-			for i, grupo := range groupList {
-				idNumber := fmt.Sprintf("G-%d-%s", grupo.ID, grupo.Nombre)
-				data[i] = moodle.GroupRequest{
-					CourseID:          moodleCourseID,
-					Name:              grupo.Nombre,
-					IDNumber:          idNumber,
-					Description:       grupo.Description,
-					DescriptionFormat: 1, // HTML
-					Visibility:        0, // Visible
-					Participation:     1, // Habilitado
-				}
+			moodleID := uint(existing.ID)
+			grupo.ID_Moodle = &moodleID
+			grupo.MarkSynced(grupoHash(grupo))
+			if err := s.Repo.DB.Save(&grupo).Error; err != nil {
+				log.Printf("Error al actualizar ID_Moodle para Grupo ID %d: %v", grupo.ID, err)
+				fail(grupo, fmt.Errorf("existe en Moodle (ID %d) pero falló guardar el ID local: %w", moodleID, err))
+			} else {
+				log.Printf("Grupo '%s' (ID local: %d) ya existía en Moodle. Vinculado con Moodle ID: %d", grupo.Nombre, grupo.ID, moodleID)
+				successCount++
+				progress.Success()
 			}
+		}
+		groupList = pending
+		if len(groupList) == 0 {
+			continue
+		}
 
-			// Llamar a la API de Moodle para crear grupos en batch
-			var response []moodle.GroupResponse
-			err = s.MoodleClient.Call("core_group_create_groups", data, &response)
-			if err != nil {
-				log.Printf("Error al crear grupos en Moodle para Asignatura ID %d: %v", courseID, err)
-				errorCount += len(groupList)
-				continue
+		// Construir array de GroupRequest para este curso
+		data := make([]moodle.GroupRequest, len(groupList))
+		for i, grupo := range groupList {
+			data[i] = moodle.GroupRequest{
+				CourseID:          moodleCourseID,
+				Name:              grupo.Nombre,
+				IDNumber:          grupoIDNumber(grupo),
+				Description:       grupo.Description,
+				DescriptionFormat: 1, // HTML
+				EnrolmentKey:      grupo.EnrolmentKey,
+				Visibility:        grupo.Visibility,
+				Participation:     1, // Habilitado
 			}
+		}
 
-			// Actualizar ID_Moodle en la base de datos local
-			for i, grupo := range groupList {
-				if i < len(response) {
-					moodleID := uint(response[i].ID)
-					grupo.ID_Moodle = &moodleID
-
-					if err := s.Repo.DB.Save(&grupo).Error; err != nil {
-						log.Printf("Error al actualizar ID_Moodle para Grupo ID %d: %v", grupo.ID, err)
-						errorCount++
-					} else {
-						log.Printf("Grupo '%s' (ID local: %d) sincronizado con Moodle ID: %d", grupo.Nombre, grupo.ID, moodleID)
-						successCount++
-					}
+		// Llamar a la API de Moodle para crear grupos en batch
+		var response []moodle.GroupResponse
+		err = s.MoodleClient.Call(ctx, "core_group_create_groups", moodle.CreateGroupsParams{Groups: data}, &response)
+		if err != nil {
+			log.Printf("Error al crear grupos en Moodle para Asignatura ID %d: %v", courseID, err)
+			// Con lotes, la respuesta conserva lo creado antes del fallo: se enlaza igualmente
+			// y los grupos sin respuesta se cuentan como error más abajo.
+		}
+
+		// Actualizar ID_Moodle en la base de datos local
+		for i, grupo := range groupList {
+			if i < len(response) {
+				moodleID := uint(response[i].ID)
+				grupo.ID_Moodle = &moodleID
+				grupo.MarkSynced(grupoHash(grupo))
+
+				if err := s.Repo.DB.Save(&grupo).Error; err != nil {
+					log.Printf("Error al actualizar ID_Moodle para Grupo ID %d: %v", grupo.ID, err)
+					fail(grupo, fmt.Errorf("creado en Moodle (ID %d) pero falló guardar el ID local: %w", moodleID, err))
 				} else {
-					log.Printf("No se recibió respuesta de Moodle para Grupo ID %d", grupo.ID)
-					errorCount++
+					log.Printf("Grupo '%s' (ID local: %d) sincronizado con Moodle ID: %d", grupo.Nombre, grupo.ID, moodleID)
+					successCount++
+					progress.Success()
 				}
+			} else {
+				log.Printf("No se recibió respuesta de Moodle para Grupo ID %d", grupo.ID)
+				fail(grupo, sinRespuesta(err))
 			}
 		}
+	}
 
-		log.Printf("Sincronización masiva completada: %d exitosas, %d errores", successCount, errorCount)
-	}()
+	log.Printf("Sincronización masiva completada: %d exitosas, %d errores", successCount, errorCount)
+	return nil
 }
@@ -0,0 +1,291 @@
+package services
+
+import (
+	"api_concurrencia/src/models"
+	"api_concurrencia/src/moodle"
+	"api_concurrencia/src/repository"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// outboxBatchSize es el máximo de eventos que se leen en cada pasada del despachador.
+	outboxBatchSize = 100
+	// Espera antes de reintentar un evento: outboxRetryBase, el doble en cada fallo, hasta outboxRetryMax.
+	outboxRetryBase = 30 * time.Second
+	outboxRetryMax  = time.Hour
+)
+
+// ErrEventoNoFallido se devuelve al reintentar un evento que no está fallido.
+var ErrEventoNoFallido = errors.New("solo se pueden reintentar eventos fallidos")
+
+// ErrParentNotSynced se devuelve al llevar a Moodle un registro cuyo padre (o el curso, grupo o usuario
+// del que depende) todavía no está en Moodle. Se reintenta: el evento del padre se entregará antes o después.
+var ErrParentNotSynced = errors.New("el registro del que depende todavía no está sincronizado con Moodle")
+
+// OutboxHandler lleva a Moodle los eventos de una entidad.
+type OutboxHandler struct {
+	// Sync lleva el estado actual del registro local a Moodle (crea o actualiza).
+	Sync func(ctx context.Context, id uint) error
+	// Delete elimina de Moodle lo que correspondía a un registro local eliminado.
+	Delete func(ctx context.Context, moodleID uint) error
+	// Remove da de baja a un usuario del curso o grupo moodleID (bajas de matrículas y de miembros).
+	Remove func(ctx context.Context, moodleID, userMoodleID uint) error
+}
+
+// OutboxService registra los cambios locales como eventos en la misma transacción que el cambio y los
+// entrega a Moodle en segundo plano: en orden por registro y al menos una vez (un evento solo se marca
+// enviado después de que Moodle acepte el cambio, así que tras una caída puede repetirse).
+type OutboxService struct {
+	Repo        *repository.OutboxRepository
+	MaxAttempts int
+
+	handlers map[string]OutboxHandler
+	wake     chan struct{}
+}
+
+func NewOutboxService(repo *repository.OutboxRepository) *OutboxService {
+	return &OutboxService{
+		Repo:        repo,
+		MaxAttempts: outboxMaxAttemptsFromEnv(),
+		handlers:    make(map[string]OutboxHandler),
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// Handle registra cómo se entregan los eventos de la entidad. Debe llamarse antes de Run.
+func (o *OutboxService) Handle(entidad string, h OutboxHandler) {
+	o.handlers[entidad] = h
+}
+
+// Record ejecuta write en una transacción y registra en ella el evento que devuelve: el cambio local y
+// su evento se guardan juntos o no se guarda ninguno. Si write devuelve un evento nil, el cambio no
+// tiene nada que llevar a Moodle y solo se guarda el cambio.
+func (o *OutboxService) Record(write func(tx *gorm.DB) (*models.OutboxEvent, error)) error {
+	return o.RecordAll(func(tx *gorm.DB) ([]*models.OutboxEvent, error) {
+		event, err := write(tx)
+		if err != nil || event == nil {
+			return nil, err
+		}
+		return []*models.OutboxEvent{event}, nil
+	})
+}
+
+// RecordAll es Record para cambios que generan varios eventos (por ejemplo, quitar varios miembros de
+// un grupo): se guardan todos en la transacción del cambio, en el orden en que se devuelven.
+func (o *OutboxService) RecordAll(write func(tx *gorm.DB) ([]*models.OutboxEvent, error)) error {
+	recorded := false
+	err := o.Repo.DB.Transaction(func(tx *gorm.DB) error {
+		events, err := write(tx)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			if err := o.Repo.WithTx(tx).Add(event); err != nil {
+				return err
+			}
+			recorded = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if recorded {
+		o.notify()
+	}
+	return nil
+}
+
+// notify avisa al despachador sin bloquear: si ya tiene un aviso pendiente, con ese basta.
+func (o *OutboxService) notify() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run entrega los eventos pendientes cada interval (o en cuanto se registra uno nuevo) hasta que ctx se
+// cancela. Con interval <= 0 no hace nada: los eventos se siguen registrando, pero no se entregan.
+func (o *OutboxService) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		log.Printf("⚠️ Propagación automática a Moodle desactivada (MOODLE_OUTBOX_INTERVAL=0).")
+		return
+	}
+	log.Printf("📤 Propagación automática a Moodle activada (revisión cada %s).", interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for ctx.Err() == nil {
+		delivered, err := o.DispatchPending(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("⚠️ Error al entregar eventos a Moodle: %v", err)
+		}
+		// Tras entregar eventos puede haber otros del mismo registro esperando su turno.
+		if delivered > 0 {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+		case <-o.wake:
+		}
+	}
+}
+
+// DispatchPending entrega, en orden, los eventos a los que les toca y devuelve cuántos se entregaron.
+// Un evento que falla retiene los posteriores de su registro: se reintenta más tarde si el fallo es
+// transitorio y queda fallido si no.
+func (o *OutboxService) DispatchPending(ctx context.Context) (int, error) {
+	events, err := o.Repo.GetDeliverable(time.Now(), outboxBatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("no se pudieron leer los eventos pendientes: %w", err)
+	}
+
+	delivered := 0
+	for i := range events {
+		e := &events[i]
+		err := o.deliver(ctx, e)
+		if ctx.Err() != nil {
+			// Interrumpido: no cuenta como intento y se repetirá en la próxima ejecución.
+			return delivered, ctx.Err()
+		}
+		if err != nil {
+			o.retryLater(e, err)
+			continue
+		}
+		enviado := time.Now()
+		e.Estado = models.OutboxEnviado
+		e.EnviadoEn = &enviado
+		e.UltimoError = nil
+		if err := o.Repo.Update(e); err != nil {
+			// Moodle ya tiene el cambio: si no se pudo anotar, se volverá a entregar (es idempotente).
+			log.Printf("⚠️ Evento %d entregado, pero no se pudo marcar como enviado: %v", e.ID, err)
+			continue
+		}
+		delivered++
+	}
+	return delivered, nil
+}
+
+// deliver lleva un evento a Moodle con el manejador de su entidad.
+func (o *OutboxService) deliver(ctx context.Context, e *models.OutboxEvent) error {
+	h, ok := o.handlers[e.Entidad]
+	if !ok {
+		return fmt.Errorf("no hay manejador para la entidad %q", e.Entidad)
+	}
+	switch e.Operacion {
+	case models.OutboxCreate, models.OutboxUpdate:
+		err := h.Sync(ctx, e.EntidadID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// El registro se eliminó después del cambio: lo que haya que hacer en Moodle lo decide su evento delete.
+			log.Printf("Evento %d (%s %s %d) descartado: el registro ya no existe.", e.ID, e.Operacion, e.Entidad, e.EntidadID)
+			return nil
+		}
+		return err
+	case models.OutboxDelete:
+		if e.MoodleID == nil {
+			return nil // Eliminado sin propagación o nunca llegó a Moodle
+		}
+		if e.UserMoodleID != nil {
+			if h.Remove == nil {
+				return fmt.Errorf("la entidad %q no admite bajas de usuarios", e.Entidad)
+			}
+			return h.Remove(ctx, *e.MoodleID, *e.UserMoodleID)
+		}
+		return h.Delete(ctx, *e.MoodleID)
+	default:
+		return fmt.Errorf("operación desconocida %q", e.Operacion)
+	}
+}
+
+// retryLater anota el fallo del evento y programa el siguiente intento. Los rechazos permanentes de
+// Moodle (validación, función no habilitada, categoría con contenido...) no se arreglan esperando, así
+// que el evento queda fallido en el primer intento, igual que al agotar los reintentos. Un evento
+// fallido retiene los posteriores de su registro hasta que se reintenta a mano.
+func (o *OutboxService) retryLater(e *models.OutboxEvent, err error) {
+	msg := err.Error()
+	e.Intentos++
+	e.UltimoError = &msg
+	switch {
+	case !outboxRetryable(err):
+		e.Estado = models.OutboxFallido
+		log.Printf("❌ Evento %d (%s %s %d) fallido (error permanente, no se reintenta): %v", e.ID, e.Operacion, e.Entidad, e.EntidadID, err)
+	case o.MaxAttempts > 0 && e.Intentos >= o.MaxAttempts:
+		e.Estado = models.OutboxFallido
+		log.Printf("❌ Evento %d (%s %s %d) fallido tras %d intentos: %v", e.ID, e.Operacion, e.Entidad, e.EntidadID, e.Intentos, err)
+	default:
+		wait := outboxRetryBase << (e.Intentos - 1)
+		if wait > outboxRetryMax || wait <= 0 {
+			wait = outboxRetryMax
+		}
+		e.SiguienteIntento = time.Now().Add(wait)
+		log.Printf("⚠️ Evento %d (%s %s %d) falló (intento %d), se reintentará en %s: %v", e.ID, e.Operacion, e.Entidad, e.EntidadID, e.Intentos, wait, err)
+	}
+	if saveErr := o.Repo.Update(e); saveErr != nil {
+		log.Printf("⚠️ No se pudo guardar el fallo del evento %d: %v", e.ID, saveErr)
+	}
+}
+
+// outboxRetryable indica si tiene sentido reintentar un evento que falló con err: sí para los fallos
+// transitorios de Moodle (red, HTTP 5xx, bloqueos), para un padre que aún no está en Moodle y para los
+// errores de la BD local; no para lo que Moodle rechaza o no permite hacer.
+func outboxRetryable(err error) bool {
+	if moodle.IsTransient(err) || errors.Is(err, ErrParentNotSynced) {
+		return true
+	}
+	var httpErr *moodle.HTTPError
+	var netErr *moodle.NetworkError
+	if _, ok := moodle.AsMoodleError(err); ok || errors.As(err, &httpErr) || errors.As(err, &netErr) {
+		return false
+	}
+	return !errors.Is(err, moodle.ErrUnsupported) && !errors.Is(err, ErrCategoryNotEmpty)
+}
+
+// GetAll devuelve los eventos más recientes, filtrados opcionalmente por estado.
+func (o *OutboxService) GetAll(estado string, limit int) ([]models.OutboxEvent, error) {
+	return o.Repo.GetAll(estado, limit)
+}
+
+// Retry vuelve a dejar pendiente un evento fallido para que se entregue en cuanto sea posible.
+func (o *OutboxService) Retry(id uint) (models.OutboxEvent, error) {
+	e, err := o.Repo.GetByID(id)
+	if err != nil {
+		return e, err
+	}
+	if e.Estado != models.OutboxFallido {
+		return e, fmt.Errorf("%w: el evento %d está %s", ErrEventoNoFallido, id, e.Estado)
+	}
+	e.Estado = models.OutboxPendiente
+	e.Intentos = 0
+	e.SiguienteIntento = time.Now()
+	if err := o.Repo.Update(&e); err != nil {
+		return e, fmt.Errorf("no se pudo reintentar el evento %d: %w", id, err)
+	}
+	o.notify()
+	return e, nil
+}
+
+// deleteEvent es el evento del borrado local de un registro. Con propagate lleva el ID de Moodle del
+// registro, que el despachador elimina después; sin él solo deja constancia del borrado.
+func deleteEvent(entidad string, id uint, moodleID *uint, propagate bool) *models.OutboxEvent {
+	event := models.NewOutboxEvent(entidad, id, models.OutboxDelete)
+	if propagate {
+		event.MoodleID = moodleID
+	}
+	return event
+}
+
+// OutboxIntervalFromEnv lee MOODLE_OUTBOX_INTERVAL (por defecto 5s). 0 desactiva la entrega automática.
+func OutboxIntervalFromEnv() time.Duration {
+	return moodle.EnvDuration("MOODLE_OUTBOX_INTERVAL", 5*time.Second)
+}
+
+// outboxMaxAttemptsFromEnv lee MOODLE_OUTBOX_MAX_ATTEMPTS (por defecto 10). 0 reintenta indefinidamente.
+func outboxMaxAttemptsFromEnv() int {
+	return moodle.EnvInt("MOODLE_OUTBOX_MAX_ATTEMPTS", 10)
+}
@@ -0,0 +1,233 @@
+package services
+
+import (
+	"api_concurrencia/src/models"
+	"api_concurrencia/src/moodle"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// eventsOf devuelve los eventos de un registro en orden de registro.
+func eventsOf(t *testing.T, svc *Services, entidad string, id uint) []models.OutboxEvent {
+	t.Helper()
+	all, err := svc.Outbox.GetAll("", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []models.OutboxEvent
+	for i := len(all) - 1; i >= 0; i-- {
+		if all[i].Entidad == entidad && all[i].EntidadID == id {
+			events = append(events, all[i])
+		}
+	}
+	return events
+}
+
+// dispatchAll entrega eventos hasta que no quede ninguno al que le toque.
+func dispatchAll(t *testing.T, svc *Services) {
+	t.Helper()
+	for i := 0; i < 10; i++ {
+		n, err := svc.Outbox.DispatchPending(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 {
+			return
+		}
+	}
+	t.Fatal("el despachador no terminó de entregar los eventos")
+}
+
+// Los eventos de un registro se entregan en el orden en que se registraron, uno por pasada.
+func TestOutboxDeliversInOrder(t *testing.T) {
+	svc, fake, _ := newTestServices(t)
+	pe := models.ProgramaEstudio{Nombre: "Original", ID_Externo: strPtr("PROG-1")}
+	if err := svc.Programas.CreateLocal(&pe); err != nil {
+		t.Fatal(err)
+	}
+	pe.Nombre = "Renombrado"
+	if err := svc.Programas.UpdateLocal(&pe); err != nil {
+		t.Fatal(err)
+	}
+	otro := models.ProgramaEstudio{Nombre: "Otro", ID_Externo: strPtr("PROG-2")}
+	if err := svc.Programas.CreateLocal(&otro); err != nil {
+		t.Fatal(err)
+	}
+
+	// Primera pasada: el create de cada registro; el update espera a que se envíe su create.
+	n, err := svc.Outbox.DispatchPending(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("primera pasada entregó %d eventos, se esperaban 2", n)
+	}
+	dispatchAll(t, svc)
+
+	want := []string{"core_course_create_categories", "core_course_create_categories", "core_course_update_categories"}
+	if len(fake.Calls) != len(want) {
+		t.Fatalf("llamadas = %v, se esperaban %v", fake.Calls, want)
+	}
+	for i := range want {
+		if fake.Calls[i] != want[i] {
+			t.Fatalf("llamadas = %v, se esperaban %v", fake.Calls, want)
+		}
+	}
+
+	got, err := svc.Programas.GetByID(pe.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID_Moodle == nil || fake.Categories[*got.ID_Moodle].Name != "Renombrado" {
+		t.Errorf("la categoría en Moodle no tiene el último nombre local")
+	}
+	for _, e := range eventsOf(t, svc, models.OutboxProgramaEstudio, pe.ID) {
+		if e.Estado != models.OutboxEnviado || e.EnviadoEn == nil {
+			t.Errorf("evento %d (%s) = %s, se esperaba enviado", e.ID, e.Operacion, e.Estado)
+		}
+	}
+}
+
+func TestOutboxRetry(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		maxAttempts int
+		wantEstado  string
+	}{
+		{"error transitorio se reintenta", &moodle.HTTPError{StatusCode: http.StatusServiceUnavailable}, 10, models.OutboxPendiente},
+		{"error de red se reintenta", &moodle.NetworkError{Err: errors.New("connection refused")}, 10, models.OutboxPendiente},
+		{"padre sin sincronizar se reintenta", fmt.Errorf("cuatrimestre 3: %w", ErrParentNotSynced), 10, models.OutboxPendiente},
+		{"error de la BD local se reintenta", errors.New("database is locked"), 10, models.OutboxPendiente},
+		{"error permanente falla al primer intento", &moodle.MoodleError{ErrorCode: "invalidparameter", Message: "Invalid parameter value detected"}, 10, models.OutboxFallido},
+		{"reintentos agotados", &moodle.HTTPError{StatusCode: http.StatusInternalServerError}, 1, models.OutboxFallido},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, fake, _ := newTestServices(t)
+			svc.Outbox.MaxAttempts = tt.maxAttempts
+			pe := models.ProgramaEstudio{Nombre: "Original", ID_Externo: strPtr("PROG-1")}
+			if err := svc.Programas.CreateLocal(&pe); err != nil {
+				t.Fatal(err)
+			}
+			pe.Nombre = "Renombrado"
+			if err := svc.Programas.UpdateLocal(&pe); err != nil {
+				t.Fatal(err)
+			}
+			fake.FailNext("core_course_create_categories", tt.err)
+
+			if n, err := svc.Outbox.DispatchPending(context.Background()); err != nil || n != 0 {
+				t.Fatalf("DispatchPending = %d, %v; no debería entregar nada", n, err)
+			}
+			events := eventsOf(t, svc, models.OutboxProgramaEstudio, pe.ID)
+			create, update := events[0], events[1]
+			if create.Estado != tt.wantEstado || create.Intentos != 1 || create.UltimoError == nil {
+				t.Fatalf("create = %s con %d intentos, se esperaba %s con 1 intento y el error anotado", create.Estado, create.Intentos, tt.wantEstado)
+			}
+			if tt.wantEstado == models.OutboxPendiente && !create.SiguienteIntento.After(time.Now()) {
+				t.Error("el reintento debería esperar antes del siguiente intento")
+			}
+			// Mientras el create no se envía, el update del mismo registro espera.
+			if update.Estado != models.OutboxPendiente || update.Intentos != 0 {
+				t.Errorf("update = %s con %d intentos, debería seguir esperando su turno", update.Estado, update.Intentos)
+			}
+			if n, _ := svc.Outbox.DispatchPending(context.Background()); n != 0 {
+				t.Errorf("se entregaron %d eventos antes de que le tocara al create", n)
+			}
+
+			// Reintentar a mano solo es posible con eventos fallidos, y los deja listos para entregarse.
+			_, err := svc.Outbox.Retry(create.ID)
+			if tt.wantEstado == models.OutboxPendiente {
+				if !errors.Is(err, ErrEventoNoFallido) {
+					t.Fatalf("Retry de un evento pendiente = %v, se esperaba ErrEventoNoFallido", err)
+				}
+				create.SiguienteIntento = time.Now()
+				if err := svc.Outbox.Repo.Update(&create); err != nil {
+					t.Fatal(err)
+				}
+			} else if err != nil {
+				t.Fatalf("Retry: %v", err)
+			}
+
+			dispatchAll(t, svc)
+			for _, e := range eventsOf(t, svc, models.OutboxProgramaEstudio, pe.ID) {
+				if e.Estado != models.OutboxEnviado {
+					t.Errorf("evento %d (%s) = %s tras reintentar, se esperaba enviado", e.ID, e.Operacion, e.Estado)
+				}
+			}
+			got, err := svc.Programas.GetByID(pe.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.ID_Moodle == nil || fake.Categories[*got.ID_Moodle].Name != "Renombrado" {
+				t.Error("tras reintentar, la categoría en Moodle debería tener el último nombre local")
+			}
+		})
+	}
+}
+
+// Las altas y bajas de matrículas pasan por el outbox: se guardan en local al momento y se entregan a
+// Moodle en el orden en que ocurrieron, incluso si el usuario vuelve a matricularse tras una baja.
+func TestOutboxMatriculas(t *testing.T) {
+	svc, fake, _ := newTestServices(t)
+	if err := svc.Roles.Create(&models.RolMoodle{Nombre: "Alumno", MoodleRoleID: 5, Shortname: "student", Estudiante: true}); err != nil {
+		t.Fatal(err)
+	}
+	pe := models.ProgramaEstudio{Nombre: "Ingeniería", ID_Externo: strPtr("PROG-1")}
+	if err := svc.Programas.CreateLocal(&pe); err != nil {
+		t.Fatal(err)
+	}
+	c := models.Cuatrimestre{Nombre: "Primero", ProgramaEstudioID: pe.ID}
+	if err := svc.Cuatrimestres.CreateLocal(&c); err != nil {
+		t.Fatal(err)
+	}
+	a := models.Asignatura{NombreCompleto: "Cálculo", NombreCorto: "CALC", CuatrimestreID: c.ID}
+	if err := svc.Asignaturas.CreateLocal(&a); err != nil {
+		t.Fatal(err)
+	}
+	u := models.Usuario{Username: "ana", Password: "Segura123#", FirstName: "Ana", LastName: "López", Email: "ana@example.com", Rol: "Alumno"}
+	if err := svc.Usuarios.CreateLocal(&u); err != nil {
+		t.Fatal(err)
+	}
+
+	// Hasta que la asignatura y el usuario están en Moodle no hay con qué matricular.
+	if _, err := svc.Usuarios.MatricularUsuario(u.ID, a.ID, OpcionesMatricula{}); !errors.Is(err, ErrParentNotSynced) {
+		t.Fatalf("MatricularUsuario sin sincronizar = %v, se esperaba ErrParentNotSynced", err)
+	}
+	dispatchAll(t, svc)
+	fake.Calls = nil
+
+	if _, err := svc.Usuarios.MatricularUsuario(u.ID, a.ID, OpcionesMatricula{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.Usuarios.DesmatricularUsuario(u.ID, a.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.Usuarios.MatricularUsuario(u.ID, a.ID, OpcionesMatricula{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.Calls) != 0 {
+		t.Fatalf("se llamó a Moodle antes de entregar los eventos: %v", fake.Calls)
+	}
+
+	dispatchAll(t, svc)
+	want := []string{"enrol_manual_enrol_users", "enrol_manual_unenrol_users", "enrol_manual_enrol_users"}
+	if fmt.Sprint(fake.Calls) != fmt.Sprint(want) {
+		t.Fatalf("llamadas = %v, se esperaban %v", fake.Calls, want)
+	}
+	m, err := svc.Usuarios.Repo.GetMatricula(u.ID, a.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.SyncStatus != models.SyncSynced {
+		t.Errorf("matrícula = %s, se esperaba synced", m.SyncStatus)
+	}
+	if _, ok := fake.Enrolments[fmt.Sprintf("%d:%d", m.CourseMoodleID, m.UserMoodleID)]; !ok {
+		t.Error("el usuario debería seguir matriculado en Moodle tras volver a matricularse")
+	}
+}
@@ -1,100 +1,238 @@
 package services
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 
 	"api_concurrencia/src/models"
 	"api_concurrencia/src/moodle"
 	"api_concurrencia/src/repository"
+
+	"gorm.io/gorm"
 )
 
 type ProgramaEstudioService struct {
 	Repo *repository.ProgramaEstudioRepository
 	// Aquí se inyectaría el cliente de Moodle API
-	MoodleClient *moodle.Client
+	MoodleClient moodle.MoodleAPI
+	Outbox       *OutboxService
+	Roles        *RolMoodleService // Indica qué roles son de alumno (miembros de la cohorte)
 }
 
-func NewProgramaEstudioService(repo *repository.ProgramaEstudioRepository, client *moodle.Client) *ProgramaEstudioService {
-	return &ProgramaEstudioService{Repo: repo, MoodleClient: client}
+func NewProgramaEstudioService(repo *repository.ProgramaEstudioRepository, client moodle.MoodleAPI, outbox *OutboxService, roles *RolMoodleService) *ProgramaEstudioService {
+	return &ProgramaEstudioService{Repo: repo, MoodleClient: client, Outbox: outbox, Roles: roles}
 }
 
-// CreateLocal crea el registro en la BD local y lo prepara.
+// CreateLocal crea el registro en la BD local y registra su creación en el outbox para llevarla a Moodle.
 func (s *ProgramaEstudioService) CreateLocal(pe *models.ProgramaEstudio) error {
-	return s.Repo.Create(pe)
+	pe.SyncState = models.SyncState{}
+	return s.Outbox.Record(func(tx *gorm.DB) (*models.OutboxEvent, error) {
+		if err := s.Repo.WithTx(tx).Create(pe); err != nil {
+			return nil, err
+		}
+		return models.NewOutboxEvent(models.OutboxProgramaEstudio, pe.ID, models.OutboxCreate), nil
+	})
 }
 
 func safeString(s *string) string {
-    if s != nil {
-        return *s
-    }
-    return ""
+	if s != nil {
+		return *s
+	}
+	return ""
 }
 
 // SyncToMoodle realiza la lógica de sincronización (Pasos 1 a 3 del flujo).
-func (s *ProgramaEstudioService) SyncToMoodle(id uint) error {
-    pe, err := s.Repo.GetByID(id)
-    if err != nil {
-        return fmt.Errorf("PE no encontrado en BD local: %w", err)
-    }
-
-    // Si ya tiene ID_Moodle, no lo creamos de nuevo (solo actualizaríamos, pero por ahora solo crearemos)
-    if pe.ID_Moodle != nil {
-        log.Printf("PE ID %d ya sincronizado (Moodle ID: %d). Saltando creación.", id, *pe.ID_Moodle)
-        return nil 
-    }
-
-    // 1. Construir el array de datos para la función de Moodle
-    data := []moodle.CategoryRequest{
-        {
-            Name: pe.Nombre,
-            Parent: 0, // 0 para categoría padre, como se requiere
-            IDNumber: safeString(pe.ID_Externo), // SafeString maneja punteros nulos
-            Description: safeString(pe.Descripcion),
-        },
-    }
-    
-    // 2. Ejecutar la llamada a la API de Moodle
-    var response []moodle.CategoryResponse
-    err = s.MoodleClient.Call("core_course_create_categories", data, &response)
-    if err != nil {
-        return fmt.Errorf("fallo al crear categoría en Moodle: %w", err)
-    }
-
-    // 3. Procesar la respuesta y actualizar el ID_Moodle local
-    if len(response) == 0 {
-        return fmt.Errorf("moodle no devolvió ninguna categoría creada")
-    }
-
-    moodleID := response[0].ID
-    pe.ID_Moodle = &moodleID
-    
-    if err := s.Repo.Update(&pe); err != nil {
-        return fmt.Errorf("falla al actualizar ID Moodle local para PE ID %d: %w", id, err)
-    }
-
-    log.Printf("✅ Programa Estudio '%s' (ID local: %d) creado exitosamente en Moodle con ID: %d", pe.Nombre, id, moodleID)
-    return nil
+func (s *ProgramaEstudioService) SyncToMoodle(ctx context.Context, id uint) error {
+	pe, err := s.Repo.GetByID(id)
+	if err != nil {
+		return fmt.Errorf("PE no encontrado en BD local: %w", err)
+	}
+
+	// Si ya tiene ID_Moodle, no lo creamos de nuevo: actualizamos la categoría existente
+	if pe.ID_Moodle != nil {
+		log.Printf("PE ID %d ya sincronizado (Moodle ID: %d). Actualizando en Moodle...", id, *pe.ID_Moodle)
+		return s.UpdateInMoodle(ctx, &pe)
+	}
+
+	// 1. Construir el array de datos para la función de Moodle
+	data := []moodle.CategoryRequest{
+		{
+			Name:        pe.Nombre,
+			Parent:      0,                         // 0 para categoría padre, como se requiere
+			IDNumber:    safeString(pe.ID_Externo), // SafeString maneja punteros nulos
+			Description: safeString(pe.Descripcion),
+		},
+	}
+
+	// 2. Ejecutar la llamada a la API de Moodle
+	var response []moodle.CategoryResponse
+	err = s.MoodleClient.Call(ctx, "core_course_create_categories", moodle.CreateCategoriesParams{Categories: data}, &response)
+	if err != nil {
+		if !moodle.IsDuplicate(err) {
+			return s.syncFailed(&pe, fmt.Errorf("fallo al crear categoría en Moodle: %w", err))
+		}
+		// El idnumber ya está usado por otra categoría: la vinculamos en lugar de fallar.
+		existing, lookupErr := moodle.FindCategoryByIDNumber(ctx, s.MoodleClient, safeString(pe.ID_Externo))
+		if lookupErr != nil || existing == nil {
+			return s.syncFailed(&pe, fmt.Errorf("la categoría ya existe en Moodle y no se pudo recuperar su ID: %w", err))
+		}
+		log.Printf("⚠️ Programa Estudio '%s' ya existía en Moodle (Categoría ID: %d). Vinculando.", pe.Nombre, existing.ID)
+		response = []moodle.CategoryResponse{{ID: existing.ID, Name: existing.Name, IDNumber: existing.IDNumber}}
+	}
+
+	// 3. Procesar la respuesta y actualizar el ID_Moodle local
+	if len(response) == 0 {
+		return s.syncFailed(&pe, fmt.Errorf("moodle no devolvió ninguna categoría creada"))
+	}
+
+	moodleID := response[0].ID
+	pe.ID_Moodle = &moodleID
+	pe.MarkSynced(programaHash(pe))
+
+	if err := s.Repo.Update(&pe); err != nil {
+		return fmt.Errorf("falla al actualizar ID Moodle local para PE ID %d: %w", id, err)
+	}
+
+	log.Printf("✅ Programa Estudio '%s' (ID local: %d) creado exitosamente en Moodle con ID: %d", pe.Nombre, id, moodleID)
+	return nil
+}
+
+// UpdateInMoodle actualiza la categoría de un PE que ya existe en Moodle y guarda el resultado en su estado de sincronización.
+func (s *ProgramaEstudioService) UpdateInMoodle(ctx context.Context, pe *models.ProgramaEstudio) error {
+	if pe.ID_Moodle == nil {
+		return fmt.Errorf("el PE no tiene ID de Moodle, debe crearse primero")
+	}
+
+	data := []moodle.CategoryUpdateRequest{
+		{
+			ID:          *pe.ID_Moodle,
+			Name:        pe.Nombre,
+			IDNumber:    safeString(pe.ID_Externo),
+			Description: safeString(pe.Descripcion),
+		},
+	}
+	err := s.MoodleClient.Call(ctx, "core_course_update_categories", moodle.UpdateCategoriesParams{Categories: data}, nil)
+	if err != nil {
+		return s.syncFailed(pe, fmt.Errorf("fallo al actualizar Programa Estudio en Moodle: %w", err))
+	}
+
+	pe.MarkSynced(programaHash(*pe))
+	if err := s.Repo.Update(pe); err != nil {
+		return fmt.Errorf("PE actualizado en Moodle, pero falló guardar el estado de sincronización: %w", err)
+	}
+	log.Printf("✅ Programa Estudio '%s' (Moodle ID: %d) actualizado exitosamente en Moodle", pe.Nombre, *pe.ID_Moodle)
+	return nil
+}
+
+// syncFailed guarda el fallo de sincronización del PE y devuelve err.
+func (s *ProgramaEstudioService) syncFailed(pe *models.ProgramaEstudio, err error) error {
+	return markSyncFailed(&pe.SyncState, func() error { return s.Repo.Update(pe) }, err)
 }
 
 // GetByID recupera un PE.
 func (s *ProgramaEstudioService) GetByID(id uint) (models.ProgramaEstudio, error) {
-    return s.Repo.GetByID(id) 
+	return s.Repo.GetByID(id)
 }
 
 // GetAll recupera todos los PE, delegando al repo.
 func (s *ProgramaEstudioService) GetAll() ([]models.ProgramaEstudio, error) {
-    return s.Repo.GetAll() 
+	return s.Repo.GetAll()
 }
 
-// UpdateLocal actualiza el registro en la BD local.
+// UpdateLocal actualiza el registro en la BD local y registra el cambio en el outbox.
 func (s *ProgramaEstudioService) UpdateLocal(pe *models.ProgramaEstudio) error {
-	return s.Repo.Update(pe)
+	current, err := s.Repo.GetByID(pe.ID)
+	if err != nil {
+		return fmt.Errorf("PE no encontrado en BD local: %w", err)
+	}
+	// El estado de sincronización lo mantiene el servicio: se ignora el que venga en la petición.
+	pe.SyncState = current.SyncState
+	pe.MarkDirty(programaHash(*pe))
+	return s.Outbox.Record(func(tx *gorm.DB) (*models.OutboxEvent, error) {
+		if err := s.Repo.WithTx(tx).Update(pe); err != nil {
+			return nil, err
+		}
+		return models.NewOutboxEvent(models.OutboxProgramaEstudio, pe.ID, models.OutboxUpdate), nil
+	})
+}
+
+// Delete elimina el Programa Estudio local y registra el borrado en el outbox. Si propagate es true y
+// está sincronizado, el despachador elimina después su categoría de Moodle. Si la categoría aún tiene
+// cursos o subcategorías devuelve ErrCategoryNotEmpty y el registro local se conserva.
+func (s *ProgramaEstudioService) Delete(ctx context.Context, id uint, propagate bool) error {
+	if id == 0 {
+		return errors.New("ID de Programa Estudio inválido")
+	}
+	pe, err := s.Repo.GetByID(id)
+	if err != nil {
+		return fmt.Errorf("PE no encontrado en BD local: %w", err)
+	}
+	if propagate && pe.ID_Moodle != nil {
+		if err := ensureCategoryEmpty(ctx, s.MoodleClient, *pe.ID_Moodle); err != nil {
+			return err
+		}
+	}
+	return s.Outbox.Record(func(tx *gorm.DB) (*models.OutboxEvent, error) {
+		// Nota: Idealmente, aquí se verificaría que no tenga hijos antes de borrar.
+		if err := s.Repo.WithTx(tx).Delete(id); err != nil {
+			return nil, err
+		}
+		return deleteEvent(models.OutboxProgramaEstudio, id, pe.ID_Moodle, propagate), nil
+	})
 }
 
-// DeleteLocal elimina el registro en la BD local.
-func (s *ProgramaEstudioService) DeleteLocal(id uint) error {
-	// Nota: Idealmente, aquí se verificaría que no tenga hijos antes de borrar.
-	return s.Repo.Delete(id)
+// DeleteInMoodle elimina la categoría de un PE ya eliminado localmente. Si entretanto recibió cursos o
+// subcategorías devuelve ErrCategoryNotEmpty y el evento queda fallido.
+func (s *ProgramaEstudioService) DeleteInMoodle(ctx context.Context, moodleID uint) error {
+	if err := deleteCategoryInMoodle(ctx, s.MoodleClient, moodleID); err != nil {
+		return err
+	}
+	log.Printf("🗑️ Programa Estudio eliminado de Moodle (Categoría ID: %d)", moodleID)
+	return nil
 }
 
+// SyncCohort crea, si aún no existe, la cohorte de Moodle del programa (en su categoría si ya está
+// sincronizado) y le añade los alumnos matriculados en sus asignaturas que ya están en Moodle.
+func (s *ProgramaEstudioService) SyncCohort(ctx context.Context, id uint) (*CohortSyncResult, error) {
+	pe, err := s.Repo.GetByID(id)
+	if err != nil {
+		return nil, fmt.Errorf("PE no encontrado en BD local: %w", err)
+	}
+
+	cohortID, created, err := ensureCohort(ctx, s.MoodleClient, pe.CohortMoodleID, cohortSpec{
+		Name:        pe.Nombre,
+		IDNumber:    cohortIDNumber("PE", pe.ID, pe.ID_Externo),
+		Description: safeString(pe.Descripcion),
+		CategoryID:  pe.ID_Moodle,
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := &CohortSyncResult{CohortID: cohortID, Created: created}
+	if created {
+		pe.CohortMoodleID = &cohortID
+		if err := s.Repo.Update(&pe); err != nil {
+			return nil, fmt.Errorf("cohorte %d creada en Moodle pero falló guardar su ID para PE ID %d: %w", cohortID, id, err)
+		}
+		log.Printf("✅ Cohorte del Programa Estudio '%s' (ID local: %d) creada en Moodle con ID: %d", pe.Nombre, id, cohortID)
+	}
+
+	rolesAlumno, err := s.Roles.IDsRolesEstudiante()
+	if err != nil {
+		return result, err
+	}
+	alumnos, err := s.Repo.GetAlumnos(id, rolesAlumno)
+	if err != nil {
+		return result, fmt.Errorf("no se pudieron obtener los alumnos del PE ID %d: %w", id, err)
+	}
+	if err := addCohortMembers(ctx, s.MoodleClient, cohortID, alumnos, result); err != nil {
+		return result, err
+	}
+	if err := removeStaleCohortMembers(ctx, s.MoodleClient, cohortID, alumnos, result); err != nil {
+		return result, err
+	}
+	log.Printf("✅ Cohorte %d del Programa Estudio '%s': %d alumnos enviados, %d quitados, %d sin sincronizar.", cohortID, pe.Nombre, result.Members, result.Removed, result.Skipped)
+	return result, nil
+}
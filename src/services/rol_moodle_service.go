@@ -0,0 +1,247 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"api_concurrencia/src/models"
+	"api_concurrencia/src/moodle"
+	"api_concurrencia/src/repository"
+
+	"gorm.io/gorm"
+)
+
+// ErrRolInvalido indica que los datos de la correspondencia de rol no son válidos.
+var ErrRolInvalido = errors.New("correspondencia de rol inválida")
+
+// ErrRolEnUso indica que una correspondencia de rol no puede eliminarse porque hay usuarios con ese rol.
+var ErrRolEnUso = errors.New("el rol está asignado a usuarios")
+
+// RolMoodleService gestiona la correspondencia entre roles locales y roles de Moodle.
+type RolMoodleService struct {
+	Repo         *repository.RolMoodleRepository
+	MoodleClient moodle.MoodleAPI
+}
+
+func NewRolMoodleService(repo *repository.RolMoodleRepository, moodleClient moodle.MoodleAPI) *RolMoodleService {
+	return &RolMoodleService{Repo: repo, MoodleClient: moodleClient}
+}
+
+// ValidacionRoles es el resultado de comparar las correspondencias con los roles reales de Moodle.
+type ValidacionRoles struct {
+	Disponible bool          `json:"disponible"`          // Moodle permite listar los roles (plugin local_wsgetroles)
+	Roles      []moodle.Role `json:"roles,omitempty"`     // Roles definidos en Moodle
+	Resueltos  []string      `json:"resueltos,omitempty"` // Roles locales cuyo ID se completó a partir del shortname
+	Problemas  []string      `json:"problemas,omitempty"` // Correspondencias que no encajan con Moodle
+}
+
+// GetAll devuelve todas las correspondencias.
+func (s *RolMoodleService) GetAll() ([]models.RolMoodle, error) {
+	return s.Repo.GetAll()
+}
+
+// GetByID devuelve una correspondencia.
+func (s *RolMoodleService) GetByID(id uint) (models.RolMoodle, error) {
+	return s.Repo.GetByID(id)
+}
+
+// Create valida y guarda una correspondencia nueva.
+func (s *RolMoodleService) Create(rol *models.RolMoodle) error {
+	if err := validarRolMoodle(rol); err != nil {
+		return err
+	}
+	if existe, err := s.ExisteRol(rol.Nombre); err != nil {
+		return err
+	} else if existe {
+		return fmt.Errorf("%w: ya existe el rol '%s'", ErrRolInvalido, rol.Nombre)
+	}
+	return s.Repo.Create(rol)
+}
+
+// Update valida y guarda una correspondencia existente. El nombre no puede cambiar si hay usuarios
+// con ese rol, porque dejarían de tener correspondencia.
+func (s *RolMoodleService) Update(rol *models.RolMoodle) error {
+	if err := validarRolMoodle(rol); err != nil {
+		return err
+	}
+	actual, err := s.Repo.GetByID(rol.ID)
+	if err != nil {
+		return fmt.Errorf("rol (ID: %d) no encontrado: %w", rol.ID, err)
+	}
+	if actual.Nombre != rol.Nombre {
+		if err := s.comprobarSinUsuarios(actual.Nombre); err != nil {
+			return err
+		}
+		if existe, err := s.ExisteRol(rol.Nombre); err != nil {
+			return err
+		} else if existe {
+			return fmt.Errorf("%w: ya existe el rol '%s'", ErrRolInvalido, rol.Nombre)
+		}
+	}
+	return s.Repo.Update(rol)
+}
+
+// Delete elimina una correspondencia que no tenga usuarios asignados.
+func (s *RolMoodleService) Delete(id uint) error {
+	rol, err := s.Repo.GetByID(id)
+	if err != nil {
+		return fmt.Errorf("rol (ID: %d) no encontrado: %w", id, err)
+	}
+	if err := s.comprobarSinUsuarios(rol.Nombre); err != nil {
+		return err
+	}
+	return s.Repo.Delete(id)
+}
+
+func (s *RolMoodleService) comprobarSinUsuarios(nombre string) error {
+	count, err := s.Repo.CountUsuarios(nombre)
+	if err != nil {
+		return fmt.Errorf("no se pudo comprobar si el rol '%s' está en uso: %w", nombre, err)
+	}
+	if count > 0 {
+		return fmt.Errorf("%w: %d usuarios tienen el rol '%s'", ErrRolEnUso, count, nombre)
+	}
+	return nil
+}
+
+func validarRolMoodle(rol *models.RolMoodle) error {
+	rol.Nombre = strings.TrimSpace(rol.Nombre)
+	rol.Shortname = strings.TrimSpace(rol.Shortname)
+	if rol.Nombre == "" {
+		return fmt.Errorf("%w: el nombre es obligatorio", ErrRolInvalido)
+	}
+	if rol.MoodleRoleID == 0 && rol.Shortname == "" {
+		return fmt.Errorf("%w: debe indicarse moodle_role_id o shortname", ErrRolInvalido)
+	}
+	return nil
+}
+
+// ExisteRol indica si hay una correspondencia para el rol local nombre.
+func (s *RolMoodleService) ExisteRol(nombre string) (bool, error) {
+	_, err := s.Repo.GetByNombre(nombre)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Resolver devuelve la correspondencia del rol local nombre, que debe tener ya un ID de Moodle.
+func (s *RolMoodleService) Resolver(nombre string) (models.RolMoodle, error) {
+	rol, err := s.Repo.GetByNombre(nombre)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return rol, fmt.Errorf("rol local desconocido: %s", nombre)
+	}
+	if err != nil {
+		return rol, fmt.Errorf("no se pudo obtener la correspondencia del rol '%s': %w", nombre, err)
+	}
+	if rol.MoodleRoleID == 0 {
+		return rol, fmt.Errorf("el rol '%s' no tiene ID de Moodle (shortname '%s' sin resolver)", nombre, rol.Shortname)
+	}
+	return rol, nil
+}
+
+// RolesEstudiante devuelve los IDs de rol de Moodle cuyas matrículas son de alumnos.
+func (s *RolMoodleService) RolesEstudiante() (map[uint]bool, error) {
+	roles, err := s.Repo.GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("no se pudieron obtener las correspondencias de rol: %w", err)
+	}
+	ids := make(map[uint]bool)
+	for _, rol := range roles {
+		if rol.Estudiante && rol.MoodleRoleID != 0 {
+			ids[rol.MoodleRoleID] = true
+		}
+	}
+	return ids, nil
+}
+
+// IDsRolesEstudiante devuelve como lista los IDs de rol de Moodle de alumno, para filtrar consultas.
+func (s *RolMoodleService) IDsRolesEstudiante() ([]uint, error) {
+	roles, err := s.RolesEstudiante()
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]uint, 0, len(roles))
+	for id := range roles {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// ValidarConMoodle compara las correspondencias con la lista de roles de Moodle. Completa el ID de las
+// que sólo tienen shortname y anota las que apuntan a un rol inexistente o con otro shortname.
+// Si Moodle no permite listar roles devuelve el resultado con Disponible a false, sin error.
+func (s *RolMoodleService) ValidarConMoodle(ctx context.Context) (*ValidacionRoles, error) {
+	result := &ValidacionRoles{}
+	roles, err := moodle.GetRoles(ctx, s.MoodleClient)
+	if err != nil {
+		if errors.Is(err, moodle.ErrUnsupported) {
+			return result, nil
+		}
+		return nil, err
+	}
+	result.Disponible = true
+	result.Roles = roles
+
+	porID := make(map[uint]moodle.Role, len(roles))
+	porShortname := make(map[string]moodle.Role, len(roles))
+	for _, r := range roles {
+		porID[r.ID] = r
+		porShortname[r.Shortname] = r
+	}
+
+	locales, err := s.Repo.GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("no se pudieron obtener las correspondencias de rol: %w", err)
+	}
+	for _, local := range locales {
+		if local.MoodleRoleID == 0 {
+			r, ok := porShortname[local.Shortname]
+			if !ok {
+				result.Problemas = append(result.Problemas, fmt.Sprintf("'%s': no existe ningún rol con shortname '%s' en Moodle", local.Nombre, local.Shortname))
+				continue
+			}
+			local.MoodleRoleID = r.ID
+			if err := s.Repo.Update(&local); err != nil {
+				result.Problemas = append(result.Problemas, fmt.Sprintf("'%s': no se pudo guardar el ID resuelto: %v", local.Nombre, err))
+				continue
+			}
+			result.Resueltos = append(result.Resueltos, local.Nombre)
+			continue
+		}
+
+		r, ok := porID[local.MoodleRoleID]
+		switch {
+		case !ok:
+			result.Problemas = append(result.Problemas, fmt.Sprintf("'%s': el rol %d no existe en Moodle", local.Nombre, local.MoodleRoleID))
+		case local.Shortname != "" && r.Shortname != local.Shortname:
+			result.Problemas = append(result.Problemas, fmt.Sprintf("'%s': el rol %d de Moodle es '%s', no '%s'", local.Nombre, local.MoodleRoleID, r.Shortname, local.Shortname))
+		}
+	}
+	return result, nil
+}
+
+// StartupCheck valida las correspondencias al arrancar y sólo registra el resultado.
+func (s *RolMoodleService) StartupCheck(ctx context.Context) {
+	result, err := s.ValidarConMoodle(ctx)
+	if err != nil {
+		log.Printf("⚠️ No se pudieron validar los roles contra Moodle: %v", err)
+		return
+	}
+	if !result.Disponible {
+		log.Printf("⚠️ No se pueden validar los roles: el servicio web no tiene %s (plugin local_wsgetroles). Se usan los IDs configurados.", moodle.RolesFunction)
+		return
+	}
+	for _, nombre := range result.Resueltos {
+		log.Printf("✅ Rol '%s' resuelto por shortname.", nombre)
+	}
+	for _, problema := range result.Problemas {
+		log.Printf("⚠️ Correspondencia de rol incorrecta: %s", problema)
+	}
+	if len(result.Problemas) == 0 {
+		log.Printf("✅ Correspondencias de rol validadas contra %d roles de Moodle.", len(result.Roles))
+	}
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"api_concurrencia/src/models"
+	"api_concurrencia/src/moodle"
+	"api_concurrencia/src/repository"
+
+	"gorm.io/gorm"
+)
+
+// Services agrupa los servicios de la API ya conectados entre sí. Construirlo no arranca ninguna
+// tarea en segundo plano ni escribe en la BD: los trabajadores (outbox, importación periódica,
+// comprobaciones de arranque) los inicia main con un contexto que se cancela al detener la API.
+type Services struct {
+	Jobs           *SyncJobService
+	SyncState      *SyncStateService
+	Outbox         *OutboxService
+	Programas      *ProgramaEstudioService
+	Cuatrimestres  *CuatrimestreService
+	Asignaturas    *AsignaturaService
+	Roles          *RolMoodleService
+	Usuarios       *UsuarioService
+	Grupos         *GrupoService
+	Tree           *SyncTreeService
+	Conciliacion   *ConciliacionService
+	Calificaciones *CalificacionService
+	Finalizacion   *FinalizacionService
+}
+
+func NewServices(db *gorm.DB, moodleClient moodle.MoodleAPI) *Services {
+	s := &Services{}
+
+	// --- TRABAJOS DE SINCRONIZACIÓN ---
+	s.Jobs = NewSyncJobService(repository.NewSyncJobRepository(db))
+	s.SyncState = NewSyncStateService(repository.NewSyncStateRepository(db))
+	s.Outbox = NewOutboxService(repository.NewOutboxRepository(db))
+
+	// --- ROLES DE MOODLE ---
+	s.Roles = NewRolMoodleService(repository.NewRolMoodleRepository(db), moodleClient)
+
+	// --- PROGRAMA ESTUDIO, CUATRIMESTRE Y ASIGNATURA ---
+	s.Programas = NewProgramaEstudioService(repository.NewProgramaEstudioRepository(db), moodleClient, s.Outbox, s.Roles)
+	cRepo := repository.NewCuatrimestreRepository(db)
+	aRepo := repository.NewAsignaturaRepository(db)
+	s.Cuatrimestres = NewCuatrimestreService(cRepo, moodleClient, s.Outbox, s.Roles, aRepo)
+	s.Asignaturas = NewAsignaturaService(aRepo, moodleClient, s.Outbox)
+
+	// --- USUARIO Y GRUPO ---
+	uRepo := repository.NewUsuarioRepository(db)
+	s.Usuarios = NewUsuarioService(uRepo, moodleClient, aRepo, s.Roles, s.Outbox)
+	s.Grupos = NewGrupoService(repository.NewGrupoRepository(db), moodleClient, aRepo, uRepo, s.Outbox)
+
+	// --- SINCRONIZACIÓN EN ÁRBOL Y CONCILIACIÓN ---
+	s.Tree = NewSyncTreeService(s.Programas, s.Cuatrimestres, s.Asignaturas, s.Grupos, s.Usuarios)
+	s.Conciliacion = NewConciliacionService(repository.NewDiscrepanciaRepository(db), moodleClient, s.Programas, s.Cuatrimestres, s.Asignaturas, s.Usuarios, s.Grupos, s.Outbox)
+
+	// --- PROPAGACIÓN AUTOMÁTICA (OUTBOX) ---
+	s.Outbox.Handle(models.OutboxProgramaEstudio, OutboxHandler{Sync: s.Programas.SyncToMoodle, Delete: s.Programas.DeleteInMoodle})
+	s.Outbox.Handle(models.OutboxCuatrimestre, OutboxHandler{Sync: s.Cuatrimestres.SyncToMoodle, Delete: s.Cuatrimestres.DeleteInMoodle})
+	s.Outbox.Handle(models.OutboxAsignatura, OutboxHandler{Sync: s.Asignaturas.SyncToMoodle, Delete: s.Asignaturas.DeleteInMoodle})
+	s.Outbox.Handle(models.OutboxUsuario, OutboxHandler{Sync: s.Usuarios.SyncToMoodle, Delete: s.Usuarios.DeleteInMoodle})
+	s.Outbox.Handle(models.OutboxGrupo, OutboxHandler{Sync: s.Grupos.SyncToMoodle, Delete: s.Grupos.DeleteInMoodle})
+	s.Outbox.Handle(models.OutboxMatricula, OutboxHandler{Sync: s.Usuarios.SyncMatriculas, Remove: s.Usuarios.UnenrolInMoodle})
+	s.Outbox.Handle(models.OutboxGrupoMiembros, OutboxHandler{Sync: s.Grupos.SyncMembersToMoodle, Remove: s.Grupos.RemoveMemberInMoodle})
+
+	// --- CALIFICACIONES Y FINALIZACIÓN ---
+	s.Calificaciones = NewCalificacionService(repository.NewCalificacionRepository(db), uRepo, aRepo, moodleClient, s.Roles)
+	s.Finalizacion = NewFinalizacionService(repository.NewFinalizacionRepository(db), uRepo, aRepo, cRepo, moodleClient, s.Roles)
+
+	return s
+}
@@ -0,0 +1,48 @@
+package services
+
+import (
+	"api_concurrencia/src/models"
+	"api_concurrencia/src/moodle"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestServices conecta los servicios a una BD SQLite en memoria y a un FakeClient vacío.
+func newTestServices(t *testing.T) (*Services, *moodle.FakeClient, *gorm.DB) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("no se pudo abrir la BD de pruebas: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Cada conexión a ":memory:" es una BD distinta: con una sola todas las consultas ven las mismas tablas.
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	err = db.AutoMigrate(
+		&models.ProgramaEstudio{},
+		&models.Cuatrimestre{},
+		&models.Asignatura{},
+		&models.Usuario{},
+		&models.Matricula{},
+		&models.Grupo{},
+		&models.RolMoodle{},
+		&models.OutboxEvent{},
+		&models.SyncJob{},
+		&models.SyncJobError{},
+	)
+	if err != nil {
+		t.Fatalf("no se pudieron crear las tablas: %v", err)
+	}
+
+	fake := moodle.NewFakeClient()
+	return NewServices(db, fake), fake, db
+}
+
+func strPtr(s string) *string { return &s }
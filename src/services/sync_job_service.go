@@ -0,0 +1,254 @@
+package services
+
+import (
+	"api_concurrencia/src/models"
+	"api_concurrencia/src/repository"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// syncProgressSaveInterval limita cada cuánto se guardan los contadores de un trabajo en curso.
+const syncProgressSaveInterval = time.Second
+
+// SyncProgress recoge el avance de una sincronización masiva y lo guarda en su SyncJob.
+// Puede usarse desde varias goroutines. Un *SyncProgress nil no registra nada, de modo que las
+// sincronizaciones también pueden ejecutarse fuera de un trabajo.
+type SyncProgress struct {
+	mu       sync.Mutex
+	repo     *repository.SyncJobRepository
+	job      *models.SyncJob
+	lastSave time.Time
+}
+
+// JobID devuelve el ID del trabajo (0 fuera de un trabajo).
+func (p *SyncProgress) JobID() uint {
+	if p == nil {
+		return 0
+	}
+	return p.job.ID
+}
+
+// SetTotal indica cuántos registros va a procesar la sincronización.
+func (p *SyncProgress) SetTotal(total int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.job.Total = total
+	p.save(true)
+}
+
+// Success anota un registro sincronizado.
+func (p *SyncProgress) Success() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.job.Exitosos++
+	p.save(false)
+}
+
+// Failure anota un registro que no se pudo sincronizar y guarda el motivo.
+func (p *SyncProgress) Failure(entidad string, id uint, err error) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.job.Errores++
+	detalle := models.SyncJobError{JobID: p.job.ID, Entidad: entidad, EntidadID: id, Mensaje: err.Error()}
+	if saveErr := p.repo.AddError(&detalle); saveErr != nil {
+		log.Printf("⚠️ No se pudo guardar el error del %s %d en el trabajo %d: %v", entidad, id, p.job.ID, saveErr)
+	}
+	p.save(false)
+}
+
+// save guarda los contadores si pasó syncProgressSaveInterval desde la última vez (o siempre con force).
+// Debe llamarse con mu bloqueado.
+func (p *SyncProgress) save(force bool) {
+	if !force && time.Since(p.lastSave) < syncProgressSaveInterval {
+		return
+	}
+	if err := p.repo.Update(p.job); err != nil {
+		log.Printf("⚠️ No se pudo guardar el avance del trabajo %d: %v", p.job.ID, err)
+		return
+	}
+	p.lastSave = time.Now()
+}
+
+// sinRespuesta es el error de un registro para el que Moodle no devolvió resultado en una llamada en
+// lote: el error del lote si lo hubo.
+func sinRespuesta(err error) error {
+	if err != nil {
+		return err
+	}
+	return errors.New("moodle no devolvió resultado para este registro")
+}
+
+// SyncTask es el trabajo que ejecuta un SyncJob. Los errores de cada registro se anotan en progress;
+// el error devuelto indica que la sincronización no pudo completarse.
+type SyncTask func(ctx context.Context, progress *SyncProgress) error
+
+// ErrTrabajoNoEnCurso se devuelve al cancelar un trabajo que ya terminó.
+var ErrTrabajoNoEnCurso = errors.New("solo se pueden cancelar trabajos en curso")
+
+// SyncJobService lanza las sincronizaciones masivas en segundo plano y guarda su avance. Guarda la
+// función de cancelación de cada trabajo en curso para poder cancelarlos a petición o al apagar la API.
+type SyncJobService struct {
+	Repo *repository.SyncJobRepository
+
+	mu         sync.Mutex
+	cancels    map[uint]context.CancelFunc
+	cancelados map[uint]bool // trabajos cancelados con Cancel (no por apagar la API)
+	running    sync.WaitGroup
+}
+
+func NewSyncJobService(repo *repository.SyncJobRepository) *SyncJobService {
+	return &SyncJobService{Repo: repo, cancels: make(map[uint]context.CancelFunc), cancelados: make(map[uint]bool)}
+}
+
+// Start registra un trabajo pendiente y ejecuta task en segundo plano con un contexto derivado de ctx,
+// que debe vivir lo que el servidor (no lo que la petición). Devuelve el trabajo tal como quedó
+// registrado, para informar de su ID.
+func (s *SyncJobService) Start(ctx context.Context, tipo, parametros string, task SyncTask) (models.SyncJob, error) {
+	job := &models.SyncJob{Tipo: tipo, Estado: models.SyncJobPendiente}
+	if parametros != "" {
+		job.Parametros = &parametros
+	}
+	if err := s.Repo.Create(job); err != nil {
+		return models.SyncJob{}, fmt.Errorf("no se pudo registrar el trabajo de sincronización: %w", err)
+	}
+	registrado := *job
+
+	jobCtx, cancel := context.WithCancel(ctx)
+	s.mu.Lock()
+	s.cancels[job.ID] = cancel
+	s.mu.Unlock()
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+		defer s.forget(job.ID)
+		s.run(jobCtx, &SyncProgress{repo: s.Repo, job: job}, task)
+	}()
+	return registrado, nil
+}
+
+// forget libera la función de cancelación de un trabajo que ya terminó.
+func (s *SyncJobService) forget(id uint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cancel, ok := s.cancels[id]; ok {
+		cancel()
+		delete(s.cancels, id)
+	}
+	delete(s.cancelados, id)
+}
+
+// Cancel detiene un trabajo en curso: cancela su contexto y, cuando la tarea se detiene, el trabajo
+// queda cancelado con lo que llevara hecho. Devuelve el trabajo tal como estaba al pedir la cancelación.
+func (s *SyncJobService) Cancel(id uint) (models.SyncJob, error) {
+	job, err := s.Repo.GetByID(id)
+	if err != nil {
+		return job, err
+	}
+	s.mu.Lock()
+	cancel, ok := s.cancels[id]
+	if ok {
+		s.cancelados[id] = true
+	}
+	s.mu.Unlock()
+	if !ok {
+		return job, fmt.Errorf("%w: el trabajo %d está %s", ErrTrabajoNoEnCurso, id, job.Estado)
+	}
+	cancel()
+	log.Printf("⏹️ Cancelación solicitada para el trabajo de sincronización %d (%s).", id, job.Tipo)
+	return job, nil
+}
+
+// cancelado indica si el trabajo se canceló con Cancel.
+func (s *SyncJobService) cancelado(id uint) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cancelados[id]
+}
+
+// Stop cancela los trabajos en curso y espera a que guarden su estado final. Lo llama main al detener la API.
+func (s *SyncJobService) Stop() {
+	s.mu.Lock()
+	for _, cancel := range s.cancels {
+		cancel()
+	}
+	s.mu.Unlock()
+	s.running.Wait()
+}
+
+// run ejecuta el trabajo y guarda su resultado, también si task entra en pánico.
+func (s *SyncJobService) run(ctx context.Context, progress *SyncProgress, task SyncTask) {
+	job := progress.job
+	progress.mu.Lock()
+	iniciado := time.Now()
+	job.Estado = models.SyncJobEnCurso
+	job.IniciadoEn = &iniciado
+	progress.save(true)
+	progress.mu.Unlock()
+	log.Printf("🔄 Trabajo de sincronización %d (%s) iniciado.", job.ID, job.Tipo)
+
+	var err error
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("error inesperado: %v", r)
+		}
+		progress.mu.Lock()
+		defer progress.mu.Unlock()
+		finalizado := time.Now()
+		job.FinalizadoEn = &finalizado
+		job.Estado = models.SyncJobCompletado
+		switch {
+		case err != nil && s.cancelado(job.ID):
+			job.Estado = models.SyncJobCancelado
+			mensaje := "cancelado a petición (" + err.Error() + ")"
+			job.Mensaje = &mensaje
+		case err != nil:
+			job.Estado = models.SyncJobFallido
+			mensaje := err.Error()
+			if ctx.Err() != nil {
+				mensaje = "interrumpido: la API se detuvo antes de terminar (" + mensaje + ")"
+			}
+			job.Mensaje = &mensaje
+		}
+		progress.save(true)
+		log.Printf("🏁 Trabajo de sincronización %d (%s) %s: %d de %d exitosos, %d errores.",
+			job.ID, job.Tipo, job.Estado, job.Exitosos, job.Total, job.Errores)
+	}()
+
+	err = task(ctx, progress)
+}
+
+// GetAll devuelve los trabajos más recientes, filtrados opcionalmente por tipo y estado.
+func (s *SyncJobService) GetAll(tipo, estado string, limit int) ([]models.SyncJob, error) {
+	return s.Repo.GetAll(tipo, estado, limit)
+}
+
+// GetByID devuelve un trabajo con el detalle de sus errores.
+func (s *SyncJobService) GetByID(id uint) (models.SyncJob, error) {
+	return s.Repo.GetByID(id)
+}
+
+// FailInterrupted marca como fallidos los trabajos que la API dejó a medias al detenerse.
+// Se llama al arrancar, antes de aceptar peticiones.
+func (s *SyncJobService) FailInterrupted() {
+	n, err := s.Repo.FailUnfinished("interrumpido: la API se detuvo antes de terminar")
+	if err != nil {
+		log.Printf("⚠️ No se pudieron revisar los trabajos de sincronización interrumpidos: %v", err)
+		return
+	}
+	if n > 0 {
+		log.Printf("⚠️ %d trabajos de sincronización interrumpidos marcados como fallidos.", n)
+	}
+}
@@ -0,0 +1,41 @@
+package services
+
+import (
+	"api_concurrencia/src/models"
+	"context"
+	"errors"
+	"testing"
+)
+
+// Un trabajo cancelado a petición se detiene y queda cancelado, no fallido; ya no se puede volver a cancelar.
+func TestSyncJobCancel(t *testing.T) {
+	svc, _, _ := newTestServices(t)
+	started := make(chan struct{})
+	job, err := svc.Jobs.Start(context.Background(), models.SyncJobUsuarios, "", func(ctx context.Context, progress *SyncProgress) error {
+		progress.SetTotal(3)
+		progress.Success()
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	if _, err := svc.Jobs.Cancel(job.ID); err != nil {
+		t.Fatalf("Cancel: %v", err)
+	}
+	svc.Jobs.Stop()
+
+	got, err := svc.Jobs.GetByID(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Estado != models.SyncJobCancelado || got.FinalizadoEn == nil || got.Exitosos != 1 {
+		t.Errorf("trabajo = %s (exitosos %d, finalizado %v), se esperaba cancelado con su avance", got.Estado, got.Exitosos, got.FinalizadoEn)
+	}
+	if _, err := svc.Jobs.Cancel(job.ID); !errors.Is(err, ErrTrabajoNoEnCurso) {
+		t.Errorf("cancelar un trabajo terminado = %v, se esperaba ErrTrabajoNoEnCurso", err)
+	}
+}
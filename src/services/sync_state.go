@@ -0,0 +1,126 @@
+package services
+
+import (
+	"api_concurrencia/src/models"
+	"api_concurrencia/src/moodle"
+	"api_concurrencia/src/repository"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+)
+
+// payloadHash es el SHA-256 del JSON de los datos que se envían a Moodle para un registro. Se calcula
+// siempre sobre la petición de actualización sin el ID de Moodle, de modo que el hash de la creación y
+// el de las actualizaciones posteriores son comparables.
+func payloadHash(payload interface{}) string {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		// Las peticiones son structs simples: no debería ocurrir nunca.
+		log.Printf("⚠️ No se pudo calcular el hash de %T: %v", payload, err)
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// markSyncFailed anota el fallo en el estado del registro y lo guarda con save. Devuelve err sin
+// modificar para poder usarse en el return del error.
+func markSyncFailed(state *models.SyncState, save func() error, err error) error {
+	state.MarkFailed(err)
+	if saveErr := save(); saveErr != nil {
+		log.Printf("⚠️ No se pudo guardar el estado de sincronización fallido: %v", saveErr)
+	}
+	return err
+}
+
+func programaHash(pe models.ProgramaEstudio) string {
+	return payloadHash(moodle.CategoryUpdateRequest{
+		Name:        pe.Nombre,
+		IDNumber:    safeString(pe.ID_Externo),
+		Description: safeString(pe.Descripcion),
+	})
+}
+
+func cuatrimestreHash(c models.Cuatrimestre) string {
+	return payloadHash(moodle.CategoryUpdateRequest{
+		Name:        c.Nombre,
+		IDNumber:    safeString(c.ID_Externo),
+		Description: safeString(c.Descripcion),
+	})
+}
+
+// asignaturaHash necesita el cuatrimestre precargado, del que salen los valores por defecto del curso.
+func asignaturaHash(a models.Asignatura) string {
+	req := courseUpdateRequest(a)
+	req.ID = 0
+	return payloadHash(req)
+}
+
+func usuarioHash(u models.Usuario) string {
+	req := userUpdateRequest(u)
+	req.ID = 0
+	return payloadHash(req)
+}
+
+func grupoHash(g models.Grupo) string {
+	req := groupUpdateRequest(g)
+	req.ID = 0
+	return payloadHash(req)
+}
+
+func matriculaHash(m models.Matricula) string {
+	return payloadHash(moodle.EnrolmentRequest{
+		RoleID:    int(m.RoleID),
+		UserID:    m.UserMoodleID,
+		CourseID:  m.CourseMoodleID,
+		Timestart: m.Timestart,
+		Timeend:   m.Timeend,
+		Suspend:   suspendFlag(m.Suspended),
+	})
+}
+
+// RegistrosSync son los registros de cada entidad que están en un estado de sincronización.
+type RegistrosSync struct {
+	Estado           string                   `json:"estado" example:"failed"`
+	Total            int                      `json:"total" example:"3"`
+	ProgramasEstudio []models.ProgramaEstudio `json:"programas_estudio"`
+	Cuatrimestres    []models.Cuatrimestre    `json:"cuatrimestres"`
+	Asignaturas      []models.Asignatura      `json:"asignaturas"`
+	Usuarios         []models.Usuario         `json:"usuarios"`
+	Grupos           []models.Grupo           `json:"grupos"`
+	Matriculas       []models.Matricula       `json:"matriculas"`
+}
+
+// SyncStateService consulta los registros según su estado de sincronización con Moodle.
+type SyncStateService struct {
+	Repo *repository.SyncStateRepository
+}
+
+func NewSyncStateService(repo *repository.SyncStateRepository) *SyncStateService {
+	return &SyncStateService{Repo: repo}
+}
+
+// Registros devuelve los registros de todas las entidades que están en el estado indicado.
+func (s *SyncStateService) Registros(estado string) (*RegistrosSync, error) {
+	r := &RegistrosSync{Estado: estado}
+	consultas := []struct {
+		entidad string
+		dest    interface{}
+	}{
+		{"programas de estudio", &r.ProgramasEstudio},
+		{"cuatrimestres", &r.Cuatrimestres},
+		{"asignaturas", &r.Asignaturas},
+		{"usuarios", &r.Usuarios},
+		{"grupos", &r.Grupos},
+		{"matrículas", &r.Matriculas},
+	}
+	for _, c := range consultas {
+		if err := s.Repo.GetByStatus(estado, c.dest); err != nil {
+			return nil, fmt.Errorf("no se pudieron obtener los %s en estado %s: %w", c.entidad, estado, err)
+		}
+	}
+	r.Total = len(r.ProgramasEstudio) + len(r.Cuatrimestres) + len(r.Asignaturas) + len(r.Usuarios) + len(r.Grupos) + len(r.Matriculas)
+	return r, nil
+}
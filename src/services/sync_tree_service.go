@@ -0,0 +1,270 @@
+package services
+
+import (
+	"api_concurrencia/src/models"
+	"context"
+	"fmt"
+	"log"
+)
+
+// Niveles de la sincronización en árbol, en el orden en que se procesan.
+const (
+	NivelProgramaEstudio = "programa_estudio"
+	NivelCuatrimestres   = "cuatrimestres"
+	NivelAsignaturas     = "asignaturas"
+	NivelGrupos          = "grupos"
+	NivelMatriculas      = "matriculas"
+	NivelMiembros        = "miembros"
+)
+
+// ErrorNivelSync es un registro de un nivel que falló o se omitió.
+type ErrorNivelSync struct {
+	ID    uint   `json:"id" example:"12"` // ID local (en matrículas, el del usuario)
+	Error string `json:"error" example:"fallo al crear Grupo en Moodle: ..."`
+}
+
+// NivelSync resume un nivel de la sincronización en árbol.
+type NivelSync struct {
+	Nivel         string           `json:"nivel" example:"asignaturas"`
+	Total         int              `json:"total" example:"7"`
+	Sincronizados int              `json:"sincronizados" example:"6"` // En Moodle al terminar
+	Fallidos      int              `json:"fallidos" example:"1"`      // Falló la llamada a Moodle
+	Omitidos      int              `json:"omitidos" example:"0"`      // No se intentaron porque su padre no está en Moodle
+	Errores       []ErrorNivelSync `json:"errores,omitempty"`
+}
+
+func (n *NivelSync) ok() {
+	n.Total++
+	n.Sincronizados++
+}
+
+func (n *NivelSync) fallido(id uint, err error) {
+	n.Total++
+	n.Fallidos++
+	n.Errores = append(n.Errores, ErrorNivelSync{ID: id, Error: err.Error()})
+}
+
+func (n *NivelSync) omitido(id uint, motivo string) {
+	n.Total++
+	n.Omitidos++
+	n.Errores = append(n.Errores, ErrorNivelSync{ID: id, Error: "omitido: " + motivo})
+}
+
+// SyncTreeResult es el informe de la sincronización en árbol de un Programa de Estudio.
+type SyncTreeResult struct {
+	ProgramaEstudioID uint        `json:"programa_estudio_id" example:"3"`
+	Niveles           []NivelSync `json:"niveles"`
+}
+
+// SyncTreeService sincroniza un Programa de Estudio y todo lo que cuelga de él, nivel a nivel.
+type SyncTreeService struct {
+	Programas     *ProgramaEstudioService
+	Cuatrimestres *CuatrimestreService
+	Asignaturas   *AsignaturaService
+	Grupos        *GrupoService
+	Usuarios      *UsuarioService
+}
+
+func NewSyncTreeService(pe *ProgramaEstudioService, c *CuatrimestreService, a *AsignaturaService, g *GrupoService, u *UsuarioService) *SyncTreeService {
+	return &SyncTreeService{Programas: pe, Cuatrimestres: c, Asignaturas: a, Grupos: g, Usuarios: u}
+}
+
+// matriculaArbol es una matrícula que debe existir en Moodle: las ya guardadas localmente y las de los
+// miembros de los grupos de la asignatura.
+type matriculaArbol struct {
+	usuarioID uint
+	existente *models.Matricula
+}
+
+// SyncTree sincroniza en orden de dependencias el programa, sus cuatrimestres, sus asignaturas, sus
+// grupos, las matrículas (las locales pendientes y las de los miembros de los grupos que aún no están
+// matriculados) y los miembros de los grupos. Lo que falla en un nivel no detiene el resto: sus
+// descendientes se omiten y el informe lo recoge por nivel. Solo devuelve error si el programa no
+// existe o no se puede leer la BD local.
+func (s *SyncTreeService) SyncTree(ctx context.Context, programaID uint) (*SyncTreeResult, error) {
+	if _, err := s.Programas.GetByID(programaID); err != nil {
+		return nil, fmt.Errorf("PE no encontrado en BD local: %w", err)
+	}
+	log.Printf("🌳 Iniciando sincronización en árbol del PE ID %d...", programaID)
+
+	// 1. Programa de estudio
+	nivelPE := NivelSync{Nivel: NivelProgramaEstudio}
+	peOK := false
+	if err := s.Programas.SyncToMoodle(ctx, programaID); err != nil {
+		nivelPE.fallido(programaID, err)
+	} else {
+		nivelPE.ok()
+		peOK = true
+	}
+
+	// 2. Cuatrimestres
+	nivelC := NivelSync{Nivel: NivelCuatrimestres}
+	cuatrimestres, err := s.Cuatrimestres.Repo.GetByProgramaEstudio(programaID)
+	if err != nil {
+		return nil, fmt.Errorf("no se pudieron obtener los cuatrimestres del PE %d: %w", programaID, err)
+	}
+	cuatrimestreOK := make(map[uint]bool, len(cuatrimestres))
+	for _, c := range cuatrimestres {
+		if !peOK {
+			nivelC.omitido(c.ID, "el programa de estudio no está en Moodle")
+			continue
+		}
+		if err := s.Cuatrimestres.SyncToMoodle(ctx, c.ID); err != nil {
+			nivelC.fallido(c.ID, err)
+			continue
+		}
+		nivelC.ok()
+		cuatrimestreOK[c.ID] = true
+	}
+
+	// 3. Asignaturas
+	nivelA := NivelSync{Nivel: NivelAsignaturas}
+	var asignaturas []models.Asignatura
+	for _, c := range cuatrimestres {
+		deCuatrimestre, err := s.Asignaturas.Repo.GetByCuatrimestre(c.ID)
+		if err != nil {
+			return nil, fmt.Errorf("no se pudieron obtener las asignaturas del cuatrimestre %d: %w", c.ID, err)
+		}
+		asignaturas = append(asignaturas, deCuatrimestre...)
+	}
+	asignaturaOK := make(map[uint]bool, len(asignaturas))
+	for _, a := range asignaturas {
+		if !cuatrimestreOK[a.CuatrimestreID] {
+			nivelA.omitido(a.ID, fmt.Sprintf("el cuatrimestre %d no está en Moodle", a.CuatrimestreID))
+			continue
+		}
+		if err := s.Asignaturas.SyncToMoodle(ctx, a.ID); err != nil {
+			nivelA.fallido(a.ID, err)
+			continue
+		}
+		nivelA.ok()
+		asignaturaOK[a.ID] = true
+	}
+
+	// 4. Grupos
+	nivelG := NivelSync{Nivel: NivelGrupos}
+	var grupos []models.Grupo
+	for _, a := range asignaturas {
+		deAsignatura, err := s.Grupos.Repo.GetAllByCourseID(a.ID)
+		if err != nil {
+			return nil, fmt.Errorf("no se pudieron obtener los grupos de la asignatura %d: %w", a.ID, err)
+		}
+		grupos = append(grupos, deAsignatura...)
+	}
+	grupoOK := make(map[uint]bool, len(grupos))
+	for _, g := range grupos {
+		if !asignaturaOK[g.CourseID] {
+			nivelG.omitido(g.ID, fmt.Sprintf("la asignatura %d no está en Moodle", g.CourseID))
+			continue
+		}
+		if err := s.Grupos.SyncToMoodle(ctx, g.ID); err != nil {
+			nivelG.fallido(g.ID, err)
+			continue
+		}
+		nivelG.ok()
+		grupoOK[g.ID] = true
+	}
+
+	// 5. Matrículas: las guardadas localmente y las de los miembros de los grupos de cada asignatura
+	nivelM := NivelSync{Nivel: NivelMatriculas}
+	for _, a := range asignaturas {
+		pendientes, err := s.matriculasAsignatura(a.ID, grupos)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range pendientes {
+			if !asignaturaOK[a.ID] {
+				nivelM.omitido(m.usuarioID, fmt.Sprintf("la asignatura %d no está en Moodle", a.ID))
+				continue
+			}
+			if err := s.syncMatricula(ctx, a.ID, m); err != nil {
+				nivelM.fallido(m.usuarioID, err)
+				continue
+			}
+			nivelM.ok()
+		}
+	}
+
+	// 6. Miembros de los grupos (uno por grupo: cada llamada añade todos sus miembros)
+	nivelMiembros := NivelSync{Nivel: NivelMiembros}
+	for _, g := range grupos {
+		if !grupoOK[g.ID] {
+			nivelMiembros.omitido(g.ID, "el grupo no está en Moodle")
+			continue
+		}
+		if err := s.Grupos.SyncMembersToMoodle(ctx, g.ID); err != nil {
+			nivelMiembros.fallido(g.ID, err)
+			continue
+		}
+		nivelMiembros.ok()
+	}
+
+	result := &SyncTreeResult{
+		ProgramaEstudioID: programaID,
+		Niveles:           []NivelSync{nivelPE, nivelC, nivelA, nivelG, nivelM, nivelMiembros},
+	}
+	for _, n := range result.Niveles {
+		log.Printf("🌳 PE %d, %s: %d sincronizados, %d fallidos, %d omitidos (de %d)", programaID, n.Nivel, n.Sincronizados, n.Fallidos, n.Omitidos, n.Total)
+	}
+	return result, nil
+}
+
+// matriculasAsignatura reúne las matrículas de la asignatura (una por usuario): las locales y las de los
+// miembros de sus grupos que todavía no tienen matrícula.
+func (s *SyncTreeService) matriculasAsignatura(asignaturaID uint, grupos []models.Grupo) ([]matriculaArbol, error) {
+	existentes, err := s.Usuarios.Repo.GetMatriculasByAsignatura(asignaturaID)
+	if err != nil {
+		return nil, fmt.Errorf("no se pudieron obtener las matrículas de la asignatura %d: %w", asignaturaID, err)
+	}
+	vistos := make(map[uint]bool, len(existentes))
+	matriculas := make([]matriculaArbol, 0, len(existentes))
+	for i := range existentes {
+		vistos[existentes[i].UsuarioID] = true
+		matriculas = append(matriculas, matriculaArbol{usuarioID: existentes[i].UsuarioID, existente: &existentes[i]})
+	}
+
+	for _, g := range grupos {
+		if g.CourseID != asignaturaID {
+			continue
+		}
+		miembros, err := s.Grupos.Repo.GetMembers(g.ID)
+		if err != nil {
+			return nil, fmt.Errorf("no se pudieron obtener los miembros del grupo %d: %w", g.ID, err)
+		}
+		for _, u := range miembros {
+			if vistos[u.ID] {
+				continue
+			}
+			vistos[u.ID] = true
+			matriculas = append(matriculas, matriculaArbol{usuarioID: u.ID})
+		}
+	}
+	return matriculas, nil
+}
+
+// syncMatricula lleva una matrícula a Moodle. Una matrícula local ya sincronizada no se reenvía; una
+// pendiente de reenviar conserva su rol, periodo y suspensión. Un miembro de grupo sin matrícula se
+// matricula con el rol de su usuario, sincronizando antes el usuario si aún no está en Moodle.
+func (s *SyncTreeService) syncMatricula(ctx context.Context, asignaturaID uint, m matriculaArbol) error {
+	if m.existente != nil {
+		if m.existente.SyncStatus == models.SyncSynced {
+			return nil
+		}
+		return s.Usuarios.SyncMatricula(ctx, m.existente)
+	}
+
+	usuario, err := s.Usuarios.GetByID(m.usuarioID)
+	if err != nil {
+		return fmt.Errorf("usuario (ID: %d) no encontrado: %w", m.usuarioID, err)
+	}
+	if usuario.ID_Moodle == nil {
+		if err := s.Usuarios.SyncToMoodle(ctx, m.usuarioID); err != nil {
+			return err
+		}
+	}
+	matricula, err := s.Usuarios.MatricularUsuario(m.usuarioID, asignaturaID, OpcionesMatricula{})
+	if err != nil {
+		return err
+	}
+	return s.Usuarios.SyncMatricula(ctx, &matricula)
+}
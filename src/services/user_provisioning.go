@@ -0,0 +1,93 @@
+package services
+
+import (
+	"api_concurrencia/src/moodle"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordMode indica cómo obtiene su contraseña de Moodle un usuario nuevo con autenticación interna.
+// La contraseña local (hash bcrypt) nunca se envía a Moodle.
+type PasswordMode string
+
+const (
+	PasswordModeCreate    PasswordMode = "createpassword" // Moodle genera la contraseña y la envía por correo
+	PasswordModeTemporary PasswordMode = "temporary"      // MOODLE_TEMP_PASSWORD, con cambio obligatorio al primer acceso
+)
+
+// UserProvisioning es la forma de dar de alta las cuentas en Moodle.
+type UserProvisioning struct {
+	Auth         string       // Método de autenticación de Moodle (manual, oauth2, ldap, nologin...)
+	PasswordMode PasswordMode // Sólo se usa con autenticación interna (manual, email)
+	TempPassword string
+}
+
+// userProvisioningFromEnv lee MOODLE_USER_AUTH (manual por defecto), MOODLE_PASSWORD_MODE
+// (createpassword por defecto, o temporary) y MOODLE_TEMP_PASSWORD. Sin contraseña temporal
+// configurada, el modo temporary se sustituye por createpassword.
+func userProvisioningFromEnv() UserProvisioning {
+	p := UserProvisioning{
+		Auth:         strings.ToLower(strings.TrimSpace(os.Getenv("MOODLE_USER_AUTH"))),
+		PasswordMode: PasswordModeCreate,
+		TempPassword: os.Getenv("MOODLE_TEMP_PASSWORD"),
+	}
+	if p.Auth == "" {
+		p.Auth = "manual"
+	}
+
+	switch raw := strings.ToLower(os.Getenv("MOODLE_PASSWORD_MODE")); raw {
+	case "", string(PasswordModeCreate):
+	case string(PasswordModeTemporary):
+		if p.TempPassword == "" {
+			log.Printf("⚠️ MOODLE_PASSWORD_MODE=temporary sin MOODLE_TEMP_PASSWORD. Usando createpassword.")
+		} else {
+			p.PasswordMode = PasswordModeTemporary
+		}
+	default:
+		log.Printf("⚠️ Valor inválido para MOODLE_PASSWORD_MODE (%q). Usando createpassword.", raw)
+	}
+	return p
+}
+
+// internalAuth indica si el método de autenticación guarda la contraseña en Moodle. Con los
+// externos (ldap, oauth2, cas...) o nologin Moodle no necesita contraseña.
+func (p UserProvisioning) internalAuth() bool {
+	return p.Auth == "manual" || p.Auth == "email"
+}
+
+// apply completa la petición de alta con el método de autenticación y la contraseña que corresponda.
+func (p UserProvisioning) apply(req *moodle.UserRequest) {
+	req.Auth = p.Auth
+	req.Password = ""
+	req.CreatePassword = 0
+	req.Preferences = nil
+	if !p.internalAuth() {
+		return
+	}
+	if p.PasswordMode == PasswordModeTemporary {
+		req.Password = p.TempPassword
+		req.Preferences = []moodle.UserPreference{{Type: "auth_forcepasswordchange", Value: "1"}}
+		return
+	}
+	req.CreatePassword = 1
+}
+
+// hashPassword sustituye la contraseña en claro del usuario por su hash bcrypt. Lo que llega en la
+// petición siempre se trata como contraseña en claro, aunque tenga forma de hash: si no, un cliente
+// podría fijar directamente el hash guardado.
+func hashPassword(password *string) error {
+	if *password == "" {
+		return errors.New("la contraseña es obligatoria")
+	}
+	hashed, err := bcrypt.GenerateFromPassword([]byte(*password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("error al procesar la contraseña: %w", err)
+	}
+	*password = string(hashed)
+	return nil
+}
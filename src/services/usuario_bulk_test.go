@@ -0,0 +1,61 @@
+package services
+
+import (
+	"api_concurrencia/src/models"
+	"api_concurrencia/src/moodle"
+	"context"
+	"strings"
+	"testing"
+)
+
+// La sincronización masiva vincula los usernames que ya existen en Moodle sin intentar crearlos y, si
+// Moodle rechaza el lote, crea sus usuarios uno a uno en lugar de perderlos todos.
+func TestBulkSyncUsuarios(t *testing.T) {
+	svc, fake, _ := newTestServices(t)
+	var existing []moodle.UserResponse
+	err := fake.Call(context.Background(), "core_user_create_users", moodle.CreateUsersParams{
+		Users: []moodle.UserRequest{{Username: "bruiz", Firstname: "Berta", Lastname: "Ruiz", Email: "berta@example.com", Password: "Segura123#"}},
+	}, &existing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []uint
+	for _, username := range []string{"alopez", "BRuiz", "cgarcia"} {
+		u := models.Usuario{Username: username, Password: "Segura123#", FirstName: "Nombre", LastName: "Apellido", Email: strings.ToLower(username) + "@example.com", Rol: "Alumno"}
+		if err := svc.Usuarios.CreateLocal(&u); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, u.ID)
+	}
+	fake.Calls = nil
+	fake.FailNext("core_user_create_users", &moodle.MoodleError{ErrorCode: "invalidparameter", Message: "Invalid parameter value detected"})
+
+	if err := svc.Usuarios.BulkSyncToMoodle(context.Background(), "Alumno", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	creates := 0
+	for _, call := range fake.Calls {
+		if call == "core_user_create_users" {
+			creates++
+		}
+	}
+	// El lote rechazado (2 usuarios) y después cada uno por separado.
+	if creates != 3 {
+		t.Errorf("core_user_create_users llamado %d veces, se esperaban 3: %v", creates, fake.Calls)
+	}
+	for i, id := range ids {
+		u, err := svc.Usuarios.GetByID(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.ID_Moodle == nil || u.SyncStatus != models.SyncSynced {
+			t.Errorf("%s: ID_Moodle = %v, estado = %s; se esperaba sincronizado", u.Username, u.ID_Moodle, u.SyncStatus)
+			continue
+		}
+		if i == 1 && *u.ID_Moodle != existing[0].ID {
+			t.Errorf("%s vinculado a %d, se esperaba la cuenta existente %d", u.Username, *u.ID_Moodle, existing[0].ID)
+		}
+	}
+}
@@ -4,27 +4,42 @@ import (
 	"api_concurrencia/src/models"
 	"api_concurrencia/src/moodle"
 	"api_concurrencia/src/repository"
+	"context"
+	"errors"
 	"fmt"
 	"log"
-	"sync"
+	"strings"
+
+	"gorm.io/gorm"
 )
 
 type UsuarioService struct {
 	Repo           *repository.UsuarioRepository
-	MoodleClient   *moodle.Client                   // Cliente para la API de Moodle
+	MoodleClient   moodle.MoodleAPI                 // Cliente para la API de Moodle
 	AsignaturaRepo *repository.AsignaturaRepository // Repositorio para Asignaturas
+	Roles          *RolMoodleService                // Correspondencia de roles locales con roles de Moodle
+	Outbox         *OutboxService                   // Registro de cambios locales para propagarlos a Moodle
 }
 
-func NewUsuarioService(repo *repository.UsuarioRepository, moodleClient *moodle.Client, asignaturaRepo *repository.AsignaturaRepository) *UsuarioService {
-	return &UsuarioService{Repo: repo, MoodleClient: moodleClient, AsignaturaRepo: asignaturaRepo}
+func NewUsuarioService(repo *repository.UsuarioRepository, moodleClient moodle.MoodleAPI, asignaturaRepo *repository.AsignaturaRepository, roles *RolMoodleService, outbox *OutboxService) *UsuarioService {
+	return &UsuarioService{Repo: repo, MoodleClient: moodleClient, AsignaturaRepo: asignaturaRepo, Roles: roles, Outbox: outbox}
 }
 
-// (Implementar CreateLocal, GetByID, GetAll, UpdateLocal, DeleteLocal) ...
+// (Implementar CreateLocal, GetByID, GetAll, UpdateLocal, Delete) ...
 
-// CreateLocal crea el registro en la BD local.
+// CreateLocal crea el registro en la BD local y registra su creación en el outbox. La contraseña se
+// guarda siempre como hash bcrypt.
 func (s *UsuarioService) CreateLocal(a *models.Usuario) error {
-	// Se requeriría la importación de "errors" si el paquete no lo tiene por defecto.
-	return s.Repo.Create(a)
+	if err := hashPassword(&a.Password); err != nil {
+		return err
+	}
+	a.SyncState = models.SyncState{}
+	return s.Outbox.Record(func(tx *gorm.DB) (*models.OutboxEvent, error) {
+		if err := s.Repo.WithTx(tx).Create(a); err != nil {
+			return nil, err
+		}
+		return models.NewOutboxEvent(models.OutboxUsuario, a.ID, models.OutboxCreate), nil
+	})
 }
 
 // GetAll recupera todas las Asignaturas.
@@ -37,14 +52,70 @@ func (s *UsuarioService) GetByID(id uint) (models.Usuario, error) {
 	return s.Repo.GetByID(id)
 }
 
-// UpdateLocal actualiza el registro en la BD local.
+// UpdateLocal actualiza el registro en la BD local. Sin contraseña se conserva la actual; si se
+// indica una nueva se guarda su hash. El estado de sincronización se conserva y pasa a dirty si
+// cambiaron los datos que se envían a Moodle. El cambio se registra en el outbox.
 func (s *UsuarioService) UpdateLocal(a *models.Usuario) error {
-	return s.Repo.Update(a)
+	actual, err := s.Repo.GetByID(a.ID)
+	if err != nil {
+		return fmt.Errorf("usuario (ID: %d) no encontrado: %w", a.ID, err)
+	}
+	if a.Password == "" {
+		a.Password = actual.Password
+	} else if err := hashPassword(&a.Password); err != nil {
+		return err
+	}
+	a.SyncState = actual.SyncState
+	a.MarkDirty(usuarioHash(*a))
+	return s.Outbox.Record(func(tx *gorm.DB) (*models.OutboxEvent, error) {
+		if err := s.Repo.WithTx(tx).Update(a); err != nil {
+			return nil, err
+		}
+		return models.NewOutboxEvent(models.OutboxUsuario, a.ID, models.OutboxUpdate), nil
+	})
+}
+
+// syncFailed guarda el fallo de sincronización del usuario y devuelve err.
+func (s *UsuarioService) syncFailed(u *models.Usuario, err error) error {
+	return markSyncFailed(&u.SyncState, func() error { return s.Repo.Update(u) }, err)
 }
 
-// DeleteLocal elimina el registro en la BD local.
-func (s *UsuarioService) DeleteLocal(id uint) error {
-	return s.Repo.Delete(id)
+// Delete elimina el Usuario local y registra el borrado en el outbox. Si propagate es true y está
+// sincronizado, el despachador aplica después MOODLE_USER_DELETE_POLICY a su cuenta de Moodle.
+func (s *UsuarioService) Delete(id uint, propagate bool) error {
+	if id == 0 {
+		return errors.New("ID de Usuario inválido")
+	}
+	usuario, err := s.Repo.GetByID(id)
+	if err != nil {
+		return fmt.Errorf("usuario no encontrado en BD local: %w", err)
+	}
+	return s.Outbox.Record(func(tx *gorm.DB) (*models.OutboxEvent, error) {
+		if err := s.Repo.WithTx(tx).Delete(id); err != nil {
+			return nil, err
+		}
+		return deleteEvent(models.OutboxUsuario, id, usuario.ID_Moodle, propagate), nil
+	})
+}
+
+// DeleteInMoodle aplica la política MOODLE_USER_DELETE_POLICY a la cuenta de Moodle de un usuario ya
+// eliminado localmente: "suspend" (por defecto) la suspende; "delete" la elimina.
+func (s *UsuarioService) DeleteInMoodle(ctx context.Context, moodleID uint) error {
+	if userDeletePolicyFromEnv() == UserDeleteSuspend {
+		suspended := 1
+		data := moodle.UpdateUsersParams{Users: []moodle.UserUpdateRequest{{ID: moodleID, Suspended: &suspended}}}
+		if err := s.MoodleClient.Call(ctx, "core_user_update_users", data, nil); err != nil {
+			return fmt.Errorf("fallo al suspender Usuario en Moodle (ID: %d): %w", moodleID, err)
+		}
+		log.Printf("⏸️ Usuario suspendido en Moodle (ID: %d)", moodleID)
+		return nil
+	}
+
+	if err := s.MoodleClient.Call(ctx, "core_user_delete_users", moodle.DeleteUsersParams{UserIDs: []uint{moodleID}}, nil); err != nil {
+		return fmt.Errorf("fallo al eliminar Usuario en Moodle (ID: %d): %w", moodleID, err)
+	}
+	log.Printf("🗑️ Usuario eliminado de Moodle (ID: %d)", moodleID)
+	return nil
 }
 
 // GetUnsyncedByRole recupera los usuarios pendientes de sincronización para un rol específico.
@@ -58,7 +129,7 @@ func (s *UsuarioService) GetByGroupID(grupoID uint) ([]models.Usuario, error) {
 }
 
 // SyncToMoodle (Para un solo usuario).
-func (s *UsuarioService) SyncToMoodle(id uint) error {
+func (s *UsuarioService) SyncToMoodle(ctx context.Context, id uint) error {
 	usuario, err := s.Repo.GetByID(id)
 	if err != nil {
 		return fmt.Errorf("usuario (ID: %d) no encontrado en BD local: %w", id, err)
@@ -67,36 +138,36 @@ func (s *UsuarioService) SyncToMoodle(id uint) error {
 	// Si ya tiene ID_Moodle, llamamos a UPDATE en lugar de CREATE
 	if usuario.ID_Moodle != nil {
 		log.Printf("Usuario ID %d ya sincronizado (Moodle ID: %d). Actualizando en Moodle...", id, *usuario.ID_Moodle)
-		return s.UpdateInMoodle(&usuario)
+		return s.UpdateInMoodle(ctx, &usuario)
 	}
 
-	// 1. Construir el array de datos para la función de Moodle
-	data := []moodle.UserRequest{
-		{
-			Username:  usuario.Username,
-			Password:  usuario.Password,  // Usamos la contraseña hasheada/temporal de tu modelo
-			Firstname: usuario.FirstName, // Usamos FirstName
-			Lastname:  usuario.LastName,  // Usamos LastName
-			Email:     usuario.Email,
-			// 👈 Mapeamos Matricula a IDNumber de Moodle
-			IDNumber: safeString(usuario.Matricula),
-		},
-	}
+	// 1. Construir el array de datos para la función de Moodle (la contraseña según MOODLE_PASSWORD_MODE)
+	data := []moodle.UserRequest{userRequest(usuario, userProvisioningFromEnv())}
 
 	// 2. Ejecutar la llamada a la API de Moodle
 	var response []moodle.UserResponse
-	err = s.MoodleClient.Call("core_user_create_users", data, &response)
+	err = s.MoodleClient.Call(ctx, "core_user_create_users", moodle.CreateUsersParams{Users: data}, &response)
 	if err != nil {
-		return fmt.Errorf("fallo al crear Usuario en Moodle: %w", err)
+		if !moodle.IsDuplicate(err) {
+			return s.syncFailed(&usuario, fmt.Errorf("fallo al crear Usuario en Moodle: %w", err))
+		}
+		// El username ya existe en Moodle: recuperamos la cuenta existente y la vinculamos.
+		existing, lookupErr := moodle.FindUserByUsername(ctx, s.MoodleClient, usuario.Username)
+		if lookupErr != nil || existing == nil {
+			return s.syncFailed(&usuario, fmt.Errorf("el usuario '%s' ya existe en Moodle y no se pudo recuperar su ID: %w", usuario.Username, err))
+		}
+		log.Printf("⚠️ Usuario '%s' ya existía en Moodle (ID: %d). Vinculando.", usuario.Username, existing.ID)
+		response = []moodle.UserResponse{{ID: existing.ID, Username: existing.Username}}
 	}
 
 	// 3. Procesar la respuesta y actualizar el ID_Moodle local
 	if len(response) == 0 {
-		return fmt.Errorf("moodle no devolvió ningún Usuario creado")
+		return s.syncFailed(&usuario, fmt.Errorf("moodle no devolvió ningún Usuario creado"))
 	}
 
 	moodleID := response[0].ID
 	usuario.ID_Moodle = &moodleID
+	usuario.MarkSynced(usuarioHash(usuario))
 
 	if err := s.Repo.Update(&usuario); err != nil {
 		return fmt.Errorf("falla al actualizar ID Moodle local para Usuario ID %d: %w", id, err)
@@ -106,195 +177,472 @@ func (s *UsuarioService) SyncToMoodle(id uint) error {
 	return nil
 }
 
-// UpdateInMoodle actualiza un usuario que ya existe en Moodle
-func (s *UsuarioService) UpdateInMoodle(usuario *models.Usuario) error {
+// UpdateInMoodle actualiza un usuario que ya existe en Moodle y guarda el resultado en su estado de sincronización.
+func (s *UsuarioService) UpdateInMoodle(ctx context.Context, usuario *models.Usuario) error {
 	if usuario.ID_Moodle == nil {
 		return fmt.Errorf("el usuario no tiene ID de Moodle, debe crearse primero")
 	}
 
-	data := []moodle.UserUpdateRequest{
-		{
-			ID:        *usuario.ID_Moodle,
-			Username:  usuario.Username,
-			Firstname: usuario.FirstName,
-			Lastname:  usuario.LastName,
-			Email:     usuario.Email,
-			IDNumber:  safeString(usuario.Matricula),
-			// Password solo se envía si se cambió (deberías tener un flag para esto)
-		},
-	}
+	data := []moodle.UserUpdateRequest{userUpdateRequest(*usuario)}
 
 	// Moodle NO devuelve datos en core_user_update_users, solo confirma sin errores
 	var response interface{}
-	err := s.MoodleClient.Call("core_user_update_users", data, &response)
+	err := s.MoodleClient.Call(ctx, "core_user_update_users", moodle.UpdateUsersParams{Users: data}, &response)
 	if err != nil {
-		return fmt.Errorf("fallo al actualizar Usuario en Moodle: %w", err)
+		return s.syncFailed(usuario, fmt.Errorf("fallo al actualizar Usuario en Moodle: %w", err))
+	}
+
+	usuario.MarkSynced(usuarioHash(*usuario))
+	if err := s.Repo.Update(usuario); err != nil {
+		return fmt.Errorf("usuario actualizado en Moodle, pero falló guardar el estado de sincronización: %w", err)
 	}
 
 	log.Printf("Usuario '%s' (Moodle ID: %d) actualizado exitosamente en Moodle", usuario.Username, *usuario.ID_Moodle)
 	return nil
 }
 
-// BulkSyncToMoodle lanza una tarea masiva y concurrente para crear usuarios.
-// Usamos un goroutine para no bloquear el API.
-func (s *UsuarioService) BulkSyncToMoodle(role string) {
-	go func() {
-		usuarios, err := s.Repo.GetUnsyncedByRole(role)
-		if err != nil {
-			log.Printf("ERROR: No se pudieron obtener usuarios no sincronizados para el rol %s: %v", role, err)
-			return
-		}
+// BulkSyncToMoodle crea en Moodle los usuarios no sincronizados del rol y anota el resultado de cada
+// uno en progress. Si ctx se cancela, se abortan las llamadas en curso.
+func (s *UsuarioService) BulkSyncToMoodle(ctx context.Context, role string, progress *SyncProgress) error {
+	usuarios, err := s.Repo.GetUnsyncedByRole(role)
+	if err != nil {
+		return fmt.Errorf("no se pudieron obtener usuarios no sincronizados para el rol %s: %w", role, err)
+	}
+	progress.SetTotal(len(usuarios))
 
-		if len(usuarios) == 0 {
-			log.Printf("No hay usuarios de rol %s pendientes de sincronizar.", role)
-			return
-		}
+	if len(usuarios) == 0 {
+		log.Printf("No hay usuarios de rol %s pendientes de sincronizar.", role)
+		return nil
+	}
 
-		log.Printf("Iniciando sincronización masiva para %d usuarios de rol %s...", len(usuarios), role)
+	log.Printf("Iniciando sincronización masiva para %d usuarios de rol %s...", len(usuarios), role)
 
-		s.processInBatches(usuarios)
+	s.createUsersInMoodle(ctx, usuarios, progress)
 
-		log.Printf("✅ Sincronización masiva de usuarios de rol %s finalizada.", role)
-	}()
+	log.Printf("✅ Sincronización masiva de usuarios de rol %s finalizada.", role)
+	return ctx.Err()
 }
 
-func translateRoleToMoodleID(rol string) (int, error) {
-	switch rol {
-	case "Docente":
-		return 3, nil // 3 = Rol de Profesor (Teacher/Editing teacher)
-	case "Alumno":
-		return 5, nil // 5 = Rol de Estudiante (Student)
-	default:
-		return 0, fmt.Errorf("rol local desconocido: %s", rol)
+// RolValido indica si el rol local tiene correspondencia con un rol de Moodle.
+func (s *UsuarioService) RolValido(rol string) (bool, error) {
+	return s.Roles.ExisteRol(rol)
+}
+
+// OpcionesMatricula son los datos opcionales al matricular: periodo de acceso, suspensión y rol.
+type OpcionesMatricula struct {
+	Rol       string `json:"rol,omitempty" example:"Docente sin edición"` // Rol local para este curso (por defecto, el Rol del usuario)
+	Timestart *int64 `json:"timestart,omitempty" example:"1704067200"`    // Inicio del acceso (UNIX timestamp)
+	Timeend   *int64 `json:"timeend,omitempty" example:"1719792000"`      // Fin del acceso (UNIX timestamp)
+	Suspended bool   `json:"suspended" example:"false"`                   // Matricular ya suspendido
+}
+
+// Validate comprueba que el periodo de matrícula sea coherente.
+func (o OpcionesMatricula) Validate() error {
+	if o.Timestart != nil && *o.Timestart < 0 {
+		return errors.New("timestart no puede ser negativo")
+	}
+	if o.Timeend != nil && *o.Timeend < 0 {
+		return errors.New("timeend no puede ser negativo")
 	}
+	if o.Timestart != nil && o.Timeend != nil && *o.Timeend != 0 && *o.Timeend <= *o.Timestart {
+		return errors.New("timeend debe ser posterior a timestart")
+	}
+	return nil
+}
+
+// suspendFlag convierte el estado de suspensión al valor que espera enrol_manual_enrol_users.
+func suspendFlag(suspended bool) *int {
+	flag := 0
+	if suspended {
+		flag = 1
+	}
+	return &flag
 }
 
-func (s *UsuarioService) MatricularUsuario(usuarioID, asignaturaID uint) error {
+// MatricularUsuario guarda (o actualiza) la Matricula local y registra en el outbox que hay que llevarla
+// a Moodle. Si el usuario ya estaba matriculado se actualizan su rol, periodo y suspensión, y la matrícula
+// se vuelve a enviar aunque no haya cambiado. El usuario y la asignatura deben estar ya en Moodle.
+func (s *UsuarioService) MatricularUsuario(usuarioID, asignaturaID uint, opts OpcionesMatricula) (models.Matricula, error) {
+	if err := opts.Validate(); err != nil {
+		return models.Matricula{}, err
+	}
+
 	// 1. Obtener el Usuario local (para ID_Moodle y Rol)
 	usuario, err := s.Repo.GetByID(usuarioID)
 	if err != nil {
-		return fmt.Errorf("usuario (ID: %d) no encontrado: %w", usuarioID, err)
+		return models.Matricula{}, fmt.Errorf("usuario (ID: %d) no encontrado: %w", usuarioID, err)
 	}
 
 	// 2. Obtener la Asignatura local (para su ID_Moodle)
 	asignatura, err := s.AsignaturaRepo.GetByID(asignaturaID)
 	if err != nil {
-		return fmt.Errorf("asignatura (ID: %d) no encontrada: %w", asignaturaID, err)
+		return models.Matricula{}, fmt.Errorf("asignatura (ID: %d) no encontrada: %w", asignaturaID, err)
 	}
 
 	// 3. Validaciones de IDs de Moodle
 	if usuario.ID_Moodle == nil {
-		return fmt.Errorf("el usuario '%s' no está sincronizado con Moodle (ID_Moodle local es nulo)", usuario.Username)
+		return models.Matricula{}, fmt.Errorf("el usuario '%s' no está sincronizado con Moodle: %w", usuario.Username, ErrParentNotSynced)
 	}
 	if asignatura.ID_Moodle == nil {
-		return fmt.Errorf("la asignatura '%s' no está sincronizada con Moodle (ID_Moodle local es nulo)", asignatura.NombreCompleto)
+		return models.Matricula{}, fmt.Errorf("la asignatura '%s' no está sincronizada con Moodle: %w", asignatura.NombreCompleto, ErrParentNotSynced)
+	}
+
+	// 4. Traducir el rol (el indicado para este curso o el del usuario) al RoleID de Moodle
+	rolNombre := usuario.Rol
+	if opts.Rol != "" {
+		rolNombre = opts.Rol
+	}
+	rol, err := s.Roles.Resolver(rolNombre)
+	if err != nil {
+		return models.Matricula{}, fmt.Errorf("no se pudo matricular: %w", err)
 	}
 
-	// 4. Traducir el Rol de Usuario al RoleID de Moodle
-	moodleRoleID, err := translateRoleToMoodleID(usuario.Rol)
+	// 5. Guardar la matrícula local. Si ya existía (re-matriculación), se actualiza en lugar de chocar
+	// con el índice único.
+	matricula, err := s.Repo.GetMatricula(usuarioID, asignaturaID)
+	switch {
+	case err == nil:
+		if matricula.SyncStatus == models.SyncSynced {
+			matricula.SyncStatus = models.SyncDirty
+		}
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		matricula = models.Matricula{UsuarioID: usuarioID, AsignaturaID: asignaturaID}
+	default:
+		return models.Matricula{}, fmt.Errorf("no se pudo leer la matrícula local: %w", err)
+	}
+	matricula.UserMoodleID = *usuario.ID_Moodle
+	matricula.CourseMoodleID = *asignatura.ID_Moodle
+	matricula.RoleID = rol.MoodleRoleID
+	matricula.Timestart = opts.Timestart
+	matricula.Timeend = opts.Timeend
+	matricula.Suspended = opts.Suspended
+
+	err = s.Outbox.Record(func(tx *gorm.DB) (*models.OutboxEvent, error) {
+		if err := s.Repo.WithTx(tx).UpdateMatricula(&matricula); err != nil {
+			return nil, err
+		}
+		return models.NewOutboxEvent(models.OutboxMatricula, usuarioID, models.OutboxUpdate), nil
+	})
 	if err != nil {
-		return fmt.Errorf("no se pudo matricular: %w", err)
+		return models.Matricula{}, fmt.Errorf("no se pudo guardar la matrícula local: %w", err)
 	}
 
-	// Convertir a uint para los modelos
-	moodleRoleIDUint := uint(moodleRoleID)
+	log.Printf("✅ Matrícula de %s en '%s' registrada con RoleID %d (suspendida: %t); se enviará a Moodle.",
+		usuario.Username, asignatura.NombreCompleto, rol.MoodleRoleID, opts.Suspended)
+	return matricula, nil
+}
 
-	// 5. Construir el array de datos para la API de Moodle
+// SetMatriculaSuspendida suspende (suspended=true) o reactiva una matrícula existente sin eliminarla y
+// registra el cambio en el outbox: se envía con el mismo rol y periodo y el nuevo estado.
+func (s *UsuarioService) SetMatriculaSuspendida(usuarioID, asignaturaID uint, suspended bool) error {
+	matricula, err := s.Repo.GetMatricula(usuarioID, asignaturaID)
+	if err != nil {
+		return fmt.Errorf("matrícula del usuario %d en la asignatura %d no encontrada: %w", usuarioID, asignaturaID, err)
+	}
+
+	matricula.Suspended = suspended
+	matricula.MarkDirty(matriculaHash(matricula))
+	err = s.Outbox.Record(func(tx *gorm.DB) (*models.OutboxEvent, error) {
+		if err := s.Repo.WithTx(tx).UpdateMatricula(&matricula); err != nil {
+			return nil, err
+		}
+		return models.NewOutboxEvent(models.OutboxMatricula, usuarioID, models.OutboxUpdate), nil
+	})
+	if err != nil {
+		return fmt.Errorf("no se pudo guardar el estado de la matrícula: %w", err)
+	}
+
+	estado := "reactivada"
+	if suspended {
+		estado = "suspendida"
+	}
+	log.Printf("✅ Matrícula del Usuario ID %d en la Asignatura ID %d %s; se enviará a Moodle.", usuarioID, asignaturaID, estado)
+	return nil
+}
+
+// SyncMatriculas lleva a Moodle las matrículas del usuario que no están sincronizadas. Es el manejador
+// del outbox para las matrículas.
+func (s *UsuarioService) SyncMatriculas(ctx context.Context, usuarioID uint) error {
+	matriculas, err := s.Repo.GetMatriculasByUsuario(usuarioID)
+	if err != nil {
+		return fmt.Errorf("no se pudieron obtener las matrículas del usuario %d: %w", usuarioID, err)
+	}
+	var errs []error
+	for i := range matriculas {
+		if matriculas[i].SyncStatus == models.SyncSynced {
+			continue
+		}
+		if err := s.SyncMatricula(ctx, &matriculas[i]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SyncMatricula lleva una matrícula local a Moodle con enrol_manual_enrol_users, que también actualiza el
+// rol, el periodo y la suspensión de una matrícula existente. Si el rol es de estudiante, añade además al
+// usuario a las cohortes del cuatrimestre y del programa de la asignatura.
+func (s *UsuarioService) SyncMatricula(ctx context.Context, m *models.Matricula) error {
 	data := []moodle.EnrolmentRequest{
 		{
-			RoleID:   moodleRoleID,
-			UserID:   *usuario.ID_Moodle,
-			CourseID: *asignatura.ID_Moodle,
+			RoleID:    int(m.RoleID),
+			UserID:    m.UserMoodleID,
+			CourseID:  m.CourseMoodleID,
+			Timestart: m.Timestart,
+			Timeend:   m.Timeend,
+			Suspend:   suspendFlag(m.Suspended),
 		},
 	}
+	err := s.MoodleClient.Call(ctx, "enrol_manual_enrol_users", moodle.EnrolUsersParams{Enrolments: data}, nil)
+	if err != nil {
+		return s.matriculaSyncFailed(m, fmt.Errorf("fallo al matricular al usuario (Moodle ID: %d) en el curso (Moodle ID: %d): %w", m.UserMoodleID, m.CourseMoodleID, err))
+	}
 
-	// 6. Ejecutar la llamada a la API de Moodle
-	var response interface{}
-	err = s.MoodleClient.Call("enrol_manual_enrol_users", data, &response)
+	estudiante, err := s.Roles.RolesEstudiante()
 	if err != nil {
-		return fmt.Errorf("fallo al matricular usuario '%s' en curso '%s' en Moodle: %w", usuario.Username, asignatura.NombreCompleto, err)
+		log.Printf("⚠️ No se pudieron leer los roles de estudiante; no se actualizan las cohortes: %v", err)
+	} else if estudiante[m.RoleID] {
+		usuario, uErr := s.Repo.GetByID(m.UsuarioID)
+		asignatura, aErr := s.AsignaturaRepo.GetByID(m.AsignaturaID)
+		if uErr == nil && aErr == nil {
+			addToAsignaturaCohorts(ctx, s.MoodleClient, usuario, asignatura)
+		}
 	}
 
-	// 7. 🚀 NUEVO PASO: GUARDAR REFERENCIA EN LA TABLA MATRICULA LOCAL
-	matricula := models.Matricula{
-		UsuarioID:      usuarioID,
-		AsignaturaID:   asignaturaID,
-		UserMoodleID:   *usuario.ID_Moodle,
-		CourseMoodleID: *asignatura.ID_Moodle,
-		RoleID:         moodleRoleIDUint,
+	m.MarkSynced(matriculaHash(*m))
+	if err := s.Repo.UpdateMatricula(m); err != nil {
+		return fmt.Errorf("matrícula enviada a Moodle, pero falló la referencia local: %w", err)
+	}
+	log.Printf("✅ Matrícula del Usuario ID %d en la Asignatura ID %d sincronizada (Moodle: usuario %d, curso %d, RoleID %d).",
+		m.UsuarioID, m.AsignaturaID, m.UserMoodleID, m.CourseMoodleID, m.RoleID)
+	return nil
+}
+
+// matriculaSyncFailed guarda el fallo de sincronización de la matrícula y devuelve err.
+func (s *UsuarioService) matriculaSyncFailed(m *models.Matricula, err error) error {
+	return markSyncFailed(&m.SyncState, func() error { return s.Repo.UpdateMatricula(m) }, err)
+}
+
+// DesmatricularUsuario elimina la Matricula local (y la pertenencia del usuario a los grupos de la
+// asignatura) y registra en el outbox la baja en Moodle (enrol_manual_unenrol_users).
+func (s *UsuarioService) DesmatricularUsuario(usuarioID, asignaturaID uint) error {
+	matricula, err := s.Repo.GetMatricula(usuarioID, asignaturaID)
+	if err != nil {
+		return fmt.Errorf("matrícula del usuario %d en la asignatura %d no encontrada: %w", usuarioID, asignaturaID, err)
 	}
 
-	if err := s.Repo.SaveMatricula(matricula); err != nil {
-		// La restricción de índice único compuesto en la tabla Matricula evita duplicados.
-		log.Printf("⚠️ ADVERTENCIA: La matrícula fue exitosa en Moodle, pero falló al guardar la referencia local: %v", err)
-		return fmt.Errorf("matrícula exitosa en Moodle, pero falló la referencia local: %w", err)
+	err = s.Outbox.Record(func(tx *gorm.DB) (*models.OutboxEvent, error) {
+		if err := s.Repo.WithTx(tx).DeleteMatricula(matricula); err != nil {
+			return nil, err
+		}
+		event := models.NewOutboxEvent(models.OutboxMatricula, usuarioID, models.OutboxDelete)
+		event.MoodleID = &matricula.CourseMoodleID
+		event.UserMoodleID = &matricula.UserMoodleID
+		return event, nil
+	})
+	if err != nil {
+		return fmt.Errorf("no se pudo eliminar la matrícula local: %w", err)
 	}
 
-	log.Printf("✅ Usuario %s (ID Moodle: %d) matriculado con éxito en el curso '%s' (ID Moodle: %d) con RoleID: %d y referencia local guardada.",
-		usuario.Username, *usuario.ID_Moodle, asignatura.NombreCompleto, *asignatura.ID_Moodle, moodleRoleID)
+	log.Printf("✅ Usuario ID %d dado de baja de la Asignatura ID %d; la baja se enviará a Moodle (usuario %d, curso %d).",
+		usuarioID, asignaturaID, matricula.UserMoodleID, matricula.CourseMoodleID)
+	return nil
+}
 
+// UnenrolInMoodle da de baja a un usuario de un curso de Moodle (enrol_manual_unenrol_users) y lo quita
+// de las cohortes del cuatrimestre y del programa de la asignatura si ya no es alumno de ninguna otra.
+func (s *UsuarioService) UnenrolInMoodle(ctx context.Context, courseMoodleID, userMoodleID uint) error {
+	data := []moodle.UnenrolmentRequest{{UserID: userMoodleID, CourseID: courseMoodleID}}
+	err := s.MoodleClient.Call(ctx, "enrol_manual_unenrol_users", moodle.UnenrolUsersParams{Enrolments: data}, nil)
+	if err != nil {
+		return fmt.Errorf("fallo al dar de baja al usuario (Moodle ID: %d) del curso (Moodle ID: %d): %w", userMoodleID, courseMoodleID, err)
+	}
+	log.Printf("🗑️ Usuario (Moodle ID: %d) dado de baja del curso (Moodle ID: %d).", userMoodleID, courseMoodleID)
+	s.removeFromCohorts(ctx, courseMoodleID, userMoodleID)
+
+	// Si el usuario se volvió a matricular antes de entregarse la baja, un evento anterior ya pudo enviar
+	// la matrícula nueva y la baja acaba de deshacerla: queda pendiente para que su evento la reenvíe.
+	m, err := s.Repo.GetMatriculaByMoodleIDs(courseMoodleID, userMoodleID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("baja enviada a Moodle, pero no se pudo revisar la matrícula local: %w", err)
+	}
+	if m.SyncStatus == models.SyncSynced {
+		m.SyncStatus = models.SyncDirty
+		if err := s.Repo.UpdateMatricula(&m); err != nil {
+			return fmt.Errorf("baja enviada a Moodle, pero no se pudo marcar la matrícula local para reenviarla: %w", err)
+		}
+	}
 	return nil
 }
 
-// processInBatches divide los usuarios en lotes y los procesa concurrentemente.
-func (s *UsuarioService) processInBatches(usuarios []models.Usuario) {
-	var wg sync.WaitGroup
-	batchSize := 100 // Lotes de 100 usuarios por llamada a la API de Moodle
+// removeFromCohorts quita al usuario dado de baja del curso de las cohortes de la asignatura en las que
+// ya no tiene ninguna matrícula de alumno. Las matrículas se cuentan después de borrar la local, así que
+// una nueva matrícula en el mismo curso también lo mantiene en ellas.
+func (s *UsuarioService) removeFromCohorts(ctx context.Context, courseMoodleID, userMoodleID uint) {
+	asignatura, err := s.AsignaturaRepo.GetByMoodleID(courseMoodleID)
+	if err != nil {
+		log.Printf("⚠️ Curso (Moodle ID: %d) sin asignatura local; no se revisan las cohortes: %v", courseMoodleID, err)
+		return
+	}
+	roles, err := s.Roles.IDsRolesEstudiante()
+	if err != nil {
+		log.Printf("⚠️ No se pudieron leer los roles de estudiante; no se actualizan las cohortes: %v", err)
+		return
+	}
+	removeFromAsignaturaCohorts(ctx, s.MoodleClient, userMoodleID, asignatura, func(programa bool) (bool, error) {
+		var n int64
+		var err error
+		if programa {
+			n, err = s.Repo.CountMatriculasEnPrograma(userMoodleID, asignatura.Cuatrimestre.ProgramaEstudioID, roles)
+		} else {
+			n, err = s.Repo.CountMatriculasEnCuatrimestre(userMoodleID, asignatura.CuatrimestreID, roles)
+		}
+		return n > 0, err
+	})
+}
+
+// createUsersInMoodle crea los usuarios en Moodle. Primero vincula los que ya existen allí con el mismo
+// username, porque un solo duplicado haría fallar todo su lote. El resto se crea con una sola llamada que
+// el cliente parte en lotes (MOODLE_CHUNK_SIZE / MOODLE_CHUNK_SIZES) y devuelve en el mismo orden que los
+// datos. Si un lote falla, sus usuarios se crean uno a uno (así un registro inválido no arrastra a los
+// demás) y se sigue con los lotes siguientes; si el fallo es transitorio, el lote se da por fallido.
+func (s *UsuarioService) createUsersInMoodle(ctx context.Context, usuarios []models.Usuario, progress *SyncProgress) {
+	fail := func(u *models.Usuario, err error) {
+		progress.Failure("usuario", u.ID, err)
+		s.syncFailed(u, err)
+	}
 
-	for i := 0; i < len(usuarios); i += batchSize {
-		end := i + batchSize
-		if end > len(usuarios) {
-			end = len(usuarios)
+	pending := s.linkExistingUsers(ctx, usuarios, progress)
+	provisioning := userProvisioningFromEnv()
+	for len(pending) > 0 && ctx.Err() == nil {
+		data := make([]moodle.UserRequest, len(pending))
+		for i, usuario := range pending {
+			data[i] = userRequest(usuario, provisioning)
 		}
 
-		batch := usuarios[i:end]
-		wg.Add(1)
-
-		// Ejecutamos cada lote en una goroutine separada
-		go func(b []models.Usuario) {
-			defer wg.Done()
-
-			log.Printf("-> Procesando lote de %d usuarios...", len(b))
-
-			// Construir array de UserRequest para Moodle
-			data := make([]moodle.UserRequest, len(b))
-			for i, usuario := range b {
-				data[i] = moodle.UserRequest{
-					Username:  usuario.Username,
-					Password:  usuario.Password,
-					Firstname: usuario.FirstName,
-					Lastname:  usuario.LastName,
-					Email:     usuario.Email,
-					IDNumber:  safeString(usuario.Matricula),
-				}
-			}
+		var response []moodle.UserResponse
+		err := s.MoodleClient.Call(ctx, "core_user_create_users", moodle.CreateUsersParams{Users: data}, &response)
 
-			// Llamar a la API de Moodle
-			var response []moodle.UserResponse
-			err := s.MoodleClient.Call("core_user_create_users", data, &response)
-			if err != nil {
-				log.Printf("❌ Error al procesar lote: %v", err)
-				return
+		// Vincular los creados (también los de los lotes que se completaron antes de un fallo)
+		created := min(len(response), len(pending))
+		for i := 0; i < created; i++ {
+			s.linkUser(&pending[i], response[i].ID, progress)
+		}
+		if err == nil {
+			for i := created; i < len(pending); i++ {
+				fail(&pending[i], sinRespuesta(nil))
 			}
+			return
+		}
+		log.Printf("❌ Error al crear usuarios en Moodle: %v", err)
 
-			// Actualizar ID_Moodle en BD local
-			for i, userResp := range response {
-				if i < len(b) {
-					moodleID := userResp.ID
-					b[i].ID_Moodle = &moodleID
-					if err := s.Repo.Update(&b[i]); err != nil {
-						log.Printf("⚠️ Error al actualizar usuario ID %d con Moodle ID %d: %v", b[i].ID, moodleID, err)
-					} else {
-						log.Printf("✅ Usuario '%s' sincronizado con Moodle ID: %d", b[i].Username, moodleID)
-					}
-				}
+		failed := len(pending) - created
+		var chunkErr *moodle.ChunkError
+		if errors.As(err, &chunkErr) {
+			failed = min(chunkErr.Failed, failed)
+		}
+		lote := pending[created : created+failed]
+		pending = pending[created+failed:]
+		if moodle.IsTransient(err) || ctx.Err() != nil {
+			for i := range lote {
+				fail(&lote[i], err)
+			}
+			continue
+		}
+		log.Printf("Creando uno a uno los %d usuarios del lote fallido...", len(lote))
+		for _, usuario := range lote {
+			if err := s.SyncToMoodle(ctx, usuario.ID); err != nil {
+				progress.Failure("usuario", usuario.ID, err)
+				continue
 			}
+			progress.Success()
+		}
+	}
+	for i := range pending {
+		fail(&pending[i], sinRespuesta(ctx.Err()))
+	}
+}
+
+// linkExistingUsers vincula los usuarios cuyo username ya existe en Moodle y devuelve los que hay que
+// crear. Si la consulta falla se intentan crear todos: los duplicados se resolverán uno a uno.
+func (s *UsuarioService) linkExistingUsers(ctx context.Context, usuarios []models.Usuario, progress *SyncProgress) []models.Usuario {
+	usernames := make([]string, len(usuarios))
+	for i, u := range usuarios {
+		usernames[i] = strings.ToLower(u.Username)
+	}
+	existing, err := moodle.GetUsersByField(ctx, s.MoodleClient, "username", usernames...)
+	if err != nil {
+		log.Printf("⚠️ No se pudieron consultar los usuarios existentes en Moodle: %v", err)
+		return usuarios
+	}
+	byUsername := make(map[string]uint, len(existing))
+	for _, u := range existing {
+		byUsername[strings.ToLower(u.Username)] = u.ID
+	}
 
-		}(batch)
+	var pending []models.Usuario
+	for i := range usuarios {
+		moodleID, ok := byUsername[strings.ToLower(usuarios[i].Username)]
+		if !ok {
+			pending = append(pending, usuarios[i])
+			continue
+		}
+		log.Printf("⚠️ Usuario '%s' ya existía en Moodle (ID: %d). Vinculando.", usuarios[i].Username, moodleID)
+		s.linkUser(&usuarios[i], moodleID, progress)
 	}
+	return pending
+}
+
+// linkUser guarda el ID de Moodle de un usuario creado o vinculado y anota el resultado en progress.
+func (s *UsuarioService) linkUser(u *models.Usuario, moodleID uint, progress *SyncProgress) {
+	u.ID_Moodle = &moodleID
+	u.MarkSynced(usuarioHash(*u))
+	if err := s.Repo.Update(u); err != nil {
+		log.Printf("⚠️ Error al actualizar usuario ID %d con Moodle ID %d: %v", u.ID, moodleID, err)
+		err = fmt.Errorf("existe en Moodle (ID %d) pero falló guardar el ID local: %w", moodleID, err)
+		progress.Failure("usuario", u.ID, err)
+		s.syncFailed(u, err)
+		return
+	}
+	log.Printf("✅ Usuario '%s' sincronizado con Moodle ID: %d", u.Username, moodleID)
+	progress.Success()
+}
+
+// userRequest construye el alta de Moodle del usuario. La contraseña local nunca se incluye:
+// la decide provisioning (createpassword, contraseña temporal o ninguna con autenticación externa).
+func userRequest(usuario models.Usuario, provisioning UserProvisioning) moodle.UserRequest {
+	req := moodle.UserRequest{
+		Username:  usuario.Username,
+		Firstname: usuario.FirstName, // Usamos FirstName
+		Lastname:  usuario.LastName,  // Usamos LastName
+		Email:     usuario.Email,
+		// 👈 Mapeamos Matricula a IDNumber de Moodle
+		IDNumber: safeString(usuario.Matricula),
+	}
+	provisioning.apply(&req)
+	return req
+}
+
+// userUpdateRequest construye la actualización de los datos del usuario en Moodle (sin contraseña).
+// El ID de Moodle queda a 0 si el usuario aún no está sincronizado.
+func userUpdateRequest(usuario models.Usuario) moodle.UserUpdateRequest {
+	req := moodle.UserUpdateRequest{
+		Username:  usuario.Username,
+		Firstname: usuario.FirstName,
+		Lastname:  usuario.LastName,
+		Email:     usuario.Email,
+		IDNumber:  safeString(usuario.Matricula),
+	}
+	if usuario.ID_Moodle != nil {
+		req.ID = *usuario.ID_Moodle
+	}
+	return req
+}
 
-	wg.Wait() // Espera a que todas las goroutines del lote terminen.
-} // CheckUniqueFields delega la verificación de unicidad al repositorio.
+// CheckUniqueFields delega la verificación de unicidad al repositorio.
 func (s *UsuarioService) CheckUniqueFields(u *models.Usuario) (bool, error) {
 	// Nota: El repositorio es responsable de buscar duplicados por Username, Email o Matricula.
 	return s.Repo.ExistsByUniqueFields(u)